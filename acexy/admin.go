@@ -0,0 +1,250 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// The prefix under which all the administrative endpoints are served
+const ADMIN_URL = "/admin"
+
+// authorizeAdmin verifies the request carries the configured admin token, either as a
+// bearer token or in the X-Admin-Token header. When no token is configured, admin
+// endpoints are left open. Returns false after writing the error response.
+func (p *Proxy) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if p.AdminToken == "" {
+		return true
+	}
+
+	token := r.Header.Get("X-Admin-Token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.AdminToken)) != 1 {
+		slog.Warn("Unauthorized admin request", "path", r.URL.Path, "remote", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// HandleAdminSummary returns an overview of the proxy and orchestrator state
+func (p *Proxy) HandleAdminSummary(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizeAdmin(w, r) {
+		return
+	}
+
+	recovering, circuitOpen := p.Orch.RecoveryStats()
+	summary := map[string]any{
+		"instance_id":             p.InstanceID,
+		"orchestrator_configured": p.Orch != nil,
+		"engines_recovering":      recovering,
+		"engine_circuit_open":     circuitOpen,
+	}
+	if p.Orch != nil {
+		summary["orchestrator"] = p.Orch.HealthSnapshot()
+	}
+
+	queue := p.Acexy.QueueStats()
+	summary["queue"] = map[string]any{
+		"max_workers":     queue.MaxWorkers,
+		"depth":           queue.Depth,
+		"timeouts":        queue.Timeouts,
+		"pending_streams": p.pending.Load(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(summary)
+}
+
+// HandleAdminOrchestratorRefresh forces an immediate orchestrator health check and returns
+// the fresh snapshot, so automation does not have to wait for the next monitor tick
+func (p *Proxy) HandleAdminOrchestratorRefresh(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizeAdmin(w, r) {
+		return
+	}
+	if p.Orch == nil {
+		http.Error(w, "Orchestrator not configured", http.StatusNotFound)
+		return
+	}
+
+	if err := p.Orch.updateHealth(); err != nil {
+		slog.Warn("Forced orchestrator health refresh failed", "error", err)
+		http.Error(w, "Failed to refresh orchestrator health: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	slog.Info("Orchestrator health refreshed on demand")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p.Orch.HealthSnapshot())
+}
+
+// adminEngine is an orchestrator engine as reported by `/admin/engines`
+type adminEngine struct {
+	ContainerID   string         `json:"container_id"`
+	ContainerName string         `json:"container_name,omitempty"`
+	Host          string         `json:"host"`
+	Port          int            `json:"port"`
+	HealthStatus  string         `json:"health_status"`
+	Streams       int            `json:"streams"`
+	Version       *engineVersion `json:"version,omitempty"` // Only once probed
+
+	Reliability engineReliabilityStats `json:"reliability"` // Outcomes of its latest streams
+}
+
+// The configuration fields holding secrets, never reported by `/admin/config`
+var redactedConfigFields = map[string]bool{
+	"APIKey":     true,
+	"AdminToken": true,
+}
+
+// The value reported instead of a set secret
+const REDACTED_VALUE = "[redacted]"
+
+// The configuration fields holding URLs that may carry secrets, reported masked
+var maskedConfigFields = map[string]func(string) string{
+	"EventSinkURL": redactEventSinks,
+	"DebugSink":    redactDebugSink,
+}
+
+// redactDebugSink masks the password of the debug sink URL and the values of its query,
+// which carry the signature of presigned URLs
+func redactDebugSink(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return REDACTED_VALUE
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			q.Set(k, "xxxxx")
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// HandleAdminConfig returns the effective configuration, after the flags and the
+// environment were resolved, with its secrets redacted
+func (p *Proxy) HandleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizeAdmin(w, r) {
+		return
+	}
+	if p.Config == nil {
+		http.Error(w, "Configuration not available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(configFields(reflect.ValueOf(*p.Config)))
+}
+
+// configFields maps each field of the configuration struct to a readable value: durations
+// and sizes as text, nested groups as objects and secrets redacted when set
+func configFields(v reflect.Value) map[string]any {
+	fields := make(map[string]any, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		switch value := v.Field(i).Interface().(type) {
+		case time.Duration:
+			fields[name] = value.String()
+		case Size:
+			fields[name] = humanize.Bytes(value.Bytes)
+		case string:
+			if redactedConfigFields[name] && value != "" {
+				value = REDACTED_VALUE
+			} else if mask := maskedConfigFields[name]; mask != nil {
+				value = mask(value)
+			}
+			fields[name] = value
+		default:
+			if v.Field(i).Kind() == reflect.Struct {
+				fields[name] = configFields(v.Field(i))
+			} else {
+				fields[name] = value
+			}
+		}
+	}
+	return fields
+}
+
+// HandleAdminEngines lists the orchestrator engines along with the AceStream version of
+// those already probed
+func (p *Proxy) HandleAdminEngines(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizeAdmin(w, r) {
+		return
+	}
+	if p.Orch == nil {
+		http.Error(w, "Orchestrator not configured", http.StatusNotFound)
+		return
+	}
+
+	engines, err := p.Orch.GetEngines()
+	if err != nil {
+		slog.Warn("Failed to list the orchestrator engines", "error", err)
+		http.Error(w, "Failed to list engines: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p.adminEngines(engines))
+}
+
+// HandleAdminEnginesRefresh invalidates the engine cache and returns the engine list fetched
+// anew, so engines scaled by hand are seen without waiting for the cache to expire
+func (p *Proxy) HandleAdminEnginesRefresh(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizeAdmin(w, r) {
+		return
+	}
+	if p.Orch == nil {
+		http.Error(w, "Orchestrator not configured", http.StatusNotFound)
+		return
+	}
+
+	engines, err := p.Orch.RefreshEngines()
+	if err != nil {
+		slog.Warn("Forced engine list refresh failed", "error", err)
+		http.Error(w, "Failed to refresh engines: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	slog.Info("Engine list refreshed on demand", "engines", len(engines))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p.adminEngines(engines))
+}
+
+// adminEngines converts the orchestrator engines to their admin representation
+func (p *Proxy) adminEngines(engines []engineState) []adminEngine {
+	list := make([]adminEngine, 0, len(engines))
+	for _, engine := range engines {
+		entry := adminEngine{
+			ContainerID:   engine.ContainerID,
+			ContainerName: engine.ContainerName,
+			Host:          engine.Host,
+			Port:          engine.Port,
+			HealthStatus:  engine.HealthStatus,
+			Streams:       len(engine.Streams),
+			Reliability:   p.Orch.GetEngineHealth(engine.ContainerID),
+		}
+		if version, ok := p.Orch.versions.Cached(engine.ContainerID); ok {
+			entry.Version = &version
+		}
+		list = append(list, entry)
+	}
+	return list
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestOrchestratorAssignedStreamID verifies the stream ID the orchestrator answers the
+// stream_started event with is the one the stream_ended event is sent for
+func TestOrchestratorAssignedStreamID(t *testing.T) {
+	_, enginePort := newStandbyTestEngine(t, "test data", false)
+
+	var mu sync.Mutex
+	var started struct {
+		Labels map[string]string `json:"labels"`
+	}
+	var ended []string
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-1", Host: "127.0.0.1", Port: enginePort, HealthStatus: "healthy"},
+			})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		case "/events/stream_started":
+			mu.Lock()
+			json.NewDecoder(r.Body).Decode(&started)
+			mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]string{"stream_id": "orch-stream-42"})
+		case "/events/stream_ended":
+			var ev endedEvent
+			json.NewDecoder(r.Body).Decode(&ev)
+			mu.Lock()
+			ended = append(ended, ev.StreamID)
+			mu.Unlock()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		endedStreams:        make(map[string]bool),
+	}
+	acexyInst := &acexy.Acexy{
+		Scheme:            "http",
+		Host:              "127.0.0.1",
+		Port:              1,
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      5 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+	proxy := &Proxy{Acexy: acexyInst, Orch: client}
+
+	w := httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+	if got := w.Body.String(); got != "test data" {
+		t.Fatalf("Expected the stream data, got %q", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		got := append([]string(nil), ended...)
+		mu.Unlock()
+		if len(got) > 0 {
+			if len(got) != 1 || got[0] != "orch-stream-42" {
+				t.Fatalf("Expected the stream to end with the assigned ID, got %v", got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a stream_ended event")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The started event was still sent with the local ID
+	mu.Lock()
+	defer mu.Unlock()
+	if local := started.Labels["stream_id"]; local != "test123|playback123" {
+		t.Errorf("Expected the started event to carry the local stream ID, got %q", local)
+	}
+}
+
+// TestAssignedStreamIDInRegistry verifies the registry reports and cancels streams by the
+// ID the orchestrator assigned
+func TestAssignedStreamIDInRegistry(t *testing.T) {
+	var streams streamRegistry
+	stream := &activeStream{PlaybackID: "playback123", Key: "test123"}
+	streams.Add(stream)
+
+	if id := stream.StreamID(); id != "test123|playback123" {
+		t.Errorf("Expected the local stream ID before one is assigned, got %q", id)
+	}
+	stream.AssignStreamID("orch-stream-42")
+	if _, ok := streams.StreamIDs()["orch-stream-42"]; !ok {
+		t.Errorf("Expected the assigned ID among the stream IDs, got %v", streams.StreamIDs())
+	}
+	if !streams.Cancel("orch-stream-42") {
+		t.Error("Expected the stream to be cancelled by its assigned ID")
+	}
+}
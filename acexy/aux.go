@@ -0,0 +1,71 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// setAuxHeaders advertises the auxiliary resources of the stream so the client can fetch
+// them through `/ace/aux?session=<session>&name=<name>`
+func setAuxHeaders(w http.ResponseWriter, playbackID string, auxURLs map[string]string) {
+	names := make([]string, 0, len(auxURLs))
+	for name := range auxURLs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("X-Acexy-Session", playbackID)
+	w.Header().Set("X-Acexy-Aux", strings.Join(names, ", "))
+}
+
+// HandleAux relays an auxiliary resource (subtitles, thumbnails...) the middleware exposed
+// for an active stream, so clients never have to reach the engine directly
+func (p *Proxy) HandleAux(w http.ResponseWriter, r *http.Request) {
+	if !p.EnableAux {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	session, name := q.Get("session"), q.Get("name")
+	if session == "" || name == "" {
+		http.Error(w, "`session` and `name` parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	stream, ok := p.streams.Get(session)
+	if !ok {
+		http.Error(w, "Stream not active", http.StatusNotFound)
+		return
+	}
+	auxURL, ok := stream.Stream.AuxURLs[name]
+	if !ok {
+		http.Error(w, "Auxiliary resource not available", http.StatusNotFound)
+		return
+	}
+
+	resp, err := p.Acexy.FetchAux(auxURL)
+	if err != nil {
+		slog.Error("Failed to fetch auxiliary resource", "session", session, "name", name, "error", err)
+		http.Error(w, "Failed to fetch auxiliary resource", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range []string{"Content-Type", "Content-Length", "Last-Modified"} {
+		if v := resp.Header.Get(header); v != "" {
+			w.Header().Set(header, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		slog.Debug("Failed to relay auxiliary resource", "session", session, "name", name, "error", err)
+	}
+}
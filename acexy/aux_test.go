@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newAuxTestEngine creates a mock engine whose middleware response includes a subtitles URL.
+// The stream is kept open until the returned channel is closed.
+func newAuxTestEngine() (*httptest.Server, chan struct{}) {
+	release := make(chan struct{})
+	var engine *httptest.Server
+	engine = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			json.NewEncoder(w).Encode(map[string]any{
+				"response": map[string]any{
+					"playback_url":  engine.URL + "/stream",
+					"stat_url":      engine.URL + "/ace/stat/test/playback123",
+					"command_url":   engine.URL + "/ace/cmd/test/playback123",
+					"subtitles_url": engine.URL + "/subs.vtt",
+				},
+			})
+		case "/stream":
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.Write([]byte("stream data"))
+			w.(http.Flusher).Flush()
+			<-release
+		case "/subs.vtt":
+			w.Header().Set("Content-Type", "text/vtt")
+			w.Write([]byte("WEBVTT\n\n00:00.000 --> 00:01.000\nHello"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return engine, release
+}
+
+func newAuxTestProxy(engine *httptest.Server, enableAux bool) *Proxy {
+	engineURL, _ := url.Parse(engine.URL)
+	acexyInst := &acexy.Acexy{
+		Scheme:            engineURL.Scheme,
+		Host:              engineURL.Hostname(),
+		Port:              parsePort(engineURL.Port()),
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      5 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+	return &Proxy{Acexy: acexyInst, EnableAux: enableAux}
+}
+
+// TestAuxPassthrough verifies the auxiliary URLs returned by the middleware are relayed
+// through acexy while the stream is active
+func TestAuxPassthrough(t *testing.T) {
+	engine, release := newAuxTestEngine()
+	defer engine.Close()
+	proxy := newAuxTestProxy(engine, true)
+
+	streamRec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		proxy.HandleStream(streamRec, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test", nil))
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := proxy.streams.Get("playback123"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			close(release)
+			t.Fatal("Stream was never registered as active")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ace/aux?session=playback123&name=subtitles", nil))
+
+	close(release)
+	<-done
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/vtt" {
+		t.Errorf("Expected Content-Type text/vtt, got %s", ct)
+	}
+	if body := rec.Body.String(); body != "WEBVTT\n\n00:00.000 --> 00:01.000\nHello" {
+		t.Errorf("Unexpected subtitles body: %q", body)
+	}
+	if aux := streamRec.Header().Get("X-Acexy-Aux"); aux != "subtitles" {
+		t.Errorf("Expected X-Acexy-Aux header to list subtitles, got %q", aux)
+	}
+	if session := streamRec.Header().Get("X-Acexy-Session"); session != "playback123" {
+		t.Errorf("Expected X-Acexy-Session header playback123, got %q", session)
+	}
+
+	// Once the stream ended, the auxiliary resource is no longer available
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ace/aux?session=playback123&name=subtitles", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after the stream ended, got %d", rec.Code)
+	}
+}
+
+// TestAuxDisabled verifies the aux route is not served unless enabled
+func TestAuxDisabled(t *testing.T) {
+	engine, release := newAuxTestEngine()
+	defer engine.Close()
+	close(release)
+	proxy := newAuxTestProxy(engine, false)
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ace/aux?session=playback123&name=subtitles", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 when aux is disabled, got %d", rec.Code)
+	}
+}
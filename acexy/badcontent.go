@@ -0,0 +1,107 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// badContentCache is a short-lived negative cache for content IDs the AceStream middleware
+// keeps rejecting. Once an ID accumulates `threshold` middleware errors within `window`, it is
+// blocked for `ttl` and requests for it are answered without touching the engine.
+type badContentCache struct {
+	threshold int
+	window    time.Duration
+	ttl       time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*badContentEntry
+}
+
+type badContentEntry struct {
+	failures     int
+	firstFailure time.Time
+	blockedUntil time.Time
+	reason       string
+}
+
+// newBadContentCache creates the negative cache. Returns nil (disabled) when either the
+// threshold or the TTL are not positive.
+func newBadContentCache(threshold int, window, ttl time.Duration) *badContentCache {
+	if threshold <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &badContentCache{
+		threshold: threshold,
+		window:    window,
+		ttl:       ttl,
+		entries:   make(map[string]*badContentEntry),
+	}
+}
+
+// Blocked reports whether the given key is currently blocked, together with the last
+// middleware error received for it
+func (c *badContentCache) Blocked(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || entry.blockedUntil.IsZero() {
+		return "", false
+	}
+	if time.Now().After(entry.blockedUntil) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return entry.reason, true
+}
+
+// RecordFailure registers a middleware error for the given key, blocking it once the
+// threshold is reached within the configured window
+func (c *badContentCache) RecordFailure(key, reason string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.pruneLocked(now)
+
+	entry, ok := c.entries[key]
+	if !ok || now.Sub(entry.firstFailure) > c.window {
+		entry = &badContentEntry{firstFailure: now}
+		c.entries[key] = entry
+	}
+	entry.failures++
+	entry.reason = reason
+
+	if entry.failures >= c.threshold && entry.blockedUntil.IsZero() {
+		entry.blockedUntil = now.Add(c.ttl)
+		slog.Warn("Blocking content after repeated middleware errors",
+			"key", key, "failures", entry.failures, "ttl", c.ttl, "reason", reason)
+	}
+}
+
+// pruneLocked drops the entries whose window or block period already expired.
+// Must be called with the lock held.
+func (c *badContentCache) pruneLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if entry.blockedUntil.IsZero() {
+			if now.Sub(entry.firstFailure) > c.window {
+				delete(c.entries, key)
+			}
+		} else if now.After(entry.blockedUntil) {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBadContentStopsReachingEngine verifies that after the configured number of middleware
+// errors for the same ID, further requests are answered from the negative cache
+func TestBadContentStopsReachingEngine(t *testing.T) {
+	var engineHits atomic.Int32
+
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ace/getstream" {
+			engineHits.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"response": null, "error": "unknown content"}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer engine.Close()
+
+	engineURL, _ := url.Parse(engine.URL)
+	acexyInst := &acexy.Acexy{
+		Scheme:            engineURL.Scheme,
+		Host:              engineURL.Hostname(),
+		Port:              parsePort(engineURL.Port()),
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      1 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+
+	proxy := &Proxy{
+		Acexy:      acexyInst,
+		BadContent: newBadContentCache(2, time.Minute, time.Minute),
+	}
+
+	var lastCode int
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/ace/getstream?id=bad-content", nil)
+		rec := httptest.NewRecorder()
+		proxy.HandleStream(rec, req)
+		lastCode = rec.Code
+	}
+
+	if hits := engineHits.Load(); hits != 2 {
+		t.Errorf("Expected the engine to be reached only 2 times, got %d", hits)
+	}
+	if lastCode != http.StatusNotFound {
+		t.Errorf("Expected blocked requests to return 404, got %d", lastCode)
+	}
+
+	// A different ID must still reach the engine
+	req := httptest.NewRequest(http.MethodGet, "/ace/getstream?id=other-content", nil)
+	rec := httptest.NewRecorder()
+	proxy.HandleStream(rec, req)
+	if hits := engineHits.Load(); hits != 3 {
+		t.Errorf("Expected an unrelated ID to reach the engine, got %d hits", hits)
+	}
+}
+
+// TestBadContentCacheExpires verifies blocked entries are cleared once the TTL elapses
+func TestBadContentCacheExpires(t *testing.T) {
+	cache := newBadContentCache(1, time.Minute, 50*time.Millisecond)
+
+	cache.RecordFailure("key", "unknown content")
+	if _, blocked := cache.Blocked("key"); !blocked {
+		t.Fatal("Expected key to be blocked after reaching the threshold")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, blocked := cache.Blocked("key"); blocked {
+		t.Error("Expected key to be unblocked after the TTL")
+	}
+}
+
+// TestBadContentCacheDisabled verifies a disabled cache never blocks
+func TestBadContentCacheDisabled(t *testing.T) {
+	cache := newBadContentCache(1, time.Minute, 0)
+	if cache != nil {
+		t.Fatal("Expected a nil cache when the TTL is 0")
+	}
+
+	cache.RecordFailure("key", "unknown content")
+	if _, blocked := cache.Blocked("key"); blocked {
+		t.Error("Expected a disabled cache to never block")
+	}
+}
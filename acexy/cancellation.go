@@ -0,0 +1,75 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// cancelWatcher periodically asks the orchestrator for the streams of this container it
+// marked as cancelled (e.g. through an admin action), stopping those acexy still serves.
+// Unlike the reconciler, which heals the orchestrator accounting, it acts on the explicit
+// intent of the orchestrator.
+type cancelWatcher struct {
+	interval  time.Duration
+	cancelled atomic.Uint64 // Streams stopped because the orchestrator cancelled them
+}
+
+// newCancelWatcher creates the watcher. Returns nil (disabled) when the interval is not positive.
+func newCancelWatcher(interval time.Duration) *cancelWatcher {
+	if interval <= 0 {
+		return nil
+	}
+	return &cancelWatcher{interval: interval}
+}
+
+// Run checks the cancelled streams every interval until the context is done
+func (c *cancelWatcher) Run(ctx context.Context, orch *orchClient, streams *streamRegistry) {
+	if c == nil || orch == nil {
+		return
+	}
+	if orch.containerID == "" {
+		slog.Warn("Watching the cancelled streams requires the container ID, disabling it")
+		return
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.check(orch, streams)
+		}
+	}
+}
+
+// check stops the served streams the orchestrator marked as cancelled
+func (c *cancelWatcher) check(orch *orchClient, streams *streamRegistry) {
+	listed, err := orch.GetCancelledStreams()
+	if err != nil {
+		slog.Warn("Failed to get the streams cancelled by the orchestrator", "error", err)
+		return
+	}
+
+	for _, stream := range listed {
+		if streams.Cancel(stream.ID) {
+			c.cancelled.Add(1)
+			slog.Info("Orchestrator cancelled a served stream, stopping it", "stream_id", stream.ID)
+		}
+	}
+}
+
+// Cancelled returns the number of streams stopped because the orchestrator cancelled them
+func (c *cancelWatcher) Cancelled() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.cancelled.Load()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestCancelWatcherStopsCancelledStreams verifies a served stream the orchestrator marks as
+// cancelled is stopped, while unknown cancelled streams are ignored
+func TestCancelWatcherStopsCancelledStreams(t *testing.T) {
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/streams" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("container_id") != "acexy-1" || r.URL.Query().Get("status") != "cancelled" {
+			t.Errorf("Expected the cancelled streams of this container, got %s", r.URL.RawQuery)
+		}
+		json.NewEncoder(w).Encode([]streamState{
+			{ID: "test123|playback123", Status: "cancelled"},
+			{ID: "other|gone", Status: "cancelled"},
+		})
+	}))
+	defer orch.Close()
+
+	client := newOrchClient(OrchConfig{URL: orch.URL, ContainerID: "acexy-1"})
+	defer client.Close()
+
+	proxy, _, _ := newDuplicateSessionProxy(t, "playback123")
+	wait := streamConcurrently(t, proxy, 1)
+
+	watcher := newCancelWatcher(time.Second)
+	watcher.check(client, &proxy.streams)
+
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the cancelled stream to be stopped")
+	}
+
+	if watcher.Cancelled() != 1 {
+		t.Errorf("Expected 1 cancelled stream, got %d", watcher.Cancelled())
+	}
+	if streams := proxy.history.Latest(1); len(streams) != 1 || streams[0].Reason != "orchestrator_cancelled" {
+		t.Errorf("Expected the stream to end as cancelled by the orchestrator, got %+v", streams)
+	}
+}
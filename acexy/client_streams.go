@@ -0,0 +1,56 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import "sync"
+
+// clientStreamLimit caps the streams each client IP may have open at once, so a single
+// client cannot exhaust the engines by opening many streams. Unlike the rate limit, it
+// counts the streams being served rather than the requests.
+type clientStreamLimit struct {
+	max int
+
+	mu   sync.Mutex
+	open map[string]int // Streams open by each client IP
+}
+
+// newClientStreamLimit creates the limit. Returns nil (disabled) when max is not positive.
+func newClientStreamLimit(max int) *clientStreamLimit {
+	if max <= 0 {
+		return nil
+	}
+	return &clientStreamLimit{max: max, open: make(map[string]int)}
+}
+
+// Acquire reserves a stream for the client, reporting whether it is below its limit.
+// Each successful call must be followed by a Release once the stream ends.
+func (l *clientStreamLimit) Acquire(ip string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[ip] >= l.max {
+		return false
+	}
+	l.open[ip]++
+	return true
+}
+
+// Release frees a stream reserved by the client
+func (l *clientStreamLimit) Release(ip string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[ip] <= 1 {
+		delete(l.open, ip)
+		return
+	}
+	l.open[ip]--
+}
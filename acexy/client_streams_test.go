@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMaxStreamsPerClient verifies a client opening more distinct streams than allowed is
+// rejected with 429, while other clients are not affected and ended streams are released
+func TestMaxStreamsPerClient(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback1", "playback2", "playback3", "playback4")
+	proxy.ClientStreams = newClientStreamLimit(2)
+
+	request := func(id, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/ace/getstream?id="+id, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		proxy.HandleStream(rec, req)
+		return rec
+	}
+
+	var wg sync.WaitGroup
+	stream := func(id, remoteAddr string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request(id, remoteAddr)
+		}()
+		want := proxy.streams.Len() + 1
+		deadline := time.Now().Add(5 * time.Second)
+		for proxy.streams.Len() < want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Stream %s was not registered", id)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	stream("test1", "10.0.0.1:1234")
+	stream("test2", "10.0.0.1:5678")
+
+	if rec := request("test3", "10.0.0.1:9012"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 past the client limit, got %d", rec.Code)
+	}
+	// Another client is still served
+	stream("test4", "10.0.0.2:1234")
+
+	close(release)
+	wg.Wait()
+
+	if open := len(proxy.ClientStreams.open); open != 0 {
+		t.Errorf("Expected every client stream to be released, %d clients left", open)
+	}
+	if !proxy.ClientStreams.Acquire("10.0.0.1") {
+		t.Error("Expected the client to open streams again once its streams ended")
+	}
+}
+
+// TestMaxStreamsPerClientDisabled verifies a nil limit allows every stream
+func TestMaxStreamsPerClientDisabled(t *testing.T) {
+	limit := newClientStreamLimit(0)
+	if limit != nil {
+		t.Fatal("Expected a nil limit for a zero maximum")
+	}
+	for i := 0; i < 10; i++ {
+		if !limit.Acquire("10.0.0.1") {
+			t.Fatalf("Stream %d was limited by a disabled limit", i+1)
+		}
+	}
+	limit.Release("10.0.0.1")
+}
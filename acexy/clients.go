@@ -0,0 +1,181 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errClientQuotaExceeded is returned when a client received all the bytes its quota allows
+var errClientQuotaExceeded = errors.New("client byte quota exceeded")
+
+// clientQuota caps the bytes each client IP may receive within a window, across all its
+// streams and requests. The count of a client starts over once its window elapsed.
+type clientQuota struct {
+	limit  uint64
+	window time.Duration
+
+	mu        sync.Mutex
+	used      map[string]*quotaUsage // Bytes received by each client IP in its window
+	lastPrune time.Time
+}
+
+type quotaUsage struct {
+	bytes uint64
+	since time.Time
+}
+
+// newClientQuota creates the quota. Returns nil (disabled) when the limit is 0. A window
+// that is not positive never resets the counts.
+func newClientQuota(limit uint64, window time.Duration) *clientQuota {
+	if limit == 0 {
+		return nil
+	}
+	return &clientQuota{limit: limit, window: window, used: make(map[string]*quotaUsage)}
+}
+
+// Limit returns the bytes each client may receive within the window, 0 when disabled
+func (q *clientQuota) Limit() uint64 {
+	if q == nil {
+		return 0
+	}
+	return q.limit
+}
+
+// Writer wraps the output of a stream of the client, counting its bytes against the quota
+func (q *clientQuota) Writer(client string, w io.Writer) io.Writer {
+	if q == nil {
+		return w
+	}
+	return &quotaWriter{w: w, quota: q, client: client}
+}
+
+// take reserves up to n bytes of the quota of the client, returning how many it may receive
+func (q *clientQuota) take(client string, n uint64) uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	q.pruneLocked(now)
+	usage, ok := q.used[client]
+	if !ok || q.expired(usage, now) {
+		usage = &quotaUsage{since: now}
+		q.used[client] = usage
+	}
+	granted := min(n, q.limit-usage.bytes)
+	usage.bytes += granted
+	return granted
+}
+
+// Usage returns the bytes received by each client within its current window
+func (q *clientQuota) Usage() map[string]uint64 {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	usage := make(map[string]uint64, len(q.used))
+	for client, u := range q.used {
+		if !q.expired(u, now) {
+			usage[client] = u.bytes
+		}
+	}
+	return usage
+}
+
+func (q *clientQuota) expired(usage *quotaUsage, now time.Time) bool {
+	return q.window > 0 && now.Sub(usage.since) >= q.window
+}
+
+// pruneLocked drops the counts whose window elapsed, at most once per window. Must be
+// called with the lock held.
+func (q *clientQuota) pruneLocked(now time.Time) {
+	if q.window <= 0 || now.Sub(q.lastPrune) < q.window {
+		return
+	}
+	q.lastPrune = now
+	for client, usage := range q.used {
+		if q.expired(usage, now) {
+			delete(q.used, client)
+		}
+	}
+}
+
+// quotaWriter forwards the bytes the quota of the client still allows, failing the write
+// that would go beyond them so the client is disconnected
+type quotaWriter struct {
+	w      io.Writer
+	quota  *clientQuota
+	client string
+}
+
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	granted := q.quota.take(q.client, uint64(len(p)))
+	if granted == uint64(len(p)) {
+		return q.w.Write(p)
+	}
+	n, err := q.w.Write(p[:granted])
+	if err == nil {
+		err = errClientQuotaExceeded
+	}
+	return n, err
+}
+
+// clientUsage is the per-client breakdown returned by `/admin/clients`
+type clientUsage struct {
+	Client          string    `json:"client"`
+	Label           string    `json:"label,omitempty"`
+	AceID           string    `json:"ace_id"`
+	PlaybackID      string    `json:"playback_id"`
+	EngineHost      string    `json:"engine_host"`
+	EnginePort      int       `json:"engine_port"`
+	EngineForwarded bool      `json:"engine_forwarded"`
+	StartedAt       time.Time `json:"started_at"`
+	BytesSent       uint64    `json:"bytes_sent"`
+}
+
+// HandleAdminClients returns the bytes delivered to each client currently being served,
+// together with the totals per client address and the bytes counted against their quota
+func (p *Proxy) HandleAdminClients(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizeAdmin(w, r) {
+		return
+	}
+
+	streams := p.streams.List()
+	clients := make([]clientUsage, 0, len(streams))
+	totals := make(map[string]uint64)
+	for _, stream := range streams {
+		usage := clientUsage{
+			Client:          stream.Client,
+			Label:           stream.Label,
+			AceID:           stream.AceID,
+			PlaybackID:      stream.PlaybackID,
+			EngineHost:      stream.EngineHost,
+			EnginePort:      stream.EnginePort,
+			EngineForwarded: stream.EngineForwarded,
+			StartedAt:       stream.StartedAt,
+			BytesSent:       stream.BytesSent(),
+		}
+		clients = append(clients, usage)
+		totals[usage.Client] += usage.BytesSent
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].StartedAt.Before(clients[j].StartedAt) })
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"clients":      clients,
+		"client_bytes": totals,
+		"byte_quota":   p.ClientQuota.Limit(),
+		"quota_used":   p.ClientQuota.Usage(),
+	})
+}
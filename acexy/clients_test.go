@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"javinator9889/acexy/lib/pmw"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestClientByteQuota verifies a client is disconnected once it received its quota, which
+// also applies to its later requests
+func TestClientByteQuota(t *testing.T) {
+	proxy, _ := newEventTestProxy(t, 1, nil)
+	proxy.ClientQuota = newClientQuota(4, time.Hour)
+
+	w := httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+
+	if got := w.Body.String(); got != "test" {
+		t.Errorf("Expected only the first 4 bytes to be delivered, got %q", got)
+	}
+	if proxy.streams.Len() != 0 {
+		t.Error("Expected the stream to be unregistered after the disconnect")
+	}
+
+	w = httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+	if got := w.Body.String(); got != "" {
+		t.Errorf("Expected nothing to be delivered once the quota was used, got %q", got)
+	}
+	if used := proxy.ClientQuota.Usage()["192.0.2.1"]; used != 4 {
+		t.Errorf("Expected 4 bytes counted against the quota, got %d", used)
+	}
+
+	if reason, _ := classifyDisconnectReason(errClientQuotaExceeded); reason != "quota_exceeded" {
+		t.Errorf("Expected quota_exceeded reason, got %s", reason)
+	}
+}
+
+// TestClientQuotaWindow verifies the quota is counted per client and starts over once its
+// window elapsed
+func TestClientQuotaWindow(t *testing.T) {
+	quota := newClientQuota(10, time.Hour)
+	if granted := quota.take("10.0.0.1", 6); granted != 6 {
+		t.Errorf("Expected 6 bytes granted, got %d", granted)
+	}
+	if granted := quota.take("10.0.0.1", 6); granted != 4 {
+		t.Errorf("Expected the remaining 4 bytes granted, got %d", granted)
+	}
+	if granted := quota.take("10.0.0.2", 6); granted != 6 {
+		t.Errorf("Expected another client to have its own quota, got %d", granted)
+	}
+
+	quota.mu.Lock()
+	quota.used["10.0.0.1"].since = time.Now().Add(-time.Hour)
+	quota.mu.Unlock()
+	if granted := quota.take("10.0.0.1", 6); granted != 6 {
+		t.Errorf("Expected the quota to start over after the window, got %d", granted)
+	}
+
+	var disabled *clientQuota
+	if disabled.Limit() != 0 || disabled.Usage() != nil {
+		t.Error("Expected a nil quota to be disabled")
+	}
+}
+
+// TestAdminClients verifies the bytes delivered to each client are reported, along with
+// the totals per client address
+func TestAdminClients(t *testing.T) {
+	proxy := &Proxy{}
+
+	clients := []struct {
+		playbackID string
+		client     string
+		data       string
+	}{
+		{"p1", "10.0.0.1", "0123456789"},
+		{"p2", "10.0.0.1", "01234"},
+		{"p3", "10.0.0.2", "012"},
+	}
+	for i, c := range clients {
+		writer := &bytes.Buffer{}
+		out := pmw.New(writer)
+		out.Write([]byte(c.data))
+		proxy.streams.Add(&activeStream{
+			PlaybackID: c.playbackID,
+			AceID:      "id:test",
+			Client:     c.client,
+			StartedAt:  time.Now().Add(time.Duration(i) * time.Second),
+			Output:     out,
+			Writer:     writer,
+		})
+	}
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/clients", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var resp struct {
+		Clients     []clientUsage     `json:"clients"`
+		ClientBytes map[string]uint64 `json:"client_bytes"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if len(resp.Clients) != len(clients) {
+		t.Fatalf("Expected %d clients, got %d", len(clients), len(resp.Clients))
+	}
+	for i, c := range clients {
+		if got := resp.Clients[i]; got.PlaybackID != c.playbackID || got.BytesSent != uint64(len(c.data)) {
+			t.Errorf("Expected %s with %d bytes, got %s with %d", c.playbackID, len(c.data), got.PlaybackID, got.BytesSent)
+		}
+	}
+	if resp.ClientBytes["10.0.0.1"] != 15 || resp.ClientBytes["10.0.0.2"] != 3 {
+		t.Errorf("Unexpected totals per client: %v", resp.ClientBytes)
+	}
+}
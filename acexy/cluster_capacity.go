@@ -0,0 +1,47 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// Code of the provisioning error returned when the cluster capacity is exhausted, and the
+// retry delay suggested to the client
+const (
+	CLUSTER_CAPACITY_CODE        = "max_capacity"
+	CLUSTER_CAPACITY_RETRY_AFTER = 30
+)
+
+// clusterCapacityError returns the error refusing new streams and provisions when the
+// orchestrator reports no capacity left in the cluster, or nil when the guard is disabled,
+// the orchestrator reports no capacity or its health is stale. The engine list may still
+// show free engines during bursts, before the orchestrator accounts for the new streams.
+func (c *orchClient) clusterCapacityError() *ProvisioningError {
+	if c == nil || !c.respectClusterCapacity {
+		return nil
+	}
+
+	c.health.mu.RLock()
+	defer c.health.mu.RUnlock()
+
+	capacity := c.health.capacity
+	if c.health.lastCheck.IsZero() || c.healthStale() || capacity.Total <= 0 || capacity.Available > 0 {
+		return nil
+	}
+	slog.Warn("Cluster capacity exhausted, refusing the stream",
+		"capacity_total", capacity.Total, "capacity_used", capacity.Used, "capacity_available", capacity.Available)
+	return &ProvisioningError{
+		StatusCode: http.StatusServiceUnavailable,
+		Details: &ProvisionError{
+			Code:               CLUSTER_CAPACITY_CODE,
+			Message:            "cluster capacity exhausted",
+			RecoveryETASeconds: CLUSTER_CAPACITY_RETRY_AFTER,
+			ShouldWait:         true,
+			CanRetry:           true,
+		},
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRespectClusterCapacity verifies the selection and provisioning are refused while the
+// orchestrator reports the cluster capacity exhausted, despite an engine looking free
+func TestRespectClusterCapacity(t *testing.T) {
+	var provisions atomic.Int32
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{{ContainerID: "engine-1", Host: "127.0.0.1", Port: 6878, HealthStatus: "healthy"}})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		case "/provision/acestream":
+			provisions.Add(1)
+			json.NewEncoder(w).Encode(aceProvisionResponse{ContainerID: "engine-2", HostHTTPPort: 6879})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                   orch.URL,
+		maxStreamsPerEngine:    1,
+		hc:                     &http.Client{Timeout: 3 * time.Second},
+		ctx:                    ctx,
+		cancel:                 cancel,
+		endedStreams:           make(map[string]bool),
+		respectClusterCapacity: true,
+	}
+	setCapacity := func(total, available int) {
+		client.health.mu.Lock()
+		defer client.health.mu.Unlock()
+		client.health.lastCheck = time.Now()
+		client.health.canProvision = true
+		client.health.capacity = CapacityInfo{Total: total, Used: total - available, Available: available}
+	}
+
+	// Exhausted, refused although engine-1 is free
+	setCapacity(10, 0)
+	_, err := client.SelectBestEngine()
+	var provErr *ProvisioningError
+	if !errors.As(err, &provErr) || provErr.Details.Code != CLUSTER_CAPACITY_CODE || provErr.Details.RecoveryETASeconds != CLUSTER_CAPACITY_RETRY_AFTER {
+		t.Fatalf("Expected a cluster capacity error, got %v", err)
+	}
+	if _, err := client.ProvisionWithRetryContext(context.Background(), 1); !errors.As(err, &provErr) {
+		t.Errorf("Expected the provisioning refused, got %v", err)
+	}
+	if got := provisions.Load(); got != 0 {
+		t.Errorf("Expected no provision requested, got %d", got)
+	}
+
+	// The client is told to come back later
+	rec := httptest.NewRecorder()
+	(&Proxy{}).handleSelectionError(rec, err)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a 503 with Retry-After, got %d and %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	tests := []struct {
+		name      string
+		total     int
+		available int
+		enabled   bool
+	}{
+		{"capacity left", 10, 1, true},
+		{"capacity not reported", 0, 0, true},
+		{"disabled", 10, 0, false},
+	}
+	for _, tt := range tests {
+		client.respectClusterCapacity = tt.enabled
+		setCapacity(tt.total, tt.available)
+		if engine, err := client.SelectBestEngine(); err != nil || engine.ContainerID != "engine-1" {
+			t.Errorf("%s: expected engine-1 selected, got %s (%v)", tt.name, engine.ContainerID, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+type streamKeyContextKey struct{}
+
+// withStreamKey returns a context telling the engine selection the key of the requested
+// stream (its content ID or infohash)
+func withStreamKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, streamKeyContextKey{}, key)
+}
+
+// requestedStreamKey returns the key of the stream the engine selection is run for, if known
+func requestedStreamKey(ctx context.Context) string {
+	key, _ := ctx.Value(streamKeyContextKey{}).(string)
+	return key
+}
+
+// servingEngine returns which of the given engines the orchestrator lists as already
+// streaming the key, possibly for another acexy instance. Joining it spares the cluster a
+// second P2P session of the same content. The started streams of every engine are fetched
+// in a single query, the first listed engine serving the key being returned. Returns an
+// empty string when none does.
+func (c *orchClient) servingEngine(ctx context.Context, key string, containerIDs []string) string {
+	if len(containerIDs) == 0 {
+		return ""
+	}
+
+	var streams []streamState
+	err := c.retryTransient(ctx, "started_streams", func() (err error) {
+		streams, err = c.getStreams(ctx, "", "started")
+		return err
+	})
+	if err != nil {
+		slog.Debug("Failed to get the started streams", "error", err)
+		return ""
+	}
+
+	serving := make(map[string]bool)
+	for _, stream := range streams {
+		if stream.Key == key && stream.Status == "started" {
+			serving[stream.ContainerID] = true
+		}
+	}
+	for _, containerID := range containerIDs {
+		if serving[containerID] {
+			return containerID
+		}
+	}
+	return ""
+}
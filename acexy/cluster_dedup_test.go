@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSelectBestEngineClusterDedup verifies the engine the orchestrator lists as already
+// streaming the requested key is joined even when busier, unless it is full or disabled,
+// looking the key up with a single query of the started streams of every engine
+func TestSelectBestEngineClusterDedup(t *testing.T) {
+	var clusterQueries atomic.Int32
+	var recommended atomic.Value
+	recommended.Store("")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-idle", Host: "host-idle", Port: 8001, HealthStatus: "healthy"},
+				{ContainerID: "engine-serving", Host: "host-serving", Port: 8002, HealthStatus: "healthy"},
+			})
+		case "/streams":
+			streams := []streamState{}
+			switch r.URL.Query().Get("container_id") {
+			case "engine-serving":
+				streams = append(streams, streamState{ID: "abc|p1", Key: "abc", ContainerID: "engine-serving", Status: "started"})
+			case "":
+				clusterQueries.Add(1)
+				streams = append(streams, streamState{ID: "abc|p1", Key: "abc", ContainerID: "engine-serving", Status: "started"})
+				if r.URL.Query().Get("status") != "started" {
+					streams = append(streams, streamState{ID: "ghi|p2", Key: "ghi", ContainerID: "engine-serving", Status: "ended"})
+				}
+			}
+			json.NewEncoder(w).Encode(streams)
+		case "/orchestrator/status":
+			status := orchestratorStatus{Status: "healthy"}
+			status.Provisioning.CanProvision = true
+			status.RecommendedEngine = recommended.Load().(string)
+			json.NewEncoder(w).Encode(status)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                server.URL,
+		maxStreamsPerEngine: 2,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		clusterDedup:        true,
+	}
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"abc", "engine-serving"}, // Already streamed by the cluster, joined over load
+		{"def", "engine-idle"},    // Streamed nowhere, the least loaded engine wins
+		{"ghi", "engine-idle"},    // Its stream already ended
+		{"", "engine-idle"},
+	}
+	for _, tt := range tests {
+		clusterQueries.Store(0)
+		engine, err := client.SelectBestEngineContext(withStreamKey(context.Background(), tt.key))
+		if err != nil {
+			t.Fatalf("Unexpected selection error for %q: %v", tt.key, err)
+		}
+		if engine.ContainerID != tt.expected {
+			t.Errorf("Key %q: expected %s to be selected, got %s", tt.key, tt.expected, engine.ContainerID)
+		}
+		if expected := int32(min(len(tt.key), 1)); clusterQueries.Load() != expected {
+			t.Errorf("Key %q: expected %d cluster stream queries, got %d", tt.key, expected, clusterQueries.Load())
+		}
+	}
+
+	// The engine recommended by the orchestrator wins over the one serving the content
+	recommended.Store("engine-idle")
+	client.updateHealth()
+	if engine, err := client.SelectBestEngineContext(withStreamKey(context.Background(), "abc")); err != nil || engine.ContainerID != "engine-idle" {
+		t.Errorf("Expected the recommended engine-idle, got %s (%v)", engine.ContainerID, err)
+	}
+	recommended.Store("")
+	client.updateHealth()
+
+	// A full engine is not joined
+	client.maxStreamsPerEngine = 1
+	if engine, err := client.SelectBestEngineContext(withStreamKey(context.Background(), "abc")); err != nil || engine.ContainerID != "engine-idle" {
+		t.Errorf("Expected engine-idle when the serving engine is full, got %s (%v)", engine.ContainerID, err)
+	}
+
+	// Without the preference, load decides as before
+	client.maxStreamsPerEngine = 2
+	client.clusterDedup = false
+	if engine, err := client.SelectBestEngineContext(withStreamKey(context.Background(), "abc")); err != nil || engine.ContainerID != "engine-idle" {
+		t.Errorf("Expected engine-idle without cluster deduplication, got %s (%v)", engine.ContainerID, err)
+	}
+}
@@ -0,0 +1,34 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"compress/gzip"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"strings"
+)
+
+// manifestGzip returns the writer compressing the M3U8 manifest for the client, nil when
+// compression is disabled, not accepted by the client or the MPEG-TS stream is served
+func (p *Proxy) manifestGzip(w http.ResponseWriter, r *http.Request) *gzip.Writer {
+	if !p.CompressManifest || p.Acexy.Endpoint != acexy.M3U8_ENDPOINT || !acceptsGzip(r) {
+		return nil
+	}
+	return gzip.NewWriter(w)
+}
+
+// acceptsGzip reports whether the client accepts gzip encoded responses, per its
+// `Accept-Encoding` header
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			q := strings.ReplaceAll(params, " ", "")
+			return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+		}
+	}
+	return false
+}
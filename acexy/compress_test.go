@@ -0,0 +1,90 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const testManifest = "#EXTM3U\n#EXT-X-TARGETDURATION:5\n#EXTINF:5.0,\nsegment1.ts\n"
+
+// newManifestTestProxy creates an M3U8 proxy whose engine serves testManifest
+func newManifestTestProxy(t *testing.T, compress bool) *Proxy {
+	var engine *httptest.Server
+	engine = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case string(acexy.M3U8_ENDPOINT):
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"playback_url": engine.URL + "/manifest",
+				"stat_url":     engine.URL + "/ace/stat/test/playback123",
+				"command_url":  engine.URL + "/ace/cmd/test/playback123",
+			}})
+		case "/manifest":
+			w.Header().Set("Content-Type", "application/x-mpegURL")
+			io.WriteString(w, testManifest)
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"response": "ok"})
+		}
+	}))
+	t.Cleanup(engine.Close)
+
+	engineURL, _ := url.Parse(engine.URL)
+	acexyInst := &acexy.Acexy{
+		Scheme:            engineURL.Scheme,
+		Host:              engineURL.Hostname(),
+		Port:              parsePort(engineURL.Port()),
+		Endpoint:          acexy.M3U8_ENDPOINT,
+		EmptyTimeout:      time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+	return &Proxy{Acexy: acexyInst, CompressManifest: compress, AllowNoDataCompletion: true}
+}
+
+// TestCompressManifest verifies a client accepting gzip gets a compressed, valid manifest
+// when enabled, and the others the plain one
+func TestCompressManifest(t *testing.T) {
+	proxy := newManifestTestProxy(t, true)
+
+	req := httptest.NewRequest(http.MethodGet, "/ace/manifest.m3u8?id=test123", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip;q=0.8")
+	w := httptest.NewRecorder()
+	proxy.HandleStream(w, req)
+
+	if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("Expected a gzip encoded manifest, got %q", enc)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Invalid gzip stream: %v", err)
+	}
+	if body, err := io.ReadAll(zr); err != nil || string(body) != testManifest {
+		t.Errorf("Expected the manifest once decompressed, got %q (%v)", body, err)
+	}
+
+	for _, tt := range []struct {
+		compress bool
+		accept   string
+	}{
+		{true, ""},
+		{true, "gzip;q=0"},
+		{false, "gzip"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/ace/manifest.m3u8?id=test123", nil)
+		req.Header.Set("Accept-Encoding", tt.accept)
+		w := httptest.NewRecorder()
+		newManifestTestProxy(t, tt.compress).HandleStream(w, req)
+
+		if enc := w.Header().Get("Content-Encoding"); enc != "" || w.Body.String() != testManifest {
+			t.Errorf("Compress %t, accept %q: expected the plain manifest, got %q encoded %q",
+				tt.compress, tt.accept, w.Body.String(), enc)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"fmt"
+	"javinator9889/acexy/lib/acexy"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Config holds every setting needed to build a Proxy. It is filled by `parseArgs` from the
+// command line and the environment, so the proxy and the orchestrator client never read
+// the environment themselves.
+type Config struct {
+	Addr          string        // Address the HTTP server listens on
+	TLSCert       string        // TLS certificate file. Together with TLSKey, enables HTTPS
+	TLSKey        string        // TLS private key file
+	RedirectHTTP  string        // Address of a plain HTTP listener redirecting to HTTPS (empty disables)
+	StreamTimeout time.Duration // Stream timeout (M3U8 mode)
+	DebugMode     bool          // Whether the debug logger is enabled
+	DebugLogDir   string        // Directory for the debug logs
+	DebugSink     string        // URL the debug logs are shipped to (empty keeps them local)
+	LogFormat     string        // Format of the logs, `text` or `json`
+
+	// Server timeouts. Stream responses are never timed.
+	ReadHeaderTimeout time.Duration // Time clients are given to send the request headers
+	IdleTimeout       time.Duration // Time an idle keep-alive connection is kept open
+
+	// AceStream middleware settings
+	Scheme            string        // Scheme used to reach the AceStream middleware
+	Host              string        // Fallback AceStream host
+	Port              int           // Fallback AceStream port
+	M3U8              bool          // Whether to serve the M3U8 endpoint instead of MPEG-TS
+	CompressManifest  bool          // Whether the M3U8 manifest is gzipped for the clients accepting it
+	ForceChunked      bool          // Whether MPEG-TS responses are always chunked, ignoring the engine Content-Length
+	EmptyTimeout      time.Duration // Timeout after which, if no data is written, the stream is closed
+	NoResponseTimeout time.Duration // Timeout to wait for a response from the AceStream middleware
+	BufferSize        Size          // The buffer size to use when copying the data, the size of the reads from the engine
+	WriteChunk        Size          // Maximum bytes written to the clients at once (0 uses BufferSize)
+	MaxStreamWorkers  int           // Maximum streams copied at once, the rest wait for a free worker (0 is unbounded)
+	APIPrefix         string        // Path prepended to the middleware endpoints (e.g. `/hls`)
+
+	AllowEngineRedirects bool // Whether the engine may redirect stream requests to other hosts
+
+	// Orchestrator settings
+	Orch OrchConfig
+
+	// Engine selection
+	RegionHeader             string // Header the preferred engine region is read from (empty disables it)
+	RefetchDuplicateSessions bool   // Whether streams getting the playback session ID of another one are fetched again
+	DedupByResolvedInfohash  bool   // Whether streams are keyed by the infohash the engine resolved their content ID to
+	WarmStandby              bool   // Whether a standby engine is selected to take streams over when their engine fails
+	StartRetries             int    // Other engines a stream is retried on when it fails before any data was delivered
+	RewriteEngineURLs        bool   // Whether the stat and command URLs are rewritten to the engine host acexy used
+	ExposeEngineHeaders      bool   // Whether the engine chosen by the orchestrator is reported in the response headers
+	AllowEnginePinning       bool   // Whether clients may pin a stream to an engine through the `engine` query parameter
+
+	// Time an engine has to send the first byte of a stream before it is marked as failing and
+	// the stream moves to another engine, instead of waiting for NoResponseTimeout (0 disables)
+	FirstByteFailoverTimeout time.Duration
+
+	// Time the addresses the engine host names resolve to are cached (0 resolves them on each
+	// connection)
+	ResolveCacheTTL time.Duration
+
+	// Engine fallback chain
+	FallbackChain       string        // Ordered engine sources, empty to use the orchestrator and then Host/Port
+	FallbackHopTimeout  time.Duration // Time each static engine hop of the chain is given to provide an engine
+	FallbackOrchTimeout time.Duration // Time the orchestrator hop of the chain is given to provide an engine
+
+	// Admin and protection settings
+	AdminToken          string        // Token required to access the admin endpoints
+	BadContentThreshold int           // Middleware errors for the same ID before it is blocked
+	BadContentWindow    time.Duration // Window in which middleware errors are counted
+	BadContentTTL       time.Duration // How long a repeatedly failing ID is blocked
+	DenyList            string        // Content IDs rejected with a 403, inline or `@file`
+	AllowList           string        // Only content IDs streamed when set, inline or `@file`
+	RateLimit           float64       // Stream requests per second allowed for each client IP (0 disables)
+	RateLimitBurst      int           // Stream requests a client IP may perform at once
+	MaxStreamsPerClient int           // Streams each client IP may have open at once (0 is unbounded)
+	ClientByteQuota     Size          // Bytes each client IP may receive within the window before it is disconnected (0 disables)
+
+	ClientByteQuotaWindow time.Duration // Window the client byte quota applies to (0 never resets it)
+
+	// Whether `/metrics` is served in the OpenMetrics format, with the request IDs of example
+	// requests as exemplars of the latency histograms, to the scrapers asking for it
+	OpenMetricsExemplars bool
+
+	// Optional features
+	EnableAux         bool          // Whether auxiliary middleware resources are relayed through `/ace/aux`
+	HideRoot          bool          // Whether `/` returns a 404 instead of the license
+	VerboseStatus     bool          // Whether `/ace/status` always includes the health summary
+	SignalDrain       bool          // Whether SIGUSR1 toggles the drain mode and SIGUSR2 logs the active streams
+	ShutdownGrace     time.Duration // Time the active streams are given to finish on SIGINT/SIGTERM before being terminated
+	IgnoreClientPID   bool          // Whether a client `pid` parameter is dropped instead of rejecting the request
+	IDPrecedence      string        // Which of `id` and `infohash` is used when both are given: `strict`, `id` or `infohash`
+	OnStreamStart     string        // Command run when a stream starts
+	OnStreamEnd       string        // Command run when a stream ends
+	HookTimeout       time.Duration // Time after which a stream hook is killed
+	EventSinkURL      string        // Comma separated URLs of the message queues stream events are published to
+	KeepaliveInterval time.Duration // Interval of the stat URL pings keeping engine sessions warm (0 disables)
+	StuckThreshold    time.Duration // Time a stream may deliver no data before it is reported as stuck (0 disables)
+	EarlyEOFThreshold time.Duration // Streams ending with an EOF before being served this long are reported as `early_eof` (0 disables)
+
+	AllowNoDataCompletion bool // Whether streams ending cleanly without any data are reported as `completed` instead of `no_data`
+
+	ProvisionHoldingResponse bool   // Whether a placeholder is served instead of a 503 while an engine is provisioned
+	ProvisionHoldingClip     string // MPEG-TS clip looped as placeholder (empty keeps the 503 in MPEG-TS mode)
+	ErrorClip                string // MPEG-TS clip written to clients whose stream failed (empty disables it)
+
+	InboundRequestIDHeader string // Header the request ID of the caller is adopted from (empty always generates one)
+}
+
+// OrchConfig holds the settings of the orchestrator client
+type OrchConfig struct {
+	URL                 string        // Orchestrator base URL. Empty disables the integration
+	APIKey              string        // API key sent as a bearer token
+	ContainerID         string        // Container ID of this acexy instance, reported in events
+	InstanceID          string        // ID of this acexy instance, reported in events (a UUID when empty)
+	MaxStreamsPerEngine int           // Maximum streams per engine
+	EndedStreamsCap     int           // Ended streams remembered to deduplicate their ended event
+	MinClientsForEvent  int           // Concurrent clients of the same ID before `stream_started` is emitted
+	MinReadyEngines     int           // Healthy engines with a free stream slot required by `/readyz`
+	FailReadyOnAuth     bool          // Whether `/readyz` fails while the orchestrator rejects the API key
+	SelectionRetries    int           // Retries of orchestrator queries failing with a 5xx during the engine selection
+	LabelSelector       LabelSelector // Labels an engine must carry to be used, also set on provisioned engines
+	RequestTimeout      time.Duration // Timeout of each orchestrator request
+	EngineCacheDuration time.Duration // How long the engine list is cached
+	HealthMaxStaleness  time.Duration // Age after which the orchestrator health is considered unknown (0 disables)
+	ReconcileInterval   time.Duration // Interval of the stream reconciliation with the orchestrator (0 disables)
+	CancelPollInterval  time.Duration // Interval of the checks for streams cancelled by the orchestrator (0 disables)
+	ProbeEngineVersion  bool          // Whether the AceStream version of each engine is probed on its first use
+	PreferWarmCache     bool          // Whether the engine that last served a content is preferred for it
+	CostAwareSelection  bool          // Whether cheaper engines, per their `acexy.cost` label, are preferred over less loaded ones
+
+	PropagateEngineLabels bool // Whether the labels of the selected engine are added to the `stream_started` events
+	BatchEvents           bool // Whether the stream events are coalesced and sent to `/events/batch`
+
+	EngineStreamCacheTTL time.Duration // How long the active stream count of each engine is cached (0 disables)
+	VerifyEngineIdentity bool          // Whether the engine container is checked against the orchestrator before its use
+
+	MaxConcurrentProvisions       int // Maximum engines provisioned at once (0 is unbounded)
+	MaxProvisionAttemptsPerMinute int // Provisioning attempts allowed per minute across the process (0 is unbounded)
+
+	EagerRecoverProvision bool // Whether an engine is provisioned when provisioning recovers and none is free
+	ClusterDedup          bool // Whether the engine already streaming the requested content is preferred
+
+	NewEngineProbation time.Duration // Window a new engine failing its first stream is quarantined in (0 disables)
+
+	RespectClusterCapacity bool // Whether streams are refused once the orchestrator reports no capacity left
+
+	CancelOrphanProvisions bool   // Whether engines provisioned for requests that are gone are removed
+	CancelProvisionPath    string // Orchestrator endpoint removing a provisioned engine, `{id}` is its container ID
+}
+
+// Endpoint returns the AceStream endpoint matching the configured mode
+func (c Config) Endpoint() acexy.AcexyEndpoint {
+	if c.M3U8 {
+		return acexy.M3U8_ENDPOINT
+	}
+	return acexy.MPEG_TS_ENDPOINT
+}
+
+// normalizeAPIPrefix validates the middleware path prefix, returning it with a leading and
+// without a trailing slash so it can be prepended to the endpoints. Empty is allowed.
+func normalizeAPIPrefix(prefix string) (string, error) {
+	prefix = strings.TrimRight(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return "", nil
+	}
+	if strings.Contains(prefix, "://") {
+		return "", fmt.Errorf("invalid API prefix %q: only a path is allowed, not a URL", prefix)
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	u, err := url.Parse(prefix)
+	if err != nil {
+		return "", fmt.Errorf("invalid API prefix %q: %w", prefix, err)
+	}
+	if u.Scheme != "" || u.Host != "" || u.RawQuery != "" || u.Fragment != "" || u.Path != prefix {
+		return "", fmt.Errorf("invalid API prefix %q: only a plain path is allowed", prefix)
+	}
+	return prefix, nil
+}
+
+// normalizeBufferSize makes sure the buffer fits at least one TS packet in MPEG-TS mode,
+// rounding smaller sizes up with a warning. Zero keeps the default buffer size.
+func normalizeBufferSize(size uint64, endpoint acexy.AcexyEndpoint) uint64 {
+	if endpoint != acexy.MPEG_TS_ENDPOINT || size == 0 || size >= acexy.TS_PACKET_SIZE {
+		return size
+	}
+	slog.Warn("Buffer size is smaller than a TS packet, rounding up",
+		"buffer", size, "minimum", acexy.TS_PACKET_SIZE)
+	return acexy.TS_PACKET_SIZE
+}
+
+// NewProxy builds the proxy, its AceStream middleware client and, when configured, the
+// orchestrator client from the given configuration
+func NewProxy(cfg Config) *Proxy {
+	if cfg.Orch.InstanceID == "" {
+		cfg.Orch.InstanceID = uuid.NewString()
+	}
+	slog.Info("Acexy instance", "instance_id", cfg.Orch.InstanceID)
+
+	orch := newOrchClient(cfg.Orch)
+	if orch != nil {
+		slog.Info("Orchestrator integration enabled", "url", cfg.Orch.URL, "max_streams_per_engine", orch.maxStreamsPerEngine)
+	} else {
+		slog.Info("Orchestrator integration disabled - using fallback engine configuration", "host", cfg.Host, "port", cfg.Port)
+	}
+
+	fallback, err := parseFallbackChain(cfg.FallbackChain, cfg.FallbackHopTimeout, cfg.FallbackOrchTimeout)
+	if err != nil {
+		slog.Error("Invalid fallback chain, ignoring it", "chain", cfg.FallbackChain, "error", err)
+	} else if fallback != nil {
+		slog.Info("Engine fallback chain enabled", "chain", cfg.FallbackChain, "hop_timeout", cfg.FallbackHopTimeout, "orchestrator_timeout", cfg.FallbackOrchTimeout)
+	}
+
+	holding, err := newProvisionHolding(cfg.ProvisionHoldingResponse, cfg.ProvisionHoldingClip)
+	if err != nil {
+		slog.Error("Invalid holding clip, ignoring it", "clip", cfg.ProvisionHoldingClip, "error", err)
+		holding, _ = newProvisionHolding(cfg.ProvisionHoldingResponse, "")
+	}
+
+	errorClip, err := newErrorClip(cfg.ErrorClip)
+	if err != nil {
+		slog.Error("Invalid error clip, ignoring it", "clip", cfg.ErrorClip, "error", err)
+	}
+
+	events, err := newEventSinks(cfg.EventSinkURL)
+	if err != nil {
+		slog.Error("Invalid event sink, ignoring it", "sink", redactEventSinks(cfg.EventSinkURL), "error", err)
+	} else if events != nil {
+		slog.Info("Publishing stream events", "sink", redactEventSinks(cfg.EventSinkURL))
+	}
+
+	filter, err := newContentFilter(cfg.DenyList, cfg.AllowList)
+	if err != nil {
+		slog.Error("Failed to load the content lists, fix and reload them", "error", err)
+	}
+
+	acexyInst := &acexy.Acexy{
+		Scheme:            cfg.Scheme,
+		Host:              cfg.Host,
+		Port:              cfg.Port,
+		Endpoint:          cfg.Endpoint(),
+		APIPrefix:         cfg.APIPrefix,
+		EmptyTimeout:      cfg.EmptyTimeout,
+		BufferSize:        int(cfg.BufferSize.Bytes),
+		WriteChunk:        int(cfg.WriteChunk.Bytes),
+		NoResponseTimeout: cfg.NoResponseTimeout,
+		MaxStreamWorkers:  cfg.MaxStreamWorkers,
+		FirstByteTimeout:  cfg.FirstByteFailoverTimeout,
+
+		AllowForeignRedirects: cfg.AllowEngineRedirects,
+		ResolveCacheTTL:       cfg.ResolveCacheTTL,
+	}
+	acexyInst.Init()
+
+	p := &Proxy{
+		Acexy:      acexyInst,
+		Orch:       orch,
+		InstanceID: cfg.Orch.InstanceID,
+		AdminToken: cfg.AdminToken,
+		BadContent: newBadContentCache(cfg.BadContentThreshold, cfg.BadContentWindow, cfg.BadContentTTL),
+		Filter:     filter,
+		RateLimit:  newRateLimiter(cfg.RateLimit, cfg.RateLimitBurst),
+		Fallback:   fallback,
+		Hooks:      newStreamHooks(cfg.OnStreamStart, cfg.OnStreamEnd, cfg.HookTimeout),
+		Events:     events,
+		Keepalive:  newKeepalive(cfg.KeepaliveInterval),
+		Holding:    holding,
+		ErrorClip:  errorClip,
+		Reconciler: newReconciler(cfg.Orch.ReconcileInterval),
+		Cancels:    newCancelWatcher(cfg.Orch.CancelPollInterval),
+		Stuck:      newStuckWatcher(cfg.StuckThreshold),
+		EnableAux:  cfg.EnableAux,
+		HideRoot:   cfg.HideRoot,
+
+		ClientQuota:              newClientQuota(cfg.ClientByteQuota.Bytes, cfg.ClientByteQuotaWindow),
+		ClientStreams:            newClientStreamLimit(cfg.MaxStreamsPerClient),
+		VerboseStatus:            cfg.VerboseStatus,
+		OpenMetricsExemplars:     cfg.OpenMetricsExemplars,
+		CompressManifest:         cfg.CompressManifest,
+		ForceChunked:             cfg.ForceChunked,
+		RegionHeader:             cfg.RegionHeader,
+		InboundRequestIDHeader:   cfg.InboundRequestIDHeader,
+		RefetchDuplicateSessions: cfg.RefetchDuplicateSessions,
+		DedupByResolvedInfohash:  cfg.DedupByResolvedInfohash,
+		WarmStandby:              cfg.WarmStandby,
+		StartRetries:             cfg.StartRetries,
+		RewriteEngineURLs:        cfg.RewriteEngineURLs,
+		ExposeEngineHeaders:      cfg.ExposeEngineHeaders,
+		EarlyEOFThreshold:        cfg.EarlyEOFThreshold,
+		AllowNoDataCompletion:    cfg.AllowNoDataCompletion,
+		AllowEnginePinning:       cfg.AllowEnginePinning,
+		IgnoreClientPID:          cfg.IgnoreClientPID,
+		IDPrecedence:             acexy.IDPrecedence(cfg.IDPrecedence),
+		MinClientsForEvent:       cfg.Orch.MinClientsForEvent,
+		MinReadyEngines:          cfg.Orch.MinReadyEngines,
+		FailReadyOnOrchAuth:      cfg.Orch.FailReadyOnAuth,
+		Config:                   &cfg,
+	}
+	if orch != nil {
+		go p.Reconciler.Run(orch.ctx, orch, &p.streams)
+		go p.Cancels.Run(orch.ctx, orch, &p.streams)
+		go p.Stuck.Run(orch.ctx, orch, &p.streams)
+	} else {
+		go p.Stuck.Run(context.Background(), nil, &p.streams)
+	}
+	return p
+}
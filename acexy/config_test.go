@@ -0,0 +1,130 @@
+package main
+
+import (
+	"javinator9889/acexy/lib/acexy"
+	"testing"
+	"time"
+)
+
+// TestNewOrchClientFromConfig verifies the orchestrator client takes its settings from the
+// configuration and ignores the environment
+func TestNewOrchClientFromConfig(t *testing.T) {
+	t.Setenv("ACEXY_ORCH_APIKEY", "from-env")
+	t.Setenv("ACEXY_CONTAINER_ID", "env-container")
+
+	client := newOrchClient(OrchConfig{
+		URL:                 "http://127.0.0.1:0",
+		APIKey:              "from-config",
+		ContainerID:         "config-container",
+		MaxStreamsPerEngine: 3,
+		RequestTimeout:      5 * time.Second,
+	})
+	defer client.Close()
+
+	if client.key != "from-config" {
+		t.Errorf("Expected API key from config, got %q", client.key)
+	}
+	if client.containerID != "config-container" {
+		t.Errorf("Expected container ID from config, got %q", client.containerID)
+	}
+	if client.maxStreamsPerEngine != 3 {
+		t.Errorf("Expected 3 max streams per engine, got %d", client.maxStreamsPerEngine)
+	}
+	if client.hc.Timeout != 5*time.Second {
+		t.Errorf("Expected 5s request timeout, got %v", client.hc.Timeout)
+	}
+	if client.engineCacheDuration != 2*time.Second {
+		t.Errorf("Expected default 2s engine cache duration, got %v", client.engineCacheDuration)
+	}
+}
+
+// TestNewOrchClientDisabled verifies no client is created without an orchestrator URL
+func TestNewOrchClientDisabled(t *testing.T) {
+	if client := newOrchClient(OrchConfig{}); client != nil {
+		t.Error("Expected nil client when no URL is configured")
+	}
+}
+
+// TestNewProxyFromConfig verifies the proxy and its dependencies are built from the config
+func TestNewProxyFromConfig(t *testing.T) {
+	cfg := Config{
+		Scheme:              "https",
+		Host:                "engine.local",
+		Port:                6879,
+		M3U8:                true,
+		EmptyTimeout:        5 * time.Second,
+		NoResponseTimeout:   7 * time.Second,
+		BufferSize:          Size{Bytes: 2048},
+		AdminToken:          "secret",
+		BadContentThreshold: 2,
+		BadContentWindow:    time.Minute,
+		BadContentTTL:       time.Minute,
+	}
+
+	proxy := NewProxy(cfg)
+
+	if proxy.Orch != nil {
+		t.Error("Expected no orchestrator client without URL")
+	}
+	if proxy.Acexy.Scheme != "https" || proxy.Acexy.Host != "engine.local" || proxy.Acexy.Port != 6879 {
+		t.Errorf("Unexpected engine address %s://%s:%d", proxy.Acexy.Scheme, proxy.Acexy.Host, proxy.Acexy.Port)
+	}
+	if proxy.Acexy.Endpoint != acexy.M3U8_ENDPOINT {
+		t.Errorf("Expected M3U8 endpoint, got %s", proxy.Acexy.Endpoint)
+	}
+	if proxy.Acexy.BufferSize != 2048 {
+		t.Errorf("Expected buffer size 2048, got %d", proxy.Acexy.BufferSize)
+	}
+	if proxy.AdminToken != "secret" {
+		t.Errorf("Expected admin token from config, got %q", proxy.AdminToken)
+	}
+	if proxy.BadContent == nil {
+		t.Error("Expected the bad content cache to be enabled")
+	}
+}
+
+// TestNormalizeAPIPrefix verifies API prefixes are normalized and invalid ones rejected
+func TestNormalizeAPIPrefix(t *testing.T) {
+	valid := map[string]string{
+		"":        "",
+		"/":       "",
+		"/hls":    "/hls",
+		"hls/":    "/hls",
+		"/api/v2": "/api/v2",
+	}
+	for input, expected := range valid {
+		got, err := normalizeAPIPrefix(input)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", input, err)
+		} else if got != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, input, got)
+		}
+	}
+
+	for _, invalid := range []string{"http://engine/hls", "/hls?x=1", "/hls#frag", "/h ls%zz"} {
+		if _, err := normalizeAPIPrefix(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+// TestNormalizeBufferSize verifies sub-packet buffers are rounded up in MPEG-TS mode only
+func TestNormalizeBufferSize(t *testing.T) {
+	cases := []struct {
+		size     uint64
+		endpoint acexy.AcexyEndpoint
+		expected uint64
+	}{
+		{0, acexy.MPEG_TS_ENDPOINT, 0},
+		{1, acexy.MPEG_TS_ENDPOINT, acexy.TS_PACKET_SIZE},
+		{187, acexy.MPEG_TS_ENDPOINT, acexy.TS_PACKET_SIZE},
+		{188, acexy.MPEG_TS_ENDPOINT, 188},
+		{4096, acexy.MPEG_TS_ENDPOINT, 4096},
+		{100, acexy.M3U8_ENDPOINT, 100},
+	}
+	for _, c := range cases {
+		if got := normalizeBufferSize(c.size, c.endpoint); got != c.expected {
+			t.Errorf("Expected %d for %d (%s), got %d", c.expected, c.size, c.endpoint, got)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// contentFilter holds the content IDs and infohashes that are denied, and, when an allow
+// list is set, the only ones that may be streamed. Each list is either inline, as comma
+// separated IDs, or read from the file following an `@`, with an ID per line. Files are read
+// again through `/admin/reload`.
+type contentFilter struct {
+	denySource  string
+	allowSource string
+
+	mu    sync.RWMutex
+	deny  map[string]struct{}
+	allow map[string]struct{} // nil when every content not denied is allowed
+}
+
+// newContentFilter creates the filter of the given lists. Returns nil (disabled) when both
+// are empty. When a list fails to load, the filter is still returned with empty lists, so an
+// allow list rejects everything until it is fixed and reloaded.
+func newContentFilter(deny, allow string) (*contentFilter, error) {
+	if deny == "" && allow == "" {
+		return nil, nil
+	}
+	f := &contentFilter{denySource: deny, allowSource: allow, deny: map[string]struct{}{}}
+	if allow != "" {
+		f.allow = map[string]struct{}{}
+	}
+	return f, f.Reload()
+}
+
+// Reload reads the lists again, keeping the current ones when any of them fails to load
+func (f *contentFilter) Reload() error {
+	deny, err := loadContentList(f.denySource)
+	if err != nil {
+		return fmt.Errorf("failed to load the deny list: %w", err)
+	}
+	allow, err := loadContentList(f.allowSource)
+	if err != nil {
+		return fmt.Errorf("failed to load the allow list: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deny = deny
+	if f.allowSource != "" {
+		f.allow = allow
+	}
+	return nil
+}
+
+// Allowed reports whether the content with the given ID or infohash may be streamed
+func (f *contentFilter) Allowed(id string) bool {
+	if f == nil {
+		return true
+	}
+	id = strings.ToLower(id)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if _, denied := f.deny[id]; denied {
+		return false
+	}
+	if f.allow != nil {
+		_, allowed := f.allow[id]
+		return allowed
+	}
+	return true
+}
+
+// Len returns the number of denied and allowed IDs
+func (f *contentFilter) Len() (deny int, allow int) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.deny), len(f.allow)
+}
+
+// loadContentList parses an inline list or, when prefixed with `@`, reads it from a file
+// skipping blank lines and `#` comments
+func loadContentList(source string) (map[string]struct{}, error) {
+	ids := make(map[string]struct{})
+	if source == "" {
+		return ids, nil
+	}
+
+	path, isFile := strings.CutPrefix(source, "@")
+	if !isFile {
+		for _, id := range strings.Split(source, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids[strings.ToLower(id)] = struct{}{}
+			}
+		}
+		return ids, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if id := strings.TrimSpace(line); id != "" {
+			ids[strings.ToLower(id)] = struct{}{}
+		}
+	}
+	return ids, scanner.Err()
+}
+
+// HandleAdminReload reads the content allow and deny list files again
+func (p *Proxy) HandleAdminReload(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizeAdmin(w, r) {
+		return
+	}
+	if p.Filter == nil {
+		http.Error(w, "Content lists not configured", http.StatusNotFound)
+		return
+	}
+
+	if err := p.Filter.Reload(); err != nil {
+		slog.Warn("Failed to reload the content lists, keeping the current ones", "error", err)
+		http.Error(w, "Failed to reload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deny, allow := p.Filter.Len()
+	slog.Info("Content lists reloaded", "denied", deny, "allowed", allow)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"denied":  deny,
+		"allowed": allow,
+	})
+}
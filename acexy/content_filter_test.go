@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestContentFilterDecisions verifies the allow and deny decisions of the content lists
+func TestContentFilterDecisions(t *testing.T) {
+	tests := []struct {
+		name    string
+		deny    string
+		allow   string
+		id      string
+		allowed bool
+	}{
+		{"no lists", "", "", "abc", true},
+		{"denied", "abc, def", "", "abc", false},
+		{"denied ignoring case", "ABC", "", "abc", false},
+		{"not denied", "abc", "", "xyz", true},
+		{"allowed", "", "abc,def", "def", true},
+		{"not allowed", "", "abc,def", "xyz", false},
+		{"denied over allowed", "abc", "abc", "abc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newContentFilter(tt.deny, tt.allow)
+			if err != nil {
+				t.Fatalf("Failed to create the filter: %v", err)
+			}
+			if got := filter.Allowed(tt.id); got != tt.allowed {
+				t.Errorf("Expected allowed %v for %q, got %v", tt.allowed, tt.id, got)
+			}
+		})
+	}
+}
+
+// TestContentFilterRejectsWithoutEngine verifies denied content gets a 403 without the
+// engine being contacted
+func TestContentFilterRejectsWithoutEngine(t *testing.T) {
+	proxy, _, fetches := newDuplicateSessionProxy(t, "playback123")
+	proxy.Filter, _ = newContentFilter("", "allowed123")
+
+	for _, target := range []string{"/ace/getstream?id=test123", "/ace/getstream?infohash=test123"} {
+		w := httptest.NewRecorder()
+		proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 for %s, got %d", target, w.Code)
+		}
+	}
+	if fetches.Load() != 0 {
+		t.Errorf("Expected the engine not to be contacted, got %d fetches", fetches.Load())
+	}
+}
+
+// TestAdminReload verifies the list files are read again on reload, keeping the current
+// lists when a file cannot be read
+func TestAdminReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.txt")
+	if err := os.WriteFile(path, []byte("# Blocked content\nabc\n"), 0644); err != nil {
+		t.Fatalf("Failed to write the deny list: %v", err)
+	}
+	filter, err := newContentFilter("@"+path, "")
+	if err != nil {
+		t.Fatalf("Failed to create the filter: %v", err)
+	}
+	proxy := &Proxy{AdminToken: "secret", Filter: filter}
+
+	reload := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+		req.Header.Set("X-Admin-Token", "secret")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if err := os.WriteFile(path, []byte("def\n"), 0644); err != nil {
+		t.Fatalf("Failed to update the deny list: %v", err)
+	}
+	if code := reload(); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if !filter.Allowed("abc") || filter.Allowed("def") {
+		t.Error("Expected the updated deny list to be used")
+	}
+
+	os.Remove(path)
+	if code := reload(); code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500 for a missing file, got %d", code)
+	}
+	if filter.Allowed("def") {
+		t.Error("Expected the current lists to be kept after a failed reload")
+	}
+}
@@ -0,0 +1,24 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The engine label holding the relative cost of running the engine (e.g. lower for spot
+// instances than for on-demand ones)
+const ENGINE_COST_LABEL = "acexy.cost"
+
+// engineCost returns the cost the engine is labeled with. Engines without a valid cost
+// label cost 0, the same as the cheapest ones.
+func engineCost(engine engineState) float64 {
+	cost, err := strconv.ParseFloat(strings.TrimSpace(engine.Labels[ENGINE_COST_LABEL]), 64)
+	if err != nil || cost < 0 {
+		return 0
+	}
+	return cost
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestSelectBestEngineCost verifies cheaper engines win over equally loaded ones when cost
+// aware selection is enabled, until they are full
+func TestSelectBestEngineCost(t *testing.T) {
+	engines := []engineState{
+		{
+			// Forwarded, so preferred at equal load without costs
+			ContainerID:  "engine-on-demand",
+			Host:         "host-on-demand",
+			Port:         8001,
+			HealthStatus: "healthy",
+			Forwarded:    true,
+			Labels:       map[string]string{ENGINE_COST_LABEL: "3"},
+		},
+		{
+			ContainerID:  "engine-spot",
+			Host:         "host-spot",
+			Port:         8002,
+			HealthStatus: "healthy",
+			Labels:       map[string]string{ENGINE_COST_LABEL: "1"},
+		},
+		{
+			ContainerID:  "engine-spot-full",
+			Host:         "host-spot-full",
+			Port:         8003,
+			HealthStatus: "healthy",
+			Labels:       map[string]string{ENGINE_COST_LABEL: "0.5"},
+		},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode(engines)
+		case "/streams":
+			streams := []streamState{}
+			if r.URL.Query().Get("container_id") == "engine-spot-full" {
+				streams = append(streams, streamState{ID: "s1", Status: "started"})
+			}
+			json.NewEncoder(w).Encode(streams)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, tt := range []struct {
+		costAware bool
+		expected  string
+	}{
+		{false, "engine-on-demand"},
+		{true, "engine-spot"},
+	} {
+		client := &orchClient{
+			base:                server.URL,
+			maxStreamsPerEngine: 1,
+			hc:                  &http.Client{Timeout: 3 * time.Second},
+			ctx:                 ctx,
+			cancel:              cancel,
+			costAware:           tt.costAware,
+		}
+		client.health.canProvision = true
+
+		engine, err := client.SelectBestEngineContext(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected selection error (cost aware: %v): %v", tt.costAware, err)
+		}
+		if engine.ContainerID != tt.expected {
+			t.Errorf("Cost aware %v: expected %s to be selected, got %s", tt.costAware, tt.expected, engine.ContainerID)
+		}
+	}
+}
+
+// TestEngineCost verifies engines without a valid cost label cost nothing
+func TestEngineCost(t *testing.T) {
+	for label, expected := range map[string]float64{
+		"":     0,
+		"2.5":  2.5,
+		" 4 ":  4,
+		"-1":   0,
+		"high": 0,
+	} {
+		engine := engineState{Labels: map[string]string{ENGINE_COST_LABEL: label}}
+		if got := engineCost(engine); got != expected {
+			t.Errorf("Label %q: expected a cost of %g, got %g", label, expected, got)
+		}
+	}
+	if got := engineCost(engineState{}); got != 0 {
+		t.Errorf("Expected no cost without labels, got %g", got)
+	}
+}
@@ -0,0 +1,81 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Number of ended streams kept to summarize the disconnect reasons, and the window the
+// summary covers
+const (
+	DISCONNECT_LOG_SIZE = 1000
+	DISCONNECT_WINDOW   = 15 * time.Minute
+)
+
+type disconnectRecord struct {
+	reason string
+	at     time.Time
+}
+
+// disconnectLog is a ring buffer of the reasons the latest streams ended with, as
+// classified by classifyDisconnectReason. The zero value is ready to use.
+type disconnectLog struct {
+	mu      sync.Mutex
+	records [DISCONNECT_LOG_SIZE]disconnectRecord
+	next    int
+	count   int
+}
+
+// Record adds the reason a stream ended with, replacing the oldest one when full
+func (l *disconnectLog) Record(reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records[l.next] = disconnectRecord{reason: reason, at: time.Now()}
+	l.next = (l.next + 1) % DISCONNECT_LOG_SIZE
+	if l.count < DISCONNECT_LOG_SIZE {
+		l.count++
+	}
+}
+
+// Summary counts the reasons of the streams ended within the given window
+func (l *disconnectLog) Summary(window time.Duration) map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	since := time.Now().Add(-window)
+	summary := make(map[string]int)
+	for i := 0; i < l.count; i++ {
+		if record := l.records[i]; record.at.After(since) {
+			summary[record.reason]++
+		}
+	}
+	return summary
+}
+
+// HandleAdminDisconnects returns how many streams ended with each reason within the last
+// DISCONNECT_WINDOW
+func (p *Proxy) HandleAdminDisconnects(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizeAdmin(w, r) {
+		return
+	}
+
+	reasons := p.disconnects.Summary(DISCONNECT_WINDOW)
+	total := 0
+	for _, count := range reasons {
+		total += count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"window":  DISCONNECT_WINDOW.String(),
+		"total":   total,
+		"reasons": reasons,
+	})
+}
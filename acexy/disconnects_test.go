@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestAdminDisconnects verifies the recorded disconnect reasons are counted in the summary
+func TestAdminDisconnects(t *testing.T) {
+	proxy := &Proxy{AdminToken: "secret"}
+	for _, reason := range []string{"completed", "client_disconnected", "empty_timeout", "client_disconnected", "client_disconnected"} {
+		proxy.disconnects.Record(reason)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/disconnects", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var summary struct {
+		Window  string         `json:"window"`
+		Total   int            `json:"total"`
+		Reasons map[string]int `json:"reasons"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil {
+		t.Fatalf("Failed to decode summary: %v", err)
+	}
+	if summary.Total != 5 || summary.Window != DISCONNECT_WINDOW.String() {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	expected := map[string]int{"completed": 1, "client_disconnected": 3, "empty_timeout": 1}
+	for reason, count := range expected {
+		if summary.Reasons[reason] != count {
+			t.Errorf("Expected %d %s disconnects, got %d", count, reason, summary.Reasons[reason])
+		}
+	}
+}
+
+// TestDisconnectLogWindow verifies old and overwritten records are left out of the summary
+func TestDisconnectLogWindow(t *testing.T) {
+	var log disconnectLog
+	for i := 0; i < DISCONNECT_LOG_SIZE+10; i++ {
+		log.Record("timeout")
+	}
+	if got := log.Summary(time.Minute)["timeout"]; got != DISCONNECT_LOG_SIZE {
+		t.Errorf("Expected the buffer to keep %d records, got %d", DISCONNECT_LOG_SIZE, got)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	log.Record("completed")
+	summary := log.Summary(10 * time.Millisecond)
+	if summary["timeout"] != 0 || summary["completed"] != 1 {
+		t.Errorf("Expected only the recent record within the window, got %v", summary)
+	}
+}
@@ -0,0 +1,100 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Seconds clients rejected while draining are told to wait before retrying, usually
+// reaching another replica meanwhile
+const DRAIN_RETRY_AFTER = 30
+
+// Draining reports whether new streams are rejected while the active ones finish
+func (p *Proxy) Draining() bool {
+	return p.draining.Load()
+}
+
+// SetDraining enables or disables the drain mode, in which new streams are rejected with a
+// 503 and `/readyz` fails, while the active streams keep being served
+func (p *Proxy) SetDraining(draining bool) {
+	if p.draining.Swap(draining) != draining {
+		slog.Info("Drain mode changed", "draining", draining, "active_streams", p.streams.Len())
+	}
+}
+
+// rejectDraining answers a new stream request while draining. Returns whether it did.
+func (p *Proxy) rejectDraining(w http.ResponseWriter) bool {
+	if !p.Draining() {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(DRAIN_RETRY_AFTER))
+	http.Error(w, "Draining, not accepting new streams", http.StatusServiceUnavailable)
+	return true
+}
+
+// HandleAdminDrain enables the drain mode on POST and disables it on DELETE, returning the
+// resulting state and the streams still being served
+func (p *Proxy) HandleAdminDrain(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizeAdmin(w, r) {
+		return
+	}
+	p.SetDraining(r.Method == http.MethodPost)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"draining":       p.Draining(),
+		"active_streams": p.streams.Len(),
+	})
+}
+
+// logActiveStreams logs each of the streams currently being served
+func (p *Proxy) logActiveStreams() {
+	streams := p.streams.List()
+	slog.Info("Active streams", "count", len(streams), "draining", p.Draining())
+	for _, stream := range streams {
+		slog.Info("Active stream", "stream_id", stream.StreamID(), "ace_id", stream.AceID,
+			"client", stream.Client, "engine_host", stream.EngineHost, "engine_port", stream.EnginePort,
+			"container_id", stream.ContainerID, "duration", time.Since(stream.StartedAt).Round(time.Second),
+			"bytes_sent", stream.BytesSent())
+	}
+}
+
+// notifyControlSignals starts relaying SIGUSR1 and SIGUSR2 to the returned channel instead
+// of terminating the process
+func notifyControlSignals() chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	return signals
+}
+
+// handleSignals toggles the drain mode on SIGUSR1 and logs the active streams on SIGUSR2,
+// giving operators a way to drain acexy before shutting it down without HTTP, until the
+// context is done
+func (p *Proxy) handleSignals(ctx context.Context, signals chan os.Signal) {
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			switch sig {
+			case syscall.SIGUSR1:
+				p.SetDraining(!p.Draining())
+			case syscall.SIGUSR2:
+				p.logActiveStreams()
+			}
+		}
+	}
+}
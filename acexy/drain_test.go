@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestAdminDrain verifies new streams are rejected and readiness fails while draining
+func TestAdminDrain(t *testing.T) {
+	proxy, _, fetches := newDuplicateSessionProxy(t, "playback123")
+	proxy.AdminToken = "secret"
+
+	drain := func(method string) {
+		req := httptest.NewRequest(method, "/admin/drain", nil)
+		req.Header.Set("X-Admin-Token", "secret")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d", method, w.Code)
+		}
+	}
+
+	drain(http.MethodPost)
+	w := httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a 503 with Retry-After while draining, got %d", w.Code)
+	}
+	if fetches.Load() != 0 {
+		t.Errorf("Expected the engine not to be contacted while draining, got %d fetches", fetches.Load())
+	}
+	if ready := proxy.checkReadiness(); ready.Ready || ready.Error != "draining" {
+		t.Errorf("Expected not to be ready while draining, got %+v", ready)
+	}
+
+	drain(http.MethodDelete)
+	if proxy.Draining() {
+		t.Error("Expected the drain mode to be disabled")
+	}
+}
+
+// TestSignalDrain verifies SIGUSR1 toggles the drain mode
+func TestSignalDrain(t *testing.T) {
+	proxy := &Proxy{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go proxy.handleSignals(ctx, notifyControlSignals())
+
+	for _, expected := range []bool{true, false} {
+		if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+			t.Fatalf("Failed to send SIGUSR1: %v", err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for proxy.Draining() != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected draining to be %v after SIGUSR1", expected)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
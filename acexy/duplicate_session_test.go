@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newDuplicateSessionProxy creates a proxy backed by a mock engine handing out the given
+// playback session IDs in turn, the last one being repeated. Every content resolves to the
+// same infohash. Streams are served until the returned channel is closed, and the number
+// of fetches is reported by the returned counter.
+func newDuplicateSessionProxy(t *testing.T, sessions ...string) (*Proxy, chan struct{}, *atomic.Int32) {
+	release := make(chan struct{})
+	var fetches atomic.Int32
+
+	var engine *httptest.Server
+	engine = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/ace/getstream":
+			n := int(fetches.Add(1))
+			session := sessions[min(n, len(sessions))-1]
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"playback_url": engine.URL + "/stream/" + session,
+				"stat_url":     engine.URL + "/ace/stat/test/" + session,
+				"command_url":  engine.URL + "/ace/cmd/test/" + session,
+				"infohash":     "resolved123",
+			}})
+		case strings.HasPrefix(r.URL.Path, "/stream/"):
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.Write([]byte("test stream data"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		case strings.HasPrefix(r.URL.Path, "/ace/cmd/"):
+			json.NewEncoder(w).Encode(map[string]any{"response": "ok"})
+		case strings.HasPrefix(r.URL.Path, "/ace/stat/"):
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"status": "dl", "peers": 7, "speed_down": 512,
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(engine.Close)
+
+	engineURL, _ := url.Parse(engine.URL)
+	acexyInst := &acexy.Acexy{
+		Scheme:            engineURL.Scheme,
+		Host:              engineURL.Hostname(),
+		Port:              parsePort(engineURL.Port()),
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      5 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+
+	return &Proxy{Acexy: acexyInst}, release, &fetches
+}
+
+// streamConcurrently serves the requests in parallel, returning once every stream is
+// registered and a function waiting for them to finish
+func streamConcurrently(t *testing.T, proxy *Proxy, requests int) func() {
+	targets := make([]string, requests)
+	for i := range targets {
+		targets[i] = "/ace/getstream?id=test123"
+	}
+	return streamTargetsConcurrently(t, proxy, targets...)
+}
+
+// streamTargetsConcurrently serves a request for each of the targets in parallel, in order,
+// returning once every stream is registered and a function waiting for them to finish
+func streamTargetsConcurrently(t *testing.T, proxy *Proxy, targets ...string) func() {
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			proxy.HandleStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+		}()
+		// Serialize the fetches so the engine hands out the sessions in order
+		deadline := time.Now().Add(5 * time.Second)
+		for proxy.streams.Len() < i+1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Stream %d was not registered", i+1)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	return wg.Wait
+}
+
+// TestDuplicatePlaybackSession verifies a stream getting the playback session ID of an
+// active one is detected, counted, and tracked under a distinct ID
+func TestDuplicatePlaybackSession(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback123")
+	wait := streamConcurrently(t, proxy, 2)
+
+	if _, ok := proxy.streams.Get("playback123"); !ok {
+		t.Error("Expected the first stream to keep its playback session ID")
+	}
+	if _, ok := proxy.streams.Get("playback123-2"); !ok {
+		t.Error("Expected the duplicated stream to be tracked under a distinct ID")
+	}
+	if got := proxy.streams.Duplicates(); got != 1 {
+		t.Errorf("Expected 1 duplicate, got %d", got)
+	}
+
+	w := httptest.NewRecorder()
+	proxy.HandleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "acexy_duplicate_playback_session_total 1\n") {
+		t.Errorf("Expected the duplicate to be counted in the metrics:\n%s", w.Body.String())
+	}
+
+	close(release)
+	wait()
+	if proxy.streams.Len() != 0 {
+		t.Errorf("Expected every stream to be unregistered, %d left", proxy.streams.Len())
+	}
+}
+
+// TestDuplicatePlaybackSessionRefetch verifies the stream is fetched again, getting a fresh
+// session, when refetching duplicates is enabled
+func TestDuplicatePlaybackSessionRefetch(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback123", "playback123", "playback456")
+	proxy.RefetchDuplicateSessions = true
+	wait := streamConcurrently(t, proxy, 2)
+
+	// The duplicate is registered until the refetched session replaces it
+	deadline := time.Now().Add(5 * time.Second)
+	for _, ok := proxy.streams.Get("playback456"); !ok && time.Now().Before(deadline); _, ok = proxy.streams.Get("playback456") {
+		time.Sleep(5 * time.Millisecond)
+	}
+	for _, id := range []string{"playback123", "playback456"} {
+		if _, ok := proxy.streams.Get(id); !ok {
+			t.Errorf("Expected a stream registered as %s", id)
+		}
+	}
+	if _, ok := proxy.streams.Get("playback123-2"); ok {
+		t.Error("Expected the duplicated session to be replaced by the refetched one")
+	}
+	if got := proxy.streams.Duplicates(); got != 1 {
+		t.Errorf("Expected 1 duplicate, got %d", got)
+	}
+
+	close(release)
+	wait()
+}
+
+// TestDedupByResolvedInfohash verifies a content ID request and an infohash request for the
+// same content are keyed by the same infohash, sharing the engine session instead of
+// having it refetched as a duplicate
+func TestDedupByResolvedInfohash(t *testing.T) {
+	proxy, release, fetches := newDuplicateSessionProxy(t, "playback123")
+	proxy.RefetchDuplicateSessions = true
+	proxy.DedupByResolvedInfohash = true
+	wait := streamTargetsConcurrently(t, proxy, "/ace/getstream?id=content123", "/ace/getstream?infohash=resolved123")
+
+	resolved, _ := acexy.NewAceID("", "resolved123")
+	if got := proxy.streams.CountAceID(resolved.String()); got != 2 {
+		t.Errorf("Expected both streams keyed by the resolved infohash, got %d", got)
+	}
+	ids := proxy.streams.StreamIDs()
+	for _, id := range []string{"resolved123|playback123", "resolved123|playback123-2"} {
+		if _, ok := ids[id]; !ok {
+			t.Errorf("Expected stream ID %s, got %v", id, ids)
+		}
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("Expected the shared session not to be refetched, got %d fetches", got)
+	}
+
+	close(release)
+	wait()
+}
+
+// TestDedupByResolvedInfohashDisabled verifies streams keep the identifier they were
+// requested with by default
+func TestDedupByResolvedInfohashDisabled(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback123", "playback456")
+	wait := streamTargetsConcurrently(t, proxy, "/ace/getstream?id=content123", "/ace/getstream?infohash=resolved123")
+
+	ids := proxy.streams.StreamIDs()
+	for _, id := range []string{"content123|playback123", "resolved123|playback456"} {
+		if _, ok := ids[id]; !ok {
+			t.Errorf("Expected stream ID %s, got %v", id, ids)
+		}
+	}
+
+	close(release)
+	wait()
+}
+
+// TestSelectedEngineLeavesConfigUntouched verifies serving streams from the engine picked by
+// the orchestrator does not repoint the configured engine, the fallback of the other requests
+func TestSelectedEngineLeavesConfigUntouched(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback1", "playback2")
+	port := proxy.Acexy.Port
+	proxy.Acexy.Host = "localhost"
+
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-1", Host: "127.0.0.1", Port: port, HealthStatus: "healthy"},
+			})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(orch.Close)
+	proxy.Orch = newOrchClient(OrchConfig{URL: orch.URL})
+	t.Cleanup(proxy.Orch.Close)
+
+	wait := streamConcurrently(t, proxy, 2)
+	for _, id := range []string{"playback1", "playback2"} {
+		if stream, ok := proxy.streams.Get(id); !ok || stream.EngineHost != "127.0.0.1" {
+			t.Errorf("Expected %s to be served by the selected engine, got %+v", id, stream)
+		}
+	}
+	if proxy.Acexy.Host != "localhost" || proxy.Acexy.Port != port {
+		t.Errorf("Expected the configured engine to be kept, got %s:%d", proxy.Acexy.Host, proxy.Acexy.Port)
+	}
+
+	close(release)
+	wait()
+	if proxy.Acexy.Host != "localhost" {
+		t.Errorf("Expected the configured engine to be kept once served, got %s", proxy.Acexy.Host)
+	}
+}
@@ -0,0 +1,33 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+// Ended streams remembered by default, so their ended event is not sent twice
+const DEFAULT_ENDED_STREAMS_CAP = 1000
+
+// markEnded records the stream as ended. Past the cap, the streams that ended the longest
+// ago are forgotten, one by one, so the recently ended ones stay deduplicated even under
+// sustained churn. Must be called with endedStreamsMu held.
+func (c *orchClient) markEnded(streamID string) {
+	if c.endedStreams == nil {
+		c.endedStreams = make(map[string]bool)
+	}
+	if c.endedStreams[streamID] {
+		return
+	}
+	c.endedStreams[streamID] = true
+	c.endedOrder = append(c.endedOrder, streamID)
+
+	limit := c.endedStreamsCap
+	if limit <= 0 {
+		limit = DEFAULT_ENDED_STREAMS_CAP
+	}
+	if excess := len(c.endedOrder) - limit; excess > 0 {
+		for _, id := range c.endedOrder[:excess] {
+			delete(c.endedStreams, id)
+		}
+		c.endedOrder = c.endedOrder[excess:]
+	}
+}
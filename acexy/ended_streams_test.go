@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestEndedStreamsCap verifies pushing past the cap forgets only the streams that ended the
+// longest ago, so the recent ones still send a single ended event
+func TestEndedStreamsCap(t *testing.T) {
+	var ended atomic.Int32
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events/stream_ended" {
+			ended.Add(1)
+		}
+	}))
+	defer orch.Close()
+
+	client := &orchClient{
+		base:            orch.URL,
+		hc:              &http.Client{Timeout: 3 * time.Second},
+		endedStreams:    make(map[string]bool),
+		endedStreamsCap: 100,
+	}
+	for i := 0; i < 150; i++ {
+		client.EmitEnded(fmt.Sprintf("stream-%d", i), "completed")
+	}
+	client.inflight.Wait()
+
+	client.endedStreamsMu.Lock()
+	size, order := len(client.endedStreams), len(client.endedOrder)
+	oldest, latest := client.endedStreams["stream-49"], client.endedStreams["stream-50"] && client.endedStreams["stream-149"]
+	client.endedStreamsMu.Unlock()
+	if size != 100 || order != 100 {
+		t.Errorf("Expected 100 ended streams remembered, got %d (%d ordered)", size, order)
+	}
+	if oldest || !latest {
+		t.Errorf("Expected the oldest streams forgotten and the latest kept")
+	}
+
+	// The recent streams are deduplicated, the forgotten ones are not
+	sent := ended.Load()
+	client.EmitEnded("stream-149", "completed")
+	client.EmitEnded("stream-50", "completed")
+	client.inflight.Wait()
+	if got := ended.Load(); got != sent {
+		t.Errorf("Expected no event for the recently ended streams, got %d", got-sent)
+	}
+	client.EmitEnded("stream-0", "completed")
+	client.inflight.Wait()
+	if got := ended.Load(); got != sent+1 {
+		t.Errorf("Expected an event for the forgotten stream, got %d", got-sent)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestExposeEngineHeaders verifies the engine chosen by the orchestrator is reported in the
+// response headers only when enabled
+func TestExposeEngineHeaders(t *testing.T) {
+	var engine *httptest.Server
+	engine = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"playback_url": engine.URL + "/stream",
+				"stat_url":     engine.URL + "/ace/stat/test/playback123",
+				"command_url":  engine.URL + "/ace/cmd/test/playback123",
+			}})
+		case "/stream":
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.Write([]byte("test stream data"))
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"response": "ok"})
+		}
+	}))
+	defer engine.Close()
+	engineURL, _ := url.Parse(engine.URL)
+
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{{
+				ContainerID:  "engine-1",
+				Host:         engineURL.Hostname(),
+				Port:         parsePort(engineURL.Port()),
+				HealthStatus: "healthy",
+			}})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		}
+	}))
+	defer orch.Close()
+
+	for _, enabled := range []bool{true, false} {
+		orchClient := newOrchClient(OrchConfig{URL: orch.URL})
+		acexyInst := &acexy.Acexy{
+			Scheme:            engineURL.Scheme,
+			Endpoint:          acexy.MPEG_TS_ENDPOINT,
+			EmptyTimeout:      1 * time.Second,
+			BufferSize:        1024,
+			NoResponseTimeout: 5 * time.Second,
+		}
+		acexyInst.Init()
+
+		proxy := &Proxy{Acexy: acexyInst, Orch: orchClient, ExposeEngineHeaders: enabled}
+		rec := httptest.NewRecorder()
+		proxy.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+		orchClient.Close()
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		containerID := rec.Header().Get(ENGINE_HEADER)
+		addr := rec.Header().Get(ENGINE_ADDR_HEADER)
+		if enabled {
+			if containerID != "engine-1" {
+				t.Errorf("Expected %s engine-1, got %q", ENGINE_HEADER, containerID)
+			}
+			if expected := net.JoinHostPort(engineURL.Hostname(), engineURL.Port()); addr != expected {
+				t.Errorf("Expected %s %s, got %q", ENGINE_ADDR_HEADER, expected, addr)
+			}
+		} else if containerID != "" || addr != "" {
+			t.Errorf("Expected no engine headers when disabled, got %q and %q", containerID, addr)
+		}
+	}
+}
+
+// TestEngineForwardedStatus verifies whether the serving engine is forwarded through the VPN
+// is reported by /admin/clients while the stream is served, and in the engine headers
+func TestEngineForwardedStatus(t *testing.T) {
+	release := make(chan struct{})
+	var engine *httptest.Server
+	engine = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"playback_url": engine.URL + "/stream",
+				"stat_url":     engine.URL + "/ace/stat/test/playback123",
+				"command_url":  engine.URL + "/ace/cmd/test/playback123",
+			}})
+		case "/stream":
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.Write([]byte("test stream data"))
+			w.(http.Flusher).Flush()
+			<-release
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"response": "ok"})
+		}
+	}))
+	defer engine.Close()
+	engineURL, _ := url.Parse(engine.URL)
+
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{{
+				ContainerID:  "engine-1",
+				Host:         engineURL.Hostname(),
+				Port:         parsePort(engineURL.Port()),
+				HealthStatus: "healthy",
+				Forwarded:    true,
+			}})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		}
+	}))
+	defer orch.Close()
+
+	orchClient := newOrchClient(OrchConfig{URL: orch.URL})
+	defer orchClient.Close()
+	acexyInst := &acexy.Acexy{
+		Scheme:            engineURL.Scheme,
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      5 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+	proxy := &Proxy{Acexy: acexyInst, Orch: orchClient, ExposeEngineHeaders: true}
+
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		proxy.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+	}()
+
+	var clients []clientUsage
+	for deadline := time.Now().Add(3 * time.Second); len(clients) == 0 && time.Now().Before(deadline); {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/clients", nil))
+		var resp struct {
+			Clients []clientUsage `json:"clients"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if clients = resp.Clients; len(clients) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	close(release)
+	<-done
+
+	if len(clients) != 1 || !clients[0].EngineForwarded {
+		t.Errorf("Expected the stream listed on a forwarded engine, got %+v", clients)
+	}
+	if got := rec.Header().Get(ENGINE_FORWARDED_HEADER); got != "true" {
+		t.Errorf("Expected %s true, got %q", ENGINE_FORWARDED_HEADER, got)
+	}
+}
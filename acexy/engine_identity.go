@@ -0,0 +1,41 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import "log/slog"
+
+// VerifyEngineIdentity checks the engine about to be used is still the container it was
+// selected as. The orchestrator may recycle an engine, reusing its host and port for a new
+// container, so the engine list is fetched anew and the container now listed at the address
+// is returned instead of the stale one. The engine is returned unchanged when the check is
+// disabled, it has no container ID or the orchestrator cannot be queried.
+func (c *orchClient) VerifyEngineIdentity(engine selectedEngine) selectedEngine {
+	if c == nil || !c.verifyEngineIdentity || engine.ContainerID == "" {
+		return engine
+	}
+
+	engines, err := c.RefreshEngines()
+	if err != nil {
+		slog.Warn("Failed to verify the engine identity, keeping the selected container",
+			"host", engine.Host, "port", engine.Port, "container_id", engine.ContainerID, "error", err)
+		return engine
+	}
+	for _, listed := range engines {
+		if listed.Host != engine.Host || listed.Port != engine.Port {
+			continue
+		}
+		if listed.ContainerID != engine.ContainerID {
+			slog.Warn("Engine was recycled by the orchestrator, using its new container",
+				"host", engine.Host, "port", engine.Port, "stale_container_id", engine.ContainerID,
+				"container_id", listed.ContainerID)
+			engine.ContainerID = listed.ContainerID
+		}
+		engine.Forwarded = listed.Forwarded
+		return engine
+	}
+	slog.Warn("Engine is no longer listed by the orchestrator, keeping the selected container",
+		"host", engine.Host, "port", engine.Port, "container_id", engine.ContainerID)
+	return engine
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestVerifyEngineIdentity verifies an engine recycled by the orchestrator at the same port
+// is re-resolved to its new container when enabled, instead of keeping the cached stale one
+func TestVerifyEngineIdentity(t *testing.T) {
+	_, port := newStandbyTestEngine(t, "data", false)
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-new", Host: "127.0.0.1", Port: port, HealthStatus: "healthy"},
+			})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer orch.Close()
+
+	for _, verify := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client := &orchClient{
+			base:                orch.URL,
+			maxStreamsPerEngine: 2,
+			hc:                  &http.Client{Timeout: 3 * time.Second},
+			ctx:                 ctx,
+			cancel:              cancel,
+			endedStreams:        make(map[string]bool),
+			// The engine was listed before the orchestrator recycled it
+			engineCache: []engineState{
+				{ContainerID: "engine-old", Host: "127.0.0.1", Port: port, HealthStatus: "healthy"},
+			},
+			engineCacheTime:      time.Now(),
+			engineCacheDuration:  time.Hour,
+			verifyEngineIdentity: verify,
+		}
+
+		acexyInst := &acexy.Acexy{
+			Scheme:            "http",
+			Host:              "127.0.0.1",
+			Port:              1,
+			Endpoint:          acexy.MPEG_TS_ENDPOINT,
+			EmptyTimeout:      5 * time.Second,
+			BufferSize:        1024,
+			NoResponseTimeout: 5 * time.Second,
+		}
+		acexyInst.Init()
+		proxy := &Proxy{Acexy: acexyInst, Orch: client}
+
+		w := httptest.NewRecorder()
+		proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+		if got := w.Body.String(); got != "data" {
+			t.Fatalf("Verify %t: expected the stream to be served, got %q", verify, got)
+		}
+
+		want := "engine-old"
+		if verify {
+			want = "engine-new"
+		}
+		if streams := proxy.history.Latest(1); len(streams) != 1 || streams[0].ContainerID != want {
+			t.Errorf("Verify %t: expected the stream attributed to %s, got %+v", verify, want, streams)
+		}
+	}
+}
+
+// TestVerifyEngineIdentityUnavailable verifies the selected engine is kept when the
+// orchestrator cannot be queried or no longer lists it
+func TestVerifyEngineIdentityUnavailable(t *testing.T) {
+	engine := selectedEngine{Host: "127.0.0.1", Port: 6878, ContainerID: "engine-1"}
+
+	unreachable := &orchClient{base: "http://127.0.0.1:1", hc: &http.Client{Timeout: time.Second}, verifyEngineIdentity: true}
+	if got := unreachable.VerifyEngineIdentity(engine); got != engine {
+		t.Errorf("Expected the engine kept when the orchestrator is unreachable, got %+v", got)
+	}
+
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]engineState{{ContainerID: "engine-2", Host: "127.0.0.1", Port: 6879}})
+	}))
+	defer orch.Close()
+	unlisted := &orchClient{base: orch.URL, hc: &http.Client{Timeout: time.Second}, verifyEngineIdentity: true}
+	if got := unlisted.VerifyEngineIdentity(engine); got != engine {
+		t.Errorf("Expected the engine kept when it is no longer listed, got %+v", got)
+	}
+
+	var disabled *orchClient
+	if got := disabled.VerifyEngineIdentity(engine); got != engine {
+		t.Errorf("Expected the engine unchanged without orchestrator, got %+v", got)
+	}
+}
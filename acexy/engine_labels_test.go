@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestPropagateEngineLabels verifies the labels of the selected engine are merged into the
+// stream_started event only when enabled, never overriding the stream labels
+func TestPropagateEngineLabels(t *testing.T) {
+	var mu sync.Mutex
+	var started startedEvent
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events/stream_started" {
+			mu.Lock()
+			defer mu.Unlock()
+			started = startedEvent{}
+			json.NewDecoder(r.Body).Decode(&started)
+		}
+	}))
+	defer orch.Close()
+
+	client := &orchClient{
+		base:         orch.URL,
+		hc:           &http.Client{Timeout: time.Second},
+		endedStreams: make(map[string]bool),
+		engineCache: []engineState{{
+			ContainerID: "engine-1",
+			Labels:      map[string]string{ENGINE_REGION_LABEL: "eu", "tenant": "acme", "stream_id": "engine-value"},
+		}},
+	}
+	stream := &acexy.AceStream{StatURL: "http://engine/stat", CommandURL: "http://engine/cmd"}
+
+	for _, propagate := range []bool{false, true} {
+		client.propagateEngineLabels = propagate
+		client.EmitStarted(startedStream{
+			Host: "127.0.0.1", Port: 6878, KeyType: "content_id", Key: "test123", PlaybackID: "playback123",
+			Stream: stream, StreamID: "test123|playback123", EngineContainerID: "engine-1", ClientLabel: "user-42",
+		})
+
+		mu.Lock()
+		labels := started.Labels
+		mu.Unlock()
+		if labels["stream_id"] != "test123|playback123" || labels[STREAM_LABEL_EVENT_KEY] != "user-42" {
+			t.Errorf("Propagate %t: expected the stream labels to be kept, got %v", propagate, labels)
+		}
+		if propagate && (labels[ENGINE_REGION_LABEL] != "eu" || labels["tenant"] != "acme") {
+			t.Errorf("Expected the engine labels in the started event, got %v", labels)
+		}
+		if !propagate && len(labels) != 2 {
+			t.Errorf("Expected no engine labels unless enabled, got %v", labels)
+		}
+	}
+}
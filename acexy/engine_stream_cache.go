@@ -0,0 +1,97 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// cachedStreamCount is the active stream count of an engine, as last fetched
+type cachedStreamCount struct {
+	active    int
+	fetchedAt time.Time
+}
+
+// engineStreamCache caches the active stream count of each engine for a short time, so
+// bursts of engine selections don't query the orchestrator streams of every engine each
+// time. The counts of an engine are dropped as soon as acexy starts or ends a stream on it.
+type engineStreamCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	counts  map[string]cachedStreamCount
+	engines map[string]string // Engine each reported stream started on, by stream ID
+}
+
+// newEngineStreamCache creates the stream count cache. Returns nil (disabled) when the TTL
+// is not positive.
+func newEngineStreamCache(ttl time.Duration) *engineStreamCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &engineStreamCache{
+		ttl:     ttl,
+		counts:  make(map[string]cachedStreamCount),
+		engines: make(map[string]string),
+	}
+}
+
+// Get returns the cached active stream count of the engine, if fetched within the TTL
+func (c *engineStreamCache) Get(containerID string) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count, ok := c.counts[containerID]
+	if !ok || time.Since(count.fetchedAt) >= c.ttl {
+		return 0, false
+	}
+	return count.active, true
+}
+
+// Set caches the active stream count fetched for the engine
+func (c *engineStreamCache) Set(containerID string, active int) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[containerID] = cachedStreamCount{active: active, fetchedAt: time.Now()}
+}
+
+// Started drops the count of the engine the stream started on, remembering it so the
+// count is dropped again when the stream ends
+func (c *engineStreamCache) Started(streamID, containerID string) {
+	if c == nil || containerID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.counts, containerID)
+	c.engines[streamID] = containerID
+}
+
+// Ended drops the count of the engine the stream was served by. Every count is dropped
+// when that engine is not known.
+func (c *engineStreamCache) Ended(streamID string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	containerID, ok := c.engines[streamID]
+	if !ok {
+		clear(c.counts)
+		return
+	}
+	delete(c.engines, streamID)
+	delete(c.counts, containerID)
+}
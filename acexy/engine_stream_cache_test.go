@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestEngineStreamCache verifies repeated engine selections within the TTL don't query the
+// streams of every engine again, while starting a stream refreshes its engine only
+func TestEngineStreamCache(t *testing.T) {
+	var mu sync.Mutex
+	queries := map[string]int{}
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-1", Host: "127.0.0.1", Port: 6878, HealthStatus: "healthy"},
+				{ContainerID: "engine-2", Host: "127.0.0.1", Port: 6879, HealthStatus: "healthy"},
+				{ContainerID: "engine-3", Host: "127.0.0.1", Port: 6880, HealthStatus: "healthy"},
+			})
+		case "/streams":
+			mu.Lock()
+			queries[r.URL.Query().Get("container_id")]++
+			mu.Unlock()
+			json.NewEncoder(w).Encode([]streamState{})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 2,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		endedStreams:        make(map[string]bool),
+		streamCounts:        newEngineStreamCache(time.Minute),
+	}
+
+	expect := func(step string, want map[string]int) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		for engine, n := range want {
+			if queries[engine] != n {
+				t.Errorf("%s: expected %d stream queries of %s, got %d", step, n, engine, queries[engine])
+			}
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := client.SelectBestEngine(); err != nil {
+			t.Fatalf("Selection %d failed: %v", i+1, err)
+		}
+	}
+	expect("Repeated selections", map[string]int{"engine-1": 1, "engine-2": 1, "engine-3": 1})
+
+	stream := &acexy.AceStream{StatURL: "http://engine/stat", CommandURL: "http://engine/cmd"}
+	client.EmitStarted(startedStream{Host: "127.0.0.1", Port: 6878, KeyType: "content_id", Key: "test123", PlaybackID: "playback123", Stream: stream, StreamID: "test123|playback123", EngineContainerID: "engine-1"})
+	if _, err := client.SelectBestEngine(); err != nil {
+		t.Fatalf("Selection after the stream started failed: %v", err)
+	}
+	expect("Stream started", map[string]int{"engine-1": 2, "engine-2": 1, "engine-3": 1})
+
+	client.EmitEnded("test123|playback123", "completed")
+	if _, err := client.SelectBestEngine(); err != nil {
+		t.Fatalf("Selection after the stream ended failed: %v", err)
+	}
+	expect("Stream ended", map[string]int{"engine-1": 3, "engine-2": 1, "engine-3": 1})
+}
+
+// TestEngineStreamCacheDisabled verifies every selection queries the engine streams when
+// the cache is disabled
+func TestEngineStreamCacheDisabled(t *testing.T) {
+	cache := newEngineStreamCache(0)
+	if cache != nil {
+		t.Fatal("Expected a nil cache for a zero TTL")
+	}
+	cache.Set("engine-1", 1)
+	if _, ok := cache.Get("engine-1"); ok {
+		t.Error("Expected a disabled cache to hold no counts")
+	}
+}
@@ -0,0 +1,45 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"javinator9889/acexy/lib/acexy"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// rewriteEngineURLs points the stat and command URLs of the stream at the engine acexy
+// fetched it from, as engines may report them with an internal address only some of the
+// parties can reach. The path and query are kept. Does nothing unless enabled.
+func (p *Proxy) rewriteEngineURLs(stream *acexy.AceStream, engine acexy.Engine) {
+	if !p.RewriteEngineURLs || stream == nil {
+		return
+	}
+
+	host := net.JoinHostPort(engine.Host, strconv.Itoa(engine.Port))
+	stream.StatURL = rewriteURLHost(stream.StatURL, engine.Scheme, host)
+	stream.CommandURL = rewriteURLHost(stream.CommandURL, engine.Scheme, host)
+}
+
+// rewriteURLHost replaces the scheme and host of the URL. URLs that cannot be parsed, or
+// are empty, are returned untouched.
+func rewriteURLHost(raw, scheme, host string) string {
+	if raw == "" {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		slog.Debug("Not rewriting unparseable engine URL", "url", raw, "error", err)
+		return raw
+	}
+	if u.Host != host {
+		slog.Debug("Rewriting engine URL host", "url", raw, "host", host)
+	}
+	u.Scheme = scheme
+	u.Host = host
+	return u.String()
+}
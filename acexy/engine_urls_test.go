@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRewriteEngineURLs verifies stat and command URLs reported with an unreachable host are
+// pointed at the engine acexy used, so the stream can be stopped and is reported consistently
+func TestRewriteEngineURLs(t *testing.T) {
+	var mu sync.Mutex
+	stopped := 0
+	var started startedEvent
+
+	var engine *httptest.Server
+	engine = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			// The engine only knows its internal address
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"playback_url": engine.URL + "/stream",
+				"stat_url":     "http://172.17.0.5:6878/ace/stat/test/playback123",
+				"command_url":  "http://172.17.0.5:6878/ace/cmd/test/playback123?extra=1",
+			}})
+		case "/stream":
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.Write([]byte("test stream data"))
+		case "/ace/cmd/test/playback123":
+			mu.Lock()
+			stopped++
+			mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]any{"response": "ok"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer engine.Close()
+
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events/stream_started" {
+			mu.Lock()
+			json.NewDecoder(r.Body).Decode(&started)
+			mu.Unlock()
+		}
+	}))
+	defer orch.Close()
+	orchClient := newOrchClient(OrchConfig{URL: orch.URL})
+	defer orchClient.Close()
+
+	engineURL, _ := url.Parse(engine.URL)
+	acexyInst := &acexy.Acexy{
+		Scheme:            engineURL.Scheme,
+		Host:              engineURL.Hostname(),
+		Port:              parsePort(engineURL.Port()),
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      1 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+
+	proxy := &Proxy{Acexy: acexyInst, Orch: orchClient, RewriteEngineURLs: true}
+	proxy.HandleStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if stopped != 1 {
+		t.Errorf("Expected the stream to be stopped through the rewritten command URL, got %d stops", stopped)
+	}
+	if expected := engine.URL + "/ace/stat/test/playback123"; started.Session.StatURL != expected {
+		t.Errorf("Expected stat URL %s in the started event, got %s", expected, started.Session.StatURL)
+	}
+	if !strings.HasPrefix(started.Session.CommandURL, engine.URL) || !strings.HasSuffix(started.Session.CommandURL, "?extra=1") {
+		t.Errorf("Expected the command URL on the engine host with its query kept, got %s", started.Session.CommandURL)
+	}
+}
+
+// TestRewriteURLHost verifies only the scheme and host are replaced
+func TestRewriteURLHost(t *testing.T) {
+	got := rewriteURLHost("http://172.17.0.5:6878/ace/stat/a/b?x=1", "https", "engine:6879")
+	if got != "https://engine:6879/ace/stat/a/b?x=1" {
+		t.Errorf("Unexpected rewritten URL %s", got)
+	}
+	if got := rewriteURLHost("", "http", "engine:6878"); got != "" {
+		t.Errorf("Expected empty URLs to be kept, got %s", got)
+	}
+}
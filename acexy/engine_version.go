@@ -0,0 +1,137 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Time the version probe of an engine is given
+const ENGINE_VERSION_PROBE_TIMEOUT = 2 * time.Second
+
+// Time an engine whose version probe failed is not probed again, so engines without the
+// version endpoint don't delay every stream start
+const ENGINE_VERSION_RETRY_INTERVAL = 5 * time.Minute
+
+// engineVersion is the AceStream build an engine runs, as reported by its version endpoint
+type engineVersion struct {
+	Version  string `json:"version"`
+	Code     int    `json:"code"`
+	Platform string `json:"platform"`
+}
+
+// engineVersions probes the AceStream version of each engine on its first use, caching it
+// per container ID so failures can be correlated with specific engine builds
+type engineVersions struct {
+	mu       sync.Mutex
+	versions map[string]engineVersion
+	failed   map[string]time.Time
+	client   *http.Client
+}
+
+// newEngineVersions creates the version cache. Returns nil (disabled) when not enabled.
+func newEngineVersions(enabled bool) *engineVersions {
+	if !enabled {
+		return nil
+	}
+	return &engineVersions{
+		versions: make(map[string]engineVersion),
+		failed:   make(map[string]time.Time),
+		client:   &http.Client{Timeout: ENGINE_VERSION_PROBE_TIMEOUT},
+	}
+}
+
+// Get returns the version of the engine, probing it when it is not cached yet. A failed
+// probe is not retried for ENGINE_VERSION_RETRY_INTERVAL, the engine being reported without
+// a version meanwhile.
+func (v *engineVersions) Get(ctx context.Context, engine engineState) (engineVersion, bool) {
+	if v == nil || engine.ContainerID == "" {
+		return engineVersion{}, false
+	}
+	v.mu.Lock()
+	version, ok := v.versions[engine.ContainerID]
+	failedAt, failed := v.failed[engine.ContainerID]
+	v.mu.Unlock()
+	if ok {
+		return version, true
+	}
+	if failed && time.Since(failedAt) < ENGINE_VERSION_RETRY_INTERVAL {
+		return engineVersion{}, false
+	}
+
+	version, err := v.probe(ctx, engine)
+	if err != nil {
+		slog.Debug("Failed to probe the engine version", "container_id", engine.ContainerID, "error", err)
+		v.mu.Lock()
+		v.failed[engine.ContainerID] = time.Now()
+		v.mu.Unlock()
+		return engineVersion{}, false
+	}
+
+	v.mu.Lock()
+	v.versions[engine.ContainerID] = version
+	delete(v.failed, engine.ContainerID)
+	v.mu.Unlock()
+	slog.Debug("Probed engine version", "container_id", engine.ContainerID, "version", version.Version)
+	return version, true
+}
+
+// Cached returns the version of the engine, only if it was already probed
+func (v *engineVersions) Cached(containerID string) (engineVersion, bool) {
+	if v == nil {
+		return engineVersion{}, false
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	version, ok := v.versions[containerID]
+	return version, ok
+}
+
+// probe queries the version endpoint of the engine
+func (v *engineVersions) probe(ctx context.Context, engine engineState) (engineVersion, error) {
+	scheme := engineScheme(engine)
+	if scheme == "" {
+		scheme = "http"
+	}
+	probeURL := url.URL{Scheme: scheme, Host: net.JoinHostPort(engine.Host, strconv.Itoa(engine.Port))}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL.String()+ENGINE_PROBE_PATH, nil)
+	if err != nil {
+		return engineVersion{}, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return engineVersion{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return engineVersion{}, fmt.Errorf("engine returned status %d", resp.StatusCode)
+	}
+	var body struct {
+		Result *engineVersion `json:"result"`
+		Error  string         `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return engineVersion{}, fmt.Errorf("failed to decode version response: %w", err)
+	}
+	if body.Error != "" {
+		return engineVersion{}, fmt.Errorf("engine error: %s", body.Error)
+	}
+	if body.Result == nil || body.Result.Version == "" {
+		return engineVersion{}, fmt.Errorf("engine did not report its version")
+	}
+	return *body.Result, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestEngineVersionProbedOnce verifies the engine version is fetched on its first selection
+// and reused afterwards, being reported by /admin/engines
+func TestEngineVersionProbedOnce(t *testing.T) {
+	var probes atomic.Int32
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/webui/api/service" || r.URL.Query().Get("method") != "get_version" {
+			http.NotFound(w, r)
+			return
+		}
+		probes.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"result": map[string]any{"version": "3.2.3", "code": 3020300, "platform": "linux"},
+			"error":  nil,
+		})
+	}))
+	defer engine.Close()
+	engineURL, _ := url.Parse(engine.URL)
+	enginePort, _ := strconv.Atoi(engineURL.Port())
+
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{{
+				ContainerID:  "engine-1",
+				Host:         engineURL.Hostname(),
+				Port:         enginePort,
+				HealthStatus: "healthy",
+			}})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		versions:            newEngineVersions(true),
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.SelectBestEngine(); err != nil {
+			t.Fatalf("Unexpected selection error: %v", err)
+		}
+	}
+	if got := probes.Load(); got != 1 {
+		t.Errorf("Expected the version to be probed once, got %d probes", got)
+	}
+
+	w := httptest.NewRecorder()
+	(&Proxy{Orch: client}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/engines", nil))
+	var engines []adminEngine
+	if err := json.NewDecoder(w.Body).Decode(&engines); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(engines) != 1 || engines[0].Version == nil || engines[0].Version.Version != "3.2.3" {
+		t.Errorf("Expected the probed version in /admin/engines, got %+v", engines)
+	}
+	if got := probes.Load(); got != 1 {
+		t.Errorf("Expected /admin/engines to reuse the cached version, got %d probes", got)
+	}
+}
+
+// TestEngineVersionFailureCached verifies an engine without the version endpoint is not
+// probed on every selection, only again once the retry interval elapsed
+func TestEngineVersionFailureCached(t *testing.T) {
+	var probes atomic.Int32
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer engine.Close()
+	engineURL, _ := url.Parse(engine.URL)
+	enginePort, _ := strconv.Atoi(engineURL.Port())
+	state := engineState{ContainerID: "engine-1", Host: engineURL.Hostname(), Port: enginePort}
+
+	versions := newEngineVersions(true)
+	for i := 0; i < 3; i++ {
+		if _, ok := versions.Get(context.Background(), state); ok {
+			t.Fatal("Expected no version for an engine without the endpoint")
+		}
+	}
+	if got := probes.Load(); got != 1 {
+		t.Errorf("Expected the failed probe to be cached, got %d probes", got)
+	}
+
+	versions.mu.Lock()
+	versions.failed["engine-1"] = time.Now().Add(-ENGINE_VERSION_RETRY_INTERVAL)
+	versions.mu.Unlock()
+	versions.Get(context.Background(), state)
+	if got := probes.Load(); got != 2 {
+		t.Errorf("Expected the engine to be probed again after the retry interval, got %d probes", got)
+	}
+}
@@ -0,0 +1,52 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"fmt"
+	"javinator9889/acexy/lib/acexy"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// errorClip is a short MPEG-TS clip (e.g. "stream unavailable") written to the client when
+// its stream fails, so viewers see a message instead of a player glitch or a cryptic error
+type errorClip struct {
+	clip []byte
+}
+
+// newErrorClip loads the clip at the given path once. Returns nil (disabled) when no path
+// is given.
+func newErrorClip(path string) (*errorClip, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	clip, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error clip: %w", err)
+	}
+	if len(clip) == 0 {
+		return nil, fmt.Errorf("error clip %s is empty", path)
+	}
+	return &errorClip{clip: clip}, nil
+}
+
+// Serve writes the clip at the end of a failed MPEG-TS response, whose headers were already
+// sent. Other endpoints are left untouched, as the clip would corrupt them.
+func (e *errorClip) Serve(w http.ResponseWriter, endpoint acexy.AcexyEndpoint) {
+	if e == nil || endpoint != acexy.MPEG_TS_ENDPOINT {
+		return
+	}
+
+	if _, err := w.Write(e.clip); err != nil {
+		slog.Debug("Failed to write the error clip", "error", err)
+		return
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
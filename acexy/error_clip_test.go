@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestErrorClipOnFailedStart verifies the error clip is written to the client when its
+// stream fails to start, and only in MPEG-TS mode
+func TestErrorClipOnFailedStart(t *testing.T) {
+	clipPath := filepath.Join(t.TempDir(), "unavailable.ts")
+	clip := bytes.Repeat([]byte{0x47, 0x1f, 0xff, 0x10}, 47)
+	if err := os.WriteFile(clipPath, clip, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	errorClip, err := newErrorClip(clipPath)
+	if err != nil {
+		t.Fatalf("Failed to load the error clip: %v", err)
+	}
+	if _, err := newErrorClip(filepath.Join(t.TempDir(), "missing.ts")); err == nil {
+		t.Error("Expected an error for a missing clip")
+	}
+
+	// The engine hands out a playback URL nobody listens on
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+			"playback_url": dead.URL + "/stream",
+			"stat_url":     dead.URL + "/ace/stat/test/playback123",
+			"command_url":  dead.URL + "/ace/cmd/test/playback123",
+		}})
+	}))
+	defer engine.Close()
+	engineURL, _ := url.Parse(engine.URL)
+
+	for _, tt := range []struct {
+		endpoint acexy.AcexyEndpoint
+		expected []byte
+	}{
+		{acexy.MPEG_TS_ENDPOINT, clip},
+		{acexy.M3U8_ENDPOINT, nil},
+	} {
+		acexyInst := &acexy.Acexy{
+			Scheme:            "http",
+			Host:              engineURL.Hostname(),
+			Port:              parsePort(engineURL.Port()),
+			Endpoint:          tt.endpoint,
+			EmptyTimeout:      time.Second,
+			BufferSize:        1024,
+			NoResponseTimeout: time.Second,
+		}
+		acexyInst.Init()
+		proxy := &Proxy{Acexy: acexyInst, ErrorClip: errorClip}
+
+		w := httptest.NewRecorder()
+		proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, string(tt.endpoint)+"?id=test123", nil))
+		if !bytes.Equal(w.Body.Bytes(), tt.expected) {
+			t.Errorf("Endpoint %s: expected %d bytes of error clip, got %d", tt.endpoint, len(tt.expected), w.Body.Len())
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Window the stream events are coalesced over before being sent as a batch
+const EVENT_BATCH_WINDOW = 250 * time.Millisecond
+
+// Events that trigger sending the batch right away
+const EVENT_BATCH_MAX = 100
+
+// Orchestrator endpoint receiving the batches of stream events
+const EVENT_BATCH_PATH = "/events/batch"
+
+// batchedEvent is a stream event within a batch, tagged with its type (e.g. `stream_started`)
+type batchedEvent struct {
+	Type  string `json:"type"`
+	Event any    `json:"event"`
+}
+
+// eventBatcher coalesces the stream started and ended events, sending them in order as an
+// array to EVENT_BATCH_PATH instead of a request each, which spares the orchestrator under
+// high churn. Orchestrators without the endpoint get the events one by one again.
+type eventBatcher struct {
+	orch *orchClient
+
+	mu      sync.Mutex
+	pending []batchedEvent
+	timer   *time.Timer
+
+	sendMu      sync.Mutex  // Serializes the batches, so they are delivered in order
+	unsupported atomic.Bool // Whether the orchestrator answered the batch endpoint with a 404
+}
+
+// newEventBatcher creates the batcher of the client events. Returns nil (disabled) when not
+// enabled.
+func newEventBatcher(enabled bool, orch *orchClient) *eventBatcher {
+	if !enabled {
+		return nil
+	}
+	return &eventBatcher{orch: orch}
+}
+
+// emit queues the event sent to the given path when batching, or sends it right away,
+// synchronously when its ordering matters. Returns the response body of the synchronous
+// events, nil for the others.
+func (c *orchClient) emit(path string, ev any, sync bool) []byte {
+	b := c.batcher
+	if b == nil || b.unsupported.Load() {
+		if sync {
+			return c.postSync(path, ev)
+		}
+		c.post(path, ev)
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, batchedEvent{Type: strings.TrimPrefix(path, "/events/"), Event: ev})
+	switch {
+	case len(b.pending) >= EVENT_BATCH_MAX:
+		if b.timer != nil {
+			b.timer.Stop()
+			b.timer = nil
+		}
+		go b.Flush()
+	case b.timer == nil:
+		b.timer = time.AfterFunc(EVENT_BATCH_WINDOW, b.Flush)
+	}
+	return nil
+}
+
+// Flush sends the queued events at once
+func (b *eventBatcher) Flush() {
+	if b == nil {
+		return
+	}
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	b.mu.Lock()
+	events := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+
+	status, err := b.send(events)
+	switch {
+	case err != nil:
+		slog.Warn("Failed to send event batch to orchestrator", "events", len(events), "error", err)
+	case status == http.StatusNotFound:
+		slog.Warn("Orchestrator does not support event batches, sending events one by one", "events", len(events))
+		b.unsupported.Store(true)
+		for _, ev := range events {
+			b.orch.postSync("/events/"+ev.Type, ev.Event)
+		}
+	case status < 200 || status >= 300:
+		slog.Warn("Orchestrator returned error status", "status", status, "url", b.orch.base+EVENT_BATCH_PATH, "events", len(events))
+	default:
+		slog.Debug("Sent event batch to orchestrator", "events", len(events), "status", status)
+	}
+}
+
+// send posts the events to the batch endpoint, returning the response status
+func (b *eventBatcher) send(events []batchedEvent) (int, error) {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, b.orch.base+EVENT_BATCH_PATH, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if b.orch.key != "" {
+		req.Header.Set("Authorization", "Bearer "+b.orch.key)
+	}
+
+	resp, err := b.orch.hc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
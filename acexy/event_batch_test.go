@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newBatchTestOrch starts an orchestrator recording the paths and batches it receives,
+// answering the batch endpoint with a 404 when unsupported
+func newBatchTestOrch(t *testing.T, unsupported bool) (*orchClient, func() ([]string, [][]batchedEvent)) {
+	var mu sync.Mutex
+	var paths []string
+	var batches [][]batchedEvent
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == EVENT_BATCH_PATH {
+			if unsupported {
+				http.NotFound(w, r)
+				return
+			}
+			var batch []batchedEvent
+			json.NewDecoder(r.Body).Decode(&batch)
+			batches = append(batches, batch)
+		}
+	}))
+	t.Cleanup(orch.Close)
+
+	client := newOrchClient(OrchConfig{URL: orch.URL, BatchEvents: true, RequestTimeout: time.Second})
+	t.Cleanup(client.Close)
+	return client, func() ([]string, [][]batchedEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), paths...), append([][]batchedEvent(nil), batches...)
+	}
+}
+
+// TestEventBatching verifies rapid stream events are delivered in order as a single batch
+func TestEventBatching(t *testing.T) {
+	client, received := newBatchTestOrch(t, false)
+	stream := &acexy.AceStream{StatURL: "http://engine/stat", CommandURL: "http://engine/cmd"}
+
+	client.EmitStarted(startedStream{Host: "127.0.0.1", Port: 6878, KeyType: "content_id", Key: "a", PlaybackID: "p1", Stream: stream, StreamID: "a|p1", EngineContainerID: "engine-1"})
+	client.EmitStarted(startedStream{Host: "127.0.0.1", Port: 6878, KeyType: "content_id", Key: "b", PlaybackID: "p2", Stream: stream, StreamID: "b|p2", EngineContainerID: "engine-1"})
+	client.EmitEnded("a|p1", "completed")
+	client.EmitEnded("b|p2", "client_disconnected")
+
+	time.Sleep(2 * EVENT_BATCH_WINDOW)
+	paths, batches := received()
+	var eventPaths []string
+	for _, path := range paths {
+		if path != "/orchestrator/status" {
+			eventPaths = append(eventPaths, path)
+		}
+	}
+	if len(eventPaths) != 1 || len(batches) != 1 {
+		t.Fatalf("Expected a single batch request, got %v", eventPaths)
+	}
+
+	var got []string
+	for _, ev := range batches[0] {
+		fields := ev.Event.(map[string]any)
+		streamID, _ := fields["stream_id"].(string)
+		if labels, ok := fields["labels"].(map[string]any); ok {
+			streamID, _ = labels["stream_id"].(string)
+		}
+		got = append(got, ev.Type+" "+streamID)
+	}
+	expected := []string{"stream_started a|p1", "stream_started b|p2", "stream_ended a|p1", "stream_ended b|p2"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected the events %v in emission order, got %v", expected, got)
+	}
+}
+
+// TestEventBatchingFallback verifies events are sent one by one, in order, when the
+// orchestrator has no batch endpoint
+func TestEventBatchingFallback(t *testing.T) {
+	client, received := newBatchTestOrch(t, true)
+	stream := &acexy.AceStream{StatURL: "http://engine/stat", CommandURL: "http://engine/cmd"}
+
+	client.EmitStarted(startedStream{Host: "127.0.0.1", Port: 6878, KeyType: "content_id", Key: "a", PlaybackID: "p1", Stream: stream, StreamID: "a|p1", EngineContainerID: "engine-1"})
+	client.EmitEnded("a|p1", "completed")
+	time.Sleep(2 * EVENT_BATCH_WINDOW)
+
+	client.EmitStarted(startedStream{Host: "127.0.0.1", Port: 6878, KeyType: "content_id", Key: "b", PlaybackID: "p2", Stream: stream, StreamID: "b|p2", EngineContainerID: "engine-1"})
+	time.Sleep(100 * time.Millisecond)
+
+	paths, _ := received()
+	var eventPaths []string
+	for _, path := range paths {
+		if path != "/orchestrator/status" {
+			eventPaths = append(eventPaths, path)
+		}
+	}
+	expected := []string{EVENT_BATCH_PATH, "/events/stream_started", "/events/stream_ended", "/events/stream_started"}
+	if len(eventPaths) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, eventPaths)
+	}
+	for i := range expected {
+		if eventPaths[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, eventPaths)
+		}
+	}
+}
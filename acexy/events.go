@@ -0,0 +1,241 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Events queued for each sink before further ones are dropped, so a slow sink never blocks
+// streaming
+const EVENT_SINK_QUEUE = 256
+
+// The Redis channel stream events are published to when the sink URL does not set one
+const DEFAULT_REDIS_CHANNEL = "acexy:events"
+
+// Timeout of the connection and of each command sent to Redis
+const REDIS_TIMEOUT = 5 * time.Second
+
+// streamEvent is a stream lifecycle event as published to the event sinks
+type streamEvent struct {
+	Event       string    `json:"event"`
+	Time        time.Time `json:"time"`
+	StreamID    string    `json:"stream_id"`
+	AceID       string    `json:"ace_id"`
+	EngineHost  string    `json:"engine_host,omitempty"`
+	EnginePort  int       `json:"engine_port,omitempty"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// eventSink is a destination stream events are published to, e.g. a message queue
+type eventSink interface {
+	Publish(ev streamEvent) error
+}
+
+// eventSinks publishes the stream events to every configured sink. Each sink is fed by its
+// own goroutine, dropping the events once its queue is full.
+type eventSinks struct {
+	queues []chan streamEvent
+}
+
+// newEventSinks creates the sinks of the given comma separated URLs. Returns nil (disabled)
+// when no URL is configured.
+func newEventSinks(urls string) (*eventSinks, error) {
+	var sinks []eventSink
+	for _, raw := range strings.Split(urls, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		sink, err := parseEventSink(raw)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return newEventPublisher(sinks...), nil
+}
+
+// newEventPublisher starts publishing to the given sinks. Returns nil without sinks.
+func newEventPublisher(sinks ...eventSink) *eventSinks {
+	if len(sinks) == 0 {
+		return nil
+	}
+	e := &eventSinks{}
+	for _, sink := range sinks {
+		queue := make(chan streamEvent, EVENT_SINK_QUEUE)
+		e.queues = append(e.queues, queue)
+		go func() {
+			for ev := range queue {
+				if err := sink.Publish(ev); err != nil {
+					slog.Warn("Failed to publish stream event", "event", ev.Event, "stream_id", ev.StreamID, "error", err)
+				}
+			}
+		}()
+	}
+	return e
+}
+
+// parseEventSink creates the sink of a URL. Only Redis pub/sub is supported for now.
+// The errors don't quote the URL, which may hold a password.
+func parseEventSink(raw string) (eventSink, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("invalid event sink URL: %w", err)
+	}
+	switch u.Scheme {
+	case "redis":
+		return newRedisSink(u), nil
+	default:
+		return nil, fmt.Errorf("unsupported event sink %q: expected a redis:// URL", u.Redacted())
+	}
+}
+
+// redactEventSinks returns the comma separated sink URLs with their passwords masked, to be
+// logged or reported. URLs that cannot be parsed are redacted whole.
+func redactEventSinks(urls string) string {
+	parts := strings.Split(urls, ",")
+	for i, raw := range parts {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if u, err := url.Parse(raw); err == nil {
+			parts[i] = u.Redacted()
+		} else {
+			parts[i] = REDACTED_VALUE
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// StreamStarted publishes the start of a stream
+func (e *eventSinks) StreamStarted(ev streamHookEvent) {
+	e.publish("stream_started", ev)
+}
+
+// StreamEnded publishes the end of a stream
+func (e *eventSinks) StreamEnded(ev streamHookEvent) {
+	e.publish("stream_ended", ev)
+}
+
+func (e *eventSinks) publish(event string, ev streamHookEvent) {
+	if e == nil {
+		return
+	}
+	sev := streamEvent{
+		Event:       event,
+		Time:        time.Now(),
+		StreamID:    ev.StreamID,
+		AceID:       ev.AceID,
+		EngineHost:  ev.EngineHost,
+		EnginePort:  ev.EnginePort,
+		ContainerID: ev.ContainerID,
+		Reason:      ev.Reason,
+	}
+	for _, queue := range e.queues {
+		select {
+		case queue <- sev:
+		default:
+			slog.Warn("Event sink falling behind, dropping stream event", "event", event, "stream_id", ev.StreamID)
+		}
+	}
+}
+
+// redisSink publishes the events as JSON to a Redis pub/sub channel, given by the `channel`
+// query parameter of its URL: `redis://[:password@]host[:port]?channel=name`. The
+// connection is kept open and dialed again after a failure.
+type redisSink struct {
+	addr     string
+	password string
+	channel  string
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+func newRedisSink(u *url.URL) *redisSink {
+	s := &redisSink{addr: u.Host, channel: u.Query().Get("channel")}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if s.channel == "" {
+		s.channel = DEFAULT_REDIS_CHANNEL
+	}
+	if password, ok := u.User.Password(); ok {
+		s.password = password
+	}
+	return s
+}
+
+// Publish sends the event with PUBLISH. Only called from the sink goroutine.
+func (s *redisSink) Publish(ev streamEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.command("PUBLISH", s.channel, string(payload)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *redisSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, REDIS_TIMEOUT)
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	s.conn, s.reader = conn, bufio.NewReader(conn)
+	if s.password != "" {
+		if _, err := s.command("AUTH", s.password); err != nil {
+			conn.Close()
+			s.conn = nil
+			return fmt.Errorf("failed to authenticate to redis: %w", err)
+		}
+	}
+	return nil
+}
+
+// command sends a command and returns its reply line, failing on error replies
+func (s *redisSink) command(args ...string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	s.conn.SetDeadline(time.Now().Add(REDIS_TIMEOUT))
+	if _, err := s.conn.Write([]byte(b.String())); err != nil {
+		return "", err
+	}
+	reply, err := s.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	reply = strings.TrimRight(reply, "\r\n")
+	if strings.HasPrefix(reply, "-") {
+		return "", fmt.Errorf("redis error: %s", reply[1:])
+	}
+	return reply, nil
+}
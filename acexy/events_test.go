@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockSink records the published events
+type mockSink struct {
+	events chan streamEvent
+}
+
+func (s *mockSink) Publish(ev streamEvent) error {
+	s.events <- ev
+	return nil
+}
+
+// TestEventSinks verifies the start and end of a stream are published to the sinks
+func TestEventSinks(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback123")
+	sink := &mockSink{events: make(chan streamEvent, 4)}
+	proxy.Events = newEventPublisher(sink)
+
+	wait := streamConcurrently(t, proxy, 1)
+	close(release)
+	wait()
+
+	for _, expected := range []string{"stream_started", "stream_ended"} {
+		select {
+		case ev := <-sink.events:
+			if ev.Event != expected {
+				t.Errorf("Expected %s event, got %s", expected, ev.Event)
+			}
+			if ev.StreamID != "test123|playback123" || ev.EngineHost != "127.0.0.1" {
+				t.Errorf("Unexpected stream details in %+v", ev)
+			}
+			if expected == "stream_ended" && ev.Reason != "completed" {
+				t.Errorf("Expected completed reason, got %q", ev.Reason)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the %s event", expected)
+		}
+	}
+}
+
+// TestEventSinksDisabled verifies no sinks are created without a URL and invalid ones fail
+func TestEventSinksDisabled(t *testing.T) {
+	if events, err := newEventSinks(""); events != nil || err != nil {
+		t.Errorf("Expected disabled sinks, got %v, %v", events, err)
+	}
+	if _, err := newEventSinks("kafka://localhost:9092"); err == nil {
+		t.Error("Expected an error for an unsupported sink")
+	}
+
+	// The errors and logged URLs don't leak the sink password
+	for _, raw := range []string{"kafka://:hunter2@localhost:9092", "redis://:hunter2@redis:6379/%zz"} {
+		if _, err := newEventSinks(raw); err == nil || strings.Contains(err.Error(), "hunter2") {
+			t.Errorf("Expected an error without the password for %s, got %v", raw, err)
+		}
+	}
+	if got := redactEventSinks("redis://:hunter2@redis:6379?channel=a, redis://other"); got != "redis://:xxxxx@redis:6379?channel=a,redis://other" {
+		t.Errorf("Unexpected redacted sinks %q", got)
+	}
+
+	// A nil publisher ignores the events
+	var events *eventSinks
+	events.StreamStarted(streamHookEvent{StreamID: "test"})
+}
+
+// readRESP reads a command sent in the Redis protocol
+func readRESP(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+// TestRedisSink verifies the events are published to the channel after authenticating
+func TestRedisSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	commands := make(chan []string, 4)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			args, err := readRESP(r)
+			if err != nil {
+				return
+			}
+			commands <- args
+			if args[0] == "AUTH" {
+				io.WriteString(conn, "+OK\r\n")
+			} else {
+				io.WriteString(conn, ":1\r\n")
+			}
+		}
+	}()
+
+	events, err := newEventSinks("redis://:secret@" + ln.Addr().String() + "?channel=streams")
+	if err != nil {
+		t.Fatalf("Failed to create the sinks: %v", err)
+	}
+	events.StreamStarted(streamHookEvent{StreamID: "test123|playback123", AceID: "test123"})
+
+	for _, expected := range []string{"AUTH", "PUBLISH"} {
+		select {
+		case args := <-commands:
+			if args[0] != expected {
+				t.Fatalf("Expected %s, got %q", expected, args)
+			}
+			if expected == "AUTH" && args[1] != "secret" {
+				t.Errorf("Expected the configured password, got %q", args[1])
+			}
+			if expected == "PUBLISH" {
+				if args[1] != "streams" {
+					t.Errorf("Expected the streams channel, got %q", args[1])
+				}
+				var ev streamEvent
+				if err := json.Unmarshal([]byte(args[2]), &ev); err != nil {
+					t.Fatalf("Invalid event payload %q: %v", args[2], err)
+				}
+				if ev.Event != "stream_started" || ev.StreamID != "test123|playback123" {
+					t.Errorf("Unexpected event %+v", ev)
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", expected)
+		}
+	}
+}
+
+// TestRedisSinkDefaults verifies the default port and channel
+func TestRedisSinkDefaults(t *testing.T) {
+	sink, err := parseEventSink("redis://redis")
+	if err != nil {
+		t.Fatalf("Failed to parse the sink: %v", err)
+	}
+	redis := sink.(*redisSink)
+	if redis.addr != "redis:6379" || redis.channel != DEFAULT_REDIS_CHANNEL || redis.password != "" {
+		t.Errorf("Unexpected sink %+v", redis)
+	}
+}
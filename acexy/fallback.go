@@ -0,0 +1,226 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The keyword selecting the orchestrator in the fallback chain
+const FALLBACK_ORCHESTRATOR = "orchestrator"
+
+// The path used to check whether a static engine is up
+const ENGINE_PROBE_PATH = "/webui/api/service?method=get_version"
+
+// fallbackChain is the ordered list of sources acexy tries when selecting an engine. Each
+// static hop is given `hopTimeout` to return an engine before moving on to the next one, and
+// the orchestrator hop `orchTimeout`, as provisioning an engine takes longer. A timeout of 0
+// leaves the hop bound by the request only.
+type fallbackChain struct {
+	hops        []fallbackHop
+	hopTimeout  time.Duration
+	orchTimeout time.Duration
+	client      *http.Client
+}
+
+// fallbackHop is either the orchestrator or a group of static engines, among which the
+// least loaded reachable one is selected
+type fallbackHop struct {
+	orchestrator bool
+	engines      []selectedEngine
+}
+
+func (h fallbackHop) String() string {
+	if h.orchestrator {
+		return FALLBACK_ORCHESTRATOR
+	}
+	addrs := make([]string, len(h.engines))
+	for i, engine := range h.engines {
+		addrs[i] = net.JoinHostPort(engine.Host, strconv.Itoa(engine.Port))
+	}
+	return "static(" + strings.Join(addrs, ", ") + ")"
+}
+
+// parseFallbackChain parses a comma separated chain such as
+// `orchestrator,10.0.0.5:6878,https://10.0.0.6:6878`. Consecutive engine addresses are
+// grouped in a single hop. Returns nil when the spec is empty.
+func parseFallbackChain(spec string, hopTimeout, orchTimeout time.Duration) (*fallbackChain, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	chain := &fallbackChain{
+		hopTimeout:  hopTimeout,
+		orchTimeout: orchTimeout,
+		client:      &http.Client{},
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == FALLBACK_ORCHESTRATOR {
+			chain.hops = append(chain.hops, fallbackHop{orchestrator: true})
+			continue
+		}
+
+		engine, err := parseStaticEngine(entry)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(chain.hops); n > 0 && !chain.hops[n-1].orchestrator {
+			chain.hops[n-1].engines = append(chain.hops[n-1].engines, engine)
+		} else {
+			chain.hops = append(chain.hops, fallbackHop{engines: []selectedEngine{engine}})
+		}
+	}
+	if len(chain.hops) == 0 {
+		return nil, fmt.Errorf("fallback chain %q has no hops", spec)
+	}
+	return chain, nil
+}
+
+// parseStaticEngine parses an engine address given as `host:port` or `scheme://host:port`
+func parseStaticEngine(addr string) (selectedEngine, error) {
+	var engine selectedEngine
+	if scheme, rest, ok := strings.Cut(addr, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return engine, fmt.Errorf("unsupported scheme in engine address %q", addr)
+		}
+		engine.Scheme, addr = scheme, rest
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return engine, fmt.Errorf("invalid engine address %q: %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return engine, fmt.Errorf("invalid port in engine address %q", addr)
+	}
+	engine.Host, engine.Port = host, port
+	return engine, nil
+}
+
+// Select walks the chain in order and returns the engine of the first hop that provides
+// one. The errors of every hop are joined when none does. An orchestrator hop timing out
+// abandons the engine it was provisioning, like a request that is gone.
+func (f *fallbackChain) Select(ctx context.Context, orch *orchClient, streams *streamRegistry, defaultScheme string) (selectedEngine, error) {
+	var errs []error
+	for _, hop := range f.hops {
+		timeout := f.hopTimeout
+		if hop.orchestrator {
+			timeout = f.orchTimeout
+		}
+		hopCtx, cancel := context.WithCancel(ctx)
+		if timeout > 0 {
+			hopCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		var engine selectedEngine
+		var err error
+		if hop.orchestrator {
+			engine, err = selectFromOrchestrator(hopCtx, orch)
+		} else {
+			engine, err = f.selectStatic(hopCtx, hop.engines, streams, defaultScheme)
+		}
+		cancel()
+
+		if err == nil {
+			slog.Debug("Engine selected from fallback chain", "hop", hop, "host", engine.Host, "port", engine.Port)
+			return engine, nil
+		}
+		slog.Warn("Fallback chain hop failed, trying next one", "hop", hop, "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", hop, err))
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return selectedEngine{}, fmt.Errorf("no engine available in fallback chain: %w", errors.Join(errs...))
+}
+
+// selectFromOrchestrator selects an engine through the orchestrator, giving up once the
+// context is done even if the orchestrator has not answered yet
+func selectFromOrchestrator(ctx context.Context, orch *orchClient) (selectedEngine, error) {
+	if orch == nil {
+		return selectedEngine{}, fmt.Errorf("orchestrator client not configured")
+	}
+
+	type result struct {
+		engine selectedEngine
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		engine, err := orch.SelectBestEngineContext(ctx)
+		done <- result{engine, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.engine, res.err
+	case <-ctx.Done():
+		return selectedEngine{}, ctx.Err()
+	}
+}
+
+// selectStatic returns the least loaded of the given engines that answers the probe. The
+// load of each engine is the number of streams acexy is currently serving from it.
+func (f *fallbackChain) selectStatic(ctx context.Context, engines []selectedEngine, streams *streamRegistry, defaultScheme string) (selectedEngine, error) {
+	candidates := make([]selectedEngine, len(engines))
+	copy(candidates, engines)
+	load := make(map[selectedEngine]int, len(candidates))
+	for _, engine := range candidates {
+		load[engine] = streams.CountEngine(engine.Host, engine.Port)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return load[candidates[i]] < load[candidates[j]]
+	})
+
+	var errs []error
+	for _, engine := range candidates {
+		scheme := engine.Scheme
+		if scheme == "" {
+			scheme = defaultScheme
+		}
+		if err := f.probe(ctx, scheme, engine); err != nil {
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		return engine, nil
+	}
+	return selectedEngine{}, errors.Join(errs...)
+}
+
+// probe checks whether the engine answers HTTP requests
+func (f *fallbackChain) probe(ctx context.Context, scheme string, engine selectedEngine) error {
+	probeURL := url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(engine.Host, strconv.Itoa(engine.Port)),
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL.String()+ENGINE_PROBE_PATH, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("engine %s unreachable: %w", probeURL.Host, err)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestFallbackChainOrchestratorDown verifies a static engine of the chain is selected when
+// the orchestrator cannot be reached
+func TestFallbackChainOrchestratorDown(t *testing.T) {
+	var streamRequests atomic.Int32
+
+	var engine *httptest.Server
+	engine = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/webui/api/service":
+			json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{"version": "3.2.3"}})
+		case "/ace/getstream":
+			streamRequests.Add(1)
+			json.NewEncoder(w).Encode(map[string]any{
+				"response": map[string]any{
+					"playback_url": engine.URL + "/stream",
+					"stat_url":     engine.URL + "/ace/stat/test/playback123",
+					"command_url":  engine.URL + "/ace/cmd/test/playback123",
+				},
+			})
+		case "/stream":
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.Write([]byte("test stream data"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer engine.Close()
+
+	// An orchestrator that is no longer listening
+	orch := httptest.NewServer(http.NotFoundHandler())
+	orch.Close()
+	orchClient := newOrchClient(OrchConfig{URL: orch.URL})
+	defer orchClient.Close()
+
+	engineURL, _ := url.Parse(engine.URL)
+	chain, err := parseFallbackChain("orchestrator,127.0.0.1:1,"+engineURL.Host, time.Second, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse fallback chain: %v", err)
+	}
+	if len(chain.hops) != 2 || len(chain.hops[1].engines) != 2 {
+		t.Fatalf("Expected the orchestrator hop and a static hop with 2 engines, got %v", chain.hops)
+	}
+
+	// The configured engine is unreachable, so only the chain can provide a working one
+	acexyInst := &acexy.Acexy{
+		Scheme:            "http",
+		Host:              "127.0.0.1",
+		Port:              1,
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      1 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+	proxy := &Proxy{Acexy: acexyInst, Orch: orchClient, Fallback: chain}
+
+	rec := httptest.NewRecorder()
+	proxy.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test-stream-id", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if streamRequests.Load() != 1 {
+		t.Errorf("Expected the stream to be fetched from the static engine, got %d requests", streamRequests.Load())
+	}
+	if proxy.Acexy.Port != 1 {
+		t.Errorf("Expected the configured engine to be restored, got port %d", proxy.Acexy.Port)
+	}
+}
+
+// TestFallbackChainExhausted verifies the request fails once every hop failed
+func TestFallbackChainExhausted(t *testing.T) {
+	chain, err := parseFallbackChain("orchestrator,127.0.0.1:1", time.Second, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse fallback chain: %v", err)
+	}
+	acexyInst := &acexy.Acexy{Scheme: "http", Host: "127.0.0.1", Port: 1, Endpoint: acexy.MPEG_TS_ENDPOINT}
+	acexyInst.Init()
+	proxy := &Proxy{Acexy: acexyInst, Fallback: chain}
+
+	rec := httptest.NewRecorder()
+	proxy.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test-stream-id", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", rec.Code)
+	}
+}
+
+// TestParseFallbackChainInvalid verifies malformed engine addresses are rejected
+func TestParseFallbackChainInvalid(t *testing.T) {
+	for _, spec := range []string{"localhost", "ftp://localhost:6878", "localhost:port", ","} {
+		if _, err := parseFallbackChain(spec, time.Second, 0); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+	if chain, err := parseFallbackChain("", time.Second, 0); chain != nil || err != nil {
+		t.Errorf("Expected no chain for an empty spec, got %v, %v", chain, err)
+	}
+}
+
+// TestFallbackChainOrchestratorHopTimeout verifies the orchestrator hop is not cut short by
+// the static hop timeout unless given its own, and that the chain error is not prefixed twice
+func TestFallbackChainOrchestratorHopTimeout(t *testing.T) {
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			// Slower than the static hop timeout, as a selection waiting on a provision
+			time.Sleep(300 * time.Millisecond)
+			json.NewEncoder(w).Encode([]engineState{{ContainerID: "engine-1", Host: "host-1", Port: 8001, HealthStatus: "healthy"}})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+	orchClient := newOrchClient(OrchConfig{URL: orch.URL})
+	defer orchClient.Close()
+
+	chain, err := parseFallbackChain("orchestrator,127.0.0.1:1", 100*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse fallback chain: %v", err)
+	}
+	engine, err := chain.Select(context.Background(), orchClient, &streamRegistry{}, "http")
+	if err != nil || engine.ContainerID != "engine-1" {
+		t.Fatalf("Expected engine-1 from the orchestrator hop, got %+v (%v)", engine, err)
+	}
+
+	// A fresh client, so the engine list is not served from the cache
+	uncached := newOrchClient(OrchConfig{URL: orch.URL})
+	defer uncached.Close()
+	chain.orchTimeout = 100 * time.Millisecond
+	proxy := &Proxy{Acexy: &acexy.Acexy{Host: "127.0.0.1", Port: 1}, Orch: uncached, Fallback: chain}
+	_, err = proxy.selectEngine(context.Background())
+	if err == nil {
+		t.Fatal("Expected the orchestrator hop to time out")
+	}
+	if got := strings.Count(err.Error(), "fallback chain"); got != 1 {
+		t.Errorf("Expected the chain error to be prefixed once, got %q", err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestFirstByteFailover verifies a stream whose engine accepts it but never sends its first
+// byte moves to another engine before the no response timeout, the stuck engine being
+// marked as failing
+func TestFirstByteFailover(t *testing.T) {
+	var stalled *httptest.Server
+	stalled = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"playback_url": stalled.URL + "/stream",
+				"stat_url":     stalled.URL + "/ace/stat/test/playback-stalled",
+				"command_url":  stalled.URL + "/ace/cmd/test/playback-stalled",
+			}})
+		case "/stream":
+			// Stuck resolving the content: headers sent, but no data
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"response": "ok"})
+		}
+	}))
+	defer stalled.Close()
+	stalledURL, _ := url.Parse(stalled.URL)
+	_, workingPort := newStandbyTestEngine(t, "failed over data", false)
+
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-stalled", Host: "127.0.0.1", Port: parsePort(stalledURL.Port()), HealthStatus: "healthy"},
+				{ContainerID: "engine-working", Host: "127.0.0.1", Port: workingPort, HealthStatus: "healthy", LastStreamUsage: time.Now()},
+			})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		endedStreams:        make(map[string]bool),
+	}
+
+	acexyInst := &acexy.Acexy{
+		Scheme:            "http",
+		Host:              "127.0.0.1",
+		Port:              1,
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      10 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 10 * time.Second,
+		FirstByteTimeout:  200 * time.Millisecond,
+	}
+	acexyInst.Init()
+	proxy := &Proxy{Acexy: acexyInst, Orch: client}
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+
+	if got := w.Body.String(); got != "failed over data" {
+		t.Errorf("Expected the stream from the working engine, got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the failover before the no response timeout, took %s", elapsed)
+	}
+	if !client.engineFailing("engine-stalled") {
+		t.Error("Expected the stalled engine to be marked as failing")
+	}
+}
+
+// TestShouldRetryStart verifies a failed start is retried within the configured retries, or
+// once for an engine stuck before the first byte, only while the client got no data
+func TestShouldRetryStart(t *testing.T) {
+	engineErr := errors.New("engine returned status 500")
+	proxy := &Proxy{Orch: &orchClient{}, StartRetries: 1}
+
+	tests := []struct {
+		name      string
+		proxy     *Proxy
+		attempt   int
+		err       error
+		delivered uint64
+		expected  bool
+	}{
+		{"within retries", proxy, 1, engineErr, 0, true},
+		{"past retries", proxy, 2, engineErr, 0, false},
+		{"data delivered", proxy, 1, engineErr, 188, false},
+		{"no error", proxy, 1, nil, 0, false},
+		{"clients left", proxy, 1, acexy.ErrStopped, 0, false},
+		{"no orchestrator", &Proxy{StartRetries: 1}, 1, engineErr, 0, false},
+		{"first byte timeout", &Proxy{Orch: &orchClient{}}, 1, acexy.ErrFirstByteTimeout, 0, true},
+		{"first byte timeout retried", &Proxy{Orch: &orchClient{}}, 2, acexy.ErrFirstByteTimeout, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.proxy.shouldRetryStart(context.Background(), tt.attempt, tt.err, tt.delivered); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
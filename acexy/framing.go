@@ -0,0 +1,28 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// mirrorEngineFraming returns the function writing the MPEG-TS response headers once the
+// engine answers the playback request. A finite stream (VOD) the engine sends with a
+// Content-Length keeps it, others are chunked. Only the first engine answer is mirrored.
+func mirrorEngineFraming(w http.ResponseWriter) func(*http.Response) {
+	var once sync.Once
+	return func(resp *http.Response) {
+		once.Do(func() {
+			if resp.StatusCode >= 200 && resp.StatusCode <= 299 && resp.ContentLength >= 0 {
+				w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+			} else {
+				w.Header().Set("Transfer-Encoding", "chunked")
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+}
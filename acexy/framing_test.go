@@ -0,0 +1,67 @@
+package main
+
+import (
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestEngineFramingMirrored verifies the Content-Length of a finite stream the engine sends
+// with one is preserved, while live streams and forced chunking are chunked
+func TestEngineFramingMirrored(t *testing.T) {
+	// The engine answers the small stream at once, with a Content-Length
+	_, port := newStandbyTestEngine(t, "vod data", false)
+	newProxy := func(forceChunked bool) *Proxy {
+		acexyInst := &acexy.Acexy{
+			Scheme:            "http",
+			Host:              "127.0.0.1",
+			Port:              port,
+			Endpoint:          acexy.MPEG_TS_ENDPOINT,
+			EmptyTimeout:      5 * time.Second,
+			BufferSize:        1024,
+			NoResponseTimeout: 5 * time.Second,
+		}
+		acexyInst.Init()
+		return &Proxy{Acexy: acexyInst, ForceChunked: forceChunked}
+	}
+
+	for _, tt := range []struct {
+		name          string
+		forceChunked  bool
+		contentLength string
+		chunked       bool
+	}{
+		{"mirrored", false, "8", false},
+		{"forced chunked", true, "", true},
+	} {
+		w := httptest.NewRecorder()
+		newProxy(tt.forceChunked).HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+
+		header := w.Result().Header
+		if got := w.Body.String(); got != "vod data" {
+			t.Errorf("%s: expected the stream data, got %q", tt.name, got)
+		}
+		if got := header.Get("Content-Length"); got != tt.contentLength {
+			t.Errorf("%s: expected Content-Length %q, got %q", tt.name, tt.contentLength, got)
+		}
+		if chunked := header.Get("Transfer-Encoding") == "chunked"; chunked != tt.chunked {
+			t.Errorf("%s: expected chunked %t, got Transfer-Encoding %q", tt.name, tt.chunked, header.Get("Transfer-Encoding"))
+		}
+		if got := header.Get("Content-Type"); got != "video/MP2T" {
+			t.Errorf("%s: expected the MPEG-TS content type, got %q", tt.name, got)
+		}
+	}
+
+	// A live stream the engine flushes has no length, so it is chunked
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback123")
+	close(release)
+	w := httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+	header := w.Result().Header
+	if header.Get("Content-Length") != "" || header.Get("Transfer-Encoding") != "chunked" {
+		t.Errorf("Expected a live stream to be chunked, got Content-Length %q and Transfer-Encoding %q",
+			header.Get("Content-Length"), header.Get("Transfer-Encoding"))
+	}
+}
@@ -0,0 +1,139 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How long the result of an engine connectivity check is reused
+const HEALTHZ_CACHE_TTL = 5 * time.Second
+
+// The timeout of each connection attempt to an engine
+const HEALTHZ_DIAL_TIMEOUT = 1 * time.Second
+
+// engineHealth is the result of the last engine connectivity check
+type engineHealth struct {
+	Healthy   bool      `json:"healthy"`
+	Checked   int       `json:"engines_checked"`
+	Reachable string    `json:"reachable,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// healthCache caches the engine connectivity check, so probing `/healthz` often does not
+// hit the engines on every call. The zero value is ready to use.
+type healthCache struct {
+	mu   sync.Mutex
+	last *engineHealth
+}
+
+// HandleHealthz reports whether at least one engine can be reached. Unlike `/ace/status`,
+// it fails with a 503 when acexy would not be able to serve any stream.
+func (p *Proxy) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	health := p.checkEngineHealth()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !health.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(health)
+}
+
+// checkEngineHealth returns the cached connectivity check, refreshing it once it expired
+func (p *Proxy) checkEngineHealth() engineHealth {
+	p.health.mu.Lock()
+	defer p.health.mu.Unlock()
+
+	if p.health.last != nil && time.Since(p.health.last.CheckedAt) < HEALTHZ_CACHE_TTL {
+		return *p.health.last
+	}
+
+	health := engineHealth{CheckedAt: time.Now()}
+	addrs, err := p.engineAddresses()
+	if err == nil {
+		health.Checked = len(addrs)
+		health.Reachable, err = firstReachable(addrs)
+	}
+	if err != nil {
+		health.Error = err.Error()
+		slog.Warn("No engine reachable", "engines", len(addrs), "error", err)
+	} else {
+		health.Healthy = true
+	}
+
+	p.health.last = &health
+	return health
+}
+
+// engineAddresses returns the engines acexy may stream from: those known by the
+// orchestrator when configured, or the configured engine otherwise, plus the static
+// engines of the fallback chain
+func (p *Proxy) engineAddresses() ([]string, error) {
+	var addrs []string
+	if p.Orch != nil {
+		engines, err := p.Orch.GetEngines()
+		if err != nil && p.Fallback == nil {
+			return nil, fmt.Errorf("failed to get engines: %w", err)
+		}
+		for _, engine := range engines {
+			if p.Orch.labelSelector.Matches(engine.Labels) {
+				addrs = append(addrs, net.JoinHostPort(engine.Host, strconv.Itoa(engine.Port)))
+			}
+		}
+	} else if p.Acexy != nil {
+		addrs = append(addrs, net.JoinHostPort(p.Acexy.Host, strconv.Itoa(p.Acexy.Port)))
+	}
+
+	if p.Fallback != nil {
+		for _, hop := range p.Fallback.hops {
+			for _, engine := range hop.engines {
+				addrs = append(addrs, net.JoinHostPort(engine.Host, strconv.Itoa(engine.Port)))
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// firstReachable connects to every address in parallel and returns the first one that
+// accepts the connection
+func firstReachable(addrs []string) (string, error) {
+	if len(addrs) == 0 {
+		return "", errors.New("no engines available")
+	}
+
+	results := make(chan error, len(addrs))
+	reachable := make(chan string, len(addrs))
+	for _, addr := range addrs {
+		go func(addr string) {
+			conn, err := net.DialTimeout("tcp", addr, HEALTHZ_DIAL_TIMEOUT)
+			if err != nil {
+				results <- err
+				return
+			}
+			conn.Close()
+			reachable <- addr
+		}(addr)
+	}
+
+	var errs []error
+	for range addrs {
+		select {
+		case addr := <-reachable:
+			return addr, nil
+		case err := <-results:
+			errs = append(errs, err)
+		}
+	}
+	return "", errors.Join(errs...)
+}
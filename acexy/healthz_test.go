@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newHealthzTestProxy creates a proxy in static mode pointing at the given engine URL
+func newHealthzTestProxy(engineURL string) *Proxy {
+	u, _ := url.Parse(engineURL)
+	return &Proxy{Acexy: &acexy.Acexy{Scheme: u.Scheme, Host: u.Hostname(), Port: parsePort(u.Port())}}
+}
+
+func getHealthz(t *testing.T, proxy *Proxy) (int, engineHealth) {
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	var health engineHealth
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatalf("Failed to decode health: %v", err)
+	}
+	return rec.Code, health
+}
+
+// TestHealthzReachable verifies the check succeeds when the configured engine accepts
+// connections, and that the result is cached
+func TestHealthzReachable(t *testing.T) {
+	engine := httptest.NewServer(http.NotFoundHandler())
+	proxy := newHealthzTestProxy(engine.URL)
+
+	code, health := getHealthz(t, proxy)
+	if code != http.StatusOK || !health.Healthy {
+		t.Fatalf("Expected a healthy engine, got %d: %+v", code, health)
+	}
+	if health.Reachable != engine.Listener.Addr().String() {
+		t.Errorf("Expected %s to be reachable, got %s", engine.Listener.Addr(), health.Reachable)
+	}
+
+	// The engine goes away, but the cached result is still returned
+	engine.Close()
+	if code, _ := getHealthz(t, proxy); code != http.StatusOK {
+		t.Errorf("Expected the cached result to be returned, got %d", code)
+	}
+}
+
+// TestHealthzUnreachable verifies the check fails when the configured engine is down
+func TestHealthzUnreachable(t *testing.T) {
+	engine := httptest.NewServer(http.NotFoundHandler())
+	engine.Close()
+	proxy := newHealthzTestProxy(engine.URL)
+
+	code, health := getHealthz(t, proxy)
+	if code != http.StatusServiceUnavailable || health.Healthy {
+		t.Errorf("Expected an unhealthy engine, got %d: %+v", code, health)
+	}
+	if health.Error == "" {
+		t.Error("Expected the connection error to be reported")
+	}
+}
+
+// TestHealthzOrchestrator verifies that with an orchestrator, one reachable engine out of
+// the known ones is enough
+func TestHealthzOrchestrator(t *testing.T) {
+	engine := httptest.NewServer(http.NotFoundHandler())
+	defer engine.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	engineURL, _ := url.Parse(engine.URL)
+	downURL, _ := url.Parse(down.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:   "http://test",
+		hc:     &http.Client{Timeout: 3 * time.Second},
+		ctx:    ctx,
+		cancel: cancel,
+		engineCache: []engineState{
+			{ContainerID: "engine-down", Host: downURL.Hostname(), Port: parsePort(downURL.Port())},
+			{ContainerID: "engine-up", Host: engineURL.Hostname(), Port: parsePort(engineURL.Port())},
+		},
+		engineCacheTime:     time.Now(),
+		engineCacheDuration: time.Minute,
+	}
+	proxy := &Proxy{Orch: client}
+
+	code, health := getHealthz(t, proxy)
+	if code != http.StatusOK || !health.Healthy {
+		t.Fatalf("Expected a healthy engine, got %d: %+v", code, health)
+	}
+	if health.Checked != 2 || health.Reachable != engineURL.Host {
+		t.Errorf("Expected %s to be reachable out of 2 engines, got %+v", engineURL.Host, health)
+	}
+}
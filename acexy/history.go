@@ -0,0 +1,90 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Number of ended streams kept in the history returned by `/admin/history`
+const STREAM_HISTORY_SIZE = 200
+
+// endedStream describes a stream that is no longer served, as returned by `/admin/history`
+type endedStream struct {
+	StreamID    string        `json:"stream_id"`
+	AceID       string        `json:"ace_id"`
+	Client      string        `json:"client"`
+	Label       string        `json:"label,omitempty"`
+	EngineHost  string        `json:"engine_host"`
+	EnginePort  int           `json:"engine_port"`
+	ContainerID string        `json:"container_id,omitempty"`
+	StartedAt   time.Time     `json:"started_at"`
+	EndedAt     time.Time     `json:"ended_at"`
+	Duration    time.Duration `json:"duration_ns"`
+	BytesSent   int64         `json:"bytes_sent"`
+	Reason      string        `json:"reason"`
+	PeakClients int           `json:"peak_clients"` // Most clients of the same ID served at once
+}
+
+// streamHistory is a ring buffer of the latest streams that ended. The zero value is ready
+// to use.
+type streamHistory struct {
+	mu      sync.Mutex
+	streams [STREAM_HISTORY_SIZE]endedStream
+	next    int
+	count   int
+}
+
+// Record adds a stream that ended, replacing the oldest one when full
+func (h *streamHistory) Record(stream endedStream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.streams[h.next] = stream
+	h.next = (h.next + 1) % STREAM_HISTORY_SIZE
+	if h.count < STREAM_HISTORY_SIZE {
+		h.count++
+	}
+}
+
+// Latest returns up to `limit` of the streams that ended, the most recent first
+func (h *streamHistory) Latest(limit int) []endedStream {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	limit = min(limit, h.count)
+	streams := make([]endedStream, 0, limit)
+	for i := 1; i <= limit; i++ {
+		streams = append(streams, h.streams[(h.next-i+STREAM_HISTORY_SIZE)%STREAM_HISTORY_SIZE])
+	}
+	return streams
+}
+
+// HandleAdminHistory returns the latest streams that ended, the most recent first. The
+// `limit` query parameter caps how many are returned (all the kept ones by default).
+func (p *Proxy) HandleAdminHistory(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizeAdmin(w, r) {
+		return
+	}
+
+	limit := STREAM_HISTORY_SIZE
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"streams": p.history.Latest(limit),
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// getHistory returns the streams listed by `/admin/history` for the given query
+func getHistory(t *testing.T, proxy *Proxy, query string) []endedStream {
+	req := httptest.NewRequest(http.MethodGet, "/admin/history"+query, nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var history struct {
+		Streams []endedStream `json:"streams"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&history); err != nil {
+		t.Fatalf("Failed to decode history: %v", err)
+	}
+	return history.Streams
+}
+
+// TestAdminHistory verifies the latest ended streams are listed, the most recent first
+func TestAdminHistory(t *testing.T) {
+	proxy := &Proxy{AdminToken: "secret"}
+	for _, id := range []string{"first", "second", "third"} {
+		proxy.history.Record(endedStream{StreamID: id, Reason: "completed"})
+	}
+
+	streams := getHistory(t, proxy, "?limit=2")
+	if len(streams) != 2 || streams[0].StreamID != "third" || streams[1].StreamID != "second" {
+		t.Errorf("Expected the two latest streams, got %+v", streams)
+	}
+	if streams := getHistory(t, proxy, ""); len(streams) != 3 || streams[2].StreamID != "first" {
+		t.Errorf("Expected every stream by default, got %+v", streams)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/history?limit=-1", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid limit, got %d", rec.Code)
+	}
+}
+
+// TestStreamHistoryOverwrite verifies the buffer keeps only the latest streams
+func TestStreamHistoryOverwrite(t *testing.T) {
+	var history streamHistory
+	for i := 0; i < STREAM_HISTORY_SIZE+10; i++ {
+		history.Record(endedStream{BytesSent: int64(i)})
+	}
+	streams := history.Latest(STREAM_HISTORY_SIZE + 10)
+	if len(streams) != STREAM_HISTORY_SIZE {
+		t.Fatalf("Expected the buffer to keep %d streams, got %d", STREAM_HISTORY_SIZE, len(streams))
+	}
+	if streams[0].BytesSent != STREAM_HISTORY_SIZE+9 || streams[STREAM_HISTORY_SIZE-1].BytesSent != 10 {
+		t.Errorf("Unexpected streams kept: newest %d, oldest %d",
+			streams[0].BytesSent, streams[STREAM_HISTORY_SIZE-1].BytesSent)
+	}
+}
+
+// TestStreamHistoryRecorded verifies ended streams are recorded with their details
+func TestStreamHistoryRecorded(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback1", "playback2")
+	proxy.AdminToken = "secret"
+	wait := streamConcurrently(t, proxy, 2)
+	close(release)
+	wait()
+
+	streams := getHistory(t, proxy, "")
+	if len(streams) != 2 {
+		t.Fatalf("Expected 2 ended streams, got %+v", streams)
+	}
+	for _, stream := range streams {
+		if stream.Reason != "completed" || stream.BytesSent != int64(len("test stream data")) {
+			t.Errorf("Unexpected ended stream %+v", stream)
+		}
+		if stream.PeakClients != 2 {
+			t.Errorf("Expected a peak of 2 clients, got %d", stream.PeakClients)
+		}
+		if stream.EndedAt.Before(stream.StartedAt) {
+			t.Errorf("Expected the stream to end after it started: %+v", stream)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// How long the engine selection is given before the holding response is served
+const PROVISION_HOLDING_DELAY = 2 * time.Second
+
+// How often players are asked to retry when the orchestrator gives no recovery estimate.
+// The holding clip is also written once per this interval.
+const PROVISION_HOLDING_RETRY = 1 * time.Second
+
+// holdingState tells what the holding response already wrote to the client
+type holdingState int
+
+const (
+	notHeld      holdingState = iota // Nothing was written, the response is still untouched
+	heldManifest                     // A complete holding manifest was served
+	heldClip                         // The headers and the holding clip were written, the stream must follow
+)
+
+// engineSelection is the result of an engine selection run in the background
+type engineSelection struct {
+	engine selectedEngine
+	err    error
+}
+
+// provisionHolding serves a placeholder instead of a 503 while an engine is provisioned,
+// since some players cope better with it: M3U8 clients get an empty live playlist they
+// reload, while MPEG-TS clients get a looping clip until the engine is ready.
+type provisionHolding struct {
+	clip  []byte        // MPEG-TS clip looped while waiting (nil keeps the 503 in MPEG-TS mode)
+	delay time.Duration // Time the selection is given before holding
+}
+
+// newProvisionHolding creates the holding response, loading the MPEG-TS clip when a path is
+// given. Returns nil (disabled) when not enabled.
+func newProvisionHolding(enabled bool, clipPath string) (*provisionHolding, error) {
+	if !enabled {
+		return nil, nil
+	}
+
+	h := &provisionHolding{delay: PROVISION_HOLDING_DELAY}
+	if clipPath != "" {
+		clip, err := os.ReadFile(clipPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read holding clip: %w", err)
+		}
+		if len(clip) == 0 {
+			return nil, fmt.Errorf("holding clip %s is empty", clipPath)
+		}
+		h.clip = clip
+	}
+	return h, nil
+}
+
+// Select runs the engine selection, serving the holding response when it takes longer than
+// the holding delay or the orchestrator reports provisioning will be possible shortly.
+// Returns what was written to the client along with the selection result, which is empty
+// for a holding manifest.
+//
+// The selection is detached from the request: once the holding manifest is served the
+// request is done, and the engine being provisioned must still come up for the reload.
+func (h *provisionHolding) Select(
+	w http.ResponseWriter,
+	ctx context.Context,
+	endpoint acexy.AcexyEndpoint,
+	selectEngine func(context.Context) (selectedEngine, error),
+) (selectedEngine, holdingState, error) {
+	done := make(chan engineSelection, 1)
+	selectCtx := context.WithoutCancel(ctx)
+	go func() {
+		engine, err := selectEngine(selectCtx)
+		done <- engineSelection{engine, err}
+	}()
+
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+
+	retry := PROVISION_HOLDING_RETRY
+	select {
+	case res := <-done:
+		var provErr *ProvisioningError
+		if !errors.As(res.err, &provErr) || !provErr.Details.ShouldWait {
+			return res.engine, notHeld, res.err
+		}
+		if eta := time.Duration(provErr.Details.RecoveryETASeconds) * time.Second; eta > retry {
+			retry = eta
+		}
+		// The engine will not come within this request, only a manifest can be served
+		if endpoint != acexy.M3U8_ENDPOINT {
+			return res.engine, notHeld, res.err
+		}
+	case <-timer.C:
+	}
+
+	if endpoint == acexy.M3U8_ENDPOINT {
+		writeHoldingManifest(w, retry)
+		return selectedEngine{}, heldManifest, nil
+	}
+	if h.clip == nil {
+		res := <-done
+		return res.engine, notHeld, res.err
+	}
+	return h.loopClip(w, ctx, done)
+}
+
+// writeHoldingManifest serves an empty live playlist, which players reload after its
+// target duration, getting the real one once the engine is ready
+func writeHoldingManifest(w http.ResponseWriter, retry time.Duration) {
+	seconds := int(retry.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n", seconds)
+}
+
+// loopClip writes the holding clip once per PROVISION_HOLDING_RETRY until the selection
+// finishes, whose result is then returned so the real stream continues the response
+func (h *provisionHolding) loopClip(w http.ResponseWriter, ctx context.Context, done <-chan engineSelection) (selectedEngine, holdingState, error) {
+	w.Header().Set("Content-Type", "video/MP2T")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(PROVISION_HOLDING_RETRY)
+	defer ticker.Stop()
+	for {
+		if _, err := w.Write(h.clip); err != nil {
+			return selectedEngine{}, heldClip, fmt.Errorf("failed to write holding clip: %w", err)
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		select {
+		case res := <-done:
+			return res.engine, heldClip, res.err
+		case <-ctx.Done():
+			return selectedEngine{}, heldClip, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
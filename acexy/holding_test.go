@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHoldingManifestWhileProvisioning verifies M3U8 clients get a reloadable empty
+// playlist instead of a 503 while the orchestrator asks to wait for provisioning
+func TestHoldingManifestWhileProvisioning(t *testing.T) {
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+	}
+	client.health.canProvision = false
+	client.health.shouldWait = true
+	client.health.recoveryETA = 15
+	client.health.blockedReasonCode = "max_capacity"
+
+	holding, _ := newProvisionHolding(true, "")
+	proxy := &Proxy{
+		Acexy:   &acexy.Acexy{Scheme: "http", Host: "127.0.0.1", Port: 1, Endpoint: acexy.M3U8_ENDPOINT},
+		Orch:    client,
+		Holding: holding,
+	}
+	proxy.Acexy.Init()
+
+	w := httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-mpegURL" {
+		t.Errorf("Expected an M3U8 content type, got %q", ct)
+	}
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "#EXTM3U\n") || !strings.Contains(body, "#EXT-X-TARGETDURATION:15\n") {
+		t.Errorf("Unexpected holding manifest: %q", body)
+	}
+	if strings.Contains(body, "#EXT-X-ENDLIST") {
+		t.Error("The holding manifest must stay live so players reload it")
+	}
+
+	// Without holding, the request fails as before
+	proxy.Holding = nil
+	w = httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without holding, got %d", w.Code)
+	}
+}
+
+// TestHoldingManifestSlowSelection verifies the holding manifest is served once the
+// selection takes longer than the holding delay
+func TestHoldingManifestSlowSelection(t *testing.T) {
+	h := &provisionHolding{delay: 20 * time.Millisecond}
+	release := make(chan struct{})
+	defer close(release)
+	slow := func(ctx context.Context) (selectedEngine, error) {
+		<-release
+		return selectedEngine{}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := httptest.NewRecorder()
+	_, held, err := h.Select(w, ctx, acexy.M3U8_ENDPOINT, slow)
+	if held != heldManifest || err != nil {
+		t.Fatalf("Expected the holding manifest, got state %d (%v)", held, err)
+	}
+	if !strings.Contains(w.Body.String(), "#EXT-X-TARGETDURATION:1\n") {
+		t.Errorf("Unexpected holding manifest: %q", w.Body.String())
+	}
+}
+
+// TestHoldingSelectionOutlivesRequest verifies a provision taking longer than the holding
+// delay is not aborted when the request ends after serving the holding manifest, so the
+// engine is ready for the reload
+func TestHoldingSelectionOutlivesRequest(t *testing.T) {
+	h := &provisionHolding{delay: 20 * time.Millisecond}
+	provisioned := make(chan error, 1)
+	provision := func(ctx context.Context) (selectedEngine, error) {
+		// Like the wait after a provision, aborted with the selection context
+		select {
+		case <-ctx.Done():
+			provisioned <- ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+			provisioned <- nil
+		}
+		return selectedEngine{Host: "engine", Port: 6878}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := httptest.NewRecorder()
+	_, held, err := h.Select(w, ctx, acexy.M3U8_ENDPOINT, provision)
+	if held != heldManifest || err != nil {
+		t.Fatalf("Expected the holding manifest, got state %d (%v)", held, err)
+	}
+	// The handler returned, ending the request
+	cancel()
+
+	select {
+	case err := <-provisioned:
+		if err != nil {
+			t.Errorf("Expected the provision to complete after the request ended, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Provision never completed")
+	}
+}
+
+// TestHoldingClipUntilEngineReady verifies MPEG-TS clients get the holding clip while the
+// engine is provisioned, and the selected engine is returned once ready
+func TestHoldingClipUntilEngineReady(t *testing.T) {
+	h := &provisionHolding{clip: []byte("please wait"), delay: 20 * time.Millisecond}
+	ready := func(ctx context.Context) (selectedEngine, error) {
+		time.Sleep(100 * time.Millisecond)
+		return selectedEngine{Host: "engine", Port: 6878}, nil
+	}
+
+	w := httptest.NewRecorder()
+	engine, held, err := h.Select(w, context.Background(), acexy.MPEG_TS_ENDPOINT, ready)
+	if err != nil || held != heldClip {
+		t.Fatalf("Expected the holding clip, got state %d (%v)", held, err)
+	}
+	if engine.Host != "engine" || engine.Port != 6878 {
+		t.Errorf("Expected the selected engine, got %+v", engine)
+	}
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "video/MP2T" {
+		t.Errorf("Unexpected holding response: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got := w.Body.String(); got != "please wait" {
+		t.Errorf("Expected the holding clip, got %q", got)
+	}
+
+	// Fast selections are not held at all
+	w = httptest.NewRecorder()
+	fast := func(ctx context.Context) (selectedEngine, error) { return selectedEngine{Host: "engine"}, nil }
+	if _, held, _ := h.Select(w, context.Background(), acexy.MPEG_TS_ENDPOINT, fast); held != notHeld || w.Body.Len() != 0 {
+		t.Errorf("Expected a fast selection not to be held, got state %d and %q", held, w.Body.String())
+	}
+}
@@ -0,0 +1,87 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// streamHooks runs the user configured commands when a stream starts or ends. Commands are
+// run detached and killed after `timeout`, so a hung hook never blocks streaming.
+type streamHooks struct {
+	onStart string
+	onEnd   string
+	timeout time.Duration
+}
+
+// streamHookEvent describes the stream a hook is run for
+type streamHookEvent struct {
+	StreamID    string
+	AceID       string
+	EngineHost  string
+	EnginePort  int
+	ContainerID string
+	Reason      string // Only set when the stream ends
+}
+
+// newStreamHooks creates the hooks. Returns nil (disabled) when no command is configured.
+func newStreamHooks(onStart, onEnd string, timeout time.Duration) *streamHooks {
+	if onStart == "" && onEnd == "" {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &streamHooks{onStart: onStart, onEnd: onEnd, timeout: timeout}
+}
+
+// StreamStarted runs the start hook, if configured
+func (h *streamHooks) StreamStarted(ev streamHookEvent) {
+	if h == nil || h.onStart == "" {
+		return
+	}
+	go h.run(h.onStart, "stream_started", ev)
+}
+
+// StreamEnded runs the end hook, if configured
+func (h *streamHooks) StreamEnded(ev streamHookEvent) {
+	if h == nil || h.onEnd == "" {
+		return
+	}
+	go h.run(h.onEnd, "stream_ended", ev)
+}
+
+// run executes the command with the event name and stream ID as arguments, and the
+// stream details in the `ACEXY_*` environment variables
+func (h *streamHooks) run(command, event string, ev streamHookEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command, event, ev.StreamID)
+	cmd.Env = append(os.Environ(),
+		"ACEXY_EVENT="+event,
+		"ACEXY_STREAM_ID="+ev.StreamID,
+		"ACEXY_ACE_ID="+ev.AceID,
+		"ACEXY_ENGINE_HOST="+ev.EngineHost,
+		"ACEXY_ENGINE_PORT="+strconv.Itoa(ev.EnginePort),
+		"ACEXY_ENGINE_CONTAINER_ID="+ev.ContainerID,
+		"ACEXY_REASON="+ev.Reason,
+	)
+	// Do not wait forever for children of the hook that keep its output open
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		slog.Warn("Stream hook failed", "event", event, "command", command, "stream_id", ev.StreamID,
+			"error", err, "output", string(output))
+		return
+	}
+	slog.Debug("Stream hook completed", "event", event, "command", command, "stream_id", ev.StreamID)
+}
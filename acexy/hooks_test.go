@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStreamHooks verifies the start and end hooks are run with the stream details, without
+// overriding the container ID of acexy itself
+func TestStreamHooks(t *testing.T) {
+	t.Setenv("ACEXY_CONTAINER_ID", "acexy-container")
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "invocations.log")
+	script := filepath.Join(dir, "hook.sh")
+	content := "#!/bin/sh\necho \"$1 $2 $ACEXY_ENGINE_PORT [$ACEXY_REASON] <$ACEXY_CONTAINER_ID/$ACEXY_ENGINE_CONTAINER_ID>\" >> " + logFile + "\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write hook script: %v", err)
+	}
+
+	var engine *httptest.Server
+	engine = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			json.NewEncoder(w).Encode(map[string]any{
+				"response": map[string]any{
+					"playback_url": engine.URL + "/stream",
+					"stat_url":     engine.URL + "/ace/stat/test/playback123",
+					"command_url":  engine.URL + "/ace/cmd/test/playback123",
+				},
+			})
+		case "/stream":
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.Write([]byte("test stream data"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer engine.Close()
+
+	engineURL, _ := url.Parse(engine.URL)
+	port := parsePort(engineURL.Port())
+	acexyInst := &acexy.Acexy{
+		Scheme:            engineURL.Scheme,
+		Host:              engineURL.Hostname(),
+		Port:              port,
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      1 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+	proxy := &Proxy{Acexy: acexyInst, Hooks: newStreamHooks(script, script, 5*time.Second)}
+
+	proxy.HandleStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test-stream-id", nil))
+
+	// Hooks run detached, wait for both invocations to be recorded
+	var lines []string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		data, _ := os.ReadFile(logFile)
+		lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) >= 2 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 hook invocations, got %q", lines)
+	}
+
+	// Hooks are detached, so their order is not guaranteed
+	expected := map[string]bool{
+		"stream_started test-stream-id|playback123 " + engineURL.Port() + " [] <acexy-container/>":        false,
+		"stream_ended test-stream-id|playback123 " + engineURL.Port() + " [completed] <acexy-container/>": false,
+	}
+	for _, line := range lines {
+		if _, ok := expected[line]; !ok {
+			t.Errorf("Unexpected hook invocation %q", line)
+		}
+		expected[line] = true
+	}
+	for line, seen := range expected {
+		if !seen {
+			t.Errorf("Missing hook invocation %q", line)
+		}
+	}
+}
+
+// TestStreamHooksTimeout verifies a hung hook is killed once the timeout expires
+func TestStreamHooksTimeout(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "hung.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nsleep 10\n"), 0755); err != nil {
+		t.Fatalf("Failed to write hook script: %v", err)
+	}
+	hooks := newStreamHooks(script, "", 100*time.Millisecond)
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hooks.run(script, "stream_started", streamHookEvent{StreamID: "test"})
+	}()
+
+	select {
+	case <-done:
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("Expected the hook to run until its timeout, took %v", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Hung hook was not killed after its timeout")
+	}
+}
+
+// TestStreamHooksDisabled verifies no hooks are created without commands
+func TestStreamHooksDisabled(t *testing.T) {
+	hooks := newStreamHooks("", "", time.Second)
+	if hooks != nil {
+		t.Fatal("Expected nil hooks without commands")
+	}
+	hooks.StreamStarted(streamHookEvent{})
+	hooks.StreamEnded(streamHookEvent{})
+}
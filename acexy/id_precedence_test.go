@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestIDPrecedence verifies which identifier is requested from the engine when a client
+// gives both id and infohash, and that strict mode rejects conflicting values
+func TestIDPrecedence(t *testing.T) {
+	var mu sync.Mutex
+	var requested url.Values
+	var engine *httptest.Server
+	engine = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			mu.Lock()
+			requested = r.URL.Query()
+			mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"playback_url": engine.URL + "/stream",
+				"stat_url":     engine.URL + "/ace/stat/test/playback123",
+				"command_url":  engine.URL + "/ace/cmd/test/playback123",
+			}})
+		case "/stream":
+			io.WriteString(w, "test stream data")
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"response": "ok"})
+		}
+	}))
+	defer engine.Close()
+	engineURL, _ := url.Parse(engine.URL)
+
+	for _, tt := range []struct {
+		precedence acexy.IDPrecedence
+		query      string
+		code       int
+		param      string // Parameter expected to reach the engine
+		value      string
+	}{
+		{"", "id=abc&infohash=def", http.StatusBadRequest, "", ""},
+		{acexy.STRICT_PRECEDENCE, "id=abc&infohash=def", http.StatusBadRequest, "", ""},
+		{acexy.STRICT_PRECEDENCE, "id=abc&infohash=abc", http.StatusOK, "infohash", "abc"},
+		{acexy.STRICT_PRECEDENCE, "id=abc", http.StatusOK, "id", "abc"},
+		{acexy.ID_PRECEDENCE, "id=abc&infohash=def", http.StatusOK, "id", "abc"},
+		{acexy.INFOHASH_PRECEDENCE, "id=abc&infohash=def", http.StatusOK, "infohash", "def"},
+		{acexy.INFOHASH_PRECEDENCE, "id=abc", http.StatusOK, "id", "abc"},
+	} {
+		acexyInst := &acexy.Acexy{
+			Scheme:            engineURL.Scheme,
+			Host:              engineURL.Hostname(),
+			Port:              parsePort(engineURL.Port()),
+			Endpoint:          acexy.MPEG_TS_ENDPOINT,
+			EmptyTimeout:      time.Second,
+			BufferSize:        1024,
+			NoResponseTimeout: time.Second,
+		}
+		acexyInst.Init()
+		proxy := &Proxy{Acexy: acexyInst, IDPrecedence: tt.precedence}
+
+		mu.Lock()
+		requested = nil
+		mu.Unlock()
+		w := httptest.NewRecorder()
+		proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?"+tt.query, nil))
+
+		if w.Code != tt.code {
+			t.Errorf("Precedence %q, query %s: expected status %d, got %d", tt.precedence, tt.query, tt.code, w.Code)
+			continue
+		}
+		mu.Lock()
+		got := requested
+		mu.Unlock()
+		if tt.param == "" {
+			if got != nil {
+				t.Errorf("Precedence %q, query %s: expected the engine not to be asked, got %v", tt.precedence, tt.query, got)
+			}
+			continue
+		}
+		other := map[string]string{"id": "infohash", "infohash": "id"}[tt.param]
+		if got.Get(tt.param) != tt.value || got.Has(other) {
+			t.Errorf("Precedence %q, query %s: expected only %s=%s sent to the engine, got %v", tt.precedence, tt.query, tt.param, tt.value, got)
+		}
+	}
+
+	if _, err := acexy.ParseIDPrecedence("both"); err == nil {
+		t.Error("Expected an invalid precedence to be rejected")
+	}
+	if precedence, err := acexy.ParseIDPrecedence(""); err != nil || precedence != acexy.STRICT_PRECEDENCE {
+		t.Errorf("Expected strict by default, got %q (%v)", precedence, err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestInstanceIDInEvents verifies the instance ID is sent in the started and ended events
+// and reported by /admin/summary
+func TestInstanceIDInEvents(t *testing.T) {
+	var mu sync.Mutex
+	ids := map[string]string{}
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev struct {
+			InstanceID string `json:"instance_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&ev)
+		mu.Lock()
+		ids[r.URL.Path] = ev.InstanceID
+		mu.Unlock()
+	}))
+	defer orch.Close()
+
+	proxy := NewProxy(Config{Orch: OrchConfig{URL: orch.URL, InstanceID: "acexy-eu-1", RequestTimeout: time.Second}})
+	defer proxy.Orch.Close()
+
+	stream := &acexy.AceStream{StatURL: "http://engine/stat", CommandURL: "http://engine/cmd"}
+	proxy.Orch.EmitStarted(startedStream{Host: "127.0.0.1", Port: 6878, KeyType: "content_id", Key: "test123", PlaybackID: "playback123", Stream: stream, StreamID: "test123|playback123", EngineContainerID: "engine-1"})
+	proxy.Orch.EmitEnded("test123|playback123", "completed")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		started, ended := ids["/events/stream_started"], ids["/events/stream_ended"]
+		mu.Unlock()
+		if started == "acexy-eu-1" && ended == "acexy-eu-1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the instance ID in both events, got started %q and ended %q", started, ended)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/summary", nil))
+	var summary struct {
+		InstanceID string `json:"instance_id"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&summary); err != nil || summary.InstanceID != "acexy-eu-1" {
+		t.Errorf("Expected the instance ID in the summary, got %q (%v)", summary.InstanceID, err)
+	}
+
+	if generated := NewProxy(Config{}); generated.InstanceID == "" {
+		t.Error("Expected an instance ID to be generated when not configured")
+	}
+}
@@ -0,0 +1,101 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Consecutive failed keepalive pings after which the engine is considered failing
+const KEEPALIVE_MAX_FAILURES = 3
+
+// How long an engine found failing is treated as unhealthy by the engine selection
+const ENGINE_FAILING_TTL = 1 * time.Minute
+
+// keepalive periodically hits the stat URL of the active streams, so engines do not reap
+// sessions that stay idle for a while (e.g. a paused live buffer), validating at the same
+// time that the engine is still alive.
+type keepalive struct {
+	interval time.Duration
+	client   *http.Client
+}
+
+// newKeepalive creates the keepalive. Returns nil (disabled) when the interval is not positive.
+func newKeepalive(interval time.Duration) *keepalive {
+	if interval <= 0 {
+		return nil
+	}
+	return &keepalive{interval: interval, client: &http.Client{Timeout: interval}}
+}
+
+// Run pings the stat URL every interval until the context is done. Once
+// KEEPALIVE_MAX_FAILURES consecutive pings failed, `onFailing` is called with the last
+// error and the pings stop.
+func (k *keepalive) Run(ctx context.Context, statURL string, onFailing func(error)) {
+	if k == nil || statURL == "" {
+		return
+	}
+
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := k.ping(ctx, statURL)
+		if err == nil {
+			failures = 0
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		failures++
+		slog.Debug("Keepalive ping failed", "stat_url", statURL, "failures", failures, "error", err)
+		if failures >= KEEPALIVE_MAX_FAILURES {
+			onFailing(err)
+			return
+		}
+	}
+}
+
+// ping performs a single request to the stat URL. The engine reports failures either with
+// an unexpected status code or with the `error` field of the response.
+func (k *keepalive) ping(ctx context.Context, statURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var stat struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stat); err == nil && stat.Error != "" {
+		return fmt.Errorf("engine error: %s", stat.Error)
+	}
+	return nil
+}
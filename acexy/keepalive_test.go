@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestKeepalivePollsStatURL verifies the stat URL of an active stream is polled at the
+// configured interval while the stream lasts, and no longer once it ends
+func TestKeepalivePollsStatURL(t *testing.T) {
+	var mu sync.Mutex
+	var polls []time.Time
+
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		polls = append(polls, time.Now())
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{"status": "dl"}, "error": nil})
+	}))
+	defer engine.Close()
+
+	const interval = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		defer close(done)
+		newKeepalive(interval).Run(ctx, engine.URL+"/ace/stat/test/playback123", func(err error) {
+			t.Errorf("Unexpected failing engine: %v", err)
+		})
+	}()
+
+	time.Sleep(5*interval + interval/2)
+	cancel()
+	<-done
+	time.Sleep(2 * interval)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(polls) < 4 || len(polls) > 6 {
+		t.Fatalf("Expected about 5 polls, got %d", len(polls))
+	}
+	if first := polls[0].Sub(start); first < interval/2 {
+		t.Errorf("Expected the first poll after one interval, got it after %v", first)
+	}
+	for i := 1; i < len(polls); i++ {
+		if gap := polls[i].Sub(polls[i-1]); gap < interval/2 || gap > 3*interval {
+			t.Errorf("Unexpected gap between polls %d and %d: %v", i-1, i, gap)
+		}
+	}
+}
+
+// TestKeepaliveMarksEngineFailing verifies repeated failed pings mark the engine as failing,
+// so the selection prefers other healthy engines
+func TestKeepaliveMarksEngineFailing(t *testing.T) {
+	var polls atomic.Int32
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first ping succeeds, then the engine starts failing
+		if polls.Add(1) == 1 {
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{}, "error": nil})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"response": nil, "error": "unknown playback session id"})
+	}))
+	defer engine.Close()
+
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-1", Host: "host-1", Port: 8001, HealthStatus: "healthy", Forwarded: true},
+				{ContainerID: "engine-2", Host: "host-2", Port: 8002, HealthStatus: "healthy"},
+			})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+	}
+	client.health.canProvision = true
+
+	if engine, err := client.SelectBestEngine(); err != nil || engine.ContainerID != "engine-1" {
+		t.Fatalf("Expected engine-1 before the keepalive failures, got %+v (%v)", engine, err)
+	}
+
+	var failing atomic.Bool
+	newKeepalive(10*time.Millisecond).Run(ctx, engine.URL+"/ace/stat/test/playback123", func(err error) {
+		failing.Store(true)
+		client.MarkEngineFailing("engine-1")
+	})
+
+	if !failing.Load() {
+		t.Fatal("Expected the engine to be reported failing")
+	}
+	if got := polls.Load(); got != 1+KEEPALIVE_MAX_FAILURES {
+		t.Errorf("Expected pings to stop after %d failures, got %d pings", KEEPALIVE_MAX_FAILURES, got)
+	}
+	if engine, err := client.SelectBestEngine(); err != nil || engine.ContainerID != "engine-2" {
+		t.Errorf("Expected engine-2 once engine-1 is failing, got %+v (%v)", engine, err)
+	}
+}
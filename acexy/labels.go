@@ -0,0 +1,56 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LabelSelector restricts the engines acexy may use to those carrying all of its labels.
+// It is given as `key=value` pairs separated by commas, e.g. `team=media,env=prod`.
+type LabelSelector map[string]string
+
+func (s *LabelSelector) Set(value string) error {
+	selector := LabelSelector{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !ok || key == "" {
+			return fmt.Errorf("invalid label selector %q: expected key=value", pair)
+		}
+		selector[key] = val
+	}
+	*s = selector
+	return nil
+}
+
+func (s *LabelSelector) String() string {
+	if s == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*s))
+	for key, val := range *s {
+		pairs = append(pairs, key+"="+val)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Matches reports whether the labels contain every key and value of the selector. An
+// empty selector matches everything.
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for key, val := range s {
+		if v, ok := labels[key]; !ok || v != val {
+			return false
+		}
+	}
+	return true
+}
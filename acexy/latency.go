@@ -0,0 +1,107 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds, in seconds, of the buckets of the request and selection latency histograms
+var LATENCY_BUCKETS = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Content type of the metrics in the OpenMetrics format, which carries the exemplars
+const OPENMETRICS_CONTENT_TYPE = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// Exemplar label holding the request ID, which joins the trace of the caller when adopted
+const EXEMPLAR_TRACE_LABEL = "trace_id"
+
+// exemplar is an observation kept as an example of its bucket
+type exemplar struct {
+	traceID string
+	value   float64
+	at      time.Time
+}
+
+// latencyHistogram accounts durations in LATENCY_BUCKETS, keeping the latest observation of
+// each bucket with its request ID as exemplar. The zero value is ready to use.
+type latencyHistogram struct {
+	mu        sync.Mutex
+	buckets   []uint64   // Observations of at most each bound, cumulative
+	exemplars []exemplar // Latest observation within each bound, the last one for +Inf
+	count     uint64
+	sum       float64
+}
+
+// Observe accounts the duration of the request with the given ID
+func (h *latencyHistogram) Observe(d time.Duration, traceID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.buckets == nil {
+		h.buckets = make([]uint64, len(LATENCY_BUCKETS))
+		h.exemplars = make([]exemplar, len(LATENCY_BUCKETS)+1)
+	}
+	seconds := d.Seconds()
+	bucket := len(LATENCY_BUCKETS)
+	for i := len(LATENCY_BUCKETS) - 1; i >= 0 && seconds <= LATENCY_BUCKETS[i]; i-- {
+		h.buckets[i]++
+		bucket = i
+	}
+	if traceID != "" {
+		h.exemplars[bucket] = exemplar{traceID: traceID, value: seconds, at: time.Now()}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// openMetricsWriter marks the metrics being written in the OpenMetrics format
+type openMetricsWriter struct {
+	io.Writer
+}
+
+// acceptsOpenMetrics reports whether the scraper asks for the OpenMetrics format
+func acceptsOpenMetrics(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		if mediaType, _, err := mime.ParseMediaType(part); err == nil && mediaType == "application/openmetrics-text" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeLatencyHistogram writes the histogram like writeHistogram, with the exemplar of
+// each bucket when written in the OpenMetrics format
+func writeLatencyHistogram(w io.Writer, name, help string, h *latencyHistogram) {
+	h.mu.Lock()
+	buckets := make([]uint64, len(LATENCY_BUCKETS))
+	copy(buckets, h.buckets)
+	exemplars := make([]exemplar, len(LATENCY_BUCKETS)+1)
+	copy(exemplars, h.exemplars)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	_, withExemplars := w.(*openMetricsWriter)
+	sample := func(le string, value uint64, ex exemplar) {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d", name, le, value)
+		if withExemplars && ex.traceID != "" {
+			fmt.Fprintf(w, " # {%s=%q} %g %.3f", EXEMPLAR_TRACE_LABEL, ex.traceID, ex.value, float64(ex.at.UnixMilli())/1000)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for i, bound := range LATENCY_BUCKETS {
+		sample(fmt.Sprintf("%g", bound), buckets[i], exemplars[i])
+	}
+	sample("+Inf", count, exemplars[len(LATENCY_BUCKETS)])
+	fmt.Fprintf(w, "%s_sum %g\n", name, sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, count)
+}
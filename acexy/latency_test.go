@@ -0,0 +1,91 @@
+package main
+
+import (
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOpenMetricsExemplars verifies the latency histograms carry the request ID of the
+// latest request as exemplar when enabled and asked for in the OpenMetrics format
+func TestOpenMetricsExemplars(t *testing.T) {
+	_, port := newStandbyTestEngine(t, "data", false)
+	acexyInst := &acexy.Acexy{
+		Scheme:            "http",
+		Host:              "127.0.0.1",
+		Port:              port,
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      5 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+	proxy := &Proxy{Acexy: acexyInst, InboundRequestIDHeader: "X-Trace-Id", OpenMetricsExemplars: true}
+
+	req := httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil)
+	req.Header.Set("X-Trace-Id", "4bf92f3577b34da6a3ce929d0e0e4736")
+	w := httptest.NewRecorder()
+	proxy.HandleStream(w, req)
+	if got := w.Body.String(); got != "data" {
+		t.Fatalf("Expected the stream to be served, got %q", got)
+	}
+
+	scrape := func(accept string) (string, string) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		proxy.HandleMetrics(w, req)
+		return w.Header().Get("Content-Type"), w.Body.String()
+	}
+
+	contentType, body := scrape("application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5")
+	if contentType != OPENMETRICS_CONTENT_TYPE {
+		t.Errorf("Expected the OpenMetrics content type, got %q", contentType)
+	}
+	for _, name := range []string{"acexy_engine_selection_seconds", "acexy_stream_start_seconds"} {
+		if !strings.Contains(body, name+"_count 1\n") || !strings.Contains(body, `} 1 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} `) {
+			t.Errorf("Expected an exemplar of the request in %s:\n%s", name, body)
+		}
+	}
+	if !strings.Contains(body, "# TYPE acexy_queue_timeouts counter\nacexy_queue_timeouts_total 0\n") {
+		t.Errorf("Expected the counter families named without the _total suffix:\n%s", body)
+	}
+	if !strings.HasSuffix(body, "\n# EOF\n") {
+		t.Errorf("Expected the OpenMetrics output to end with # EOF")
+	}
+
+	// Prometheus text format scrapers get no exemplars, nor do they when disabled
+	for _, enabled := range []bool{true, false} {
+		proxy.OpenMetricsExemplars = enabled
+		accept := "text/plain"
+		if !enabled {
+			accept = "application/openmetrics-text"
+		}
+		contentType, body := scrape(accept)
+		if !strings.HasPrefix(contentType, "text/plain") || strings.Contains(body, "trace_id") || strings.Contains(body, "# EOF") {
+			t.Errorf("Enabled %t, accept %s: expected the text format without exemplars, got %q:\n%s", enabled, accept, contentType, body)
+		}
+		if !strings.Contains(body, "acexy_engine_selection_seconds_count 1\n") {
+			t.Errorf("Expected the latency histograms in the text format:\n%s", body)
+		}
+	}
+}
+
+// TestLatencyHistogramBuckets verifies observations are counted cumulatively and exemplify
+// the lowest bucket they fall in
+func TestLatencyHistogramBuckets(t *testing.T) {
+	var h latencyHistogram
+	h.Observe(300*time.Millisecond, "a")
+	h.Observe(time.Minute, "b")
+	h.Observe(20*time.Millisecond, "")
+
+	if h.count != 3 || h.buckets[0] != 1 || h.buckets[3] != 2 || h.buckets[len(LATENCY_BUCKETS)-1] != 2 {
+		t.Errorf("Unexpected buckets %v with count %d", h.buckets, h.count)
+	}
+	if h.exemplars[3].traceID != "a" || h.exemplars[len(LATENCY_BUCKETS)].traceID != "b" || h.exemplars[0].traceID != "" {
+		t.Errorf("Unexpected exemplars %+v", h.exemplars)
+	}
+}
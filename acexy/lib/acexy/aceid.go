@@ -26,6 +26,46 @@ func NewAceID(id, infohash string) (AceID, error) {
 	return AceID{id: id, infohash: infohash}, nil
 }
 
+// Which of `id` and `infohash` is used when a request gives both
+type IDPrecedence string
+
+const (
+	STRICT_PRECEDENCE   IDPrecedence = "strict"   // Conflicting values are rejected, equal ones accepted
+	ID_PRECEDENCE       IDPrecedence = "id"       // The `id` wins
+	INFOHASH_PRECEDENCE IDPrecedence = "infohash" // The `infohash` wins
+)
+
+// ParseIDPrecedence validates the given precedence. Empty defaults to strict.
+func ParseIDPrecedence(precedence string) (IDPrecedence, error) {
+	switch p := IDPrecedence(precedence); p {
+	case "":
+		return STRICT_PRECEDENCE, nil
+	case STRICT_PRECEDENCE, ID_PRECEDENCE, INFOHASH_PRECEDENCE:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid ID precedence %q, expected strict, id or infohash", precedence)
+}
+
+// Create a new `AceID` object from a request that may give both `id` and `infohash`,
+// keeping the one the precedence selects. In strict mode, both are only accepted when
+// equal.
+func NewAceIDWithPrecedence(id, infohash string, precedence IDPrecedence) (AceID, error) {
+	if id != "" && infohash != "" {
+		switch precedence {
+		case ID_PRECEDENCE:
+			infohash = ""
+		case INFOHASH_PRECEDENCE:
+			id = ""
+		default:
+			if id != infohash {
+				return AceID{}, errors.New("conflicting `id` and `infohash` given, send only one of them")
+			}
+			id = ""
+		}
+	}
+	return NewAceID(id, infohash)
+}
+
 // Get the valid AceStream ID. If the `infohash` is set, it will be returned,
 // otherwise the `id`.
 func (a AceID) ID() (AceIDType, string) {
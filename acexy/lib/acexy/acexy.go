@@ -5,13 +5,18 @@
 package acexy
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"javinator9889/acexy/lib/pmw"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -33,6 +38,27 @@ type AceStreamResponse struct {
 type AceStreamMiddleware struct {
 	Response AceStreamResponse `json:"response"`
 	Error    string            `json:"error"`
+	// Auxiliary URLs (subtitles, thumbnails...) found in the response, keyed by their
+	// field name without the `_url` suffix
+	AuxURLs map[string]string `json:"-"`
+}
+
+// MiddlewareError is returned when the AceStream middleware answers the request with an
+// error message (e.g. unknown content) instead of the stream information
+type MiddlewareError struct {
+	Message string
+}
+
+func (e *MiddlewareError) Error() string { return e.Message }
+
+// EngineHTTPError is returned when the engine answers the stream request with an HTTP error
+// status instead of the middleware response, e.g. a 404 page
+type EngineHTTPError struct {
+	StatusCode int
+}
+
+func (e *EngineHTTPError) Error() string {
+	return fmt.Sprintf("engine returned HTTP status %d", e.StatusCode)
 }
 
 type AceStreamCommand struct {
@@ -50,6 +76,22 @@ type AceStream struct {
 	StatURL     string
 	CommandURL  string
 	ID          AceID
+	AuxURLs     map[string]string // Auxiliary URLs exposed by the middleware, keyed by name
+	Infohash    string            // Infohash of the content, as reported by the middleware
+	IsLive      bool              // Whether the content is a live broadcast (false for VOD)
+	IsEncrypted bool              // Whether the content is encrypted
+	Engine      string            // Host and port of the engine serving the stream
+	ContainerID string            // Container of the engine serving the stream, set by the caller when known
+}
+
+// Logger returns the logger of the stream, tagging every line with the stream ID and the
+// engine serving it so a stream can be traced to its engine
+func (s *AceStream) Logger() *slog.Logger {
+	logger := slog.With("stream", s.ID, "engine", s.Engine)
+	if s.ContainerID != "" {
+		logger = logger.With("container_id", s.ContainerID)
+	}
+	return logger
 }
 
 // Structure referencing the AceStream Proxy
@@ -59,15 +101,38 @@ type Acexy struct {
 	Host              string        // The host to be used when connecting to the AceStream middleware
 	Port              int           // The port to be used when connecting to the AceStream middleware
 	Endpoint          AcexyEndpoint // The endpoint to be used when connecting to the AceStream middleware
+	APIPrefix         string        // Path prepended to the endpoint, for engines exposing the middleware elsewhere
 	EmptyTimeout      time.Duration // Timeout after which, if no data is written, the stream is closed
 	BufferSize        int           // The buffer size to use when copying the data
+	WriteChunk        int           // Maximum bytes written to the clients at once (0 uses BufferSize)
 	NoResponseTimeout time.Duration // Timeout to wait for a response from the AceStream middleware
+	MaxStreamWorkers  int           // Maximum streams copied at once, the rest wait for a slot (0 is unbounded)
+	FirstByteTimeout  time.Duration // Time the engine has to send the first byte of a stream (0 waits for NoResponseTimeout)
+
+	// Whether the engine may redirect the stream requests to other hosts. Redirects within
+	// the engine host are always followed.
+	AllowForeignRedirects bool
+
+	// Time the addresses of the engine host names are cached, so connecting to the same engine
+	// does not resolve its container name each time (0 resolves it on each connection)
+	ResolveCacheTTL time.Duration
 
 	middleware *http.Client
+	engineAPI  http.RoundTripper // Transport of the middleware requests, nil for the default one
+	resolver   *hostResolver     // Cache of the engine host addresses, nil when disabled
+	workers    chan struct{} // Slots of the running copies, nil when unbounded
+	queue      workerQueue   // Streams waiting for a slot
 }
 
 type AcexyEndpoint string
 
+// Engine addresses the AceStream middleware a stream is fetched from
+type Engine struct {
+	Scheme string
+	Host   string
+	Port   int
+}
+
 // The AceStream API available endpoints
 const (
 	M3U8_ENDPOINT    AcexyEndpoint = "/ace/manifest.m3u8"
@@ -77,24 +142,42 @@ const (
 // Initializes the Acexy structure
 func (a *Acexy) Init() {
 	// The transport optimized for concurrent requests
+	transport := &http.Transport{
+		DisableCompression:    true,
+		MaxIdleConns:          100, // Increased for better concurrent performance
+		MaxConnsPerHost:       100, // Increased for better concurrent performance
+		MaxIdleConnsPerHost:   50,  // Reuse connections efficiently
+		IdleConnTimeout:       30 * time.Second,
+		ResponseHeaderTimeout: a.NoResponseTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
 	a.middleware = &http.Client{
-		Transport: &http.Transport{
-			DisableCompression:    true,
-			MaxIdleConns:          100, // Increased for better concurrent performance
-			MaxConnsPerHost:       100, // Increased for better concurrent performance
-			MaxIdleConnsPerHost:   50,  // Reuse connections efficiently
-			IdleConnTimeout:       30 * time.Second,
-			ResponseHeaderTimeout: a.NoResponseTimeout,
-			ExpectContinueTimeout: 1 * time.Second,
-		},
+		Transport:     transport,
+		CheckRedirect: a.checkRedirect,
+	}
+	// Both the middleware requests and the streams connect through the cached resolution
+	if a.resolver = newHostResolver(a.ResolveCacheTTL); a.resolver != nil {
+		transport.DialContext = a.resolver.DialContext
+		engineAPI := http.DefaultTransport.(*http.Transport).Clone()
+		engineAPI.DialContext = a.resolver.DialContext
+		a.engineAPI = engineAPI
+	}
+	if a.MaxStreamWorkers > 0 {
+		a.workers = make(chan struct{}, a.MaxStreamWorkers)
 	}
 }
 
 // FetchStream requests stream information from AceStream engine.
 // This is stateless - each request gets a unique PID and stream instance.
 func (a *Acexy) FetchStream(aceId AceID, extraParams url.Values) (*AceStream, error) {
+	return a.FetchStreamFrom(Engine{Scheme: a.Scheme, Host: a.Host, Port: a.Port}, aceId, extraParams)
+}
+
+// FetchStreamFrom is like FetchStream, but requests the stream from the given engine
+// instead of the configured one
+func (a *Acexy) FetchStreamFrom(engine Engine, aceId AceID, extraParams url.Values) (*AceStream, error) {
 	// Simply call the AceStream engine to get stream info
-	middleware, err := GetStream(a, aceId, extraParams)
+	middleware, err := getStreamFrom(a, engine, aceId, extraParams)
 	if err != nil {
 		slog.Error("Error getting stream middleware", "error", err)
 		return nil, err
@@ -107,9 +190,14 @@ func (a *Acexy) FetchStream(aceId AceID, extraParams url.Values) (*AceStream, er
 		StatURL:     middleware.Response.StatURL,
 		CommandURL:  middleware.Response.CommandURL,
 		ID:          aceId,
+		AuxURLs:     middleware.AuxURLs,
+		Infohash:    middleware.Response.Infohash,
+		IsLive:      middleware.Response.IsLive == 1,
+		IsEncrypted: middleware.Response.IsEncrypted == 1,
+		Engine:      net.JoinHostPort(engine.Host, strconv.Itoa(engine.Port)),
 	}
 
-	slog.Info("Fetched stream from engine", "id", aceId)
+	slog.Info("Fetched stream from engine", "id", aceId, "engine", stream.Engine)
 	return stream, nil
 }
 
@@ -117,13 +205,56 @@ func (a *Acexy) FetchStream(aceId AceID, extraParams url.Values) (*AceStream, er
 // This is stateless - just gets the stream from AceStream and copies it.
 // Returns the copier instance (for metrics) and any error that occurred.
 func (a *Acexy) StartStream(stream *AceStream, out io.Writer) (*Copier, error) {
-	// Get the stream from AceStream
-	resp, err := a.middleware.Get(stream.PlaybackURL)
+	return a.StartStreamContext(context.Background(), stream, out)
+}
+
+// StartStreamContext is like StartStream, but when the stream workers are capped, it stops
+// waiting for a free one as soon as the given context (usually the client request) is done.
+func (a *Acexy) StartStreamContext(ctx context.Context, stream *AceStream, out io.Writer) (*Copier, error) {
+	logger := stream.Logger()
+
+	// Wait for a free worker before requesting the stream, so the engine does not start
+	// sending data nobody reads yet
+	if a.workers != nil {
+		select {
+		case a.workers <- struct{}{}:
+			a.queue.leave(false, 0, false)
+		default:
+			logger.Debug("All stream workers busy, queuing the stream", "max_workers", cap(a.workers))
+			queuedAt := time.Now()
+			a.queue.enter()
+			select {
+			case a.workers <- struct{}{}:
+				a.queue.leave(true, time.Since(queuedAt), false)
+			case <-ctx.Done():
+				a.queue.leave(true, time.Since(queuedAt), true)
+				return nil, fmt.Errorf("waiting for a stream worker: %w", ctx.Err())
+			}
+		}
+		defer func() { <-a.workers }()
+	}
+
+	// Get the stream from AceStream, aborting it when the first byte takes too long
+	req, err := http.NewRequest(http.MethodGet, stream.PlaybackURL, nil)
 	if err != nil {
-		slog.Error("Failed to get stream", "error", err)
 		return nil, err
 	}
+	var watch *firstByteWatch
+	if a.FirstByteTimeout > 0 {
+		var reqCtx context.Context
+		reqCtx, watch = watchFirstByte(a.FirstByteTimeout)
+		defer watch.Stop()
+		req = req.WithContext(reqCtx)
+	}
+	resp, err := a.middleware.Do(req)
+	if err != nil {
+		err = watch.Err(err)
+		logger.Error("Failed to get stream", "error", err)
+		return nil, err
+	}
+	resp.Body = watch.Wrap(resp.Body)
 	defer resp.Body.Close()
+	notifyResponse(ctx, resp)
 
 	// Use buffered copier to reduce frame drops
 	// The larger buffer (configured via ACEXY_BUFFER, default 4.2MiB) helps smooth out streaming by:
@@ -135,33 +266,45 @@ func (a *Acexy) StartStream(stream *AceStream, out io.Writer) (*Copier, error) {
 		Source:       resp.Body,
 		EmptyTimeout: a.EmptyTimeout,
 		BufferSize:   a.BufferSize,
+		WriteChunk:   a.WriteChunk,
+		Logger:       logger,
+	}
+	// When fanning out to several clients, stop as soon as the last one leaves instead of
+	// reading from the engine until the empty timeout
+	if mw, ok := out.(*pmw.PMultiWriter); ok {
+		copier.Stop = mw.Empty()
 	}
 	
-	err = copier.Copy()
+	err = watch.Err(copier.Copy())
 	if err != nil {
 		// Don't suppress empty timeout errors - they should be reported
 		if errors.Is(err, ErrEmptyTimeout) {
-			slog.Debug("Stream copy ended due to empty timeout", "stream", stream.ID, "error", err)
+			logger.Debug("Stream copy ended due to empty timeout", "error", err)
 			return copier, err
 		}
 		// Suppress io.EOF as it's a normal stream completion
 		if !errors.Is(err, io.EOF) {
-			slog.Debug("Stream copy completed with error", "stream", stream.ID, "error", err)
+			logger.Debug("Stream copy completed with error", "error", err)
 			return copier, err
 		}
 	}
 
-	slog.Debug("Stream finished successfully", "stream", stream.ID)
+	logger.Debug("Stream finished successfully")
 	return copier, nil
 }
 
 // GetStream performs a request to the AceStream backend to start a new stream.
 // Each request gets a unique PID to prevent conflicts.
 func GetStream(a *Acexy, aceId AceID, extraParams url.Values) (*AceStreamMiddleware, error) {
+	return getStreamFrom(a, Engine{Scheme: a.Scheme, Host: a.Host, Port: a.Port}, aceId, extraParams)
+}
+
+// getStreamFrom is like GetStream, but performs the request to the given engine
+func getStreamFrom(a *Acexy, engine Engine, aceId AceID, extraParams url.Values) (*AceStreamMiddleware, error) {
 	slog.Debug("Getting stream", "id", aceId)
-	slog.Debug("Acexy Information", "scheme", a.Scheme, "host", a.Host, "port", a.Port)
+	slog.Debug("Acexy Information", "scheme", engine.Scheme, "host", engine.Host, "port", engine.Port)
 	
-	req, err := http.NewRequest("GET", a.Scheme+"://"+a.Host+":"+strconv.Itoa(a.Port)+string(a.Endpoint), nil)
+	req, err := http.NewRequest("GET", engine.Scheme+"://"+engine.Host+":"+strconv.Itoa(engine.Port)+a.APIPrefix+string(a.Endpoint), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -173,7 +316,10 @@ func GetStream(a *Acexy, aceId AceID, extraParams url.Values) (*AceStreamMiddlew
 	if extraParams == nil {
 		extraParams = req.URL.Query()
 	}
+	// Only the identifier the stream was resolved to reaches the engine
 	idType, id := aceId.ID()
+	extraParams.Del("id")
+	extraParams.Del("infohash")
 	extraParams.Set(string(idType), id)
 	extraParams.Set("format", "json")
 	extraParams.Set("pid", pid)
@@ -183,7 +329,8 @@ func GetStream(a *Acexy, aceId AceID, extraParams url.Values) (*AceStreamMiddlew
 
 	slog.Debug("Request URL", "url", req.URL.String())
 	client := &http.Client{
-		Timeout: a.NoResponseTimeout,
+		Timeout:   a.NoResponseTimeout,
+		Transport: a.engineAPI,
 	}
 	res, err := client.Do(req)
 	if err != nil {
@@ -192,6 +339,12 @@ func GetStream(a *Acexy, aceId AceID, extraParams url.Values) (*AceStreamMiddlew
 	}
 	defer res.Body.Close()
 
+	// Error pages are not middleware responses, don't try to decode them
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		slog.Debug("Engine returned an HTTP error", "status", res.StatusCode)
+		return nil, &EngineHTTPError{StatusCode: res.StatusCode}
+	}
+
 	// Read the response
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
@@ -208,11 +361,54 @@ func GetStream(a *Acexy, aceId AceID, extraParams url.Values) (*AceStreamMiddlew
 
 	if response.Error != "" {
 		slog.Debug("Error in stream response", "error", response.Error)
-		return nil, errors.New(response.Error)
+		return nil, &MiddlewareError{Message: response.Error}
 	}
+	response.AuxURLs = parseAuxURLs(body)
 	return &response, nil
 }
 
+// parseAuxURLs collects the auxiliary URLs the middleware may include in its response
+// besides the well-known playback, stat and command ones (e.g. `subtitles_url`)
+func parseAuxURLs(body []byte) map[string]string {
+	var raw struct {
+		Response map[string]any `json:"response"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil
+	}
+
+	var aux map[string]string
+	for key, value := range raw.Response {
+		name, ok := strings.CutSuffix(key, "_url")
+		if !ok || name == "playback" || name == "stat" || name == "command" {
+			continue
+		}
+		if url, ok := value.(string); ok && url != "" {
+			if aux == nil {
+				aux = make(map[string]string)
+			}
+			aux[name] = url
+		}
+	}
+	return aux
+}
+
+// FetchAux requests an auxiliary resource exposed by the middleware using the same
+// transport as the streams. The caller must close the response body.
+func (a *Acexy) FetchAux(auxURL string) (*http.Response, error) {
+	return a.middleware.Get(auxURL)
+}
+
+// FetchStat requests the statistics of a stream (peers, speeds...) from its stat URL, until
+// the given context is done. The caller must close the response body.
+func (a *Acexy) FetchStat(ctx context.Context, stream *AceStream) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, stream.StatURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return a.middleware.Do(req)
+}
+
 // CloseStream closes a stream by sending a stop command to the AceStream backend.
 func CloseStream(stream *AceStream) error {
 	req, err := http.NewRequest("GET", stream.CommandURL, nil)
@@ -6,11 +6,15 @@ package acexy
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"javinator9889/acexy/lib/pmw"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -90,6 +94,36 @@ func TestFetchStreamStateless(t *testing.T) {
 	}
 }
 
+// TestFetchStreamAPIPrefix verifies the middleware is requested under the configured prefix
+func TestFetchStreamAPIPrefix(t *testing.T) {
+	var requestedPath string
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"response": {"playback_url": "http://localhost/stream", "stat_url": "http://localhost/stat", "command_url": "http://localhost/cmd"}}`))
+	}))
+	defer engine.Close()
+
+	u, _ := url.Parse(engine.URL)
+	acexyInst := &Acexy{
+		Scheme:            u.Scheme,
+		Host:              u.Hostname(),
+		Port:              parseInt(u.Port()),
+		Endpoint:          MPEG_TS_ENDPOINT,
+		APIPrefix:         "/hls/v2",
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+
+	aceID, _ := NewAceID("test-stream", "")
+	if _, err := acexyInst.FetchStream(aceID, nil); err != nil {
+		t.Fatalf("FetchStream failed: %v", err)
+	}
+	if requestedPath != "/hls/v2/ace/getstream" {
+		t.Errorf("Expected request to /hls/v2/ace/getstream, got %s", requestedPath)
+	}
+}
+
 // TestStartStreamStateless tests that StartStream directly proxies without state
 func TestStartStreamStateless(t *testing.T) {
 	streamData := []byte("test stream data content")
@@ -167,6 +201,75 @@ func TestStartStreamStateless(t *testing.T) {
 	}
 }
 
+// TestStartStreamAllWritersRemoved verifies that when every client of a multiwriter leaves
+// at once, the copy stops right away and the engine connection is released, instead of
+// reading from the engine until the empty timeout
+func TestStartStreamAllWritersRemoved(t *testing.T) {
+	released := make(chan struct{})
+	streamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/MP2T")
+		chunk := bytes.Repeat([]byte{0x47}, 188)
+		for {
+			select {
+			case <-r.Context().Done():
+				close(released)
+				return
+			case <-time.After(5 * time.Millisecond):
+				w.Write(chunk)
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	defer streamServer.Close()
+
+	acexyInst := &Acexy{
+		EmptyTimeout:      10 * time.Second,
+		BufferSize:        188,
+		NoResponseTimeout: 10 * time.Second,
+	}
+	acexyInst.Init()
+	stream := &AceStream{PlaybackURL: streamServer.URL}
+
+	writers := make([]*bytes.Buffer, 5)
+	out := pmw.New()
+	for i := range writers {
+		writers[i] = &bytes.Buffer{}
+		out.Add(writers[i])
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := acexyInst.StartStream(stream, out)
+		done <- err
+	}()
+
+	// Let some data flow, then drop every client simultaneously
+	time.Sleep(100 * time.Millisecond)
+	var wg sync.WaitGroup
+	for _, w := range writers {
+		wg.Add(1)
+		go func(w *bytes.Buffer) {
+			defer wg.Done()
+			out.Remove(w)
+		}(w)
+	}
+	wg.Wait()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrStopped) {
+			t.Errorf("Expected ErrStopped, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stream kept copying after all the writers were removed")
+	}
+	select {
+	case <-released:
+	case <-time.After(2 * time.Second):
+		t.Error("Engine connection was not released after all the writers were removed")
+	}
+}
+
 // TestConcurrentRequests tests that multiple concurrent requests work without blocking
 func TestConcurrentRequests(t *testing.T) {
 	requestCount := 0
@@ -268,3 +371,114 @@ func parseInt(s string) int {
 	fmt.Sscanf(s, "%d", &i)
 	return i
 }
+
+// lockedBuffer is a bytes.Buffer safe for the concurrent writes of a logger
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestStartStreamLogsEngine verifies the lines logged while a stream is copied carry the
+// engine and the container serving it
+func TestStartStreamLogsEngine(t *testing.T) {
+	// Sends some data, then stalls until the empty timeout is triggered
+	release := make(chan struct{})
+	streamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("test stream data content"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer streamServer.Close()
+	defer close(release)
+
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fmt.Sprintf(`{"response": {"playback_url": "%s"}}`, streamServer.URL)))
+	}))
+	defer engine.Close()
+
+	u, _ := url.Parse(engine.URL)
+	acexyInst := &Acexy{
+		Scheme:            u.Scheme,
+		Host:              u.Hostname(),
+		Port:              parseInt(u.Port()),
+		Endpoint:          MPEG_TS_ENDPOINT,
+		EmptyTimeout:      500 * time.Millisecond,
+		BufferSize:        1024,
+		NoResponseTimeout: 10 * time.Second,
+	}
+	acexyInst.Init()
+
+	aceID, _ := NewAceID("test-stream", "")
+	stream, err := acexyInst.FetchStream(aceID, nil)
+	if err != nil {
+		t.Fatalf("FetchStream failed: %v", err)
+	}
+	if expected := u.Host; stream.Engine != expected {
+		t.Errorf("Expected engine %q, got %q", expected, stream.Engine)
+	}
+	stream.ContainerID = "engine-abc"
+
+	var logs lockedBuffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(previous)
+
+	var output bytes.Buffer
+	if _, err := acexyInst.StartStream(stream, &output); !errors.Is(err, ErrEmptyTimeout) {
+		t.Fatalf("Expected the empty timeout, got %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if !strings.Contains(logs.String(), "Stream empty timeout triggered") {
+		t.Fatalf("Expected the empty timeout to be logged, got: %s", logs.String())
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "container_id=engine-abc") || !strings.Contains(line, "engine="+u.Host) {
+			t.Errorf("Log line without the engine: %s", line)
+		}
+	}
+}
+
+// TestFetchStreamEngineHTTPError verifies an engine answering with an HTTP error page is
+// reported with its status instead of a decoding error
+func TestFetchStreamEngineHTTPError(t *testing.T) {
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<html><body>Not Found</body></html>"))
+	}))
+	defer engine.Close()
+
+	u, _ := url.Parse(engine.URL)
+	acexyInst := &Acexy{
+		Scheme:            u.Scheme,
+		Host:              u.Hostname(),
+		Port:              parseInt(u.Port()),
+		Endpoint:          MPEG_TS_ENDPOINT,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+
+	aceID, _ := NewAceID("test-stream", "")
+	_, err := acexyInst.FetchStream(aceID, nil)
+	var httpErr *EngineHTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected an engine HTTP 404 error, got %v", err)
+	}
+	if err.Error() != "engine returned HTTP status 404" {
+		t.Errorf("Unexpected error message %q", err.Error())
+	}
+}
@@ -4,14 +4,25 @@ import (
 	"bufio"
 	"errors"
 	"io"
+	"javinator9889/acexy/lib/pmw"
 	"log/slog"
 	"sync/atomic"
 	"time"
 )
 
+// TS_PACKET_SIZE is the size of a single MPEG-TS packet
+const TS_PACKET_SIZE = 188
+
+// MIN_BUFFER_SIZE is the smallest buffer the Copier uses. Smaller (non-zero) sizes are raised
+// to it so a TS packet is never split across several writes. Zero keeps the bufio default.
+const MIN_BUFFER_SIZE = TS_PACKET_SIZE
+
 // ErrEmptyTimeout is returned when the copier times out waiting for data
 var ErrEmptyTimeout = errors.New("stream empty timeout: no data received within timeout period")
 
+// ErrStopped is returned when the copier was stopped through its Stop channel
+var ErrStopped = errors.New("stream copy stopped: no destination left")
+
 // Copier is an implementation that copies the data from the source to the destination.
 // It has an empty timeout that is used to determine when the source is empty - this is,
 // it has no more data to read after the timeout.
@@ -22,19 +33,42 @@ type Copier struct {
 	Source io.Reader
 	// The timeout to use when the source is empty.
 	EmptyTimeout time.Duration
-	// The buffer size to use when copying the data.
+	// The buffer size to use when copying the data: the size of the reads from the source,
+	// and of the writes to the destination unless WriteChunk is set. Non-zero values below
+	// MIN_BUFFER_SIZE are raised to it.
 	BufferSize int
+	// Maximum bytes written to the destination at once. Smaller chunks reach the clients
+	// sooner, while larger reads keep the throughput. Zero uses BufferSize. Non-zero values
+	// below MIN_BUFFER_SIZE are raised to it.
+	WriteChunk int
+	// Optional channel that stops the copy once closed, e.g. when the destination has no
+	// clients left. Nil never stops the copy.
+	Stop <-chan struct{}
+	// Optional logger the copy is logged with, e.g. tagged with the stream and its engine.
+	// Nil uses the default logger.
+	Logger *slog.Logger
 
 	/**! Private Data */
 	timer          *time.Timer
 	bufferedWriter *bufio.Writer
+	writeChunk     int
 	bytesCopied    int64
 	timedOut       atomic.Bool
+	stopped        atomic.Bool
 }
 
 // Starts copying the data from the source to the destination.
 func (c *Copier) Copy() error {
-	c.bufferedWriter = bufio.NewWriterSize(c.Destination, c.BufferSize)
+	logger := c.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	writeChunk := copyBufferSize(c.WriteChunk)
+	if writeChunk == 0 {
+		writeChunk = copyBufferSize(c.BufferSize)
+	}
+	c.bufferedWriter = bufio.NewWriterSize(fullWriter{c.Destination}, writeChunk)
+	c.writeChunk = c.bufferedWriter.Size()
 	c.timer = time.NewTimer(c.EmptyTimeout)
 	done := make(chan struct{})
 	defer close(done)
@@ -44,22 +78,31 @@ func (c *Copier) Copy() error {
 			c.timer.Reset(c.EmptyTimeout)
 			select {
 			case <-done:
-				slog.Debug("Done copying", "source", c.Source, "destination", c.Destination)
+				logger.Debug("Done copying", "source", c.Source, "destination", c.Destination)
 				return
 			case <-c.timer.C:
 				// On timeout, mark as timed out and close the source to interrupt io.Copy
 				// We don't flush here to avoid race conditions with the main goroutine,
 				// which may still be writing data. Flushing only happens in the main goroutine.
 				c.timedOut.Store(true)
-				slog.Info("Stream empty timeout triggered", "empty_timeout", c.EmptyTimeout, "bytes_copied", atomic.LoadInt64(&c.bytesCopied))
+				logger.Info("Stream empty timeout triggered", "empty_timeout", c.EmptyTimeout, "bytes_copied", atomic.LoadInt64(&c.bytesCopied))
 				// Close source to interrupt the io.Copy operation
 				if closer, ok := c.Source.(io.Closer); ok {
-					slog.Debug("Closing source due to empty timeout", "source", c.Source)
+					logger.Debug("Closing source due to empty timeout", "source", c.Source)
 					closer.Close()
 				}
 				// Close destination to signal end of stream
 				if closer, ok := c.Destination.(io.Closer); ok {
-					slog.Debug("Closing destination due to empty timeout", "destination", c.Destination)
+					logger.Debug("Closing destination due to empty timeout", "destination", c.Destination)
+					closer.Close()
+				}
+				return
+			case <-c.Stop:
+				// Nobody is reading anymore, interrupt the io.Copy right away instead of
+				// waiting for the empty timeout
+				c.stopped.Store(true)
+				logger.Info("Stream copy stopped", "bytes_copied", atomic.LoadInt64(&c.bytesCopied))
+				if closer, ok := c.Source.(io.Closer); ok {
 					closer.Close()
 				}
 				return
@@ -67,20 +110,30 @@ func (c *Copier) Copy() error {
 		}
 	}()
 
-	_, err := io.Copy(c, c.Source)
+	var buf []byte
+	if size := copyBufferSize(c.BufferSize); size > 0 {
+		buf = make([]byte, size)
+	}
+	_, err := io.CopyBuffer(c, c.Source, buf)
 	
 	// Flush the buffer when copy completes (EOF or error)
 	// This ensures buffered data is written before returning
 	if ferr := c.bufferedWriter.Flush(); ferr != nil {
-		slog.Debug("Error flushing buffer", "error", ferr)
+		logger.Debug("Error flushing buffer", "error", ferr)
 		if err == nil {
 			err = ferr
 		}
 	}
 	
+	// If the copy was stopped, return ErrStopped instead of the underlying error
+	if c.stopped.Load() {
+		logger.Debug("Returning stopped error", "underlying_error", err)
+		return ErrStopped
+	}
+
 	// If the timeout occurred, return ErrEmptyTimeout instead of the underlying error
 	if c.timedOut.Load() {
-		slog.Debug("Returning empty timeout error", "underlying_error", err)
+		logger.Debug("Returning empty timeout error", "underlying_error", err)
 		return ErrEmptyTimeout
 	}
 	
@@ -97,13 +150,39 @@ func (c *Copier) Write(p []byte) (n int, err error) {
 	}
 	// Reset the timer, since we have data to write
 	c.timer.Reset(c.EmptyTimeout)
-	// Write the data to the destination
-	n, err = c.bufferedWriter.Write(p)
-	atomic.AddInt64(&c.bytesCopied, int64(n))
-	return n, err
+	// Write the data to the destination, in chunks of at most the write chunk size
+	for len(p) > 0 {
+		chunk := p[:min(len(p), c.writeChunk)]
+		m, err := c.bufferedWriter.Write(chunk)
+		n += m
+		atomic.AddInt64(&c.bytesCopied, int64(m))
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
 }
 
 // BytesCopied returns the total number of bytes copied
 func (c *Copier) BytesCopied() int64 {
 	return atomic.LoadInt64(&c.bytesCopied)
 }
+
+// fullWriter completes the short writes of the destination, which bufio would otherwise fail
+// with io.ErrShortWrite, dropping the rest of the buffer
+type fullWriter struct {
+	io.Writer
+}
+
+func (w fullWriter) Write(p []byte) (int, error) {
+	return pmw.WriteFull(w.Writer, p)
+}
+
+// copyBufferSize applies the MIN_BUFFER_SIZE floor to the configured buffer size
+func copyBufferSize(size int) int {
+	if size > 0 && size < MIN_BUFFER_SIZE {
+		return MIN_BUFFER_SIZE
+	}
+	return size
+}
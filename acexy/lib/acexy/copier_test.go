@@ -3,6 +3,7 @@ package acexy
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"testing"
 	"time"
@@ -110,3 +111,145 @@ func TestCopier_BytesCopied(t *testing.T) {
 		t.Errorf("Expected %d bytes copied, got %d", expected, copier.BytesCopied())
 	}
 }
+
+func TestCopier_BufferSizeFloor(t *testing.T) {
+	for size, expected := range map[int]int{0: 0, 1: MIN_BUFFER_SIZE, 187: MIN_BUFFER_SIZE, 188: 188, 4096: 4096} {
+		if got := copyBufferSize(size); got != expected {
+			t.Errorf("Expected buffer size %d for %d, got %d", expected, size, got)
+		}
+	}
+
+	data := bytes.Repeat([]byte{0x47}, 3*TS_PACKET_SIZE)
+	var dst bytes.Buffer
+	c := &Copier{
+		Destination:  &dst,
+		Source:       bytes.NewReader(data),
+		EmptyTimeout: time.Second,
+		BufferSize:   1,
+	}
+	if err := c.Copy(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c.bufferedWriter.Size() != MIN_BUFFER_SIZE {
+		t.Errorf("Expected a %d byte buffer, got %d", MIN_BUFFER_SIZE, c.bufferedWriter.Size())
+	}
+	if !bytes.Equal(dst.Bytes(), data) {
+		t.Errorf("Copied data mismatch: %d bytes", dst.Len())
+	}
+}
+
+// trickleWriter accepts a few bytes per write without reporting an error, as some custom
+// response writers do
+type trickleWriter struct {
+	bytes.Buffer
+}
+
+func (w *trickleWriter) Write(p []byte) (int, error) {
+	return w.Buffer.Write(p[:min(len(p), 5)])
+}
+
+func TestCopier_ShortWrites(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	var dst trickleWriter
+	c := &Copier{
+		Destination:  &dst,
+		Source:       bytes.NewReader(data),
+		EmptyTimeout: time.Second,
+		BufferSize:   TS_PACKET_SIZE,
+	}
+	if err := c.Copy(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(dst.Bytes(), data) {
+		t.Errorf("Expected every byte delivered, got %d of %d", dst.Len(), len(data))
+	}
+}
+
+// chunkRecorder records the size of each write and when the first one happened
+type chunkRecorder struct {
+	first   time.Time
+	largest int
+	total   int
+}
+
+func (w *chunkRecorder) Write(p []byte) (int, error) {
+	if w.first.IsZero() {
+		w.first = time.Now()
+	}
+	w.largest = max(w.largest, len(p))
+	w.total += len(p)
+	return len(p), nil
+}
+
+func TestCopier_WriteChunk(t *testing.T) {
+	data := bytes.Repeat([]byte{0x47}, 64*TS_PACKET_SIZE)
+	for _, tt := range []struct {
+		bufferSize, writeChunk, largest int
+	}{
+		{16 * TS_PACKET_SIZE, 0, 16 * TS_PACKET_SIZE},
+		{16 * TS_PACKET_SIZE, 4 * TS_PACKET_SIZE, 4 * TS_PACKET_SIZE},
+		{16 * TS_PACKET_SIZE, 1, MIN_BUFFER_SIZE},
+	} {
+		var dst chunkRecorder
+		c := &Copier{
+			Destination:  &dst,
+			Source:       bytes.NewReader(data),
+			EmptyTimeout: time.Second,
+			BufferSize:   tt.bufferSize,
+			WriteChunk:   tt.writeChunk,
+		}
+		if err := c.Copy(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if dst.largest != tt.largest {
+			t.Errorf("Write chunk %d: expected writes of at most %d bytes, got %d", tt.writeChunk, tt.largest, dst.largest)
+		}
+		if dst.total != len(data) || c.BytesCopied() != int64(len(data)) {
+			t.Errorf("Write chunk %d: expected %d bytes copied, got %d (%d counted)", tt.writeChunk, len(data), dst.total, c.BytesCopied())
+		}
+	}
+}
+
+// pacedReader returns the data in bursts arriving at a steady rate, as an engine does
+type pacedReader struct {
+	remaining int
+	burst     int
+	interval  time.Duration
+}
+
+func (r *pacedReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.interval)
+	n := min(len(p), r.burst, r.remaining)
+	r.remaining -= n
+	return n, nil
+}
+
+// BenchmarkCopier_WriteChunk measures how long the clients wait for their first bytes with
+// a large read buffer, depending on the write chunk size
+func BenchmarkCopier_WriteChunk(b *testing.B) {
+	const readBuffer = 1 << 20
+	for _, writeChunk := range []int{readBuffer, 256 << 10, 64 << 10, 16 << 10} {
+		b.Run(fmt.Sprintf("writeChunk=%dKiB", writeChunk>>10), func(b *testing.B) {
+			var latency time.Duration
+			for i := 0; i < b.N; i++ {
+				var dst chunkRecorder
+				c := &Copier{
+					Destination:  &dst,
+					Source:       &pacedReader{remaining: 2 << 20, burst: 16 << 10, interval: 50 * time.Microsecond},
+					EmptyTimeout: time.Second,
+					BufferSize:   readBuffer,
+					WriteChunk:   writeChunk,
+				}
+				start := time.Now()
+				if err := c.Copy(); err != nil {
+					b.Fatal(err)
+				}
+				latency += dst.first.Sub(start)
+			}
+			b.ReportMetric(float64(latency.Microseconds())/float64(b.N), "µs/first-write")
+		})
+	}
+}
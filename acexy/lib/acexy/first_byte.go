@@ -0,0 +1,79 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package acexy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrFirstByteTimeout is returned when the engine accepts the stream request but sends no
+// data within the first byte timeout, usually stuck resolving the content
+var ErrFirstByteTimeout = errors.New("first byte timeout: engine sent no data within the timeout period")
+
+// firstByteWatch aborts a stream request when its first byte does not arrive in time
+type firstByteWatch struct {
+	cancel   context.CancelFunc
+	timer    *time.Timer
+	received atomic.Bool
+	expired  atomic.Bool
+}
+
+// watchFirstByte returns the context the stream request must be made with, cancelled when
+// no byte was read from the wrapped response body after the timeout
+func watchFirstByte(timeout time.Duration) (context.Context, *firstByteWatch) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &firstByteWatch{cancel: cancel}
+	w.timer = time.AfterFunc(timeout, func() {
+		if !w.received.Load() {
+			w.expired.Store(true)
+			cancel()
+		}
+	})
+	return ctx, w
+}
+
+// Wrap returns the response body, flagging the first byte once read
+func (w *firstByteWatch) Wrap(body io.ReadCloser) io.ReadCloser {
+	if w == nil {
+		return body
+	}
+	return &firstByteReader{ReadCloser: body, watch: w}
+}
+
+// Err returns ErrFirstByteTimeout wrapping the given error when the watch aborted the
+// request, or the error itself otherwise
+func (w *firstByteWatch) Err(err error) error {
+	if w == nil || err == nil || !w.expired.Load() {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrFirstByteTimeout, err)
+}
+
+// Stop releases the watch, once the request is done
+func (w *firstByteWatch) Stop() {
+	if w != nil {
+		w.timer.Stop()
+		w.cancel()
+	}
+}
+
+type firstByteReader struct {
+	io.ReadCloser
+	watch *firstByteWatch
+}
+
+func (r *firstByteReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && !r.watch.received.Load() {
+		r.watch.received.Store(true)
+		r.watch.timer.Stop()
+	}
+	return n, err
+}
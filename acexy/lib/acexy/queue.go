@@ -0,0 +1,90 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package acexy
+
+import (
+	"sync"
+	"time"
+)
+
+// Upper bounds, in seconds, of the buckets the stream worker waits are counted in
+var QUEUE_WAIT_BUCKETS = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// QueueStats describes the streams waiting for a free stream worker
+type QueueStats struct {
+	MaxWorkers int      // Maximum streams copied at once (0 is unbounded, so nothing queues)
+	Depth      int      // Streams currently waiting for a worker
+	Timeouts   uint64   // Streams that stopped waiting before a worker was free
+	Buckets    []uint64 // Waits of at most each of QUEUE_WAIT_BUCKETS, cumulative
+	Count      uint64   // Streams that got a worker
+	Sum        float64  // Seconds waited by the streams that got a worker
+}
+
+// workerQueue accounts the streams waiting for a free worker
+type workerQueue struct {
+	mu       sync.Mutex
+	depth    int
+	timeouts uint64
+	buckets  []uint64
+	count    uint64
+	sum      float64
+}
+
+// enter records a stream starting to wait for a worker
+func (q *workerQueue) enter() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.depth++
+}
+
+// leave records a stream done waiting, either with a worker or timed out. Streams getting a
+// worker right away are recorded with a zero wait, without having entered the queue.
+func (q *workerQueue) leave(queued bool, waited time.Duration, timedOut bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if queued {
+		q.depth--
+	}
+	if timedOut {
+		q.timeouts++
+		return
+	}
+
+	if q.buckets == nil {
+		q.buckets = make([]uint64, len(QUEUE_WAIT_BUCKETS))
+	}
+	seconds := waited.Seconds()
+	for i, bound := range QUEUE_WAIT_BUCKETS {
+		if seconds <= bound {
+			q.buckets[i]++
+		}
+	}
+	q.count++
+	q.sum += seconds
+}
+
+// QueueStats returns the state of the streams waiting for a free worker. It is safe to call
+// on a nil Acexy.
+func (a *Acexy) QueueStats() QueueStats {
+	if a == nil {
+		return QueueStats{Buckets: make([]uint64, len(QUEUE_WAIT_BUCKETS))}
+	}
+
+	a.queue.mu.Lock()
+	defer a.queue.mu.Unlock()
+
+	stats := QueueStats{
+		MaxWorkers: cap(a.workers),
+		Depth:      a.queue.depth,
+		Timeouts:   a.queue.timeouts,
+		Buckets:    make([]uint64, len(QUEUE_WAIT_BUCKETS)),
+		Count:      a.queue.count,
+		Sum:        a.queue.sum,
+	}
+	copy(stats.Buckets, a.queue.buckets)
+	return stats
+}
@@ -0,0 +1,46 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package acexy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// The maximum redirects followed when requesting the engine, as the default HTTP client does
+const MAX_REDIRECTS = 10
+
+// RedirectError is returned when the engine redirects a stream request to another host,
+// which is refused unless foreign redirects are allowed
+type RedirectError struct {
+	From string // Host the request was sent to
+	To   string // Host the engine redirected to
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("engine at %s redirected to another host: %s", e.From, e.To)
+}
+
+// checkRedirect follows the redirects that stay on the host of the original request. The
+// redirects to other hosts are refused, or only logged when foreign redirects are allowed.
+func (a *Acexy) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= MAX_REDIRECTS {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	from, to := via[0].URL.Hostname(), req.URL.Hostname()
+	if strings.EqualFold(from, to) {
+		return nil
+	}
+	if a.AllowForeignRedirects {
+		slog.Warn("Following engine redirect to another host", "from", via[0].URL.Host, "to", req.URL.Host)
+		return nil
+	}
+	slog.Warn("Refusing engine redirect to another host", "from", via[0].URL.Host, "to", req.URL.Host)
+	return &RedirectError{From: via[0].URL.Host, To: req.URL.Host}
+}
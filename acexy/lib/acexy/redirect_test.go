@@ -0,0 +1,83 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package acexy
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestStartStreamRedirect verifies redirects of the playback URL are only followed within
+// the engine host, unless foreign redirects are allowed
+func TestStartStreamRedirect(t *testing.T) {
+	var foreignRequests atomic.Int32
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		foreignRequests.Add(1)
+		w.Write([]byte("foreign data"))
+	}))
+	defer foreign.Close()
+
+	// Reach the foreign server through another host name than the engine
+	foreignURL, _ := url.Parse(foreign.URL)
+	foreignURL.Host = strings.Replace(foreignURL.Host, "127.0.0.1", "localhost", 1)
+
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stream/local":
+			http.Redirect(w, r, "/stream/data", http.StatusFound)
+		case "/stream/foreign":
+			http.Redirect(w, r, foreignURL.String()+"/stream", http.StatusFound)
+		case "/stream/data":
+			w.Write([]byte("engine data"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer engine.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		allow       bool
+		expected    string
+		refused     bool
+		foreignHits int32
+	}{
+		{"same host", "/stream/local", false, "engine data", false, 0},
+		{"other host refused", "/stream/foreign", false, "", true, 0},
+		{"other host allowed", "/stream/foreign", true, "foreign data", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			foreignRequests.Store(0)
+			acexyInst := &Acexy{EmptyTimeout: 5 * time.Second, BufferSize: 1024, AllowForeignRedirects: tt.allow}
+			acexyInst.Init()
+
+			var output bytes.Buffer
+			_, err := acexyInst.StartStream(&AceStream{PlaybackURL: engine.URL + tt.path}, &output)
+
+			var redirectErr *RedirectError
+			if refused := errors.As(err, &redirectErr); refused != tt.refused {
+				t.Fatalf("Expected the redirect refused: %v, got error: %v", tt.refused, err)
+			}
+			if tt.refused && redirectErr.To != foreignURL.Host {
+				t.Errorf("Expected the redirect to %s to be reported, got %s", foreignURL.Host, redirectErr.To)
+			}
+			if output.String() != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, output.String())
+			}
+			if got := foreignRequests.Load(); got != tt.foreignHits {
+				t.Errorf("Expected %d requests to the other host, got %d", tt.foreignHits, got)
+			}
+		})
+	}
+}
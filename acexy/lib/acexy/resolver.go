@@ -0,0 +1,123 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package acexy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// Time given to each connection attempt of the resolving dialer
+const RESOLVER_DIAL_TIMEOUT = 10 * time.Second
+
+// resolvedHost holds the addresses a host name resolved to, until they expire
+type resolvedHost struct {
+	addrs   []string
+	expires time.Time
+}
+
+// hostResolver caches the addresses the engine host names (usually container names) resolve
+// to, so repeated connections to an engine don't resolve its name each time
+type hostResolver struct {
+	ttl    time.Duration
+	lookup func(ctx context.Context, host string) ([]string, error)
+	dialer net.Dialer
+
+	mu    sync.Mutex
+	hosts map[string]resolvedHost
+}
+
+// newHostResolver creates the resolver cache keeping the addresses for the given time.
+// Returns nil (no caching) when the time is not positive.
+func newHostResolver(ttl time.Duration) *hostResolver {
+	if ttl <= 0 {
+		return nil
+	}
+	return &hostResolver{
+		ttl:    ttl,
+		lookup: net.DefaultResolver.LookupHost,
+		dialer: net.Dialer{Timeout: RESOLVER_DIAL_TIMEOUT},
+		hosts:  make(map[string]resolvedHost),
+	}
+}
+
+// Resolve returns the addresses of the host, from the cache while they are fresh. When the
+// host cannot be resolved anew, the expired addresses are used rather than failing.
+func (r *hostResolver) Resolve(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	r.mu.Lock()
+	cached, ok := r.hosts[host]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.addrs, nil
+	}
+
+	addrs, err := r.lookup(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+	}
+	if err != nil {
+		if ok {
+			slog.Warn("Failed to resolve the engine host, using its expired addresses", "host", host, "error", err)
+			return cached.addrs, nil
+		}
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.hosts[host] = resolvedHost{addrs: addrs, expires: time.Now().Add(r.ttl)}
+	r.mu.Unlock()
+	return addrs, nil
+}
+
+// Forget drops the cached addresses of the host, so the next connection resolves it anew
+func (r *hostResolver) Forget(host string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.hosts, host)
+}
+
+// DialContext connects to the address through the cached resolution of its host. When no
+// cached address accepts the connection, the engine may have moved, so the host is resolved
+// anew and dialed once more.
+func (r *hostResolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := r.dial(ctx, network, host, port)
+	if err == nil || net.ParseIP(host) != nil || ctx.Err() != nil {
+		return conn, err
+	}
+	slog.Debug("Failed to connect to the cached engine addresses, resolving the host again", "host", host, "error", err)
+	r.Forget(host)
+	return r.dial(ctx, network, host, port)
+}
+
+// dial connects to the first resolved address of the host accepting the connection
+func (r *hostResolver) dial(ctx context.Context, network, host, port string) (net.Conn, error) {
+	addrs, err := r.Resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, ip := range addrs {
+		conn, err := r.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
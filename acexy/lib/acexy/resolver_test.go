@@ -0,0 +1,99 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package acexy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestResolveCache verifies the engine container name is resolved once and reused by the
+// following connections within the TTL, resolved again once expired or when its cached
+// address refuses the connection, and kept when resolving it fails
+func TestResolveCache(t *testing.T) {
+	// Closing the connections makes each request dial the engine again
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"response": {"playback_url": "http://localhost/stream", "stat_url": "http://localhost/stat", "command_url": "http://localhost/cmd"}}`))
+	}))
+	defer engine.Close()
+	u, _ := url.Parse(engine.URL)
+
+	acexyInst := &Acexy{
+		Scheme:            u.Scheme,
+		Host:              "acestream-engine-1",
+		Port:              parseInt(u.Port()),
+		Endpoint:          MPEG_TS_ENDPOINT,
+		NoResponseTimeout: 5 * time.Second,
+		ResolveCacheTTL:   time.Minute,
+	}
+	acexyInst.Init()
+
+	var lookups atomic.Int32
+	var failLookup atomic.Bool
+	acexyInst.resolver.lookup = func(ctx context.Context, host string) ([]string, error) {
+		lookups.Add(1)
+		if host != "acestream-engine-1" {
+			t.Errorf("Unexpected host resolved: %s", host)
+		}
+		if failLookup.Load() {
+			return nil, errors.New("temporary DNS failure")
+		}
+		return []string{u.Hostname()}, nil
+	}
+	fetch := func(step string, expectedLookups int32) {
+		t.Helper()
+		aceID, _ := NewAceID("test-stream", "")
+		if _, err := acexyInst.FetchStream(aceID, nil); err != nil {
+			t.Fatalf("%s: FetchStream failed: %v", step, err)
+		}
+		if got := lookups.Load(); got != expectedLookups {
+			t.Errorf("%s: expected %d lookups, got %d", step, expectedLookups, got)
+		}
+	}
+	expire := func() {
+		acexyInst.resolver.mu.Lock()
+		defer acexyInst.resolver.mu.Unlock()
+		entry := acexyInst.resolver.hosts["acestream-engine-1"]
+		entry.expires = time.Now()
+		acexyInst.resolver.hosts["acestream-engine-1"] = entry
+	}
+
+	fetch("first connection", 1)
+	fetch("cached", 1)
+	fetch("still cached", 1)
+
+	expire()
+	fetch("expired", 2)
+
+	// Resolving fails, the expired address is still used
+	expire()
+	failLookup.Store(true)
+	fetch("lookup failed", 3)
+	failLookup.Store(false)
+
+	// The engine moved, the address refusing the connection is resolved again
+	acexyInst.resolver.mu.Lock()
+	acexyInst.resolver.hosts["acestream-engine-1"] = resolvedHost{addrs: []string{"127.0.0.2"}, expires: time.Now().Add(time.Minute)}
+	acexyInst.resolver.mu.Unlock()
+	fetch("refused", 4)
+	fetch("cached after refused", 4)
+}
+
+// TestResolveCacheDisabled verifies no resolver is used without a TTL
+func TestResolveCacheDisabled(t *testing.T) {
+	acexyInst := &Acexy{Scheme: "http", Host: "localhost", Port: 6878, Endpoint: MPEG_TS_ENDPOINT}
+	acexyInst.Init()
+	if acexyInst.resolver != nil || acexyInst.engineAPI != nil {
+		t.Error("Expected the default resolution without a TTL")
+	}
+}
@@ -0,0 +1,26 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package acexy
+
+import (
+	"context"
+	"net/http"
+)
+
+type responseFuncContextKey struct{}
+
+// WithResponseFunc returns a context making StartStreamContext call fn with the response of
+// the engine to the playback request, before any of its data is copied. The response body
+// must not be read.
+func WithResponseFunc(ctx context.Context, fn func(*http.Response)) context.Context {
+	return context.WithValue(ctx, responseFuncContextKey{}, fn)
+}
+
+// notifyResponse calls the response function of the context with the engine response, if any
+func notifyResponse(ctx context.Context, resp *http.Response) {
+	if fn, ok := ctx.Value(responseFuncContextKey{}).(func(*http.Response)); ok {
+		fn(resp)
+	}
+}
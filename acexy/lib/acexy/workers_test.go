@@ -0,0 +1,184 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package acexy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestStartStreamWorkerCap verifies streams beyond the worker cap wait for a free worker
+// before requesting the engine, and give up once their context is done
+func TestStartStreamWorkerCap(t *testing.T) {
+	release := make(chan struct{})
+	var requests atomic.Int32
+	streamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("data"))
+		<-release
+	}))
+	defer streamServer.Close()
+
+	acexyInst := &Acexy{EmptyTimeout: 5 * time.Second, BufferSize: 1024, MaxStreamWorkers: 1}
+	acexyInst.Init()
+	stream := &AceStream{PlaybackURL: streamServer.URL}
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := acexyInst.StartStream(stream, &bytes.Buffer{})
+		first <- err
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for requests.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A queued stream gives up with its context, without touching the engine
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := acexyInst.StartStreamContext(ctx, stream, &bytes.Buffer{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the queued stream to give up, got: %v", err)
+	}
+
+	// Another queued stream starts once the worker is released
+	var output bytes.Buffer
+	second := make(chan error, 1)
+	go func() {
+		_, err := acexyInst.StartStream(stream, &output)
+		second <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("Expected queued streams not to request the engine, got %d requests", got)
+	}
+
+	close(release)
+	for _, done := range []chan error{first, second} {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for the streams to complete")
+		}
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Expected the queued stream to request the engine, got %d requests", got)
+	}
+	if output.String() != "data" {
+		t.Errorf("Expected the queued stream data, got %q", output.String())
+	}
+}
+
+// TestQueueStats verifies the queue depth follows the streams waiting for a worker, and
+// their waits and timeouts are accounted once they leave the queue
+func TestQueueStats(t *testing.T) {
+	release := make(chan struct{})
+	streamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data"))
+		<-release
+	}))
+	defer streamServer.Close()
+
+	acexyInst := &Acexy{EmptyTimeout: 5 * time.Second, BufferSize: 1024, MaxStreamWorkers: 1}
+	acexyInst.Init()
+	stream := &AceStream{PlaybackURL: streamServer.URL}
+
+	waitDepth := func(depth int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for acexyInst.QueueStats().Depth != depth {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected a queue depth of %d, got %d", depth, acexyInst.QueueStats().Depth)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			acexyInst.StartStream(stream, &bytes.Buffer{})
+		}()
+		waitDepth(i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	timedOut := make(chan struct{})
+	go func() {
+		defer close(timedOut)
+		acexyInst.StartStreamContext(ctx, stream, &bytes.Buffer{})
+	}()
+	waitDepth(2)
+	cancel()
+	<-timedOut
+	waitDepth(1)
+
+	close(release)
+	wg.Wait()
+
+	stats := acexyInst.QueueStats()
+	if stats.MaxWorkers != 1 || stats.Depth != 0 {
+		t.Errorf("Expected an empty queue of 1 worker, got %+v", stats)
+	}
+	if stats.Timeouts != 1 {
+		t.Errorf("Expected 1 timeout, got %d", stats.Timeouts)
+	}
+	if stats.Count != 2 || stats.Sum <= 0 {
+		t.Errorf("Expected 2 waits, one of them queued, got %d waits for %gs", stats.Count, stats.Sum)
+	}
+	if last := stats.Buckets[len(stats.Buckets)-1]; last != 2 {
+		t.Errorf("Expected both waits in the last bucket, got %d", last)
+	}
+}
+
+// BenchmarkStartStreamWorkers compares copying many concurrent streams unbounded against
+// capping the copy workers. Each operation serves every stream to completion.
+func BenchmarkStartStreamWorkers(b *testing.B) {
+	const streams = 512
+	payload := bytes.Repeat([]byte{0x47}, 64*1024)
+
+	streamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer streamServer.Close()
+
+	for _, workers := range []int{0, 16, 64, 256} {
+		name := "unbounded"
+		if workers > 0 {
+			name = fmt.Sprintf("bounded-%d", workers)
+		}
+		b.Run(name, func(b *testing.B) {
+			acexyInst := &Acexy{EmptyTimeout: 5 * time.Second, BufferSize: 32 * 1024, MaxStreamWorkers: workers}
+			acexyInst.Init()
+			stream := &AceStream{PlaybackURL: streamServer.URL}
+
+			b.SetBytes(int64(streams * len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for s := 0; s < streams; s++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if _, err := acexyInst.StartStream(stream, io.Discard); err != nil {
+							b.Error(err)
+						}
+					}()
+				}
+				wg.Wait()
+			}
+		})
+	}
+}
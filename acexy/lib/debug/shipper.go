@@ -0,0 +1,129 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package debug
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Interval at which the debug logs are shipped to the sink, and the timeout of each upload
+const (
+	DEFAULT_SHIP_INTERVAL = 5 * time.Minute
+	SHIP_UPLOAD_TIMEOUT   = 30 * time.Second
+)
+
+// The suffix of the log files rotated out for shipping, kept until they are uploaded
+const shippingSuffix = ".shipping"
+
+// StartShipping periodically ships the debug logs to the sink until the context is done,
+// shipping them one last time before returning. Meant to be run in its own goroutine.
+func (d *DebugLogger) StartShipping(ctx context.Context, sink string, interval time.Duration) {
+	if !d.enabled || sink == "" {
+		return
+	}
+	if interval <= 0 {
+		interval = DEFAULT_SHIP_INTERVAL
+	}
+
+	client := &http.Client{Timeout: SHIP_UPLOAD_TIMEOUT}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			d.ShipLogs(client, sink)
+			return
+		case <-ticker.C:
+			d.ShipLogs(client, sink)
+		}
+	}
+}
+
+// ShipLogs rotates the current log files out and uploads every rotated file to the sink with
+// a gzip compressed PUT to `<sink>/<file>.gz`, deleting it once uploaded. Files failing to
+// upload are kept and retried on the next call. Returns the last upload error.
+func (d *DebugLogger) ShipLogs(client *http.Client, sink string) error {
+	if !d.enabled {
+		return nil
+	}
+	d.rotate()
+
+	rotated, err := filepath.Glob(filepath.Join(d.logDir, "*"+shippingSuffix))
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, path := range rotated {
+		if err := uploadLog(client, sink, path); err != nil {
+			slog.Warn("Failed to ship debug log, retrying later", "file", path, "error", err)
+			lastErr = err
+			continue
+		}
+		os.Remove(path)
+	}
+	return lastErr
+}
+
+// rotate renames the current log files so the next entries start new files. The writes
+// are held meanwhile, so no entry is lost.
+func (d *DebugLogger) rotate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, err := filepath.Glob(filepath.Join(d.logDir, "*.jsonl"))
+	if err != nil {
+		return
+	}
+	stamp := time.Now().UTC().Format("20060102T150405.000000000")
+	for _, path := range current {
+		os.Rename(path, fmt.Sprintf("%s.%s%s", path, stamp, shippingSuffix))
+	}
+}
+
+// uploadLog uploads a rotated log file, gzip compressed, to the sink. The request is not
+// signed, the sink must accept plain PUT uploads.
+func uploadLog(client *http.Client, sink, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if _, err := gz.Write(data); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), shippingSuffix) + ".gz"
+	req, err := http.NewRequest(http.MethodPut, strings.TrimRight(sink, "/")+"/"+name, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sink returned status %d", resp.StatusCode)
+	}
+	return nil
+}
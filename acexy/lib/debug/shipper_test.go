@@ -0,0 +1,92 @@
+package debug
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDebugLogger_ShipLogs(t *testing.T) {
+	var mu sync.Mutex
+	uploads := map[string][]byte{}
+	failing := true
+
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodPut {
+			t.Errorf("Expected PUT, got %s", r.Method)
+		}
+		if failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("Upload is not gzip compressed: %v", err)
+			return
+		}
+		data, _ := io.ReadAll(gz)
+		uploads[r.URL.Path] = data
+	}))
+	defer sink.Close()
+
+	tempDir := t.TempDir()
+	logger := NewDebugLogger(true, tempDir)
+	logger.LogRequest("GET", "/ace/getstream", 100*time.Millisecond, 200, "test_ace_id")
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	// A failed upload keeps the rotated file for the next round
+	if err := logger.ShipLogs(client, sink.URL+"/logs"); err == nil {
+		t.Fatal("Expected the failing sink to be reported")
+	}
+	if rotated, _ := filepath.Glob(filepath.Join(tempDir, "*"+shippingSuffix)); len(rotated) != 2 {
+		t.Fatalf("Expected the session and request logs to be kept for retry, got %v", rotated)
+	}
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	logger.LogRequest("GET", "/ace/status", 10*time.Millisecond, 200, "")
+	if err := logger.ShipLogs(client, sink.URL+"/logs"); err != nil {
+		t.Fatalf("Unexpected shipping error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The session and request logs of the failed round, plus the new request log
+	if len(uploads) != 3 {
+		t.Fatalf("Expected 3 uploaded files, got %d", len(uploads))
+	}
+	var requests [][]byte
+	for path, data := range uploads {
+		if !strings.HasPrefix(path, "/logs/") || !strings.HasSuffix(path, ".gz") {
+			t.Errorf("Unexpected upload path %s", path)
+		}
+		if strings.Contains(path, "_requests.jsonl") {
+			requests = append(requests, data)
+		}
+	}
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 request logs, got %d", len(requests))
+	}
+	for _, data := range requests {
+		if entries := parseJSONLines(t, data); len(entries) != 1 {
+			t.Errorf("Expected a single entry in each request log, got %d", len(entries))
+		}
+	}
+	if !bytes.Contains(bytes.Join(requests, nil), []byte("test_ace_id")) {
+		t.Error("Expected the logged request to be uploaded")
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(tempDir, "*")); len(files) != 0 {
+		t.Errorf("Expected uploaded logs to be deleted locally, got %v", files)
+	}
+}
@@ -10,6 +10,10 @@
 // when you want to write to multiple writers at the same time, but don't want to block on
 // each write. Errors that may occur are gathered and returned after all writes are done.
 //
+// With many writers, a multiwriter created by NewBuffered gives each writer its own
+// goroutine and queue instead: writes are copied once and queued to every writer, so a slow
+// writer only delays the others once it is a full queue behind.
+//
 // Example:
 //
 //	package main
@@ -36,12 +40,18 @@
 package pmw
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
+// ErrNoWriters is returned when writing to a multiwriter that has no writers left, so
+// whoever feeds it can stop instead of discarding the data.
+var ErrNoWriters = errors.New("pmw: no writers")
+
 // PMultiWriter is an implementation of an "io.Writer" that duplicates its writes
 // to all the provided writers, similar to the Unix tee(1) command. Writers can be
 // added and removed dynamically after creation. Each write is done in a separate
@@ -49,6 +59,78 @@ import (
 type PMultiWriter struct {
 	sync.RWMutex
 	writers []io.Writer
+	index   map[io.Writer]int            // Position of each writer in writers, for O(1) removals
+	written map[io.Writer]*atomic.Uint64 // Bytes delivered to each of the writers
+	empty   chan struct{}                // Closed when the last writer is removed
+
+	queue   int                   // Writes each writer may lag behind, 0 writes synchronously
+	workers map[io.Writer]*queued // Goroutine writing to each writer, only when queued
+}
+
+// queued writes the chunks of its queue to a writer in its own goroutine, stopping at the
+// first error
+type queued struct {
+	w       io.Writer
+	written *atomic.Uint64
+	chunks  chan []byte
+	stop    chan struct{} // Closed to stop the goroutine, dropping the queued chunks
+	done    chan struct{} // Closed once the goroutine returned
+	err     error         // Why the goroutine returned, only read once done
+}
+
+func (q *queued) run() {
+	defer close(q.done)
+	for {
+		select {
+		case <-q.stop:
+			q.err = io.ErrClosedPipe
+			return
+		case chunk := <-q.chunks:
+			n, err := WriteFull(q.w, chunk)
+			q.written.Add(uint64(n))
+			if err != nil {
+				q.err = err
+				return
+			}
+		}
+	}
+}
+
+// stopLocked stops the goroutine unless it was already, by Close or Remove. Must be called
+// with the lock of the multiwriter held.
+func (q *queued) stopLocked() {
+	select {
+	case <-q.stop:
+	default:
+		close(q.stop)
+	}
+}
+
+// failed returns the error that stopped the writer, nil while it is running
+func (q *queued) failed() error {
+	select {
+	case <-q.done:
+		return q.err
+	default:
+		return nil
+	}
+}
+
+// WriteFull writes the whole buffer to the writer, writing the remainder again after a short
+// write. Returns io.ErrShortWrite when the writer makes no progress without reporting an error.
+func WriteFull(w io.Writer, p []byte) (int, error) {
+	var written int
+	for written < len(p) {
+		n, err := w.Write(p[written:])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
 }
 
 // PMultiWriterError is an error that occurs when writing to multiple writers.
@@ -68,6 +150,11 @@ func (e PMultiWriterError) Error() string {
 	return sb.String()
 }
 
+// Unwrap returns the errors of the writers, so they can be checked with errors.Is.
+func (e PMultiWriterError) Unwrap() []error {
+	return e.Errors
+}
+
 // New creates a writer that duplicates its writes to all the provided writers,
 // similar to the Unix tee(1) command. Writers can be added and removed
 // dynamically after creation.
@@ -76,29 +163,64 @@ func (e PMultiWriterError) Error() string {
 // writer returns an error, that overall write operation stops and returns the
 // error; it does not continue down the list.
 func New(writers ...io.Writer) *PMultiWriter {
-	pmw := &PMultiWriter{writers: writers}
+	return NewBuffered(0, writers...)
+}
+
+// NewBuffered creates a multiwriter where each writer is written by its own goroutine, and
+// may lag up to `queue` writes behind before the writes wait for it. Writes are copied once
+// and return as soon as they are queued to every writer, so errors are reported by the
+// writes following them, and Written only counts the bytes already delivered. The writers
+// must be removed, or the multiwriter closed, to stop their goroutines. A queue of 0 or
+// less behaves as New.
+func NewBuffered(queue int, writers ...io.Writer) *PMultiWriter {
+	pmw := &PMultiWriter{empty: make(chan struct{})}
+	if queue > 0 {
+		pmw.queue = queue
+	}
+	for _, w := range writers {
+		pmw.add(w)
+	}
 	return pmw
 }
 
+// Empty returns a channel that is closed once the last writer is removed. Adding a writer
+// afterwards arms a new channel, so it must be requested again to detect the next time
+// all the writers are gone.
+func (pmw *PMultiWriter) Empty() <-chan struct{} {
+	pmw.Lock()
+	defer pmw.Unlock()
+	if pmw.empty == nil {
+		pmw.empty = make(chan struct{})
+	}
+	return pmw.empty
+}
+
 // Write writes some bytes to all the writers.
 func (pmw *PMultiWriter) Write(p []byte) (n int, err error) {
 	pmw.RLock()
+	if len(pmw.writers) == 0 {
+		pmw.RUnlock()
+		return 0, ErrNoWriters
+	}
+	if pmw.queue > 0 {
+		// Queue outside the lock, so a full queue does not hold back Add, Remove and Close
+		workers := make([]*queued, 0, len(pmw.writers))
+		for _, w := range pmw.writers {
+			workers = append(workers, pmw.workers[w])
+		}
+		pmw.RUnlock()
+		return writeQueued(workers, p)
+	}
 	defer pmw.RUnlock()
 
 	errs := make(chan error, len(pmw.writers))
 	for _, w := range pmw.writers {
-		go func(w io.Writer) {
-			n, err := w.Write(p)
+		go func(w io.Writer, written *atomic.Uint64) {
+			n, err := WriteFull(w, p)
+			written.Add(uint64(n))
 			// Forward the error and early return
-			if err != nil || n < len(p) {
-				if err == nil && n < len(p) {
-					err = io.ErrShortWrite
-				}
-				errs <- err
-			} else {
-				errs <- nil
-			}
-		}(w)
+			errs <- err
+		}(w, pmw.written[w])
 	}
 
 	// Wait for all writes to finish. If an error occurs, return it.
@@ -111,7 +233,40 @@ func (pmw *PMultiWriter) Write(p []byte) (n int, err error) {
 	if len(errors) > 0 {
 		return len(p), PMultiWriterError{Errors: errors, Writers: len(pmw.writers)}
 	}
+	return len(p), nil
+}
 
+// writeQueued copies the bytes once and queues them to every given writer, waiting only for
+// the writers whose queue is full. Writers that failed are skipped and their error returned,
+// while those removed or closed meanwhile are skipped silently.
+func writeQueued(workers []*queued, p []byte) (int, error) {
+	chunk := make([]byte, len(p))
+	copy(chunk, p)
+
+	var errors []error
+	for _, q := range workers {
+		select {
+		case <-q.stop:
+			continue
+		default:
+		}
+		if err := q.failed(); err != nil {
+			errors = append(errors, err)
+			continue
+		}
+		select {
+		case q.chunks <- chunk:
+		case <-q.done:
+			select {
+			case <-q.stop:
+			default:
+				errors = append(errors, q.err)
+			}
+		}
+	}
+	if len(errors) > 0 {
+		return len(p), PMultiWriterError{Errors: errors, Writers: len(workers)}
+	}
 	return len(p), nil
 }
 
@@ -120,36 +275,120 @@ func (pmw *PMultiWriter) Add(w io.Writer) {
 	pmw.Lock()
 	defer pmw.Unlock()
 
-	// Check if the writer is already in the list
-	for _, ew := range pmw.writers {
-		if ew == w {
-			return
+	pmw.add(w)
+
+	// Re-arm the empty signal if it already fired
+	if pmw.empty != nil {
+		select {
+		case <-pmw.empty:
+			pmw.empty = make(chan struct{})
+		default:
 		}
 	}
+}
+
+// add appends a writer unless it is already in the list, starting its goroutine when
+// queued. Must be called with the lock held.
+func (pmw *PMultiWriter) add(w io.Writer) {
+	if pmw.index == nil {
+		pmw.index = make(map[io.Writer]int)
+	}
+	if _, ok := pmw.index[w]; ok {
+		return
+	}
+
+	pmw.index[w] = len(pmw.writers)
 	pmw.writers = append(pmw.writers, w)
+	if pmw.written == nil {
+		pmw.written = make(map[io.Writer]*atomic.Uint64)
+	}
+	pmw.written[w] = &atomic.Uint64{}
+
+	if pmw.queue > 0 {
+		if pmw.workers == nil {
+			pmw.workers = make(map[io.Writer]*queued)
+		}
+		q := &queued{
+			w:       w,
+			written: pmw.written[w],
+			chunks:  make(chan []byte, pmw.queue),
+			stop:    make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		pmw.workers[w] = q
+		go q.run()
+	}
 }
 
-// Remove will remove a previously added writer from the list of writers.
+// Remove will remove a previously added writer from the list of writers. A queued writer
+// is not written anymore once Remove returns, its pending writes are dropped.
 func (pmw *PMultiWriter) Remove(w io.Writer) {
 	pmw.Lock()
-	defer pmw.Unlock()
+	i, ok := pmw.index[w]
+	if !ok {
+		pmw.Unlock()
+		return
+	}
 
-	var writers []io.Writer
-	for _, ew := range pmw.writers {
-		if ew != w {
-			writers = append(writers, ew)
+	// Move the last writer to the freed position
+	last := len(pmw.writers) - 1
+	pmw.writers[i] = pmw.writers[last]
+	pmw.index[pmw.writers[i]] = i
+	pmw.writers[last] = nil
+	pmw.writers = pmw.writers[:last]
+	delete(pmw.index, w)
+	delete(pmw.written, w)
+	q := pmw.workers[w]
+	delete(pmw.workers, w)
+	if q != nil {
+		q.stopLocked()
+	}
+
+	// Signal the transition to zero writers
+	if len(pmw.writers) == 0 {
+		if pmw.empty == nil {
+			pmw.empty = make(chan struct{})
 		}
+		close(pmw.empty)
+	}
+	pmw.Unlock()
+
+	// Wait outside the lock, so other writers are not held while this one finishes a write
+	if q != nil {
+		<-q.done
 	}
-	pmw.writers = writers
 }
 
-// Closes all the writers in the list.
+// Written returns the number of bytes delivered to the given writer since it was added.
+// Returns 0 for writers that are not in the list.
+func (pmw *PMultiWriter) Written(w io.Writer) uint64 {
+	pmw.RLock()
+	defer pmw.RUnlock()
+	if written, ok := pmw.written[w]; ok {
+		return written.Load()
+	}
+	return 0
+}
+
+// Closes all the writers in the list. Queued writers are stopped first, dropping their
+// pending writes.
 func (pmw *PMultiWriter) Close() error {
 	pmw.Lock()
-	defer pmw.Unlock()
+	workers := make([]*queued, 0, len(pmw.workers))
+	for _, q := range pmw.workers {
+		q.stopLocked()
+		workers = append(workers, q)
+	}
+	writers := append([]io.Writer(nil), pmw.writers...)
+	pmw.Unlock()
+
+	// Wait outside the lock, as Remove does, so a writer finishing a write holds nobody back
+	for _, q := range workers {
+		<-q.done
+	}
 
 	var errors []error
-	for _, w := range pmw.writers {
+	for _, w := range writers {
 		if c, ok := w.(io.Closer); ok {
 			if err := c.Close(); err != nil {
 				errors = append(errors, err)
@@ -157,7 +396,7 @@ func (pmw *PMultiWriter) Close() error {
 		}
 	}
 	if len(errors) > 0 {
-		return PMultiWriterError{Errors: errors, Writers: len(pmw.writers)}
+		return PMultiWriterError{Errors: errors, Writers: len(writers)}
 	}
 	return nil
 }
@@ -0,0 +1,364 @@
+package pmw
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestEmptySignal verifies the empty channel is closed only when the last writer is
+// removed, and re-armed once a writer is added back
+func TestEmptySignal(t *testing.T) {
+	a, b := &bytes.Buffer{}, &bytes.Buffer{}
+	w := New(a, b)
+	empty := w.Empty()
+
+	w.Remove(a)
+	select {
+	case <-empty:
+		t.Fatal("Empty signal fired while a writer is left")
+	default:
+	}
+
+	w.Remove(b)
+	select {
+	case <-empty:
+	default:
+		t.Fatal("Empty signal did not fire after removing the last writer")
+	}
+
+	if _, err := w.Write([]byte("data")); !errors.Is(err, ErrNoWriters) {
+		t.Errorf("Expected ErrNoWriters, got: %v", err)
+	}
+
+	w.Add(a)
+	select {
+	case <-w.Empty():
+		t.Error("Empty signal was not re-armed after adding a writer")
+	default:
+	}
+	if _, err := w.Write([]byte("data")); err != nil {
+		t.Errorf("Unexpected write error: %v", err)
+	}
+}
+
+// limitedWriter accepts up to `limit` bytes, then fails with a short write
+type limitedWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if remaining := w.limit - w.Len(); len(p) > remaining {
+		n, _ := w.Buffer.Write(p[:remaining])
+		return n, errLimit
+	}
+	return w.Buffer.Write(p)
+}
+
+var errLimit = errors.New("limit reached")
+
+// TestWrittenPerWriter verifies the bytes delivered to each writer are counted separately,
+// including writers added late and writers that only accepted part of a write
+func TestWrittenPerWriter(t *testing.T) {
+	a, b := &bytes.Buffer{}, &limitedWriter{limit: 10}
+	w := New(a, b)
+
+	w.Write([]byte("12345"))
+	late := &bytes.Buffer{}
+	w.Add(late)
+	_, err := w.Write([]byte("1234567"))
+	if !errors.Is(err, errLimit) {
+		t.Errorf("Expected the writer error to be unwrappable, got: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		writer   io.Writer
+		received int
+		expected uint64
+	}{
+		{"a", a, a.Len(), 12},
+		{"b", b, b.Len(), 10},
+		{"late", late, late.Len(), 7},
+	} {
+		if got := w.Written(tt.writer); got != tt.expected || got != uint64(tt.received) {
+			t.Errorf("Writer %s: expected %d bytes, counted %d and received %d", tt.name, tt.expected, got, tt.received)
+		}
+	}
+
+	w.Remove(a)
+	if got := w.Written(a); got != 0 {
+		t.Errorf("Expected no count for a removed writer, got %d", got)
+	}
+}
+
+// blockingWriter holds every write until released
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+// lockedBuffer is a bytes.Buffer safe to read while a queued writer fills it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestBufferedSlowWriter verifies a stalled writer does not hold the writes to the others
+// until its queue is full
+func TestBufferedSlowWriter(t *testing.T) {
+	slow := &blockingWriter{release: make(chan struct{})}
+	fast := &lockedBuffer{}
+	w := NewBuffered(4, slow, fast)
+	defer w.Close()
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i := 0; i < 4; i++ {
+			w.Write([]byte("x"))
+		}
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("Writes blocked on the slow writer")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for fast.String() != "xxxx" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the fast writer to get all the writes, got %q", fast.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(slow.release)
+}
+
+// TestBufferedErrors verifies a failed writer reports its error on the following writes
+// and is removed without waiting
+func TestBufferedErrors(t *testing.T) {
+	b := &limitedWriter{limit: 3}
+	w := NewBuffered(1, b)
+
+	w.Write([]byte("12345"))
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := w.Write([]byte("6"))
+		if errors.Is(err, errLimit) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the writer error, got: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := w.Written(b); got != 3 {
+		t.Errorf("Expected 3 bytes delivered, counted %d", got)
+	}
+
+	w.Remove(b)
+	if _, err := w.Write([]byte("7")); !errors.Is(err, ErrNoWriters) {
+		t.Errorf("Expected ErrNoWriters after removing the writer, got: %v", err)
+	}
+}
+
+// TestBufferedRemove verifies removing a writer stops its goroutine once its pending
+// write returns, and leaves the other writers in place
+func TestBufferedRemove(t *testing.T) {
+	slow := &blockingWriter{release: make(chan struct{})}
+	others := make([]*lockedBuffer, 3)
+	w := NewBuffered(1)
+	w.Add(slow)
+	for i := range others {
+		others[i] = &lockedBuffer{}
+		w.Add(others[i])
+	}
+	w.Write([]byte("x"))
+
+	removed := make(chan struct{})
+	go func() {
+		defer close(removed)
+		w.Remove(slow)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(slow.release)
+	<-removed
+
+	if _, err := w.Write([]byte("x")); err != nil {
+		t.Fatalf("Unexpected write error: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for i, b := range others {
+		for b.String() != "xx" && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := b.String(); got != "xx" {
+			t.Errorf("Writer %d: expected %q, got %q", i, "xx", got)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unexpected close error: %v", err)
+	}
+	if got := w.Written(slow); got != 0 {
+		t.Errorf("Expected no count for the removed writer, got %d", got)
+	}
+}
+
+// TestBufferedRemoveAfterClose verifies the writers of a closed multiwriter can still be
+// removed, without stopping their goroutines a second time
+func TestBufferedRemoveAfterClose(t *testing.T) {
+	a, b := &lockedBuffer{}, &lockedBuffer{}
+	w := NewBuffered(1, a, b)
+	w.Write([]byte("x"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unexpected close error: %v", err)
+	}
+
+	w.Remove(a)
+	w.Remove(b)
+	select {
+	case <-w.Empty():
+	default:
+		t.Error("Expected the empty signal once every writer was removed")
+	}
+}
+
+// TestBufferedFullQueueUnlocked verifies a write waiting on a full queue, and a close waiting
+// on a writer, don't hold back the other operations of the multiwriter
+func TestBufferedFullQueueUnlocked(t *testing.T) {
+	slow := &blockingWriter{release: make(chan struct{})}
+	w := NewBuffered(1, slow)
+	w.Write([]byte("x"))
+	w.Write([]byte("x"))
+
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		w.Write([]byte("x"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	within := func(name string, f func()) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			f()
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%s blocked behind the slow writer", name)
+		}
+	}
+	other := &lockedBuffer{}
+	within("Add", func() { w.Add(other) })
+
+	closed := make(chan error)
+	go func() { closed <- w.Close() }()
+	time.Sleep(10 * time.Millisecond)
+	within("Written", func() { w.Written(other) })
+
+	close(slow.release)
+	<-blocked
+	if err := <-closed; err != nil {
+		t.Fatalf("Unexpected close error: %v", err)
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	const writers = 500
+	chunk := make([]byte, 188*7)
+
+	for _, queue := range []int{0, 16} {
+		b.Run(fmt.Sprintf("writers=%d/queue=%d", writers, queue), func(b *testing.B) {
+			w := NewBuffered(queue)
+			for i := 0; i < writers; i++ {
+				w.Add(&discard{})
+			}
+			defer w.Close()
+
+			b.SetBytes(int64(len(chunk)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w.Write(chunk)
+			}
+		})
+	}
+}
+
+// discard is a distinct io.Discard per writer, as the multiwriter dedupes writers
+type discard struct{ _ byte }
+
+func (*discard) Write(p []byte) (int, error) { return len(p), nil }
+
+// shortWriter accepts at most `max` bytes per write without reporting an error, stalling
+// (0 bytes, no error) once `stall` writes were made when set
+type shortWriter struct {
+	bytes.Buffer
+	max    int
+	stall  int
+	writes int
+}
+
+func (s *shortWriter) Write(p []byte) (int, error) {
+	s.writes++
+	if s.stall > 0 && s.writes > s.stall {
+		return 0, nil
+	}
+	return s.Buffer.Write(p[:min(len(p), s.max)])
+}
+
+// TestShortWrites verifies short writes are completed with the remainder, both written
+// directly and through the queue, and a writer making no progress fails with ErrShortWrite
+func TestShortWrites(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+
+	direct := &shortWriter{max: 3}
+	w := New(direct)
+	if n, err := w.Write(data); err != nil || n != len(data) {
+		t.Fatalf("Expected a full write, got %d: %v", n, err)
+	}
+	if direct.String() != string(data) || w.Written(direct) != uint64(len(data)) {
+		t.Errorf("Expected every byte delivered, got %q (%d counted)", direct.String(), w.Written(direct))
+	}
+
+	queued := &shortWriter{max: 7}
+	w = NewBuffered(1, queued)
+	w.Write(data)
+	deadline := time.Now().Add(time.Second)
+	for w.Written(queued) < uint64(len(data)) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	w.Close()
+	if queued.String() != string(data) {
+		t.Errorf("Expected every byte delivered through the queue, got %q", queued.String())
+	}
+
+	stalled := &shortWriter{max: 4, stall: 2}
+	if n, err := WriteFull(stalled, data); !errors.Is(err, io.ErrShortWrite) || n != 8 {
+		t.Errorf("Expected ErrShortWrite after 8 bytes, got %d: %v", n, err)
+	}
+}
@@ -0,0 +1,31 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Formats the logs can be written in
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+// newLogHandler creates the handler writing the logs of the given level and above to w, as
+// `key=value` text or as a JSON object per line for log pipelines (Loki, ELK...)
+func newLogHandler(w io.Writer, format string, level slog.Leveler) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case LOG_FORMAT_TEXT:
+		return slog.NewTextHandler(w, opts), nil
+	case LOG_FORMAT_JSON:
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected %q or %q", format, LOG_FORMAT_TEXT, LOG_FORMAT_JSON)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestLogHandlerJSON verifies the JSON format writes a parseable object per record, honoring
+// the level
+func TestLogHandlerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := newLogHandler(&buf, LOG_FORMAT_JSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	logger := slog.New(handler)
+	logger.Debug("Hidden")
+	logger.Info("Selected engine from orchestrator", "host", "127.0.0.1", "port", 6878)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected a single record above the level, got %q", buf.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Expected a JSON record, got %q: %v", lines[0], err)
+	}
+	if record["msg"] != "Selected engine from orchestrator" || record["level"] != "INFO" ||
+		record["host"] != "127.0.0.1" || record["port"] != float64(6878) {
+		t.Errorf("Unexpected record: %v", record)
+	}
+}
+
+func TestLogHandlerFormats(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := newLogHandler(&buf, LOG_FORMAT_TEXT, slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	slog.New(handler).Info("Started", "port", 6878)
+	if got := buf.String(); !strings.Contains(got, "level=INFO msg=Started port=6878") {
+		t.Errorf("Expected a text record, got %q", got)
+	}
+
+	if _, err := newLogHandler(&buf, "xml", slog.LevelInfo); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,126 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"fmt"
+	"io"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HandleMetrics exposes runtime metrics using the Prometheus text exposition format, or the
+// OpenMetrics one with the latency exemplars when enabled and asked for by the scraper
+func (p *Proxy) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if p.OpenMetricsExemplars && acceptsOpenMetrics(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", OPENMETRICS_CONTENT_TYPE)
+		p.writeMetrics(&openMetricsWriter{w})
+		fmt.Fprintln(w, "# EOF")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.writeMetrics(w)
+}
+
+// writeMetrics writes every metric to w
+func (p *Proxy) writeMetrics(w io.Writer) {
+	recovering, circuitOpen := p.Orch.RecoveryStats()
+
+	writeGauge(w, "acexy_engines_recovering",
+		"Number of engines the orchestrator reports as unhealthy (recovering)", float64(recovering))
+	writeGauge(w, "acexy_engine_circuit_open",
+		"Whether the orchestrator provisioning circuit breaker is open (1) or closed (0)", boolToFloat(circuitOpen))
+	writeCounterVec(w, "acexy_provision_total",
+		"Engine provisioning attempts by outcome code", "code", p.Orch.ProvisionStats())
+	writeCounter(w, "acexy_duplicate_playback_session_total",
+		"Streams the engine returned the playback session ID of another active stream for", p.streams.Duplicates())
+	writeCounter(w, "acexy_reconciled_stale_streams_total",
+		"Streams the orchestrator still listed although acexy no longer served them, ended by the reconciliation", p.Reconciler.Stale())
+	writeCounter(w, "acexy_orch_auth_failures_total",
+		"Orchestrator responses rejecting the API key (401/403)", p.Orch.AuthFailures())
+	writeCounter(w, "acexy_no_data_streams_total",
+		"Streams the engine ended cleanly without sending any data", p.noData.Load())
+	writeCounter(w, "acexy_orchestrator_cancelled_streams_total",
+		"Served streams stopped because the orchestrator marked them as cancelled", p.Cancels.Cancelled())
+	writeCounter(w, "acexy_stuck_streams_total",
+		"Streams whose client was not delivered any data for the stuck threshold while still served", p.Stuck.Stuck())
+	writeCounter(w, "acexy_forced_terminations_total",
+		"Streams terminated at shutdown because they did not drain within the grace period", p.forcedTerminations.Load())
+
+	queue := p.Acexy.QueueStats()
+	writeGauge(w, "acexy_queue_depth",
+		"Streams waiting for a free stream worker", float64(queue.Depth))
+	writeHistogram(w, "acexy_queue_wait_seconds",
+		"Time streams waited for a free stream worker", acexy.QUEUE_WAIT_BUCKETS, queue.Buckets, queue.Count, queue.Sum)
+	writeCounter(w, "acexy_queue_timeouts_total",
+		"Streams that stopped waiting for a free stream worker, usually as the client left", queue.Timeouts)
+	writeGauge(w, "acexy_pending_streams",
+		"Stream requests waiting for an engine to be selected or provisioned and the stream fetched", float64(p.pending.Load()))
+	writeLatencyHistogram(w, "acexy_engine_selection_seconds",
+		"Time taken to select, or provision, the engine of a stream request", &p.selectionLatency)
+	writeLatencyHistogram(w, "acexy_stream_start_seconds",
+		"Time from a stream request to the stream being fetched from its engine", &p.startLatency)
+}
+
+// writeGauge writes a single unlabeled gauge with its HELP and TYPE lines
+func writeGauge(w io.Writer, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s %g\n", name, value)
+}
+
+// writeCounter writes a single unlabeled counter with its HELP and TYPE lines
+func writeCounter(w io.Writer, name, help string, value uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", counterFamily(w, name), help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", counterFamily(w, name))
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+// counterFamily returns the name the counter is described by. OpenMetrics names the family
+// without the `_total` suffix of its sample.
+func counterFamily(w io.Writer, name string) string {
+	if _, ok := w.(*openMetricsWriter); ok {
+		return strings.TrimSuffix(name, "_total")
+	}
+	return name
+}
+
+// writeHistogram writes a single unlabeled histogram with its HELP and TYPE lines. The
+// bucket counts are cumulative, one per upper bound.
+func writeHistogram(w io.Writer, name, help string, bounds []float64, buckets []uint64, count uint64, sum float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for i, bound := range bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, count)
+}
+
+// writeCounterVec writes a counter with one sample per value of the given label, sorted by
+// label value so the output is stable
+func writeCounterVec(w io.Writer, name, help, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", counterFamily(w, name), help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", counterFamily(w, name))
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, key, values[key])
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMetricsRecoveryGauges verifies the recovery gauges are computed from the cached
+// engine list and the orchestrator health status
+func TestMetricsRecoveryGauges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &orchClient{
+		base:   "http://test",
+		hc:     &http.Client{Timeout: 3 * time.Second},
+		ctx:    ctx,
+		cancel: cancel,
+		engineCache: []engineState{
+			{ContainerID: "engine-1", HealthStatus: "healthy"},
+			{ContainerID: "engine-2", HealthStatus: "unhealthy"},
+			{ContainerID: "engine-3", HealthStatus: "unhealthy"},
+		},
+		engineCacheTime: time.Now(),
+	}
+	client.health.canProvision = false
+	client.health.blockedReasonCode = "circuit_breaker"
+
+	proxy := &Proxy{Orch: client}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "acexy_engines_recovering 2\n") {
+		t.Errorf("Expected 2 recovering engines, got:\n%s", body)
+	}
+	if !strings.Contains(body, "acexy_engine_circuit_open 1\n") {
+		t.Errorf("Expected circuit to be open, got:\n%s", body)
+	}
+}
+
+// TestMetricsWithoutOrchestrator verifies the gauges are reported as zero in fallback mode
+func TestMetricsWithoutOrchestrator(t *testing.T) {
+	proxy := &Proxy{}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "acexy_engines_recovering 0\n") {
+		t.Errorf("Expected 0 recovering engines, got:\n%s", body)
+	}
+	if !strings.Contains(body, "acexy_engine_circuit_open 0\n") {
+		t.Errorf("Expected circuit to be closed, got:\n%s", body)
+	}
+	if !strings.Contains(body, "acexy_queue_depth 0\n") {
+		t.Errorf("Expected an empty queue, got:\n%s", body)
+	}
+}
+
+// TestAdminSummaryRecovery verifies the summary endpoint exposes the recovery state and
+// enforces the admin token
+func TestAdminSummaryRecovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &orchClient{
+		base:        "http://test",
+		hc:          &http.Client{Timeout: 3 * time.Second},
+		ctx:         ctx,
+		cancel:      cancel,
+		engineCache: []engineState{{ContainerID: "engine-1", HealthStatus: "unhealthy"}},
+	}
+
+	proxy := &Proxy{Orch: client, AdminToken: "secret"}
+
+	// Missing token must be rejected
+	req := httptest.NewRequest(http.MethodGet, "/admin/summary", nil)
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 without token, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/summary", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 with token, got %d", rec.Code)
+	}
+
+	var summary struct {
+		EnginesRecovering int  `json:"engines_recovering"`
+		EngineCircuitOpen bool `json:"engine_circuit_open"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil {
+		t.Fatalf("Failed to decode summary: %v", err)
+	}
+	if summary.EnginesRecovering != 1 {
+		t.Errorf("Expected 1 recovering engine, got %d", summary.EnginesRecovering)
+	}
+	if summary.EngineCircuitOpen {
+		t.Error("Expected circuit to be closed")
+	}
+}
+
+// TestMetricsProvisionOutcomes verifies provisioning attempts are counted by outcome code
+func TestMetricsProvisionOutcomes(t *testing.T) {
+	responses := []struct {
+		status int
+		body   any
+	}{
+		{http.StatusServiceUnavailable, map[string]any{"detail": map[string]any{"code": "vpn_disconnected", "should_wait": true}}},
+		{http.StatusOK, aceProvisionResponse{ContainerID: "engine-1", HostHTTPPort: 19000}},
+		{http.StatusInternalServerError, map[string]any{"detail": map[string]any{"code": "invalid_image", "should_wait": false}}},
+	}
+	attempt := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := responses[attempt]
+		attempt++
+		w.WriteHeader(resp.status)
+		json.NewEncoder(w).Encode(resp.body)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &orchClient{
+		base:   server.URL,
+		hc:     &http.Client{Timeout: 3 * time.Second},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	if _, err := client.ProvisionWithRetry(3); err != nil {
+		t.Fatalf("Expected provisioning to succeed on retry, got: %v", err)
+	}
+	if _, err := client.ProvisionWithRetry(3); err == nil {
+		t.Fatal("Expected permanent provisioning error")
+	}
+
+	proxy := &Proxy{Orch: client}
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`acexy_provision_total{code="permanent"} 1`,
+		`acexy_provision_total{code="success"} 1`,
+		`acexy_provision_total{code="vpn_disconnected"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected %q in metrics, got:\n%s", line, body)
+		}
+	}
+}
+
+// TestMetricsQueue verifies the queue metrics and summary follow the streams waiting for a
+// free stream worker
+func TestMetricsQueue(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback123", "playback456")
+	proxy.Acexy.MaxStreamWorkers = 1
+	proxy.Acexy.Init()
+
+	metrics := func() string {
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rec.Body.String()
+	}
+	waitMetric := func(sample string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !strings.Contains(metrics(), sample) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %q in the metrics:\n%s", sample, metrics())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	wait := streamConcurrently(t, proxy, 2)
+	waitMetric("acexy_queue_depth 1\n")
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/summary", nil))
+	var summary struct {
+		Queue struct {
+			MaxWorkers int `json:"max_workers"`
+			Depth      int `json:"depth"`
+		} `json:"queue"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil {
+		t.Fatalf("Failed to decode summary: %v", err)
+	}
+	if summary.Queue.MaxWorkers != 1 || summary.Queue.Depth != 1 {
+		t.Errorf("Expected 1 queued stream for 1 worker in the summary, got %+v", summary.Queue)
+	}
+
+	close(release)
+	wait()
+	waitMetric("acexy_queue_depth 0\n")
+	body := metrics()
+	for _, sample := range []string{
+		"acexy_queue_wait_seconds_count 2\n",
+		"acexy_queue_wait_seconds_bucket{le=\"+Inf\"} 2\n",
+		"acexy_queue_timeouts_total 0\n",
+		"acexy_pending_streams 0\n",
+	} {
+		if !strings.Contains(body, sample) {
+			t.Errorf("Expected %q in the metrics:\n%s", sample, body)
+		}
+	}
+}
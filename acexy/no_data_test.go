@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNoDataStream verifies a stream the engine ends cleanly without any data is reported as
+// no_data, counted, and its engine deprioritized, unless such streams are allowed to complete
+func TestNoDataStream(t *testing.T) {
+	_, port := newStandbyTestEngine(t, "", false)
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-empty", Host: "127.0.0.1", Port: port, HealthStatus: "healthy"},
+			})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	for _, tt := range []struct {
+		allow    bool
+		expected string
+	}{
+		{false, "no_data"},
+		{true, "completed"},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		client := &orchClient{
+			base:                orch.URL,
+			maxStreamsPerEngine: 1,
+			hc:                  &http.Client{Timeout: 3 * time.Second},
+			ctx:                 ctx,
+			cancel:              cancel,
+			endedStreams:        make(map[string]bool),
+		}
+		acexyInst := &acexy.Acexy{
+			Scheme:            "http",
+			Host:              "127.0.0.1",
+			Port:              1,
+			Endpoint:          acexy.MPEG_TS_ENDPOINT,
+			EmptyTimeout:      5 * time.Second,
+			BufferSize:        1024,
+			NoResponseTimeout: 5 * time.Second,
+		}
+		acexyInst.Init()
+		proxy := &Proxy{Acexy: acexyInst, Orch: client, AllowNoDataCompletion: tt.allow}
+
+		proxy.HandleStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+		cancel()
+
+		streams := proxy.history.Latest(1)
+		if len(streams) != 1 || streams[0].Reason != tt.expected {
+			t.Errorf("Allow %v: expected the %s reason, got %+v", tt.allow, tt.expected, streams)
+		}
+		if failing := client.engineFailing("engine-empty"); failing == tt.allow {
+			t.Errorf("Allow %v: expected the engine failing to be %v", tt.allow, !tt.allow)
+		}
+
+		w := httptest.NewRecorder()
+		proxy.HandleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		count := "1"
+		if tt.allow {
+			count = "0"
+		}
+		if !strings.Contains(w.Body.String(), "acexy_no_data_streams_total "+count+"\n") {
+			t.Errorf("Allow %v: expected %s no_data streams in the metrics:\n%s", tt.allow, count, w.Body.String())
+		}
+	}
+}
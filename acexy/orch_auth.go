@@ -0,0 +1,68 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// orchAuth tracks the orchestrator rejecting the API key, which otherwise only shows up as
+// generic error statuses while acexy keeps operating degraded
+type orchAuth struct {
+	failures    atomic.Uint64
+	lastFailure atomic.Int64 // Unix nanoseconds of the last 401/403 response
+	lastSuccess atomic.Int64 // Unix nanoseconds of the last 2xx response
+}
+
+// authTransport records the authentication outcome of every orchestrator response. When an
+// API key is configured, only the requests sending it count as successes, as the health
+// status is queried without it.
+type authTransport struct {
+	base  http.RoundTripper
+	auth  *orchAuth
+	keyed bool
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		t.auth.failures.Add(1)
+		t.auth.lastFailure.Store(time.Now().UnixNano())
+		slog.Error("Orchestrator rejected the request, check the API key",
+			"status", resp.StatusCode, "url", req.URL.String(), "key_set", req.Header.Get("Authorization") != "")
+	case resp.StatusCode/100 == 2 && (!t.keyed || req.Header.Get("Authorization") != ""):
+		t.auth.lastSuccess.Store(time.Now().UnixNano())
+	}
+	return resp, nil
+}
+
+// AuthFailures returns the number of orchestrator responses rejecting the API key
+func (c *orchClient) AuthFailures() uint64 {
+	if c == nil || c.auth == nil {
+		return 0
+	}
+	return c.auth.failures.Load()
+}
+
+// AuthBroken reports whether the last orchestrator response rejected the API key, not
+// followed by any successful one
+func (c *orchClient) AuthBroken() bool {
+	if c == nil || c.auth == nil {
+		return false
+	}
+	return c.auth.lastFailure.Load() > c.auth.lastSuccess.Load()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
+	"testing"
+)
+
+// TestOrchestratorAuthFailures verifies responses rejecting the API key are counted and
+// surfaced in the metrics and the readiness, until the orchestrator accepts the key again
+func TestOrchestratorAuthFailures(t *testing.T) {
+	var rejecting atomic.Bool
+	rejecting.Store(true)
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rejecting.Load() {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-1", Host: "127.0.0.1", Port: 6878, HealthStatus: "healthy"},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	client := newOrchClient(OrchConfig{URL: orch.URL, APIKey: "wrong"})
+	defer client.Close()
+	proxy := &Proxy{Orch: client, FailReadyOnOrchAuth: true}
+
+	if _, err := client.GetEngines(); err == nil {
+		t.Fatal("Expected the engine list to fail while the API key is rejected")
+	}
+	// The health monitor may hit the orchestrator too, so only a lower bound is known
+	if client.AuthFailures() < 1 || !client.AuthBroken() {
+		t.Errorf("Expected the auth failure to be recorded, got %d failures, broken %v",
+			client.AuthFailures(), client.AuthBroken())
+	}
+	if snapshot := client.HealthSnapshot(); !snapshot.AuthBroken || snapshot.AuthFailures < 1 {
+		t.Errorf("Expected the auth failure in the health snapshot, got %+v", snapshot)
+	}
+
+	w := httptest.NewRecorder()
+	proxy.HandleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !regexp.MustCompile(`(?m)^acexy_orch_auth_failures_total [1-9]`).MatchString(w.Body.String()) {
+		t.Errorf("Expected the auth failure in the metrics:\n%s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	proxy.HandleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var ready readiness
+	json.NewDecoder(w.Body).Decode(&ready)
+	if w.Code != http.StatusServiceUnavailable || ready.Ready || !ready.OrchAuthBroken {
+		t.Errorf("Expected not to be ready with the API key rejected, got %d %+v", w.Code, ready)
+	}
+
+	// Once the key is accepted again, acexy gets ready
+	rejecting.Store(false)
+	client.engineCacheTime = client.engineCacheTime.AddDate(-1, 0, 0)
+	w = httptest.NewRecorder()
+	proxy.HandleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK || client.AuthBroken() {
+		t.Errorf("Expected to be ready once the API key is accepted, got %d: %s", w.Code, w.Body.String())
+	}
+}
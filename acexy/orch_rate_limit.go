@@ -0,0 +1,53 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Code of the provisioning error returned when the orchestrator rate limits acexy (429), and
+// the seconds waited when it gives no Retry-After
+const (
+	ORCH_RATE_LIMITED_CODE        = "orchestrator_rate_limited"
+	ORCH_RATE_LIMIT_DEFAULT_RETRY = 5
+)
+
+// retryAfterSeconds returns the seconds the Retry-After header asks to wait, given either
+// as seconds or as an HTTP date. Returns 0 when missing or invalid.
+func retryAfterSeconds(h http.Header) int {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return max(seconds, 0)
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(int(time.Until(at).Round(time.Second).Seconds()), 0)
+	}
+	return 0
+}
+
+// orchRateLimitedError is the provisioning error of an orchestrator request rate limited for
+// the given seconds, so the wait is honored by the retries and told to the client
+func orchRateLimitedError(retryAfter int) *ProvisioningError {
+	if retryAfter <= 0 {
+		retryAfter = ORCH_RATE_LIMIT_DEFAULT_RETRY
+	}
+	return &ProvisioningError{
+		StatusCode: http.StatusTooManyRequests,
+		Details: &ProvisionError{
+			Code:               ORCH_RATE_LIMITED_CODE,
+			Message:            "orchestrator rate limited the request",
+			RecoveryETASeconds: retryAfter,
+			ShouldWait:         true,
+			CanRetry:           true,
+		},
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRetryAfterSeconds verifies the Retry-After header is read as seconds or as a date
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int
+	}{
+		{"", 0},
+		{"7", 7},
+		{"-3", 0},
+		{"soon", 0},
+		{time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat), 30},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.value != "" {
+			h.Set("Retry-After", tt.value)
+		}
+		if got := retryAfterSeconds(h); got < tt.expected-1 || got > tt.expected {
+			t.Errorf("Retry-After %q: expected %d seconds, got %d", tt.value, tt.expected, got)
+		}
+	}
+}
+
+// rateLimitingOrchestrator answers the first request to the path with 429 and a Retry-After
+// of one second, recording when each request to it arrived
+func rateLimitingOrchestrator(t *testing.T, path string) (*httptest.Server, func() []time.Time) {
+	var mu sync.Mutex
+	var requests []time.Time
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			mu.Lock()
+			requests = append(requests, time.Now())
+			first := len(requests) == 1
+			mu.Unlock()
+			if first {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		}
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{{ContainerID: "engine-1", Host: "127.0.0.1", Port: 6878, HealthStatus: "healthy"}})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		case "/provision/acestream":
+			json.NewEncoder(w).Encode(aceProvisionResponse{ContainerID: "engine-2", HostHTTPPort: 6879})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(orch.Close)
+	return orch, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), requests...)
+	}
+}
+
+// newRateLimitTestClient returns an orchestrator client of the given server
+func newRateLimitTestClient(t *testing.T, base string) *orchClient {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &orchClient{
+		base:                base,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		endedStreams:        make(map[string]bool),
+	}
+}
+
+// TestProvisionHonorsRetryAfter verifies a provision rate limited by the orchestrator is
+// retried no sooner than its Retry-After
+func TestProvisionHonorsRetryAfter(t *testing.T) {
+	orch, requests := rateLimitingOrchestrator(t, "/provision/acestream")
+	client := newRateLimitTestClient(t, orch.URL)
+
+	resp, err := client.ProvisionWithRetryContext(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("Unexpected provision error: %v", err)
+	}
+	if resp.ContainerID != "engine-2" {
+		t.Errorf("Expected engine-2 provisioned, got %s", resp.ContainerID)
+	}
+	times := requests()
+	if len(times) != 2 {
+		t.Fatalf("Expected 2 provision requests, got %d", len(times))
+	}
+	if waited := times[1].Sub(times[0]); waited < time.Second {
+		t.Errorf("Expected the retry after the 1s Retry-After, waited %v", waited)
+	}
+	if got := client.ProvisionStats()[ORCH_RATE_LIMITED_CODE]; got != 1 {
+		t.Errorf("Expected 1 rate limited attempt counted, got %d", got)
+	}
+}
+
+// TestSelectionHonorsRetryAfter verifies a rate limited engine query is retried after its
+// Retry-After, and without retries the client is told when to come back
+func TestSelectionHonorsRetryAfter(t *testing.T) {
+	orch, requests := rateLimitingOrchestrator(t, "/engines")
+	client := newRateLimitTestClient(t, orch.URL)
+	client.selectionRetries = 1
+
+	engine, err := client.SelectBestEngine()
+	if err != nil {
+		t.Fatalf("Unexpected selection error: %v", err)
+	}
+	if engine.ContainerID != "engine-1" {
+		t.Errorf("Expected engine-1 selected, got %s", engine.ContainerID)
+	}
+	times := requests()
+	if len(times) != 2 {
+		t.Fatalf("Expected 2 engine requests, got %d", len(times))
+	}
+	if waited := times[1].Sub(times[0]); waited < time.Second {
+		t.Errorf("Expected the retry after the 1s Retry-After, waited %v", waited)
+	}
+
+	// Without retries the rate limit reaches the client
+	orch, _ = rateLimitingOrchestrator(t, "/engines")
+	client = newRateLimitTestClient(t, orch.URL)
+	_, err = client.SelectBestEngine()
+	if !provisioningBlocked(err) {
+		t.Fatalf("Expected the rate limit to block the selection, got %v", err)
+	}
+	rec := httptest.NewRecorder()
+	(&Proxy{}).handleSelectionError(rec, err)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Expected Retry-After 1, got %q", got)
+	}
+}
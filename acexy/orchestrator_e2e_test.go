@@ -341,7 +341,7 @@ func TestE2E_CapacityAvailable(t *testing.T) {
 	t.Log("Attempting to select engine when at capacity...")
 
 	// First attempt should fail with capacity error
-	_, _, _, err := client.SelectBestEngine()
+	_, err := client.SelectBestEngine()
 	if err == nil {
 		// If we get here before capacity is available, it's expected to fail
 		t.Log("First attempt returned immediately (expected behavior)")
@@ -354,12 +354,12 @@ func TestE2E_CapacityAvailable(t *testing.T) {
 	client.engineCacheTime = time.Time{} // Invalidate cache
 
 	// Second attempt should succeed
-	host, port, _, err := client.SelectBestEngine()
+	engine, err := client.SelectBestEngine()
 	if err != nil {
 		t.Fatalf("Expected success after capacity available, got: %v", err)
 	}
-	if host != "localhost" || port != 19000 {
-		t.Errorf("Expected host=localhost port=19000, got host=%s port=%d", host, port)
+	if engine.Host != "localhost" || engine.Port != 19000 {
+		t.Errorf("Expected host=localhost port=19000, got host=%s port=%d", engine.Host, engine.Port)
 	}
 
 	t.Log("Successfully selected engine after capacity became available")
@@ -409,3 +409,55 @@ func TestE2E_LegacyErrorFormat(t *testing.T) {
 
 	t.Log("Legacy error format successfully parsed with backward compatibility")
 }
+
+// TestE2E_ProvisionAccepted simulates an orchestrator accepting the provision (202) and the
+// engine showing up in the engine list shortly after
+func TestE2E_ProvisionAccepted(t *testing.T) {
+	var accepted atomic.Int64
+	var provisions atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/provision/acestream":
+			provisions.Add(1)
+			accepted.Store(time.Now().UnixNano())
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(aceProvisionResponse{ContainerID: "engine-pending"})
+		case "/engines":
+			engines := []engineState{}
+			// The engine is listed one second after the provision was accepted
+			if at := accepted.Load(); at != 0 && time.Since(time.Unix(0, at)) > time.Second {
+				engines = append(engines, engineState{ContainerID: "engine-pending", ContainerName: "acestream-1", Host: "localhost", Port: 19050})
+			}
+			json.NewEncoder(w).Encode(engines)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &orchClient{
+		base:   server.URL,
+		hc:     &http.Client{Timeout: 3 * time.Second},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	resp, err := client.ProvisionWithRetry(3)
+	if err != nil {
+		t.Fatalf("Expected the accepted provision to succeed, got error: %v", err)
+	}
+	if resp.ContainerID != "engine-pending" || resp.HostHTTPPort != 19050 || resp.ContainerName != "acestream-1" {
+		t.Errorf("Expected the engine details from the engine list, got %+v", resp)
+	}
+	if resp.pending {
+		t.Error("Expected the provision to be ready")
+	}
+	if n := provisions.Load(); n != 1 {
+		t.Errorf("Expected a single provision request, got %d", n)
+	}
+}
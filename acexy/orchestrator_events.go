@@ -6,10 +6,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"javinator9889/acexy/lib/acexy"
 	"javinator9889/acexy/lib/debug"
 	"log/slog"
+	"math"
+	"math/rand/v2"
 	"net/http"
-	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -23,6 +27,8 @@ type orchClient struct {
 	hc   *http.Client
 	// opcional si el proxy conoce el contenedor
 	containerID string
+	// ID of this acexy instance, telling replicas apart in the events
+	instanceID string
 	// Maximum streams per engine
 	maxStreamsPerEngine int
 	// Health monitoring
@@ -33,19 +39,129 @@ type orchClient struct {
 	// Track streams that have already had EmitEnded called to prevent duplicates
 	endedStreams   map[string]bool
 	endedStreamsMu sync.Mutex
+	// Ended streams from the oldest to the latest, the oldest are forgotten past the cap
+	endedOrder      []string
+	endedStreamsCap int
+	// Request ID of each started stream, sent again in its ended event (guarded by endedStreamsMu)
+	requestIDs map[string]string
 	// Engine list cache to reduce concurrent orchestrator queries
 	engineCache         []engineState
 	engineCacheTime     time.Time
 	engineCacheDuration time.Duration
 	engineCacheMu       sync.RWMutex
+	// Only engines matching these labels are used, and provisioned engines get them
+	labelSelector LabelSelector
+	// Provisioning attempts by outcome code, exposed in the metrics
+	provisionTotals   map[string]uint64
+	provisionTotalsMu sync.Mutex
+	// Engines acexy found failing itself, deprioritized until the given time
+	failingEngines   map[string]time.Time
+	failingEnginesMu sync.Mutex
+	// Latest stream outcomes of each engine, ranking the more reliable ones first
+	reliability engineReliability
+	// Age after which the health is considered unknown (0 trusts it forever)
+	healthMaxStaleness time.Duration
+	// AceStream version of the engines, probed on first use (nil disables the probe)
+	versions *engineVersions
+	// Engine that last served each content, preferred for it (nil disables the preference)
+	warm *warmCache
+	// Whether cheaper engines, per their cost label, are preferred over less loaded ones
+	costAware bool
+	// Endpoint removing engines provisioned for requests that are gone (empty disables it)
+	cancelProvisionPath string
+	// Slots of the provisions in flight, nil when unbounded
+	provisions chan struct{}
+	// Provisioning attempts allowed per minute across the process (nil is unbounded)
+	provisionBudget *provisionBudget
+	// Responses rejecting the API key (nil when the client was not built by newOrchClient)
+	auth *orchAuth
+	// Retries of the orchestrator queries failing transiently during the engine selection
+	selectionRetries int
+	// Whether the labels of the selected engine are added to the stream_started events
+	propagateEngineLabels bool
+	// Coalesces the stream started and ended events (nil sends each right away)
+	batcher *eventBatcher
+	// Active stream count of each engine, cached during the selection (nil queries them every time)
+	streamCounts *engineStreamCache
+	// Events being sent in the background, waited for at shutdown
+	inflight sync.WaitGroup
+	// Whether the container of an engine is checked against the orchestrator before its use
+	verifyEngineIdentity bool
+	// Whether an engine is provisioned as soon as provisioning is no longer blocked
+	eagerRecoverProvision bool
+	// Whether the engine already streaming the requested content for the cluster is preferred
+	clusterDedup bool
+	// New engines quarantined when failing their first stream (nil disables)
+	probation *engineProbation
+	// Whether streams are refused once the orchestrator reports the cluster capacity exhausted
+	respectClusterCapacity bool
 }
 
 
 
+// Intervals of the health and cleanup monitors
+const (
+	HEALTH_CHECK_INTERVAL = 30 * time.Second
+	CLEANUP_INTERVAL      = 5 * time.Minute
+)
+
+// Fraction of its interval each monitor is randomly offset by when it starts, so replicas
+// started at once don't hit the orchestrator in sync
+const MONITOR_JITTER = 0.2
+
+// monitorJitter returns a random delay below MONITOR_JITTER of the interval
+func monitorJitter(interval time.Duration) time.Duration {
+	bound := int64(float64(interval) * MONITOR_JITTER)
+	if bound <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(bound))
+}
+
+// Extra attempts of a failed orchestrator health check, and the delay between them
+const (
+	HEALTH_CHECK_RETRIES     = 2
+	HEALTH_CHECK_RETRY_DELAY = 500 * time.Millisecond
+)
+
+// Code of the provisioning error returned when every engine is recovering and no new one
+// can be provisioned
+const ALL_RECOVERING_CODE = "all_recovering"
+
+// Time an engine the orchestrator reports unhealthy is expected to take to recover, as
+// the orchestrator gives no estimate
+const ENGINE_RECOVERY_ETA = 30 * time.Second
+
+// Code of the provisioning error returned when no provision slot frees up in time, the
+// time a selection waits for one and the retry delay suggested to the client
+const (
+	PROVISION_LIMIT_CODE        = "provision_limit"
+	PROVISION_SLOT_WAIT         = 10 * time.Second
+	PROVISION_LIMIT_RETRY_AFTER = 5
+)
+
+// Code of the provisioning error returned once the provisioning attempts allowed per
+// minute are exhausted
+const PROVISION_RATE_LIMITED_CODE = "provision_rate_limited"
+
+// Interval at which the engine list is polled for a provision the orchestrator accepted
+// (202) without the engine being ready, and the time it is given to show up
+const (
+	PROVISION_READY_POLL_INTERVAL = 500 * time.Millisecond
+	PROVISION_READY_TIMEOUT       = 60 * time.Second
+)
+
+// Code and reason reported while the orchestrator health is stale
+const (
+	HEALTH_STALE_CODE   = "health_stale"
+	HEALTH_STALE_REASON = "orchestrator health is stale"
+)
+
 // OrchestratorHealth tracks the health status of the orchestrator
 type OrchestratorHealth struct {
 	mu                sync.RWMutex
 	lastCheck         time.Time
+	lastFailure       time.Time // Last health check the orchestrator did not answer
 	status            string
 	canProvision      bool
 	blockedReason     string
@@ -54,13 +170,15 @@ type OrchestratorHealth struct {
 	shouldWait        bool   // NEW: Whether clients should wait/retry
 	vpnConnected      bool
 	capacity          CapacityInfo // NEW: Capacity information
+	recommendedEngine string       // Engine the orchestrator recommends using, if any
+	preferNew         bool         // Whether the orchestrator prefers new engines to be provisioned
 }
 
 // CapacityInfo represents orchestrator capacity status
 type CapacityInfo struct {
-	Total     int
-	Used      int
-	Available int
+	Total     int `json:"total"`
+	Used      int `json:"used"`
+	Available int `json:"available"`
 }
 
 // orchestratorStatus represents the response from /orchestrator/status endpoint
@@ -79,6 +197,9 @@ type orchestratorStatus struct {
 		Used      int `json:"used"`
 		Available int `json:"available"`
 	} `json:"capacity"` // NEW: Capacity information
+	// Optional selection hints, e.g. provisioning fresh engines during a declared scale-up
+	RecommendedEngine string `json:"recommended_engine,omitempty"`
+	PreferNew         bool   `json:"prefer_new,omitempty"`
 }
 
 // ProvisionError represents structured error details from orchestrator
@@ -97,6 +218,47 @@ type ProvisioningError struct {
 	Details    *ProvisionError
 }
 
+// Errors returned by SelectBestEngine when the orchestrator cannot provide an engine and
+// provisioning one is blocked. The returned error carries the orchestrator reason, so they
+// must be checked with errors.Is.
+var (
+	ErrVPNDisconnected     = errors.New("VPN connection required")
+	ErrCircuitBreakerOpen  = errors.New("circuit breaker open")
+	ErrProvisioningBlocked = errors.New("provisioning blocked")
+)
+
+// provisioningBlockedError carries the orchestrator reason of a blocked provisioning, and
+// unwraps to the sentinel error matching its code
+type provisioningBlockedError struct {
+	reason string
+	kind   error
+}
+
+// blockedProvisioningError returns the error for a provisioning blocked by the orchestrator
+// with the given code and reason. Without a code, it is inferred from the reason.
+func blockedProvisioningError(code, reason string) error {
+	if code == "" {
+		code, _ = legacyProvisionCode(reason)
+	}
+
+	err := &provisioningBlockedError{reason: reason, kind: ErrProvisioningBlocked}
+	switch code {
+	case "vpn_disconnected", "vpn_error":
+		err.kind = ErrVPNDisconnected
+	case "circuit_breaker":
+		err.kind = ErrCircuitBreakerOpen
+	}
+	return err
+}
+
+func (e *provisioningBlockedError) Error() string {
+	return "cannot provision: " + e.reason
+}
+
+func (e *provisioningBlockedError) Unwrap() error {
+	return e.kind
+}
+
 func (e *ProvisioningError) Error() string {
 	if e.Details != nil {
 		return fmt.Sprintf("provisioning %s: %s", e.Details.Code, e.Details.Message)
@@ -104,21 +266,63 @@ func (e *ProvisioningError) Error() string {
 	return fmt.Sprintf("provisioning failed with status %d", e.StatusCode)
 }
 
-func newOrchClient(base string) *orchClient {
-	if base == "" {
+// newOrchClient creates the orchestrator client from the given configuration and starts its
+// background monitors. Returns nil when no orchestrator URL is configured.
+func newOrchClient(cfg OrchConfig) *orchClient {
+	if cfg.URL == "" {
 		return nil
 	}
+	if cfg.MaxStreamsPerEngine <= 0 {
+		cfg.MaxStreamsPerEngine = 1
+	}
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = 3 * time.Second
+	}
+	if cfg.EngineCacheDuration <= 0 {
+		cfg.EngineCacheDuration = 2 * time.Second // Cache engines for 2 seconds to reduce concurrent queries
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
+	auth := &orchAuth{}
 	client := &orchClient{
-		base:                base,
-		key:                 os.Getenv("ACEXY_ORCH_APIKEY"),
-		containerID:         os.Getenv("ACEXY_CONTAINER_ID"),
-		maxStreamsPerEngine: 1, // Default value, will be set from main
-		hc:                  &http.Client{Timeout: 3 * time.Second},
+		base:                cfg.URL,
+		key:                 cfg.APIKey,
+		containerID:         cfg.ContainerID,
+		instanceID:          cfg.InstanceID,
+		maxStreamsPerEngine: cfg.MaxStreamsPerEngine,
+		hc:                  &http.Client{Timeout: cfg.RequestTimeout, Transport: &authTransport{auth: auth, keyed: cfg.APIKey != ""}},
 		ctx:                 ctx,
 		cancel:              cancel,
 		endedStreams:        make(map[string]bool),
-		engineCacheDuration: 2 * time.Second, // Cache engines for 2 seconds to reduce concurrent queries
+		endedStreamsCap:     cfg.EndedStreamsCap,
+		engineCacheDuration: cfg.EngineCacheDuration,
+		labelSelector:       cfg.LabelSelector,
+		healthMaxStaleness:  cfg.HealthMaxStaleness,
+		versions:            newEngineVersions(cfg.ProbeEngineVersion),
+		warm:                newWarmCache(cfg.PreferWarmCache),
+		costAware:           cfg.CostAwareSelection,
+		provisionBudget:     newProvisionBudget(cfg.MaxProvisionAttemptsPerMinute),
+		auth:                auth,
+		selectionRetries:    cfg.SelectionRetries,
+
+		propagateEngineLabels: cfg.PropagateEngineLabels,
+		streamCounts:          newEngineStreamCache(cfg.EngineStreamCacheTTL),
+		verifyEngineIdentity:  cfg.VerifyEngineIdentity,
+		eagerRecoverProvision: cfg.EagerRecoverProvision,
+		clusterDedup:          cfg.ClusterDedup,
+		probation:             newEngineProbation(cfg.NewEngineProbation),
+
+		respectClusterCapacity: cfg.RespectClusterCapacity,
+	}
+	client.batcher = newEventBatcher(cfg.BatchEvents, client)
+	if cfg.MaxConcurrentProvisions > 0 {
+		client.provisions = make(chan struct{}, cfg.MaxConcurrentProvisions)
+	}
+	if cfg.CancelOrphanProvisions {
+		client.cancelProvisionPath = cfg.CancelProvisionPath
+		if client.cancelProvisionPath == "" {
+			client.cancelProvisionPath = DEFAULT_CANCEL_PROVISION_PATH
+		}
 	}
 
 	// Start health monitoring in background
@@ -135,6 +339,27 @@ func (c *orchClient) Close() {
 	if c != nil && c.cancel != nil {
 		c.cancel()
 	}
+	if c != nil {
+		c.batcher.Flush()
+	}
+}
+
+// WaitEvents waits up to the given timeout for the events being sent in the background
+func (c *orchClient) WaitEvents(timeout time.Duration) {
+	if c == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("Timed out waiting for the events sent to the orchestrator", "timeout", timeout)
+	}
 }
 
 // StartCleanupMonitor periodically cleans up stale tracking data
@@ -143,7 +368,12 @@ func (c *orchClient) StartCleanupMonitor() {
 		return
 	}
 
-	ticker := time.NewTicker(5 * time.Minute)
+	// Offset the cadence from the other replicas
+	if err := c.wait(c.ctx, monitorJitter(CLEANUP_INTERVAL)); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(CLEANUP_INTERVAL)
 	defer ticker.Stop()
 
 	for {
@@ -162,15 +392,21 @@ func (c *orchClient) cleanupStaleData() {
 		return
 	}
 
-	// Clean up ended streams tracking (keep only last 1000 entries)
-	c.endedStreamsMu.Lock()
-	if len(c.endedStreams) > 1000 {
-		// Clear all to prevent unbounded growth
-		// This is safe because streams that ended >5 minutes ago don't need tracking
-		slog.Debug("Cleaning up ended streams tracking map", "size", len(c.endedStreams))
-		c.endedStreams = make(map[string]bool)
+	c.warm.Cleanup()
+
+	// Forget the outcomes of the engines the orchestrator no longer lists
+	c.engineCacheMu.RLock()
+	var listed map[string]struct{}
+	if c.engineCache != nil {
+		listed = make(map[string]struct{}, len(c.engineCache))
+		for _, engine := range c.engineCache {
+			listed[engine.ContainerID] = struct{}{}
+		}
+	}
+	c.engineCacheMu.RUnlock()
+	if listed != nil {
+		c.reliability.Forget(listed)
 	}
-	c.endedStreamsMu.Unlock()
 }
 
 // SetMaxStreamsPerEngine sets the maximum streams per engine configuration
@@ -186,10 +422,13 @@ func (c *orchClient) StartHealthMonitor() {
 		return
 	}
 
-	// Do initial health check immediately
+	// Do initial health check immediately, then offset the cadence from the other replicas
 	c.updateHealth()
+	if err := c.wait(c.ctx, monitorJitter(HEALTH_CHECK_INTERVAL)); err != nil {
+		return
+	}
 
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(HEALTH_CHECK_INTERVAL)
 	defer ticker.Stop()
 
 	for {
@@ -202,29 +441,44 @@ func (c *orchClient) StartHealthMonitor() {
 	}
 }
 
-// updateHealth fetches and updates the orchestrator health status
-func (c *orchClient) updateHealth() {
+// updateHealth fetches and updates the orchestrator health status. Transient failures are
+// retried up to HEALTH_CHECK_RETRIES times; once they are exhausted, the previous health is
+// kept until it becomes stale.
+func (c *orchClient) updateHealth() error {
 	debugLog := debug.GetDebugLogger()
 
 	if c == nil {
-		return
+		return fmt.Errorf("orchestrator client not configured")
 	}
 
-	resp, err := c.hc.Get(c.base + "/orchestrator/status")
-	if err != nil {
-		slog.Warn("Health check failed", "error", err)
-		return
-	}
-	defer resp.Body.Close()
-
 	var status orchestratorStatus
-	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
-		slog.Warn("Failed to decode health status", "error", err)
-		return
+	var err error
+	for attempt := 0; attempt <= HEALTH_CHECK_RETRIES; attempt++ {
+		if attempt > 0 {
+			if c.ctx != nil && c.wait(context.Background(), HEALTH_CHECK_RETRY_DELAY) != nil {
+				break
+			}
+			slog.Debug("Retrying orchestrator health check", "attempt", attempt, "error", err)
+		}
+		if status, err = c.fetchHealth(); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		slog.Warn("Health check failed", "error", err, "retries", HEALTH_CHECK_RETRIES)
+		c.health.mu.Lock()
+		c.health.lastFailure = time.Now()
+		c.health.mu.Unlock()
+		return err
 	}
 
 	c.health.mu.Lock()
 	defer c.health.mu.Unlock()
+
+	// Provisioning recovered from being blocked, provision ahead of the next client
+	if c.eagerRecoverProvision && !c.health.lastCheck.IsZero() && !c.health.canProvision && status.Provisioning.CanProvision {
+		go c.recoverProvision()
+	}
 	c.health.lastCheck = time.Now()
 	c.health.status = status.Status
 	c.health.canProvision = status.Provisioning.CanProvision
@@ -235,6 +489,8 @@ func (c *orchClient) updateHealth() {
 		Used:      status.Capacity.Used,
 		Available: status.Capacity.Available,
 	}
+	c.health.recommendedEngine = status.RecommendedEngine
+	c.health.preferNew = status.PreferNew
 
 	// Extract details from blocked reason if available
 	if status.Provisioning.BlockedReasonDetails != nil {
@@ -282,9 +538,48 @@ func (c *orchClient) updateHealth() {
 			},
 		)
 	}
+	return nil
+}
+
+// fetchHealth queries the orchestrator health status once
+func (c *orchClient) fetchHealth() (orchestratorStatus, error) {
+	var status orchestratorStatus
+
+	resp, err := c.hc.Get(c.base + "/orchestrator/status")
+	if err != nil {
+		return status, fmt.Errorf("health check failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("health check failed: orchestrator returned status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, fmt.Errorf("failed to decode health status: %w", err)
+	}
+	return status, nil
 }
 
-// CanProvision checks if orchestrator can provision new engines
+// healthStale reports whether the last successful health check is older than the allowed
+// staleness, in which case the orchestrator health is unknown. The health lock must be held.
+func (c *orchClient) healthStale() bool {
+	return c.healthMaxStaleness > 0 && time.Since(c.health.lastCheck) > c.healthMaxStaleness
+}
+
+// HealthStale reports whether the orchestrator health is too old to be trusted
+func (c *orchClient) HealthStale() bool {
+	if c == nil {
+		return false
+	}
+
+	c.health.mu.RLock()
+	defer c.health.mu.RUnlock()
+
+	return c.healthStale()
+}
+
+// CanProvision checks if orchestrator can provision new engines. While the health is stale,
+// provisioning is not considered possible.
 func (c *orchClient) CanProvision() (bool, string) {
 	if c == nil {
 		return false, "orchestrator not configured"
@@ -293,10 +588,26 @@ func (c *orchClient) CanProvision() (bool, string) {
 	c.health.mu.RLock()
 	defer c.health.mu.RUnlock()
 
+	if c.healthStale() {
+		return false, HEALTH_STALE_REASON
+	}
 	return c.health.canProvision, c.health.blockedReason
 }
 
-// GetProvisioningStatus returns detailed provisioning status including recovery information
+// selectionHints returns the engine the orchestrator recommends and whether it prefers new
+// engines to be provisioned. No hint is returned while the health is stale.
+func (c *orchClient) selectionHints() (recommended string, preferNew bool) {
+	c.health.mu.RLock()
+	defer c.health.mu.RUnlock()
+
+	if c.healthStale() {
+		return "", false
+	}
+	return c.health.recommendedEngine, c.health.preferNew
+}
+
+// GetProvisioningStatus returns detailed provisioning status including recovery information.
+// While the health is stale, provisioning is not considered possible.
 func (c *orchClient) GetProvisioningStatus() (canProvision bool, shouldWait bool, recoveryETA int) {
 	if c == nil {
 		return false, false, 0
@@ -305,9 +616,85 @@ func (c *orchClient) GetProvisioningStatus() (canProvision bool, shouldWait bool
 	c.health.mu.RLock()
 	defer c.health.mu.RUnlock()
 
+	if c.healthStale() {
+		return false, false, 0
+	}
 	return c.health.canProvision, c.health.shouldWait, c.health.recoveryETA
 }
 
+// HealthSnapshot is a point-in-time copy of the orchestrator health, safe to serialize
+type HealthSnapshot struct {
+	LastCheck         time.Time    `json:"last_check"`
+	Status            string       `json:"status"`
+	Healthy           bool         `json:"healthy"` // Whether the status is healthy and not stale
+	Stale             bool         `json:"stale"`   // Whether the last check is too old to be trusted
+	Reachable         bool         `json:"reachable"` // Whether the last health check was answered
+	CanProvision      bool         `json:"can_provision"`
+	BlockedReason     string       `json:"blocked_reason,omitempty"`
+	BlockedReasonCode string       `json:"blocked_reason_code,omitempty"`
+	RecoveryETA       int          `json:"recovery_eta_seconds"`
+	ShouldWait        bool         `json:"should_wait"`
+	VPNConnected      bool         `json:"vpn_connected"`
+	Capacity          CapacityInfo `json:"capacity"`
+	RecommendedEngine string       `json:"recommended_engine,omitempty"`
+	PreferNew         bool         `json:"prefer_new"`
+	AuthFailures      uint64       `json:"auth_failures"` // Responses rejecting the API key
+	AuthBroken        bool         `json:"auth_broken"`   // Whether the last response rejected the API key
+}
+
+// HealthSnapshot returns a copy of the current orchestrator health status
+func (c *orchClient) HealthSnapshot() HealthSnapshot {
+	if c == nil {
+		return HealthSnapshot{}
+	}
+
+	c.health.mu.RLock()
+	defer c.health.mu.RUnlock()
+
+	stale := c.healthStale()
+	return HealthSnapshot{
+		LastCheck:         c.health.lastCheck,
+		Status:            c.health.status,
+		Healthy:           !stale && c.health.status == "healthy",
+		Stale:             stale,
+		Reachable:         !c.health.lastCheck.IsZero() && c.health.lastCheck.After(c.health.lastFailure),
+		CanProvision:      c.health.canProvision,
+		BlockedReason:     c.health.blockedReason,
+		BlockedReasonCode: c.health.blockedReasonCode,
+		RecoveryETA:       c.health.recoveryETA,
+		ShouldWait:        c.health.shouldWait,
+		VPNConnected:      c.health.vpnConnected,
+		Capacity:          c.health.capacity,
+		RecommendedEngine: c.health.recommendedEngine,
+		PreferNew:         c.health.preferNew,
+		AuthFailures:      c.AuthFailures(),
+		AuthBroken:        c.AuthBroken(),
+	}
+}
+
+// RecoveryStats reports how many known engines are currently recovering (not healthy
+// according to the orchestrator) and whether the orchestrator circuit breaker is open.
+// It only reads the cached engine list, so it never triggers an orchestrator query.
+func (c *orchClient) RecoveryStats() (recovering int, circuitOpen bool) {
+	if c == nil {
+		return 0, false
+	}
+
+	c.engineCacheMu.RLock()
+	for _, engine := range c.engineCache {
+		if engine.HealthStatus == "unhealthy" {
+			recovering++
+		}
+	}
+	c.engineCacheMu.RUnlock()
+
+	c.health.mu.RLock()
+	circuitOpen = !c.health.canProvision && c.health.blockedReasonCode == "circuit_breaker"
+	c.health.mu.RUnlock()
+
+	return recovering, circuitOpen
+}
+
 // parseProvisionError parses error response from provisioning endpoint
 // Handles both structured (new) and legacy (string) error formats
 func parseProvisionError(resp *http.Response) (*ProvisionError, error) {
@@ -329,23 +716,8 @@ func parseProvisionError(resp *http.Response) (*ProvisionError, error) {
 	var stringDetail string
 	if err := json.Unmarshal(errorResp.Detail, &stringDetail); err == nil {
 		// Parse common error patterns to provide better error codes
-		code := "general_error"
-		shouldWait := false
-		recoveryETA := 0
-
-		if strings.Contains(stringDetail, "VPN") {
-			code = "vpn_disconnected"
-			shouldWait = true
-			recoveryETA = 60
-		} else if strings.Contains(stringDetail, "circuit breaker") || strings.Contains(stringDetail, "Circuit breaker") {
-			code = "circuit_breaker"
-			shouldWait = true
-			recoveryETA = 180
-		} else if strings.Contains(stringDetail, "capacity") {
-			code = "max_capacity"
-			shouldWait = true
-			recoveryETA = 30
-		}
+		code, recoveryETA := legacyProvisionCode(stringDetail)
+		shouldWait := code != "general_error"
 
 		return &ProvisionError{
 			Error:              "provisioning_failed",
@@ -360,8 +732,24 @@ func parseProvisionError(resp *http.Response) (*ProvisionError, error) {
 	return nil, fmt.Errorf("failed to parse error response")
 }
 
+// legacyProvisionCode infers the error code and recovery ETA of a legacy orchestrator
+// error, which only carries a human readable message
+func legacyProvisionCode(detail string) (code string, recoveryETA int) {
+	switch {
+	case strings.Contains(detail, "VPN"):
+		return "vpn_disconnected", 60
+	case strings.Contains(detail, "circuit breaker") || strings.Contains(detail, "Circuit breaker"):
+		return "circuit_breaker", 180
+	case strings.Contains(detail, "capacity"):
+		return "max_capacity", 30
+	default:
+		return "general_error", 0
+	}
+}
+
 type startedEvent struct {
 	ContainerID string `json:"container_id,omitempty"`
+	InstanceID  string `json:"instance_id,omitempty"`
 	Engine      struct {
 		Host string `json:"host"`
 		Port int    `json:"port"`
@@ -375,14 +763,28 @@ type startedEvent struct {
 		StatURL           string `json:"stat_url"`
 		CommandURL        string `json:"command_url"`
 		IsLive            int    `json:"is_live"`
+		IsEncrypted       int    `json:"is_encrypted"`
+		Infohash          string `json:"infohash,omitempty"`
 	} `json:"session"`
-	Labels map[string]string `json:"labels,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+type stuckEvent struct {
+	ContainerID       string `json:"container_id,omitempty"`
+	InstanceID        string `json:"instance_id,omitempty"`
+	StreamID          string `json:"stream_id"`
+	EngineContainerID string `json:"engine_container_id,omitempty"`
+	StalledSeconds    int    `json:"stalled_seconds"`
+	BytesSent         uint64 `json:"bytes_sent"`
 }
 
 type endedEvent struct {
 	ContainerID string `json:"container_id,omitempty"`
+	InstanceID  string `json:"instance_id,omitempty"`
 	StreamID    string `json:"stream_id,omitempty"`
 	Reason      string `json:"reason,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
 }
 
 // New types for engine selection and orchestrator API
@@ -430,6 +832,9 @@ type aceProvisionResponse struct {
 	HostHTTPPort       int    `json:"host_http_port"`
 	ContainerHTTPPort  int    `json:"container_http_port"`
 	ContainerHTTPSPort int    `json:"container_https_port"`
+
+	// Whether the orchestrator accepted the provision (202) without the engine being ready
+	pending bool
 }
 
 func (c *orchClient) post(path string, body any) {
@@ -453,7 +858,9 @@ func (c *orchClient) post(path string, body any) {
 		req.Header.Set("Authorization", "Bearer "+c.key)
 	}
 
+	c.inflight.Add(1)
 	go func() {
+		defer c.inflight.Done()
 		slog.Debug("Sending event to orchestrator", "url", c.base+path)
 		resp, err := c.hc.Do(req)
 		if err != nil {
@@ -471,21 +878,22 @@ func (c *orchClient) post(path string, body any) {
 }
 
 // postSync sends a synchronous POST request to orchestrator (blocks until complete)
-// Used for critical events where ordering matters (e.g., stream_started)
-func (c *orchClient) postSync(path string, body any) {
+// Used for critical events where ordering matters (e.g., stream_started). Returns the
+// response body when the orchestrator accepted the event, nil otherwise.
+func (c *orchClient) postSync(path string, body any) []byte {
 	if c == nil {
-		return
+		return nil
 	}
 	b, err := json.Marshal(body)
 	if err != nil {
 		slog.Warn("Failed to marshal orchestrator event", "error", err, "path", path)
-		return
+		return nil
 	}
 
 	req, err := http.NewRequest(http.MethodPost, c.base+path, bytes.NewReader(b))
 	if err != nil {
 		slog.Warn("Failed to create orchestrator request", "error", err, "path", path)
-		return
+		return nil
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -497,49 +905,131 @@ func (c *orchClient) postSync(path string, body any) {
 	resp, err := c.hc.Do(req)
 	if err != nil {
 		slog.Warn("Failed to send event to orchestrator", "error", err, "url", c.base+path)
-		return
+		return nil
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		slog.Warn("Orchestrator returned error status", "status", resp.StatusCode, "url", c.base+path)
-	} else {
-		slog.Debug("Successfully sent synchronous event to orchestrator", "status", resp.StatusCode, "url", c.base+path)
+		return nil
+	}
+	slog.Debug("Successfully sent synchronous event to orchestrator", "status", resp.StatusCode, "url", c.base+path)
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		slog.Debug("Failed to read the orchestrator response", "error", err, "url", c.base+path)
+		return nil
 	}
+	return respBody
 }
 
-func (c *orchClient) EmitStarted(host string, port int, keyType, key, playbackID, statURL, cmdURL, streamID, engineContainerID string) {
+// startedResponse is the answer of the orchestrator to a stream_started event
+type startedResponse struct {
+	StreamID string `json:"stream_id"` // Canonical ID the orchestrator assigned to the stream
+}
+
+// startedStream describes a stream whose start is reported to the orchestrator
+type startedStream struct {
+	Host              string
+	Port              int
+	KeyType           string
+	Key               string
+	PlaybackID        string
+	Stream            *acexy.AceStream
+	StreamID          string // Local ID of the stream, until the orchestrator assigns one
+	EngineContainerID string
+	ClientLabel       string // Label the client tagged the stream with, if any
+	RequestID         string
+}
+
+// EmitStarted reports the stream start to the orchestrator. Returns the ID the stream is
+// known by from then on: the one the orchestrator assigned in its answer, if any, or the
+// given one otherwise. The request ID, if any, is also sent with the stream end.
+func (c *orchClient) EmitStarted(started startedStream) string {
 	debugLog := debug.GetDebugLogger()
 	startTime := time.Now()
 
+	streamID, stream := started.StreamID, started.Stream
 	if c == nil {
-		return
+		return streamID
+	}
+
+	ev := startedEvent{ContainerID: c.containerID, InstanceID: c.instanceID, RequestID: started.RequestID}
+	ev.Engine.Host, ev.Engine.Port = started.Host, started.Port
+	ev.Stream.KeyType, ev.Stream.Key = started.KeyType, started.Key
+	ev.Session.PlaybackSessionID = started.PlaybackID
+	ev.Session.StatURL, ev.Session.CommandURL = stream.StatURL, stream.CommandURL
+	ev.Session.IsLive = boolToInt(stream.IsLive)
+	ev.Session.IsEncrypted = boolToInt(stream.IsEncrypted)
+	ev.Session.Infohash = stream.Infohash
+	ev.Labels = map[string]string{}
+	if c.propagateEngineLabels {
+		for k, v := range c.cachedEngineLabels(started.EngineContainerID) {
+			ev.Labels[k] = v
+		}
+	}
+	ev.Labels["stream_id"] = streamID
+	if started.ClientLabel != "" {
+		ev.Labels[STREAM_LABEL_EVENT_KEY] = started.ClientLabel
 	}
-
-	ev := startedEvent{ContainerID: c.containerID}
-	ev.Engine.Host, ev.Engine.Port = host, port
-	ev.Stream.KeyType, ev.Stream.Key = keyType, key
-	ev.Session.PlaybackSessionID = playbackID
-	ev.Session.StatURL, ev.Session.CommandURL = statURL, cmdURL
-	ev.Session.IsLive = 1
-	ev.Labels = map[string]string{"stream_id": streamID}
 
 	// Add debug logging for orchestrator integration
 	slog.Debug("Emitting stream_started event to orchestrator",
-		"stream_id", streamID, "key_type", keyType, "key", key,
-		"host", host, "port", port, "playback_id", playbackID)
+		"stream_id", streamID, "key_type", started.KeyType, "key", started.Key,
+		"host", started.Host, "port", started.Port, "playback_id", started.PlaybackID, "is_live", stream.IsLive, "request_id", started.RequestID)
 
 	// Post event synchronously to ensure ordering (started before ended)
-	c.postSync("/events/stream_started", ev)
+	if body := c.emit("/events/stream_started", ev, true); len(body) > 0 {
+		var resp startedResponse
+		if err := json.Unmarshal(body, &resp); err == nil && resp.StreamID != "" && resp.StreamID != streamID {
+			slog.Debug("Orchestrator assigned the stream ID", "local_stream_id", streamID, "stream_id", resp.StreamID)
+			streamID = resp.StreamID
+		}
+	}
+	c.streamCounts.Started(streamID, started.EngineContainerID)
+	if started.RequestID != "" {
+		c.endedStreamsMu.Lock()
+		if c.requestIDs == nil {
+			c.requestIDs = make(map[string]string)
+		}
+		c.requestIDs[streamID] = started.RequestID
+		c.endedStreamsMu.Unlock()
+	}
 
 	duration := time.Since(startTime)
-	debugLog.LogStreamEvent("stream_started", streamID, engineContainerID, duration, map[string]interface{}{
-		"host":        host,
-		"port":        port,
-		"key_type":    keyType,
-		"key":         key,
-		"playback_id": playbackID,
+	debugLog.LogStreamEvent("stream_started", streamID, started.EngineContainerID, duration, map[string]interface{}{
+		"host":        started.Host,
+		"port":        started.Port,
+		"key_type":    started.KeyType,
+		"key":         started.Key,
+		"playback_id": started.PlaybackID,
+		"is_live":     stream.IsLive,
 	})
+	return streamID
+}
+
+// cachedEngineLabels returns the labels of the engine in the cached engine list, nil when
+// it is not there
+func (c *orchClient) cachedEngineLabels(containerID string) map[string]string {
+	if containerID == "" {
+		return nil
+	}
+
+	c.engineCacheMu.RLock()
+	defer c.engineCacheMu.RUnlock()
+	for _, engine := range c.engineCache {
+		if engine.ContainerID == containerID {
+			return engine.Labels
+		}
+	}
+	return nil
+}
+
+// boolToInt converts a flag to the 0/1 representation used by the AceStream API
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 func (c *orchClient) EmitEnded(streamID, reason string) {
@@ -559,16 +1049,19 @@ func (c *orchClient) EmitEnded(streamID, reason string) {
 		return
 	}
 	// Mark as ended before releasing lock to prevent race
-	c.endedStreams[streamID] = true
+	c.markEnded(streamID)
+	requestID := c.requestIDs[streamID]
+	delete(c.requestIDs, streamID)
 	c.endedStreamsMu.Unlock()
 
-	ev := endedEvent{ContainerID: c.containerID, StreamID: streamID, Reason: reason}
+	ev := endedEvent{ContainerID: c.containerID, InstanceID: c.instanceID, StreamID: streamID, Reason: reason, RequestID: requestID}
+	c.streamCounts.Ended(streamID)
 
 	// Add debug logging for orchestrator integration
 	slog.Debug("Emitting stream_ended event to orchestrator",
 		"stream_id", streamID, "reason", reason, "container_id", c.containerID)
 
-	c.post("/events/stream_ended", ev)
+	c.emit("/events/stream_ended", ev, false)
 
 	duration := time.Since(startTime)
 	debugLog.LogStreamEvent("stream_ended", streamID, c.containerID, duration, map[string]interface{}{
@@ -576,6 +1069,51 @@ func (c *orchClient) EmitEnded(streamID, reason string) {
 	})
 }
 
+// EmitStuck reports a stream whose client has not been delivered any data for the given
+// time, although the stream is still being served
+func (c *orchClient) EmitStuck(streamID, engineContainerID string, stalled time.Duration, bytesSent uint64) {
+	if c == nil || streamID == "" {
+		return
+	}
+
+	slog.Debug("Emitting stream_stuck event to orchestrator", "stream_id", streamID, "stalled", stalled)
+	c.post("/events/stream_stuck", stuckEvent{
+		ContainerID:       c.containerID,
+		InstanceID:        c.instanceID,
+		StreamID:          streamID,
+		EngineContainerID: engineContainerID,
+		StalledSeconds:    int(stalled.Seconds()),
+		BytesSent:         bytesSent,
+	})
+}
+
+// EmitReconciledEnded reports the end of a stream the orchestrator still lists although
+// acexy no longer serves it. Unlike EmitEnded it is sent even if the stream already ended,
+// since the former event may have been lost.
+func (c *orchClient) EmitReconciledEnded(streamID string) {
+	if c == nil || streamID == "" {
+		return
+	}
+
+	c.endedStreamsMu.Lock()
+	c.markEnded(streamID)
+	c.endedStreamsMu.Unlock()
+	c.streamCounts.Ended(streamID)
+
+	slog.Debug("Emitting corrective stream_ended event to orchestrator",
+		"stream_id", streamID, "container_id", c.containerID)
+	c.emit("/events/stream_ended", endedEvent{ContainerID: c.containerID, InstanceID: c.instanceID, StreamID: streamID, Reason: "reconciled"}, true)
+}
+
+// RecordServedContent remembers the engine served the given content, so it is preferred
+// for that content while it is likely still cached there
+func (c *orchClient) RecordServedContent(aceID, containerID string) {
+	if c == nil {
+		return
+	}
+	c.warm.Record(aceID, containerID)
+}
+
 // GetEngines retrieves all available engines from the orchestrator
 // Results are cached for a short duration to reduce concurrent query load
 func (c *orchClient) GetEngines() ([]engineState, error) {
@@ -611,7 +1149,7 @@ func (c *orchClient) GetEngines() ([]engineState, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("orchestrator returned status %d", resp.StatusCode)
+		return nil, &orchStatusError{StatusCode: resp.StatusCode, RetryAfter: retryAfterSeconds(resp.Header)}
 	}
 
 	var engines []engineState
@@ -629,13 +1167,59 @@ func (c *orchClient) GetEngines() ([]engineState, error) {
 	return engines, nil
 }
 
+// RefreshEngines invalidates the engine cache and fetches the engine list anew
+func (c *orchClient) RefreshEngines() ([]engineState, error) {
+	if c == nil {
+		return nil, fmt.Errorf("orchestrator client not configured")
+	}
+
+	c.engineCacheMu.Lock()
+	c.engineCacheTime = time.Time{}
+	c.engineCacheMu.Unlock()
+
+	return c.GetEngines()
+}
+
+// GetContainerStreams retrieves the started streams the orchestrator lists for this acexy
+// instance
+func (c *orchClient) GetContainerStreams() ([]streamState, error) {
+	if c == nil {
+		return nil, fmt.Errorf("orchestrator client not configured")
+	}
+	if c.containerID == "" {
+		return nil, fmt.Errorf("container ID not configured")
+	}
+	return c.GetEngineStreams(c.containerID)
+}
+
+// GetCancelledStreams retrieves the streams the orchestrator marked as cancelled for this
+// acexy instance
+func (c *orchClient) GetCancelledStreams() ([]streamState, error) {
+	if c == nil {
+		return nil, fmt.Errorf("orchestrator client not configured")
+	}
+	if c.containerID == "" {
+		return nil, fmt.Errorf("container ID not configured")
+	}
+	return c.getStreams(context.Background(), c.containerID, "cancelled")
+}
+
 // GetEngineStreams retrieves streams for a specific engine
 func (c *orchClient) GetEngineStreams(containerID string) ([]streamState, error) {
 	if c == nil {
 		return nil, fmt.Errorf("orchestrator client not configured")
 	}
+	return c.getStreams(context.Background(), containerID, "started")
+}
 
-	req, err := http.NewRequest(http.MethodGet, c.base+"/streams?container_id="+containerID+"&status=started", nil)
+// getStreams retrieves the streams of an engine with the given status, or those of every
+// engine when no container ID is given
+func (c *orchClient) getStreams(ctx context.Context, containerID, status string) ([]streamState, error) {
+	query := "status=" + status
+	if containerID != "" {
+		query = "container_id=" + containerID + "&" + query
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/streams?"+query, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -651,7 +1235,7 @@ func (c *orchClient) GetEngineStreams(containerID string) ([]streamState, error)
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("orchestrator returned status %d", resp.StatusCode)
+		return nil, &orchStatusError{StatusCode: resp.StatusCode, RetryAfter: retryAfterSeconds(resp.Header)}
 	}
 
 	var streams []streamState
@@ -662,6 +1246,48 @@ func (c *orchClient) GetEngineStreams(containerID string) ([]streamState, error)
 	return streams, nil
 }
 
+// engineActiveStreams returns the number of streams started on the engine, from the stream
+// count cache when it holds a fresh one
+func (c *orchClient) engineActiveStreams(ctx context.Context, containerID string) (int, error) {
+	if active, ok := c.streamCounts.Get(containerID); ok {
+		return active, nil
+	}
+
+	var streams []streamState
+	err := c.retryTransient(ctx, "engine_streams", func() (err error) {
+		streams, err = c.GetEngineStreams(containerID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	activeStreams := 0
+	for _, stream := range streams {
+		if stream.Status == "started" {
+			activeStreams++
+		}
+	}
+	c.streamCounts.Set(containerID, activeStreams)
+	return activeStreams, nil
+}
+
+// wait blocks for the given duration unless either the given context or the client context
+// is cancelled first, in which case the corresponding error is returned
+func (c *orchClient) wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 // calculateWaitTime determines how long to wait before retrying based on recovery ETA
 func calculateWaitTime(recoveryETA, attempt int) int {
 	if recoveryETA > 0 {
@@ -683,12 +1309,22 @@ func calculateWaitTime(recoveryETA, attempt int) int {
 
 // ProvisionWithRetry provisions a new acestream engine with intelligent retry logic
 func (c *orchClient) ProvisionWithRetry(maxRetries int) (*aceProvisionResponse, error) {
+	return c.ProvisionWithRetryContext(context.Background(), maxRetries)
+}
+
+// ProvisionWithRetryContext is like ProvisionWithRetry, but stops retrying as soon as the
+// given context is done
+func (c *orchClient) ProvisionWithRetryContext(ctx context.Context, maxRetries int) (*aceProvisionResponse, error) {
 	debugLog := debug.GetDebugLogger()
 	startTime := time.Now()
 
 	if c == nil {
 		return nil, fmt.Errorf("orchestrator client not configured")
 	}
+	if err := c.clusterCapacityError(); err != nil {
+		c.recordProvisionOutcome(CLUSTER_CAPACITY_CODE)
+		return nil, err
+	}
 
 	var lastErr error
 
@@ -696,14 +1332,40 @@ func (c *orchClient) ProvisionWithRetry(maxRetries int) (*aceProvisionResponse,
 		// Wait before retry if we had a structured error with recovery ETA
 		// (we extract this from the previous error, not from health check)
 		if attempt > 0 && lastErr != nil {
+			if err := ctx.Err(); err != nil {
+				return nil, fmt.Errorf("provisioning aborted: %w", err)
+			}
 			var prevErr *ProvisioningError
 			if errors.As(lastErr, &prevErr) && prevErr.Details.RecoveryETASeconds > 0 {
 				waitTime := calculateWaitTime(prevErr.Details.RecoveryETASeconds, attempt)
+				// A rate limiting orchestrator won't accept the request before its Retry-After
+				if prevErr.Details.Code == ORCH_RATE_LIMITED_CODE {
+					waitTime = max(waitTime, prevErr.Details.RecoveryETASeconds)
+				}
 				slog.Info("Waiting before retry based on previous error",
 					"attempt", attempt+1,
 					"wait_seconds", waitTime,
 					"reason", prevErr.Details.Code)
-				time.Sleep(time.Duration(waitTime) * time.Second)
+				if err := c.wait(ctx, time.Duration(waitTime)*time.Second); err != nil {
+					return nil, fmt.Errorf("provisioning aborted: %w", err)
+				}
+			}
+		}
+
+		// Fail fast once the process made too many attempts, the orchestrator is clearly failing
+		if ok, retryAfter := c.provisionBudget.Allow(); !ok {
+			c.recordProvisionOutcome(PROVISION_RATE_LIMITED_CODE)
+			slog.Warn("Provisioning attempts per minute exhausted, not provisioning",
+				"attempt", attempt+1, "retry_after", retryAfter)
+			return nil, &ProvisioningError{
+				StatusCode: http.StatusServiceUnavailable,
+				Details: &ProvisionError{
+					Code:               PROVISION_RATE_LIMITED_CODE,
+					Message:            "too many provisioning attempts",
+					RecoveryETASeconds: int(math.Ceil(retryAfter.Seconds())),
+					ShouldWait:         true,
+					CanRetry:           true,
+				},
 			}
 		}
 
@@ -712,7 +1374,17 @@ func (c *orchClient) ProvisionWithRetry(maxRetries int) (*aceProvisionResponse,
 		resp, err := c.ProvisionAcestream()
 		attemptDuration := time.Since(attemptStart)
 
+		if err == nil && resp.pending {
+			// Polling is not retried, the accepted engine may still come up
+			if err := c.waitProvisionReady(ctx, resp); err != nil {
+				c.recordProvisionOutcome("pending_timeout")
+				debugLog.LogProvisioning("provision_failed_pending", time.Since(startTime), false, err.Error(), attempt+1)
+				return nil, err
+			}
+		}
 		if err == nil {
+			c.recordProvisionOutcome("success")
+			c.probation.Provisioned(resp.ContainerID)
 			totalDuration := time.Since(startTime)
 			debugLog.LogProvisioning("provision_success", totalDuration, true, "", attempt)
 			return resp, nil
@@ -729,11 +1401,13 @@ func (c *orchClient) ProvisionWithRetry(maxRetries int) (*aceProvisionResponse,
 			// Structured error
 			if !provErr.Details.ShouldWait {
 				// Don't retry permanent errors
+				c.recordProvisionOutcome("permanent")
 				totalDuration := time.Since(startTime)
 				debugLog.LogProvisioning("provision_failed_permanent", totalDuration, false, err.Error(), attempt+1)
 				return nil, err
 			}
 
+			c.recordProvisionOutcome(provErr.Details.Code)
 			slog.Warn("Provisioning failed, will retry",
 				"attempt", attempt+1,
 				"code", provErr.Details.Code,
@@ -753,6 +1427,7 @@ func (c *orchClient) ProvisionWithRetry(maxRetries int) (*aceProvisionResponse,
 			}
 		} else {
 			// Legacy error handling - retry on temporary errors
+			c.recordProvisionOutcome("error")
 			slog.Warn("Provision attempt failed", "attempt", attempt+1, "error", err)
 		}
 	}
@@ -762,6 +1437,156 @@ func (c *orchClient) ProvisionWithRetry(maxRetries int) (*aceProvisionResponse,
 	return nil, fmt.Errorf("provisioning failed after %d attempts: %w", maxRetries, lastErr)
 }
 
+// recordProvisionOutcome counts a provisioning attempt under the given outcome code.
+// Structured errors are counted by their orchestrator code, "permanent" is used for errors
+// that are not retried and "error" for unstructured failures.
+func (c *orchClient) recordProvisionOutcome(code string) {
+	if code == "" {
+		code = "unknown"
+	}
+
+	c.provisionTotalsMu.Lock()
+	defer c.provisionTotalsMu.Unlock()
+
+	if c.provisionTotals == nil {
+		c.provisionTotals = make(map[string]uint64)
+	}
+	c.provisionTotals[code]++
+}
+
+// ProvisionStats returns a copy of the provisioning attempts counted by outcome code
+func (c *orchClient) ProvisionStats() map[string]uint64 {
+	if c == nil {
+		return nil
+	}
+
+	c.provisionTotalsMu.Lock()
+	defer c.provisionTotalsMu.Unlock()
+
+	stats := make(map[string]uint64, len(c.provisionTotals))
+	for code, total := range c.provisionTotals {
+		stats[code] = total
+	}
+	return stats
+}
+
+// MarkEngineFailing makes the selection treat the engine as unhealthy for
+// ENGINE_FAILING_TTL, regardless of the health reported by the orchestrator
+func (c *orchClient) MarkEngineFailing(containerID string) {
+	if c == nil || containerID == "" {
+		return
+	}
+
+	c.failingEnginesMu.Lock()
+	defer c.failingEnginesMu.Unlock()
+
+	if c.failingEngines == nil {
+		c.failingEngines = make(map[string]time.Time)
+	}
+	c.failingEngines[containerID] = time.Now().Add(ENGINE_FAILING_TTL)
+	c.reliability.Record(containerID, true)
+	c.quarantineIfNew(containerID)
+}
+
+// engineFailing reports whether the engine was recently marked as failing
+func (c *orchClient) engineFailing(containerID string) bool {
+	c.failingEnginesMu.Lock()
+	defer c.failingEnginesMu.Unlock()
+
+	until, ok := c.failingEngines[containerID]
+	if ok && time.Now().After(until) {
+		delete(c.failingEngines, containerID)
+		return false
+	}
+	return ok
+}
+
+// engineRecoveryETA estimates how long the recovering engine takes to be usable again:
+// until acexy stops treating it as failing and, when the orchestrator reports it unhealthy,
+// at least ENGINE_RECOVERY_ETA
+func (c *orchClient) engineRecoveryETA(engine engineState) time.Duration {
+	var eta time.Duration
+	c.failingEnginesMu.Lock()
+	if until, ok := c.failingEngines[engine.ContainerID]; ok {
+		eta = time.Until(until)
+	}
+	c.failingEnginesMu.Unlock()
+
+	if engine.HealthStatus != "healthy" {
+		eta = max(eta, ENGINE_RECOVERY_ETA)
+	}
+	return eta
+}
+
+// waitProvisionReady polls the engine list until the engine of an accepted provision shows
+// up, filling its port when the orchestrator did not report it yet. Fails after
+// PROVISION_READY_TIMEOUT or once the context is done.
+func (c *orchClient) waitProvisionReady(ctx context.Context, provResp *aceProvisionResponse) error {
+	slog.Info("Provision accepted, waiting for the engine to be ready", "container_id", provResp.ContainerID)
+	deadline := time.Now().Add(PROVISION_READY_TIMEOUT)
+	for {
+		if err := c.wait(ctx, PROVISION_READY_POLL_INTERVAL); err != nil {
+			return fmt.Errorf("waiting for accepted provision aborted: %w", err)
+		}
+
+		engines, err := c.GetEngines()
+		if err != nil {
+			slog.Debug("Failed to poll the accepted provision", "container_id", provResp.ContainerID, "error", err)
+		}
+		for _, engine := range engines {
+			if engine.ContainerID != provResp.ContainerID {
+				continue
+			}
+			if provResp.HostHTTPPort == 0 {
+				provResp.HostHTTPPort = engine.Port
+			}
+			if provResp.ContainerName == "" {
+				provResp.ContainerName = engine.ContainerName
+			}
+			provResp.pending = false
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("accepted provision %s not ready after %v", provResp.ContainerID, PROVISION_READY_TIMEOUT)
+		}
+	}
+}
+
+// acquireProvisionSlot waits for a free provision slot when the provisions in flight are
+// capped, failing once PROVISION_SLOT_WAIT elapses or the context is done. The returned
+// function frees the slot.
+func (c *orchClient) acquireProvisionSlot(ctx context.Context) (func(), error) {
+	if c.provisions == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case c.provisions <- struct{}{}:
+	default:
+		slog.Debug("All provision slots busy, waiting for one", "max_provisions", cap(c.provisions))
+		timer := time.NewTimer(PROVISION_SLOT_WAIT)
+		defer timer.Stop()
+		select {
+		case c.provisions <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for a provision slot: %w", ctx.Err())
+		case <-timer.C:
+			return nil, &ProvisioningError{
+				StatusCode: http.StatusServiceUnavailable,
+				Details: &ProvisionError{
+					Code:               PROVISION_LIMIT_CODE,
+					Message:            fmt.Sprintf("%d provisions already in progress", cap(c.provisions)),
+					RecoveryETASeconds: PROVISION_LIMIT_RETRY_AFTER,
+					ShouldWait:         true,
+					CanRetry:           true,
+				},
+			}
+		}
+	}
+	return func() { <-c.provisions }, nil
+}
+
 // ProvisionAcestream provisions a new acestream engine
 func (c *orchClient) ProvisionAcestream() (*aceProvisionResponse, error) {
 	if c == nil {
@@ -772,6 +1597,9 @@ func (c *orchClient) ProvisionAcestream() (*aceProvisionResponse, error) {
 		Labels: map[string]string{},
 		Env:    map[string]string{},
 	}
+	for key, val := range c.labelSelector {
+		reqData.Labels[key] = val
+	}
 
 	body, err := json.Marshal(reqData)
 	if err != nil {
@@ -794,12 +1622,22 @@ func (c *orchClient) ProvisionAcestream() (*aceProvisionResponse, error) {
 	}
 	defer resp.Body.Close()
 
-	// Success
-	if resp.StatusCode == http.StatusOK {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, orchRateLimitedError(retryAfterSeconds(resp.Header))
+	}
+
+	// Success, or accepted with the engine still starting
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted {
 		var provResp aceProvisionResponse
 		if err := json.NewDecoder(resp.Body).Decode(&provResp); err != nil {
 			return nil, fmt.Errorf("failed to decode provision response: %w", err)
 		}
+		if resp.StatusCode == http.StatusAccepted {
+			if provResp.ContainerID == "" {
+				return nil, fmt.Errorf("provision accepted without a container ID")
+			}
+			provResp.pending = true
+		}
 		return &provResp, nil
 	}
 
@@ -817,24 +1655,79 @@ func (c *orchClient) ProvisionAcestream() (*aceProvisionResponse, error) {
 	}
 }
 
+// The engine label used by the orchestrator to override the scheme acexy uses to reach it
+const engineSchemeLabel = "acexy.scheme"
+
+// selectedEngine describes the engine chosen to serve a stream
+type selectedEngine struct {
+	Host        string
+	Port        int
+	ContainerID string
+	Scheme      string // Scheme requested by the engine labels, empty to use the configured one
+	Forwarded   bool   // Whether the P2P port of the engine is forwarded through the VPN
+}
+
+// target returns the address the stream is fetched from, using the given scheme unless the
+// engine requests its own
+func (e selectedEngine) target(scheme string) acexy.Engine {
+	if e.Scheme != "" {
+		scheme = e.Scheme
+	}
+	return acexy.Engine{Scheme: scheme, Host: e.Host, Port: e.Port}
+}
+
+// engineScheme returns the scheme the engine asks to be reached with through its labels,
+// or an empty string when it is absent or not supported
+func engineScheme(engine engineState) string {
+	scheme, ok := engine.Labels[engineSchemeLabel]
+	if !ok {
+		return ""
+	}
+	switch scheme = strings.ToLower(scheme); scheme {
+	case "http", "https":
+		return scheme
+	default:
+		slog.Warn("Ignoring unsupported engine scheme label", "container_id", engine.ContainerID, "scheme", scheme)
+		return ""
+	}
+}
+
 // SelectBestEngine selects the best available engine based on load balancing rules
-// Returns host, port, containerID, and error. Prioritizes healthy engines first, then forwarded engines (faster),
+// Returns the selected engine and error. Prioritizes healthy engines first, then forwarded engines (faster),
 // then among engines with the same health status, forwarded status, and stream count, chooses the one with the
 // oldest last_stream_usage timestamp.
-func (c *orchClient) SelectBestEngine() (string, int, string, error) {
+func (c *orchClient) SelectBestEngine() (selectedEngine, error) {
+	return c.SelectBestEngineContext(context.Background())
+}
+
+// SelectBestEngineContext is like SelectBestEngine, but the waits for a freshly provisioned
+// engine are aborted as soon as the given context (usually the client request) is done
+func (c *orchClient) SelectBestEngineContext(ctx context.Context) (selectedEngine, error) {
 	debugLog := debug.GetDebugLogger()
 	startTime := time.Now()
 
 	if c == nil {
-		return "", 0, "", fmt.Errorf("orchestrator client not configured")
+		return selectedEngine{}, fmt.Errorf("orchestrator client not configured")
+	}
+	if err := c.clusterCapacityError(); err != nil {
+		return selectedEngine{}, err
 	}
 
 	// Get all available engines
-	engines, err := c.GetEngines()
+	var engines []engineState
+	err := c.retryTransient(ctx, "engines", func() (err error) {
+		engines, err = c.GetEngines()
+		return err
+	})
 	if err != nil {
 		duration := time.Since(startTime)
 		debugLog.LogEngineSelection("select_best_engine", "", 0, "", duration, err.Error())
-		return "", 0, "", fmt.Errorf("failed to get engines: %w", err)
+		// Rate limited, the client is told to come back once the orchestrator accepts requests
+		var statusErr *orchStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests {
+			return selectedEngine{}, fmt.Errorf("failed to get engines: %w", orchRateLimitedError(statusErr.RetryAfter))
+		}
+		return selectedEngine{}, fmt.Errorf("failed to get engines: %w", err)
 	}
 
 	slog.Debug("Found engines from orchestrator", "count", len(engines), "max_streams_per_engine", c.maxStreamsPerEngine)
@@ -848,18 +1741,25 @@ func (c *orchClient) SelectBestEngine() (string, int, string, error) {
 	var availableEngines []engineWithLoad
 
 	// Check stream count for each engine
+	excluded := excludedEngine(ctx)
 	for _, engine := range engines {
-		streams, err := c.GetEngineStreams(engine.ContainerID)
-		if err != nil {
-			slog.Warn("Failed to get streams for engine", "container_id", engine.ContainerID, "error", err)
+		if excluded != "" && engine.ContainerID == excluded {
+			slog.Debug("Skipping engine excluded from the selection", "container_id", engine.ContainerID)
+			continue
+		}
+		if c.probation.Quarantined(engine.ContainerID) {
+			slog.Debug("Skipping quarantined engine", "container_id", engine.ContainerID)
+			continue
+		}
+		if !c.labelSelector.Matches(engine.Labels) {
+			slog.Debug("Skipping engine not matching the label selector", "container_id", engine.ContainerID, "labels", engine.Labels)
 			continue
 		}
 
-		activeStreams := 0
-		for _, stream := range streams {
-			if stream.Status == "started" {
-				activeStreams++
-			}
+		activeStreams, err := c.engineActiveStreams(ctx, engine.ContainerID)
+		if err != nil {
+			slog.Warn("Failed to get streams for engine", "container_id", engine.ContainerID, "error", err)
+			continue
 		}
 
 		slog.Debug("Engine stream count", "container_id", engine.ContainerID, "active_streams", activeStreams, "host", engine.Host, "port", engine.Port, "forwarded", engine.Forwarded, "max_allowed", c.maxStreamsPerEngine, "health_status", engine.HealthStatus, "last_health_check", engine.LastHealthCheck.Format(time.RFC3339), "last_stream_usage", engine.LastStreamUsage.Format(time.RFC3339))
@@ -873,6 +1773,16 @@ func (c *orchClient) SelectBestEngine() (string, int, string, error) {
 		}
 	}
 
+	// During a scale-up the orchestrator may prefer new engines over the ones with capacity
+	recommended, preferNew := c.selectionHints()
+	if preferNew && len(availableEngines) > 0 {
+		if canProvision, _ := c.CanProvision(); canProvision {
+			slog.Info("Orchestrator prefers new engines, provisioning one despite the available ones",
+				"available_engines", len(availableEngines))
+			availableEngines = nil
+		}
+	}
+
 	// If no engines have capacity, provision a new one
 	if len(availableEngines) == 0 {
 		// Check if we can provision before attempting
@@ -881,7 +1791,7 @@ func (c *orchClient) SelectBestEngine() (string, int, string, error) {
 		if !canProvision {
 			if shouldWait {
 				// Return structured error with recovery information
-				return "", 0, "", &ProvisioningError{
+				return selectedEngine{}, &ProvisioningError{
 					StatusCode: http.StatusServiceUnavailable,
 					Details: &ProvisionError{
 						Code:               c.health.blockedReasonCode,
@@ -892,19 +1802,35 @@ func (c *orchClient) SelectBestEngine() (string, int, string, error) {
 					},
 				}
 			}
-			return "", 0, "", fmt.Errorf("cannot provision: %s", c.health.blockedReason)
+			if c.HealthStale() {
+				return selectedEngine{}, blockedProvisioningError(HEALTH_STALE_CODE, HEALTH_STALE_REASON)
+			}
+			return selectedEngine{}, blockedProvisioningError(c.health.blockedReasonCode, c.health.blockedReason)
 		}
 
 		slog.Info("No available engines found (all at capacity), provisioning new acestream engine")
 
+		release, err := c.acquireProvisionSlot(ctx)
+		if err != nil {
+			return selectedEngine{}, err
+		}
 		// Use retry logic for provisioning
-		provResp, err := c.ProvisionWithRetry(3)
+		provResp, err := c.ProvisionWithRetryContext(ctx, 3)
+		release()
 		if err != nil {
-			return "", 0, "", err
+			return selectedEngine{}, err
+		}
+		// The provision may complete after the client is gone, don't leave the engine orphaned
+		if err := ctx.Err(); err != nil {
+			c.abandonProvision(provResp, err)
+			return selectedEngine{}, fmt.Errorf("provisioning aborted: %w", err)
 		}
 
 		// Shorter wait since orchestrator now syncs state immediately
-		time.Sleep(5 * time.Second)
+		if err := c.wait(ctx, 5*time.Second); err != nil {
+			c.abandonProvision(provResp, err)
+			return selectedEngine{}, fmt.Errorf("waiting for provisioned engine aborted: %w", err)
+		}
 
 		// Verify engine appears in list
 		engines, err := c.GetEngines()
@@ -914,49 +1840,88 @@ func (c *orchClient) SelectBestEngine() (string, int, string, error) {
 					slog.Info("Provisioned engine found in orchestrator",
 						"container_id", provResp.ContainerID,
 						"container_name", provResp.ContainerName)
-					return "localhost", provResp.HostHTTPPort, provResp.ContainerID, nil
+					return selectedEngine{Host: "localhost", Port: provResp.HostHTTPPort, ContainerID: provResp.ContainerID}, nil
 				}
 			}
 		}
 
 		// Still not found, wait a bit more and return anyway
 		slog.Warn("Engine not immediately available, continuing anyway")
-		time.Sleep(5 * time.Second)
+		if err := c.wait(ctx, 5*time.Second); err != nil {
+			c.abandonProvision(provResp, err)
+			return selectedEngine{}, fmt.Errorf("waiting for provisioned engine aborted: %w", err)
+		}
 
 		slog.Info("Provisioned new engine", "container_id", provResp.ContainerID, "container_name", provResp.ContainerName, "host_port", provResp.HostHTTPPort, "container_port", provResp.ContainerHTTPPort)
 
 		// Use orchestrator-provided host port mapping directly
-		return "localhost", provResp.HostHTTPPort, provResp.ContainerID, nil
+		return selectedEngine{Host: "localhost", Port: provResp.HostHTTPPort, ContainerID: provResp.ContainerID}, nil
 	}
 
 	// Sort engines by health status first (healthy engines prioritized),
+	// then by region (engines in the region preferred by the client prioritized),
+	// then by warm cache (the engine that last served the requested content prioritized),
+	// then by cost when enabled (cheaper engines prioritized until they are full),
 	// then by stream count (empty engines prioritized - addressing issue where all streams go to forwarded engines),
+	// then by success ratio of their latest streams (reliable engines prioritized),
 	// then by forwarded status (forwarded engines prioritized as they are faster),
 	// then by last_stream_usage (ascending - oldest first)
+	region := preferredRegion(ctx)
+	warmEngine := c.warm.Engine(requestedContent(ctx))
 	for i := 0; i < len(availableEngines); i++ {
 		for j := i + 1; j < len(availableEngines); j++ {
 			iEngine := availableEngines[i]
 			jEngine := availableEngines[j]
 
 			// Primary sort: by health status (healthy engines first)
-			iHealthy := iEngine.engine.HealthStatus == "healthy"
-			jHealthy := jEngine.engine.HealthStatus == "healthy"
+			iHealthy := iEngine.engine.HealthStatus == "healthy" && !c.engineFailing(iEngine.engine.ContainerID)
+			jHealthy := jEngine.engine.HealthStatus == "healthy" && !c.engineFailing(jEngine.engine.ContainerID)
+			iInRegion := inRegion(iEngine.engine, region)
+			jInRegion := inRegion(jEngine.engine, region)
+			iWarm := warmEngine != "" && iEngine.engine.ContainerID == warmEngine
+			jWarm := warmEngine != "" && jEngine.engine.ContainerID == warmEngine
+			var iCost, jCost float64
+			if c.costAware {
+				iCost, jCost = engineCost(iEngine.engine), engineCost(jEngine.engine)
+			}
 
 			if iHealthy != jHealthy {
 				// If one is healthy and other is not, prioritize healthy
 				if jHealthy && !iHealthy {
 					availableEngines[i], availableEngines[j] = availableEngines[j], availableEngines[i]
 				}
+			} else if iInRegion != jInRegion {
+				// Same health status, prioritize the engine in the preferred region
+				if jInRegion {
+					availableEngines[i], availableEngines[j] = availableEngines[j], availableEngines[i]
+				}
+			} else if iWarm != jWarm {
+				// Same health and region, prioritize the engine likely holding the content
+				if jWarm {
+					availableEngines[i], availableEngines[j] = availableEngines[j], availableEngines[i]
+				}
+			} else if iCost != jCost {
+				// Same health, region and cache, prioritize the cheaper engine
+				if jCost < iCost {
+					availableEngines[i], availableEngines[j] = availableEngines[j], availableEngines[i]
+				}
 			} else {
 				// Both have same health status, sort by active stream count (empty engines prioritized)
 				if iEngine.activeStreams > jEngine.activeStreams {
 					availableEngines[i], availableEngines[j] = availableEngines[j], availableEngines[i]
 				} else if iEngine.activeStreams == jEngine.activeStreams {
-					// Same health and stream count, sort by forwarded status (forwarded engines prioritized)
+					// Same health and stream count, sort by success ratio (reliable engines prioritized),
+					// then by forwarded status (forwarded engines prioritized)
+					iRatio := c.reliability.Health(iEngine.engine.ContainerID).SuccessRatio
+					jRatio := c.reliability.Health(jEngine.engine.ContainerID).SuccessRatio
 					iForwarded := iEngine.engine.Forwarded
 					jForwarded := jEngine.engine.Forwarded
 
-					if iForwarded != jForwarded {
+					if iRatio != jRatio {
+						if jRatio > iRatio {
+							availableEngines[i], availableEngines[j] = availableEngines[j], availableEngines[i]
+						}
+					} else if iForwarded != jForwarded {
 						// If one is forwarded and other is not, prioritize forwarded
 						if jForwarded && !iForwarded {
 							availableEngines[i], availableEngines[j] = availableEngines[j], availableEngines[i]
@@ -973,17 +1938,93 @@ func (c *orchClient) SelectBestEngine() (string, int, string, error) {
 		}
 	}
 
+	// The engine the orchestrator lists as already streaming the content goes first, so the
+	// cluster runs a single P2P session of it
+	joined := ""
+	if key := requestedStreamKey(ctx); c.clusterDedup && key != "" {
+		candidates := make([]string, 0, len(availableEngines))
+		for _, candidate := range availableEngines {
+			if candidate.engine.HealthStatus == "healthy" && !c.engineFailing(candidate.engine.ContainerID) {
+				candidates = append(candidates, candidate.engine.ContainerID)
+			}
+		}
+		if serving := c.servingEngine(ctx, key, candidates); serving != "" {
+			for i, candidate := range availableEngines {
+				if candidate.engine.ContainerID == serving {
+					slog.Debug("Joining the engine already streaming the content", "container_id", serving, "key", key)
+					availableEngines[0], availableEngines[i] = availableEngines[i], availableEngines[0]
+					joined = serving
+					break
+				}
+			}
+		}
+	}
+
+	// The engine the orchestrator recommends goes first, as long as it is healthy. It wins
+	// over the one joined by the cluster deduplication: the orchestrator may be moving load
+	// off the engine serving the content.
+	if recommended != "" {
+		for i, candidate := range availableEngines {
+			if candidate.engine.ContainerID == recommended && candidate.engine.HealthStatus == "healthy" && !c.engineFailing(recommended) {
+				if joined != "" && joined != recommended {
+					slog.Debug("Orchestrator recommendation overrides the engine already streaming the content",
+						"container_id", recommended, "serving_container_id", joined)
+				}
+				slog.Debug("Using the engine recommended by the orchestrator", "container_id", recommended)
+				availableEngines[0], availableEngines[i] = availableEngines[i], availableEngines[0]
+				break
+			}
+		}
+	}
+
 	// Select the engine with the least active streams (empty engines are prioritized)
 	bestEngine := availableEngines[0]
+
+	// Every engine with capacity is recovering. Those only deprioritized by acexy after a
+	// failed stream are still tried. When the orchestrator reports them all unhealthy, unless
+	// a new one can be provisioned, the client is told when the soonest should be usable again.
+	if bestEngine.engine.HealthStatus != "healthy" || c.engineFailing(bestEngine.engine.ContainerID) {
+		reportedHealthy := slices.IndexFunc(availableEngines, func(candidate engineWithLoad) bool {
+			return candidate.engine.HealthStatus == "healthy"
+		})
+		if reportedHealthy >= 0 {
+			bestEngine = availableEngines[reportedHealthy]
+		} else if canProvision, _ := c.CanProvision(); !canProvision {
+			eta := c.engineRecoveryETA(bestEngine.engine)
+			for _, candidate := range availableEngines[1:] {
+				eta = min(eta, c.engineRecoveryETA(candidate.engine))
+			}
+			slog.Warn("All engines are recovering and provisioning is blocked",
+				"engines", len(availableEngines), "recovery_eta", eta)
+			return selectedEngine{}, &ProvisioningError{
+				StatusCode: http.StatusServiceUnavailable,
+				Details: &ProvisionError{
+					Code:               ALL_RECOVERING_CODE,
+					Message:            "all engines are recovering",
+					RecoveryETASeconds: int(math.Ceil(eta.Seconds())),
+					ShouldWait:         true,
+					CanRetry:           true,
+				},
+			}
+		}
+	}
 	host := bestEngine.engine.Host
 	port := bestEngine.engine.Port
 	containerID := bestEngine.engine.ContainerID
+	scheme := engineScheme(bestEngine.engine)
+	version, _ := c.versions.Get(ctx, bestEngine.engine)
 
 	slog.Info("Selected best available engine",
 		"container_id", containerID,
 		"container_name", bestEngine.engine.ContainerName,
 		"host", host,
 		"port", port,
+		"scheme", scheme,
+		"version", version.Version,
+		"region", bestEngine.engine.Labels[ENGINE_REGION_LABEL],
+		"preferred_region", region,
+		"warm_cache", warmEngine != "" && containerID == warmEngine,
+		"cost", engineCost(bestEngine.engine),
 		"forwarded", bestEngine.engine.Forwarded,
 		"active_streams", bestEngine.activeStreams,
 		"max_streams", c.maxStreamsPerEngine,
@@ -1008,5 +2049,5 @@ func (c *orchClient) SelectBestEngine() (string, int, string, error) {
 		)
 	}
 
-	return host, port, containerID, nil
+	return selectedEngine{Host: host, Port: port, ContainerID: containerID, Scheme: scheme, Forwarded: bestEngine.engine.Forwarded}, nil
 }
@@ -3,9 +3,11 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -225,7 +227,7 @@ func TestSelectBestEngineProvisioningBlocked(t *testing.T) {
 	client.health.blockedReason = "VPN disconnected"
 
 	// Should fail with provisioning blocked error
-	_, _, _, err := client.SelectBestEngine()
+	_, err := client.SelectBestEngine()
 	if err == nil {
 		t.Error("Expected error when provisioning is blocked")
 	}
@@ -236,3 +238,147 @@ func TestSelectBestEngineProvisioningBlocked(t *testing.T) {
 		t.Errorf("Expected 'VPN disconnected' in error, got: %v", err)
 	}
 }
+
+// TestSelectBestEngineProvisionWaitCancelled verifies the wait for a freshly provisioned
+// engine is aborted as soon as the request context is cancelled
+func TestSelectBestEngineProvisionWaitCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode([]engineState{})
+		case "/provision/acestream":
+			json.NewEncoder(w).Encode(aceProvisionResponse{
+				ContainerID:  "new-container",
+				HostHTTPPort: 19000,
+			})
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	clientCtx, clientCancel := context.WithCancel(context.Background())
+	defer clientCancel()
+
+	client := &orchClient{
+		base:                server.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 clientCtx,
+		cancel:              clientCancel,
+	}
+	client.health.canProvision = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.SelectBestEngineContext(ctx)
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("Expected an error when the context is cancelled during the wait")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context deadline error, got: %v", err)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("Expected the wait to be aborted early, took %v", elapsed)
+	}
+}
+
+// TestHealthStaleAfterRepeatedFailures verifies failed health checks are retried, and that
+// once they keep failing the health turns stale, blocking provisioning
+func TestHealthStaleAfterRepeatedFailures(t *testing.T) {
+	var mu sync.Mutex
+	failing := false
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/engines" {
+			json.NewEncoder(w).Encode([]engineState{})
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts++
+		if failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		status := orchestratorStatus{Status: "healthy"}
+		status.Provisioning.CanProvision = true
+		json.NewEncoder(w).Encode(status)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:               server.URL,
+		hc:                 &http.Client{Timeout: 3 * time.Second},
+		ctx:                ctx,
+		cancel:             cancel,
+		healthMaxStaleness: 100 * time.Millisecond,
+	}
+
+	if err := client.updateHealth(); err != nil {
+		t.Fatalf("Unexpected health check error: %v", err)
+	}
+	if snapshot := client.HealthSnapshot(); !snapshot.Healthy || snapshot.Stale {
+		t.Errorf("Expected a healthy, fresh snapshot, got %+v", snapshot)
+	}
+	if canProvision, _ := client.CanProvision(); !canProvision {
+		t.Error("Expected provisioning to be allowed")
+	}
+
+	mu.Lock()
+	failing = true
+	attempts = 0
+	mu.Unlock()
+	time.Sleep(150 * time.Millisecond)
+
+	if err := client.updateHealth(); err == nil {
+		t.Fatal("Expected the health check to fail")
+	}
+	mu.Lock()
+	if attempts != HEALTH_CHECK_RETRIES+1 {
+		t.Errorf("Expected %d attempts, got %d", HEALTH_CHECK_RETRIES+1, attempts)
+	}
+	mu.Unlock()
+
+	if snapshot := client.HealthSnapshot(); snapshot.Healthy || !snapshot.Stale {
+		t.Errorf("Expected an unhealthy, stale snapshot, got %+v", snapshot)
+	}
+	if canProvision, reason := client.CanProvision(); canProvision || reason != HEALTH_STALE_REASON {
+		t.Errorf("Expected provisioning to be blocked by the stale health, got %v (%s)", canProvision, reason)
+	}
+	if _, err := client.SelectBestEngine(); !errors.Is(err, ErrProvisioningBlocked) {
+		t.Errorf("Expected the selection to be blocked, got %v", err)
+	}
+}
+
+// TestMonitorJitter verifies the startup offset of the monitors stays below the jitter
+// bound and actually varies between replicas
+func TestMonitorJitter(t *testing.T) {
+	for _, interval := range []time.Duration{HEALTH_CHECK_INTERVAL, CLEANUP_INTERVAL} {
+		bound := time.Duration(float64(interval) * MONITOR_JITTER)
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 100; i++ {
+			delay := monitorJitter(interval)
+			if delay < 0 || delay >= bound {
+				t.Fatalf("Delay %v outside [0, %v) for interval %v", delay, bound, interval)
+			}
+			seen[delay] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("Expected varying delays for interval %v", interval)
+		}
+	}
+
+	if delay := monitorJitter(0); delay != 0 {
+		t.Errorf("Expected no delay without an interval, got %v", delay)
+	}
+}
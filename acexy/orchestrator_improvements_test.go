@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
 	"net/http"
 	"net/http/httptest"
 	"sync"
@@ -207,8 +208,11 @@ func TestEventOrdering(t *testing.T) {
 	streamID := "test-stream-123"
 
 	// Emit started (synchronous)
-	client.EmitStarted("localhost", 19000, "infohash", "testkey", "playback123",
-		"http://stat", "http://cmd", streamID, "engine-1")
+	client.EmitStarted(startedStream{
+		Host: "localhost", Port: 19000, KeyType: "infohash", Key: "testkey", PlaybackID: "playback123",
+		Stream:   &acexy.AceStream{StatURL: "http://stat", CommandURL: "http://cmd", IsLive: true},
+		StreamID: streamID, EngineContainerID: "engine-1",
+	})
 
 	// Emit ended immediately after (async)
 	client.EmitEnded(streamID, "test")
@@ -235,40 +239,6 @@ func TestEventOrdering(t *testing.T) {
 	t.Logf("Event ordering correct: %v", events)
 }
 
-// TestCleanupMonitor verifies that the cleanup monitor properly manages tracking maps
-func TestCleanupMonitor(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	client := &orchClient{
-		base:                "http://test",
-		hc:                  &http.Client{Timeout: 3 * time.Second},
-		ctx:                 ctx,
-		cancel:              cancel,
-		endedStreams:        make(map[string]bool),
-		engineCacheDuration: 2 * time.Second,
-	}
-
-	// Add many ended streams to trigger cleanup
-	for i := 0; i < 1500; i++ {
-		streamID := "stream-" + string(rune(i))
-		client.endedStreams[streamID] = true
-	}
-
-	initialSize := len(client.endedStreams)
-	t.Logf("Initial ended streams map size: %d", initialSize)
-
-	// Run cleanup
-	client.cleanupStaleData()
-
-	finalSize := len(client.endedStreams)
-	t.Logf("Final ended streams map size: %d", finalSize)
-
-	if finalSize >= initialSize {
-		t.Errorf("Cleanup should have reduced map size from %d, but got %d", initialSize, finalSize)
-	}
-}
-
 // TestEmitEndedWithEmptyStreamID verifies that EmitEnded handles empty streamID gracefully
 func TestEmitEndedWithEmptyStreamID(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
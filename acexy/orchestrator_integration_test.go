@@ -392,7 +392,7 @@ func TestSelectBestEngine_StructuredError(t *testing.T) {
 	client.updateHealth()
 
 	// Try to select engine
-	_, _, _, err := client.SelectBestEngine()
+	_, err := client.SelectBestEngine()
 	if err == nil {
 		t.Fatal("Expected error, got nil")
 	}
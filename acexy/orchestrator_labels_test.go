@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSelectBestEngineLabelSelector verifies engines not matching every selector label are
+// never selected, and that provisioned engines are requested with those labels
+func TestSelectBestEngineLabelSelector(t *testing.T) {
+	var mu sync.Mutex
+	mineBusy := false
+	var provisionLabels map[string]string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{
+					// Idle and forwarded, but belongs to another tenant
+					ContainerID:  "engine-other",
+					Host:         "host-other",
+					Port:         8001,
+					HealthStatus: "healthy",
+					Forwarded:    true,
+					Labels:       map[string]string{"team": "sports", "env": "prod"},
+				},
+				{
+					// Only partially matching
+					ContainerID:  "engine-staging",
+					Host:         "host-staging",
+					Port:         8002,
+					HealthStatus: "healthy",
+					Labels:       map[string]string{"team": "media", "env": "staging"},
+				},
+				{
+					ContainerID:  "engine-mine",
+					Host:         "host-mine",
+					Port:         8003,
+					HealthStatus: "healthy",
+					Labels:       map[string]string{"team": "media", "env": "prod", "zone": "a"},
+				},
+			})
+		case "/streams":
+			streams := []streamState{}
+			if mineBusy && r.URL.Query().Get("container_id") == "engine-mine" {
+				streams = append(streams, streamState{ID: "s1", Status: "started"})
+			}
+			json.NewEncoder(w).Encode(streams)
+		case "/provision/acestream":
+			var req aceProvisionRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			provisionLabels = req.Labels
+			json.NewEncoder(w).Encode(aceProvisionResponse{ContainerID: "engine-new", HostHTTPPort: 19000})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var selector LabelSelector
+	if err := selector.Set("team=media, env=prod"); err != nil {
+		t.Fatalf("Failed to parse selector: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &orchClient{
+		base:                server.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		labelSelector:       selector,
+	}
+	client.health.canProvision = true
+
+	engine, err := client.SelectBestEngine()
+	if err != nil {
+		t.Fatalf("Unexpected selection error: %v", err)
+	}
+	if engine.ContainerID != "engine-mine" {
+		t.Errorf("Expected engine-mine to be selected, got %s", engine.ContainerID)
+	}
+
+	// With the only matching engine busy, a new one is provisioned instead of using others
+	mu.Lock()
+	mineBusy = true
+	mu.Unlock()
+	client.engineCacheTime = time.Time{}
+
+	selectCtx, selectCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer selectCancel()
+	client.SelectBestEngineContext(selectCtx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if provisionLabels["team"] != "media" || provisionLabels["env"] != "prod" {
+		t.Errorf("Expected provisioning with the selector labels, got %v", provisionLabels)
+	}
+}
+
+// TestLabelSelectorParse verifies selectors are parsed and invalid pairs rejected
+func TestLabelSelectorParse(t *testing.T) {
+	var selector LabelSelector
+	if err := selector.Set("env=prod,team=media"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := selector.String(); got != "env=prod,team=media" {
+		t.Errorf("Unexpected selector %q", got)
+	}
+	if !selector.Matches(map[string]string{"env": "prod", "team": "media", "extra": "x"}) {
+		t.Error("Expected labels with every selector pair to match")
+	}
+	if selector.Matches(map[string]string{"env": "prod"}) {
+		t.Error("Expected labels missing a selector pair not to match")
+	}
+	if !LabelSelector(nil).Matches(nil) {
+		t.Error("Expected an empty selector to match everything")
+	}
+
+	for _, invalid := range []string{"team", "=media"} {
+		if err := selector.Set(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder collects the orchestrator events and engine stop commands seen by the mocks
+type eventRecorder struct {
+	mu          sync.Mutex
+	started     int
+	ended       int
+	stopped     int
+	lastStarted startedEvent
+
+	startedEvents chan startedEvent // Receives the started events, dropped once full
+}
+
+func (e *eventRecorder) counts() (started, ended, stopped int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.started, e.ended, e.stopped
+}
+
+// newEventTestProxy creates a proxy backed by a mock engine serving a short stream and a
+// mock orchestrator, both reporting to the returned recorder. The extra fields are added
+// to the middleware response.
+func newEventTestProxy(t *testing.T, minClients int, extra map[string]any) (*Proxy, *eventRecorder) {
+	events := &eventRecorder{startedEvents: make(chan startedEvent, 16)}
+
+	var engine *httptest.Server
+	engine = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			response := map[string]any{
+				"playback_url": engine.URL + "/stream",
+				"stat_url":     engine.URL + "/ace/stat/test/playback123",
+				"command_url":  engine.URL + "/ace/cmd/test/playback123",
+			}
+			for k, v := range extra {
+				response[k] = v
+			}
+			json.NewEncoder(w).Encode(map[string]any{"response": response})
+		case "/stream":
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.Write([]byte("test stream data"))
+		case "/ace/cmd/test/playback123":
+			events.mu.Lock()
+			events.stopped++
+			events.mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]any{"response": "ok"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(engine.Close)
+
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events.mu.Lock()
+		defer events.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/events/stream_started":
+			events.started++
+			json.NewDecoder(r.Body).Decode(&events.lastStarted)
+			select {
+			case events.startedEvents <- events.lastStarted:
+			default:
+			}
+		case "/events/stream_ended":
+			events.ended++
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(orch.Close)
+
+	engineURL, _ := url.Parse(engine.URL)
+	acexyInst := &acexy.Acexy{
+		Scheme:            engineURL.Scheme,
+		Host:              engineURL.Hostname(),
+		Port:              parsePort(engineURL.Port()),
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      1 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+
+	orchClient := newOrchClient(OrchConfig{URL: orch.URL})
+	t.Cleanup(orchClient.Close)
+
+	return &Proxy{Acexy: acexyInst, Orch: orchClient, MinClientsForEvent: minClients}, events
+}
+
+// TestProbeRequestDoesNotEmitStarted verifies probe requests are served without being
+// reported to the orchestrator, while the engine session is still stopped
+func TestProbeRequestDoesNotEmitStarted(t *testing.T) {
+	proxy, events := newEventTestProxy(t, 1, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test-stream-id", nil)
+	req.Header.Set(PROBE_HEADER, "1")
+	rec := httptest.NewRecorder()
+	proxy.HandleStream(rec, req)
+
+	// Give async events time to complete
+	time.Sleep(200 * time.Millisecond)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	started, ended, stopped := events.counts()
+	if started != 0 || ended != 0 {
+		t.Errorf("Expected no orchestrator events for a probe, got %d started and %d ended", started, ended)
+	}
+	if stopped != 1 {
+		t.Errorf("Expected the engine session to be stopped once, got %d", stopped)
+	}
+}
+
+// TestMinClientsForEvent verifies a stream with fewer concurrent clients than required is
+// not reported, and that regular requests still are with the default setting
+func TestMinClientsForEvent(t *testing.T) {
+	proxy, events := newEventTestProxy(t, 2, nil)
+	proxy.HandleStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test-stream-id", nil))
+	time.Sleep(200 * time.Millisecond)
+
+	if started, ended, _ := events.counts(); started != 0 || ended != 0 {
+		t.Errorf("Expected no events below the client minimum, got %d started and %d ended", started, ended)
+	}
+
+	proxy, events = newEventTestProxy(t, 0, nil)
+	proxy.HandleStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test-stream-id", nil))
+	time.Sleep(200 * time.Millisecond)
+
+	if started, ended, _ := events.counts(); started != 1 || ended != 1 {
+		t.Errorf("Expected one started and one ended event, got %d started and %d ended", started, ended)
+	}
+}
+
+// TestMinClientsForEventReached verifies the start of the streams held back for lacking
+// clients is reported once a later client of the same ID reaches the minimum, and their end
+// with it
+func TestMinClientsForEventReached(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback1", "playback2")
+	proxy.MinClientsForEvent = 2
+	sink := &mockSink{events: make(chan streamEvent, 8)}
+	proxy.Events = newEventPublisher(sink)
+
+	waitFirst := streamConcurrently(t, proxy, 1)
+	select {
+	case ev := <-sink.events:
+		t.Fatalf("Expected no event below the client minimum, got %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	waitSecond := streamTargetsConcurrently(t, proxy, "/ace/getstream?id=test123")
+	started := make(map[string]bool)
+	for len(started) < 2 {
+		select {
+		case ev := <-sink.events:
+			if ev.Event != "stream_started" {
+				t.Fatalf("Expected stream_started events, got %+v", ev)
+			}
+			started[ev.StreamID] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the started events, got %v", started)
+		}
+	}
+	if !started["test123|playback1"] || !started["test123|playback2"] {
+		t.Errorf("Expected both streams to be reported, got %v", started)
+	}
+
+	close(release)
+	waitFirst()
+	waitSecond()
+	for i := 0; i < 2; i++ {
+		select {
+		case ev := <-sink.events:
+			if ev.Event != "stream_ended" || !started[ev.StreamID] {
+				t.Errorf("Expected the end of a reported stream, got %+v", ev)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the ended events")
+		}
+	}
+}
+
+// TestStartedEventReflectsVOD verifies the live, encryption and infohash information of the
+// middleware response reaches the stream_started event
+func TestStartedEventReflectsVOD(t *testing.T) {
+	proxy, events := newEventTestProxy(t, 1, map[string]any{
+		"infohash":     "0123456789abcdef0123456789abcdef01234567",
+		"is_live":      0,
+		"is_encrypted": 1,
+	})
+	proxy.HandleStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test-stream-id", nil))
+	time.Sleep(200 * time.Millisecond)
+
+	events.mu.Lock()
+	defer events.mu.Unlock()
+
+	if events.started != 1 {
+		t.Fatalf("Expected one started event, got %d", events.started)
+	}
+	session := events.lastStarted.Session
+	if session.IsLive != 0 {
+		t.Errorf("Expected is_live 0 for a VOD stream, got %d", session.IsLive)
+	}
+	if session.IsEncrypted != 1 {
+		t.Errorf("Expected is_encrypted 1, got %d", session.IsEncrypted)
+	}
+	if session.Infohash != "0123456789abcdef0123456789abcdef01234567" {
+		t.Errorf("Unexpected infohash %q", session.Infohash)
+	}
+}
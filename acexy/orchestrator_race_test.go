@@ -71,12 +71,12 @@ func TestConcurrentEngineSelection(t *testing.T) {
 	for i := 0; i < numRequests; i++ {
 		go func() {
 			defer wg.Done()
-			host, port, containerID, err := client.SelectBestEngine()
+			engine, err := client.SelectBestEngine()
 			if err == nil {
 				selectionMu.Lock()
-				selectionCount[containerID]++
+				selectionCount[engine.ContainerID]++
 				selectionMu.Unlock()
-				t.Logf("Selected engine: host=%s port=%d containerID=%s", host, port, containerID)
+				t.Logf("Selected engine: host=%s port=%d containerID=%s", engine.Host, engine.Port, engine.ContainerID)
 			} else {
 				t.Logf("Selection failed (expected when at capacity): %v", err)
 			}
@@ -151,12 +151,12 @@ func TestEngineSelectionWithoutBlocking(t *testing.T) {
 
 	// Make multiple sequential selections
 	for i := 0; i < 3; i++ {
-		host, port, containerID, err := client.SelectBestEngine()
+		engine, err := client.SelectBestEngine()
 		if err != nil {
 			t.Logf("Selection %d failed: %v", i, err)
 			continue
 		}
-		t.Logf("Selection %d: host=%s port=%d containerID=%s", i, host, port, containerID)
+		t.Logf("Selection %d: host=%s port=%d containerID=%s", i, engine.Host, engine.Port, engine.ContainerID)
 	}
 
 	duration := time.Since(startTime)
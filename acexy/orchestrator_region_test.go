@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestSelectBestEngineRegion verifies engines in the preferred region win over engines with
+// the same health in other regions, even when busier, but never over healthier ones
+func TestSelectBestEngineRegion(t *testing.T) {
+	engines := []engineState{
+		{
+			// Idle and forwarded, but in another region
+			ContainerID:  "engine-us",
+			Host:         "host-us",
+			Port:         8001,
+			HealthStatus: "healthy",
+			Forwarded:    true,
+			Labels:       map[string]string{"region": "us"},
+		},
+		{
+			ContainerID:  "engine-eu",
+			Host:         "host-eu",
+			Port:         8002,
+			HealthStatus: "healthy",
+			Labels:       map[string]string{"region": "eu"},
+		},
+		{
+			ContainerID:  "engine-eu-unhealthy",
+			Host:         "host-eu-unhealthy",
+			Port:         8003,
+			HealthStatus: "unhealthy",
+			Labels:       map[string]string{"region": "eu"},
+		},
+		{
+			ContainerID:  "engine-ap-unhealthy",
+			Host:         "host-ap-unhealthy",
+			Port:         8004,
+			HealthStatus: "unhealthy",
+			Labels:       map[string]string{"region": "ap"},
+		},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode(engines)
+		case "/streams":
+			streams := []streamState{}
+			if r.URL.Query().Get("container_id") == "engine-eu" {
+				streams = append(streams, streamState{ID: "s1", Status: "started"})
+			}
+			json.NewEncoder(w).Encode(streams)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &orchClient{
+		base:                server.URL,
+		maxStreamsPerEngine: 2,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+	}
+	client.health.canProvision = true
+
+	tests := []struct {
+		region   string
+		expected string
+	}{
+		{"", "engine-us"},   // Least loaded engine without a preference
+		{"eu", "engine-eu"}, // Region wins over load
+		{"EU", "engine-eu"}, // Regions are case-insensitive
+		{"us", "engine-us"},
+		{"ap", "engine-us"}, // Health wins over region
+		{"sa", "engine-us"}, // Unknown regions fall back to the others
+	}
+	for _, tt := range tests {
+		engine, err := client.SelectBestEngineContext(withPreferredRegion(context.Background(), tt.region))
+		if err != nil {
+			t.Fatalf("Unexpected selection error for region %q: %v", tt.region, err)
+		}
+		if engine.ContainerID != tt.expected {
+			t.Errorf("Region %q: expected %s to be selected, got %s", tt.region, tt.expected, engine.ContainerID)
+		}
+	}
+}
+
+// TestRequestRegion verifies the query parameter takes precedence over the configured header
+func TestRequestRegion(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/ace/getstream?id=abc&region=eu", nil)
+	r.Header.Set("CF-IPCountry", "US")
+	if got := requestRegion(r, "CF-IPCountry"); got != "eu" {
+		t.Errorf("Expected the query region, got %q", got)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/ace/getstream?id=abc", nil)
+	r.Header.Set("CF-IPCountry", "US")
+	if got := requestRegion(r, "CF-IPCountry"); got != "US" {
+		t.Errorf("Expected the header region, got %q", got)
+	}
+	if got := requestRegion(r, ""); got != "" {
+		t.Errorf("Expected no region without a configured header, got %q", got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSelectBestEngineSchemeFromLabels verifies the scheme label of the selected engine is
+// returned alongside its host and port, with mixed http and https engines
+func TestSelectBestEngineSchemeFromLabels(t *testing.T) {
+	var mu sync.Mutex
+	busyEngine := "engine-http"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{
+					ContainerID:  "engine-http",
+					Host:         "host-http",
+					Port:         8001,
+					HealthStatus: "healthy",
+					Labels:       map[string]string{engineSchemeLabel: "http"},
+				},
+				{
+					ContainerID:  "engine-https",
+					Host:         "host-https",
+					Port:         8443,
+					HealthStatus: "healthy",
+					Labels:       map[string]string{engineSchemeLabel: "HTTPS"},
+				},
+			})
+		case "/streams":
+			mu.Lock()
+			busy := r.URL.Query().Get("container_id") == busyEngine
+			mu.Unlock()
+			streams := []streamState{}
+			if busy {
+				streams = append(streams, streamState{ID: "s1", Status: "started"})
+			}
+			json.NewEncoder(w).Encode(streams)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &orchClient{
+		base:                server.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+	}
+
+	engine, err := client.SelectBestEngine()
+	if err != nil {
+		t.Fatalf("Unexpected selection error: %v", err)
+	}
+	if engine.ContainerID != "engine-https" || engine.Scheme != "https" {
+		t.Errorf("Expected engine-https with scheme https, got %s with scheme %q", engine.ContainerID, engine.Scheme)
+	}
+	if engine.Host != "host-https" || engine.Port != 8443 {
+		t.Errorf("Expected host-https:8443, got %s:%d", engine.Host, engine.Port)
+	}
+
+	mu.Lock()
+	busyEngine = "engine-https"
+	mu.Unlock()
+
+	engine, err = client.SelectBestEngine()
+	if err != nil {
+		t.Fatalf("Unexpected selection error: %v", err)
+	}
+	if engine.ContainerID != "engine-http" || engine.Scheme != "http" {
+		t.Errorf("Expected engine-http with scheme http, got %s with scheme %q", engine.ContainerID, engine.Scheme)
+	}
+}
+
+// TestEngineSchemeFallback verifies engines without a valid label fall back to the global scheme
+func TestEngineSchemeFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected string
+	}{
+		{"no labels", nil, ""},
+		{"unrelated labels", map[string]string{"region": "eu"}, ""},
+		{"unsupported scheme", map[string]string{engineSchemeLabel: "ftp"}, ""},
+		{"https", map[string]string{engineSchemeLabel: "https"}, "https"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if scheme := engineScheme(engineState{Labels: tt.labels}); scheme != tt.expected {
+				t.Errorf("Expected scheme %q, got %q", tt.expected, scheme)
+			}
+		})
+	}
+}
+
+// TestSelectedEngineTarget verifies the engine a stream is fetched from carries the scheme
+// of the engine label, and the configured one otherwise
+func TestSelectedEngineTarget(t *testing.T) {
+	target := selectedEngine{Host: "host-https", Port: 8443, Scheme: "https"}.target("http")
+	if target.Scheme != "https" || target.Host != "host-https" || target.Port != 8443 {
+		t.Errorf("Expected https://host-https:8443, got %s://%s:%d", target.Scheme, target.Host, target.Port)
+	}
+	if target = (selectedEngine{Host: "host-http", Port: 8001}).target("http"); target.Scheme != "http" {
+		t.Errorf("Expected the configured scheme, got %q", target.Scheme)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"javinator9889/acexy/lib/acexy"
@@ -8,6 +9,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -101,7 +103,7 @@ func TestStreamEndEmitsEndedEvent(t *testing.T) {
 	acexyInst.Init()
 
 	// Create orchestrator client
-	orchClient := newOrchClient(orchServer.URL)
+	orchClient := newOrchClient(OrchConfig{URL: orchServer.URL})
 	defer orchClient.Close()
 
 	// Create proxy
@@ -219,7 +221,7 @@ func TestStreamFailureEmitsEndedEvent(t *testing.T) {
 	acexyInst.Init()
 
 	// Create orchestrator client
-	orchClient := newOrchClient(orchServer.URL)
+	orchClient := newOrchClient(OrchConfig{URL: orchServer.URL})
 	defer orchClient.Close()
 
 	// Create proxy
@@ -321,6 +323,78 @@ func TestNoOrchestratorNoEndedEvent(t *testing.T) {
 	}
 }
 
+// TestClientGoneDuringProvisioning verifies that when the client disconnects while a new
+// engine is being provisioned, the wait is aborted and no stream is left behind
+func TestClientGoneDuringProvisioning(t *testing.T) {
+	var engineRequests, startedEvents atomic.Int32
+
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		engineRequests.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer engine.Close()
+
+	orchServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{})
+		case "/provision/acestream":
+			json.NewEncoder(w).Encode(aceProvisionResponse{ContainerID: "new-container", HostHTTPPort: 19000})
+		case "/events/stream_started":
+			startedEvents.Add(1)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orchServer.Close()
+
+	clientCtx, clientCancel := context.WithCancel(context.Background())
+	defer clientCancel()
+	orchClient := &orchClient{
+		base:                orchServer.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 clientCtx,
+		cancel:              clientCancel,
+		endedStreams:        make(map[string]bool),
+	}
+	orchClient.health.canProvision = true
+
+	engineURL, _ := url.Parse(engine.URL)
+	acexyInst := &acexy.Acexy{
+		Scheme:            engineURL.Scheme,
+		Host:              engineURL.Hostname(),
+		Port:              parsePort(engineURL.Port()),
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      1 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+	proxy := &Proxy{Acexy: acexyInst, Orch: orchClient}
+
+	// The client leaves while acexy waits for the provisioned engine
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test-stream-id", nil).WithContext(ctx)
+
+	start := time.Now()
+	proxy.HandleStream(httptest.NewRecorder(), req)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the provisioning wait to be aborted early, took %v", elapsed)
+	}
+
+	if n := proxy.streams.Len(); n != 0 {
+		t.Errorf("Expected no active streams, got %d", n)
+	}
+	if n := engineRequests.Load(); n != 0 {
+		t.Errorf("Expected no request to reach the engine, got %d", n)
+	}
+	if n := startedEvents.Load(); n != 0 {
+		t.Errorf("Expected no stream_started event, got %d", n)
+	}
+}
+
 // Helper function to parse port from string
 func parsePort(portStr string) int {
 	var port int
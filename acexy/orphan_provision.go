@@ -0,0 +1,54 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// DEFAULT_CANCEL_PROVISION_PATH is the orchestrator endpoint deleting a provisioned engine.
+// The `{id}` placeholder is replaced by the container ID.
+const DEFAULT_CANCEL_PROVISION_PATH = "/provision/{id}"
+
+// CancelProvision asks the orchestrator to remove a provisioned engine. An engine the
+// orchestrator does not know anymore is considered already removed.
+func (c *orchClient) CancelProvision(containerID string) error {
+	if c == nil {
+		return fmt.Errorf("orchestrator client not configured")
+	}
+	path := strings.ReplaceAll(c.cancelProvisionPath, "{id}", containerID)
+	req, err := http.NewRequest(http.MethodDelete, c.base+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create cancel request: %w", err)
+	}
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to cancel provision: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("cancel provision failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// abandonProvision cancels an engine provisioned for a request that is gone, so it is not
+// left orphaned. Does nothing unless cancelling orphan provisions is enabled.
+func (c *orchClient) abandonProvision(provResp *aceProvisionResponse, reason error) {
+	if c == nil || c.cancelProvisionPath == "" || provResp == nil || provResp.ContainerID == "" {
+		return
+	}
+	slog.Info("Cancelling abandoned provision", "container_id", provResp.ContainerID, "reason", reason)
+	if err := c.CancelProvision(provResp.ContainerID); err != nil {
+		slog.Warn("Failed to cancel abandoned provision", "container_id", provResp.ContainerID, "error", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestAbandonedProvisionCancelled verifies an engine provisioned after the client is gone
+// is removed through the cancel endpoint, and only when enabled
+func TestAbandonedProvisionCancelled(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		var mu sync.Mutex
+		var cancelled []string
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/engines":
+				json.NewEncoder(w).Encode([]engineState{})
+			case r.URL.Path == "/provision/acestream":
+				// The provision outlives the client request
+				time.Sleep(200 * time.Millisecond)
+				json.NewEncoder(w).Encode(aceProvisionResponse{ContainerID: "engine-late", HostHTTPPort: 19000})
+			case r.Method == http.MethodDelete:
+				mu.Lock()
+				cancelled = append(cancelled, r.URL.Path)
+				mu.Unlock()
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+
+		clientCtx, clientCancel := context.WithCancel(context.Background())
+		client := &orchClient{
+			base:                server.URL,
+			maxStreamsPerEngine: 1,
+			hc:                  &http.Client{Timeout: 3 * time.Second},
+			ctx:                 clientCtx,
+			cancel:              clientCancel,
+		}
+		if enabled {
+			client.cancelProvisionPath = DEFAULT_CANCEL_PROVISION_PATH
+		}
+		client.health.canProvision = true
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := client.SelectBestEngineContext(ctx)
+		cancel()
+		clientCancel()
+		server.Close()
+
+		if err == nil {
+			t.Fatalf("Expected the selection to be aborted (enabled=%v)", enabled)
+		}
+		mu.Lock()
+		got := cancelled
+		mu.Unlock()
+		if enabled && (len(got) != 1 || got[0] != "/provision/engine-late") {
+			t.Errorf("Expected a single cancel of engine-late, got %v", got)
+		}
+		if !enabled && len(got) != 0 {
+			t.Errorf("Expected no cancel when disabled, got %v", got)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The query parameter a client uses to pin the stream to an engine, by its container ID
+const ENGINE_PIN_PARAM = "engine"
+
+// PinnedEngine returns the engine with the given container ID, bypassing the selection.
+// Fails when the orchestrator does not know it or it is not healthy.
+func (c *orchClient) PinnedEngine(containerID string) (selectedEngine, error) {
+	if c == nil {
+		return selectedEngine{}, fmt.Errorf("engine pinning requires the orchestrator")
+	}
+	containerID = strings.TrimSpace(containerID)
+
+	engines, err := c.GetEngines()
+	if err != nil {
+		return selectedEngine{}, fmt.Errorf("failed to list engines: %w", err)
+	}
+	for _, engine := range engines {
+		if engine.ContainerID != containerID {
+			continue
+		}
+		if engine.HealthStatus != "healthy" || c.engineFailing(containerID) {
+			return selectedEngine{}, fmt.Errorf("engine %s is not healthy", containerID)
+		}
+		return selectedEngine{
+			Host:        engine.Host,
+			Port:        engine.Port,
+			ContainerID: engine.ContainerID,
+			Scheme:      engineScheme(engine),
+			Forwarded:   engine.Forwarded,
+		}, nil
+	}
+	return selectedEngine{}, fmt.Errorf("unknown engine %s", containerID)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestEnginePinning verifies a pinned engine is used instead of the selected one, and that
+// unknown or unhealthy engines are rejected
+func TestEnginePinning(t *testing.T) {
+	var served atomic.Int32
+	var engine *httptest.Server
+	engine = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			served.Add(1)
+			if r.URL.Query().Has(ENGINE_PIN_PARAM) {
+				t.Errorf("The engine parameter must not be relayed to the engine")
+			}
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"playback_url": engine.URL + "/stream",
+				"stat_url":     engine.URL + "/ace/stat/test/playback123",
+				"command_url":  engine.URL + "/ace/cmd/test/playback123",
+			}})
+		case "/stream":
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.Write([]byte("test stream data"))
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"response": "ok"})
+		}
+	}))
+	defer engine.Close()
+	engineURL, _ := url.Parse(engine.URL)
+
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				// Preferred by the selection, but unreachable
+				{ContainerID: "engine-best", Host: "127.0.0.1", Port: 1, HealthStatus: "healthy", Forwarded: true},
+				{ContainerID: "engine-pinned", Host: engineURL.Hostname(), Port: parsePort(engineURL.Port()), HealthStatus: "healthy"},
+				{ContainerID: "engine-sick", Host: engineURL.Hostname(), Port: parsePort(engineURL.Port()), HealthStatus: "unhealthy"},
+			})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		}
+	}))
+	defer orch.Close()
+	orchClient := newOrchClient(OrchConfig{URL: orch.URL})
+	defer orchClient.Close()
+
+	acexyInst := &acexy.Acexy{
+		Scheme:            engineURL.Scheme,
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      1 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+	proxy := &Proxy{Acexy: acexyInst, Orch: orchClient, AllowEnginePinning: true, ExposeEngineHeaders: true}
+
+	rec := httptest.NewRecorder()
+	proxy.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123&engine=engine-pinned", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(ENGINE_HEADER); got != "engine-pinned" {
+		t.Errorf("Expected the pinned engine to serve the stream, got %q", got)
+	}
+	if served.Load() != 1 {
+		t.Errorf("Expected the pinned engine to be asked for the stream once, got %d", served.Load())
+	}
+
+	for _, pinned := range []string{"engine-unknown", "engine-sick"} {
+		rec := httptest.NewRecorder()
+		proxy.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123&engine="+pinned, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 pinning %s, got %d", pinned, rec.Code)
+		}
+	}
+	if served.Load() != 1 {
+		t.Errorf("Expected rejected pins not to reach any engine, got %d requests", served.Load())
+	}
+}
@@ -0,0 +1,56 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// The window the provisioning attempts of the whole process are counted in
+const PROVISION_BUDGET_WINDOW = time.Minute
+
+// provisionBudget caps the provisioning attempts of the whole process within a sliding
+// window, so a failing orchestrator is not flooded by the retries of every request
+type provisionBudget struct {
+	limit  int
+	window time.Duration
+
+	mu       sync.Mutex
+	attempts []time.Time // Oldest first
+}
+
+// newProvisionBudget creates the budget. Returns nil (unbounded) when the limit is not
+// positive.
+func newProvisionBudget(limit int) *provisionBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &provisionBudget{limit: limit, window: PROVISION_BUDGET_WINDOW}
+}
+
+// Allow records an attempt when the budget has room for it. Otherwise, it returns false
+// together with the time until the oldest attempt leaves the window.
+func (b *provisionBudget) Allow() (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	expired := 0
+	for expired < len(b.attempts) && now.Sub(b.attempts[expired]) >= b.window {
+		expired++
+	}
+	b.attempts = b.attempts[expired:]
+
+	if len(b.attempts) >= b.limit {
+		return false, b.window - now.Sub(b.attempts[0])
+	}
+	b.attempts = append(b.attempts, now)
+	return true, 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestProvisionBudget verifies provisioning stops being attempted once the attempts per
+// minute are exhausted, failing with a retry delay instead
+func TestProvisionBudget(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/provision/acestream" {
+			attempts.Add(1)
+		}
+		http.Error(w, "orchestrator failing", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:            server.URL,
+		hc:              &http.Client{Timeout: 3 * time.Second},
+		ctx:             ctx,
+		cancel:          cancel,
+		provisionBudget: newProvisionBudget(4),
+	}
+
+	// The first call retries 3 times, the second one only has room for a single attempt
+	if _, err := client.ProvisionWithRetry(3); err == nil {
+		t.Fatal("Expected the failing orchestrator to fail the provision")
+	}
+	_, err := client.ProvisionWithRetry(3)
+	var provErr *ProvisioningError
+	if !errors.As(err, &provErr) || provErr.Details.Code != PROVISION_RATE_LIMITED_CODE {
+		t.Fatalf("Expected a %s error, got %v", PROVISION_RATE_LIMITED_CODE, err)
+	}
+	if eta := provErr.Details.RecoveryETASeconds; eta <= 0 || eta > 60 {
+		t.Errorf("Expected a retry delay within the window, got %d", eta)
+	}
+	if n := attempts.Load(); n != 4 {
+		t.Errorf("Expected 4 provisioning attempts, got %d", n)
+	}
+
+	// Further selections don't reach the orchestrator at all
+	client.ProvisionWithRetry(3)
+	if n := attempts.Load(); n != 4 {
+		t.Errorf("Expected no further provisioning attempts, got %d", n)
+	}
+}
+
+// TestProvisionBudgetWindow verifies attempts leaving the window free up the budget
+func TestProvisionBudgetWindow(t *testing.T) {
+	budget := newProvisionBudget(2)
+	budget.window = 50 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := budget.Allow(); !ok {
+			t.Fatalf("Expected attempt %d to be allowed", i+1)
+		}
+	}
+	if ok, retryAfter := budget.Allow(); ok || retryAfter <= 0 {
+		t.Errorf("Expected the budget to be exhausted with a retry delay, got %v, %v", ok, retryAfter)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if ok, _ := budget.Allow(); !ok {
+		t.Error("Expected the budget to be freed up once the window passed")
+	}
+
+	var unbounded *provisionBudget
+	if ok, _ := unbounded.Allow(); !ok {
+		t.Error("Expected a nil budget to always allow")
+	}
+}
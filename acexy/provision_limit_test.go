@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMaxConcurrentProvisions verifies simultaneous selections needing a new engine never
+// have more provisions in flight than allowed, the rest waiting for a free slot
+func TestMaxConcurrentProvisions(t *testing.T) {
+	const limit = 2
+	const selections = 5
+
+	var mu sync.Mutex
+	inFlight, maxInFlight, total := 0, 0, 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{})
+		case "/provision/acestream":
+			mu.Lock()
+			inFlight++
+			total++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+
+			time.Sleep(100 * time.Millisecond)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			json.NewEncoder(w).Encode(aceProvisionResponse{ContainerID: "engine-new", HostHTTPPort: 19000})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	clientCtx, clientCancel := context.WithCancel(context.Background())
+	defer clientCancel()
+	client := &orchClient{
+		base:                server.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 clientCtx,
+		cancel:              clientCancel,
+		provisions:          make(chan struct{}, limit),
+	}
+	client.health.canProvision = true
+
+	// The selections are given up while waiting for the provisioned engine to show up,
+	// long after every provision completed
+	var wg sync.WaitGroup
+	for i := 0; i < selections; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			client.SelectBestEngineContext(ctx)
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxInFlight > limit {
+		t.Errorf("Expected at most %d provisions in flight, got %d", limit, maxInFlight)
+	}
+	if total != selections {
+		t.Errorf("Expected %d provisions once slots freed up, got %d", selections, total)
+	}
+	if len(client.provisions) != 0 {
+		t.Errorf("Expected every provision slot to be released, %d still taken", len(client.provisions))
+	}
+}
@@ -5,6 +5,7 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"encoding/json"
 	"errors"
@@ -13,40 +14,161 @@ import (
 	"io"
 	"javinator9889/acexy/lib/acexy"
 	"javinator9889/acexy/lib/debug"
+	"javinator9889/acexy/lib/pmw"
 	"log/slog"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dustin/go-humanize"
 )
 
-var (
-	addr                string
-	scheme              string
-	host                string
-	port                int
-	streamTimeout       time.Duration
-	m3u8                bool
-	emptyTimeout        time.Duration
-	size                Size
-	noResponseTimeout   time.Duration
-	maxStreamsPerEngine int
-	debugMode           bool
-	debugLogDir         string
-)
-
 //go:embed LICENSE.short
 var LICENSE string
 
 // The API URL we are listening to
 const APIv1_URL = "/ace"
 
+// Requests carrying this header are health probes: they are served normally but never
+// reported to the orchestrator
+const PROBE_HEADER = "X-Acexy-Probe"
+
+// The response headers reporting the engine an orchestrator selection chose, when enabled
+const (
+	ENGINE_HEADER           = "X-Acexy-Engine"
+	ENGINE_ADDR_HEADER      = "X-Acexy-Engine-Addr"
+	ENGINE_FORWARDED_HEADER = "X-Acexy-Engine-Forwarded"
+)
+
 type Proxy struct {
-	Acexy *acexy.Acexy
-	Orch  *orchClient
+	Acexy      *acexy.Acexy
+	InstanceID string            // ID of this acexy instance, reported to the orchestrator and in `/admin/summary`
+	Orch       *orchClient
+	AdminToken string            // Token required to access the admin endpoints (empty disables the check)
+	BadContent *badContentCache  // Negative cache for content the middleware keeps rejecting (nil disables it)
+	Filter     *contentFilter    // Allow and deny lists of content IDs (nil allows everything)
+	RateLimit  *rateLimiter      // Per-client rate limit of stream requests (nil disables it)
+	Fallback   *fallbackChain    // Ordered engine sources to try (nil keeps the orchestrator/fallback engine behaviour)
+	Hooks      *streamHooks      // Commands run when streams start and end (nil disables them)
+	Events     *eventSinks       // Message queues stream events are published to (nil disables them)
+	Keepalive  *keepalive        // Periodic pings keeping engine sessions warm (nil disables them)
+	Holding    *provisionHolding // Placeholder response served while an engine is provisioned (nil disables it)
+	ErrorClip  *errorClip        // MPEG-TS clip written to clients whose stream failed (nil disables it)
+	Reconciler *reconciler       // Periodic reconciliation of the orchestrator streams (nil disables it)
+	Cancels    *cancelWatcher    // Stops the streams the orchestrator cancels (nil disables it)
+	Stuck      *stuckWatcher     // Reports the streams no longer delivering data (nil disables it)
+	EnableAux  bool              // Whether auxiliary middleware resources are relayed through `/ace/aux`
+	HideRoot   bool              // Whether `/` returns a 404 instead of the license, still served at `/license`
+
+	// Which of `id` and `infohash` is used when a request gives both. The zero value (and
+	// strict) rejects conflicting values.
+	IDPrecedence acexy.IDPrecedence
+
+	// Whether the M3U8 manifest is gzipped for the clients accepting it
+	CompressManifest bool
+
+	// Whether MPEG-TS responses are always chunked, instead of keeping the Content-Length
+	// of finite streams the engine sends with one
+	ForceChunked bool
+
+	// Whether `/ace/status` always includes the health summary, otherwise only returned
+	// with `?verbose=1`
+	VerboseStatus bool
+
+	// Whether a standby engine is selected when a stream starts, taking the stream over if
+	// the primary one fails mid-stream
+	WarmStandby bool
+
+	// Whether the stat and command URLs reported by the engine are rewritten to the engine
+	// host acexy used, keeping them reachable by acexy and consistent for the orchestrator
+	RewriteEngineURLs bool
+
+	// Bytes each client IP may receive within a window before it is disconnected (nil
+	// disables the quota)
+	ClientQuota *clientQuota
+
+	// Maximum streams each client IP may have open at once (nil disables the limit)
+	ClientStreams *clientStreamLimit
+
+	// Whether a stream getting the playback session ID of another active one is fetched
+	// again, instead of only being tracked under a distinct ID
+	RefetchDuplicateSessions bool
+
+	// Whether streams requested by content ID are keyed by the infohash the engine resolved
+	// it to, so requests for the same content by either identifier converge on one stream
+	DedupByResolvedInfohash bool
+
+	// Header the preferred engine region is read from when the client does not pass the
+	// `region` query parameter (empty disables it)
+	RegionHeader string
+
+	// Header the request ID of the caller is adopted from, so acexy joins its trace. A new
+	// ID is generated when empty or when the header is absent or invalid.
+	InboundRequestIDHeader string
+
+	// Concurrent clients of the same ID required before the stream is reported to the
+	// orchestrator. Values below 1 behave as 1.
+	MinClientsForEvent int
+
+	// Orchestrator engines that must be healthy with a free stream slot for `/readyz` to
+	// report acexy ready, unless new ones can be provisioned. Values below 1 behave as 1.
+	MinReadyEngines int
+
+	// Whether `/readyz` fails while the orchestrator rejects the API key
+	FailReadyOnOrchAuth bool
+
+	// Whether a `pid` parameter sent by the client is dropped instead of rejecting the
+	// request, for clients behind proxies appending their own
+	IgnoreClientPID bool
+
+	// Whether clients may pin a stream to an orchestrator engine through the `engine`
+	// query parameter, bypassing the selection (a debugging aid)
+	AllowEnginePinning bool
+
+	// Whether the container and address of the engine chosen by the orchestrator are
+	// reported in the response headers
+	ExposeEngineHeaders bool
+
+	// Other engines a stream is retried on when it fails before the client got any data
+	// (only with the orchestrator, 0 disables the retries)
+	StartRetries int
+
+	// Streams ending with an EOF before being served this long are reported as `early_eof`
+	// instead of `eof` (0 disables the distinction)
+	EarlyEOFThreshold time.Duration
+
+	// Whether streams ending cleanly without sending any data are reported as `completed`
+	// instead of `no_data`, which also deprioritizes their engine
+	AllowNoDataCompletion bool
+
+	// Whether `/metrics` is served in the OpenMetrics format to the scrapers asking for it,
+	// with the request ID of an example request attached to each latency bucket
+	OpenMetricsExemplars bool
+
+	// Effective configuration reported by `/admin/config` with its secrets redacted (nil
+	// when the proxy was not built by NewProxy)
+	Config *Config
+
+	streams     streamRegistry
+	health      healthCache
+	disconnects disconnectLog
+	history     streamHistory
+	noData      atomic.Uint64 // Streams that ended cleanly without sending any data
+	pending     atomic.Int64 // Stream requests waiting for an engine and the stream to be fetched
+	draining    atomic.Bool  // Whether new streams are rejected, see SetDraining
+
+	shuttingDown       atomic.Bool   // Whether the proxy is shutting down, see Shutdown
+	forcedTerminations atomic.Uint64 // Streams terminated at shutdown past the grace period
+
+	selectionLatency latencyHistogram // Time taken to select the engine of the stream requests
+	startLatency     latencyHistogram // Time from the stream requests to their stream being fetched
 }
 
 type Size struct {
@@ -62,13 +184,93 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		p.HandleStream(w, r)
 	case APIv1_URL + "/status":
 		p.HandleStatus(w, r)
+	case APIv1_URL + "/aux":
+		p.HandleAux(w, r)
+	case APIv1_URL + "/stat":
+		p.HandleStat(w, r)
+	case "/metrics":
+		p.HandleMetrics(w, r)
+	case "/healthz":
+		p.HandleHealthz(w, r)
+	case "/readyz":
+		p.HandleReadyz(w, r)
+	case ADMIN_URL + "/summary":
+		p.HandleAdminSummary(w, r)
+	case ADMIN_URL + "/orchestrator/refresh":
+		p.HandleAdminOrchestratorRefresh(w, r)
+	case ADMIN_URL + "/clients":
+		p.HandleAdminClients(w, r)
+	case ADMIN_URL + "/disconnects":
+		p.HandleAdminDisconnects(w, r)
+	case ADMIN_URL + "/drain":
+		p.HandleAdminDrain(w, r)
+	case ADMIN_URL + "/reload":
+		p.HandleAdminReload(w, r)
+	case ADMIN_URL + "/history":
+		p.HandleAdminHistory(w, r)
+	case ADMIN_URL + "/config":
+		p.HandleAdminConfig(w, r)
+	case ADMIN_URL + "/engines":
+		p.HandleAdminEngines(w, r)
+	case ADMIN_URL + "/engines/refresh":
+		p.HandleAdminEnginesRefresh(w, r)
 	case "/":
+		if p.HideRoot {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = fmt.Fprintln(w, LICENSE)
+	case "/license":
 		_, _ = fmt.Fprintln(w, LICENSE)
 	default:
 		http.NotFound(w, r)
 	}
 }
 
+// routeMethods lists the HTTP methods accepted by each of the routes served by the proxy
+var routeMethods = map[string][]string{
+	APIv1_URL + "/getstream":            {http.MethodGet},
+	APIv1_URL + "/getstream/":           {http.MethodGet},
+	APIv1_URL + "/status":               {http.MethodGet},
+	APIv1_URL + "/aux":                  {http.MethodGet},
+	APIv1_URL + "/stat":                 {http.MethodGet},
+	"/metrics":                          {http.MethodGet},
+	"/healthz":                          {http.MethodGet},
+	"/readyz":                           {http.MethodGet},
+	ADMIN_URL + "/summary":              {http.MethodGet},
+	ADMIN_URL + "/orchestrator/refresh": {http.MethodPost},
+	ADMIN_URL + "/clients":              {http.MethodGet},
+	ADMIN_URL + "/engines":              {http.MethodGet},
+	ADMIN_URL + "/engines/refresh":      {http.MethodPost},
+	ADMIN_URL + "/config":               {http.MethodGet},
+	ADMIN_URL + "/disconnects":          {http.MethodGet},
+	ADMIN_URL + "/history":              {http.MethodGet},
+	ADMIN_URL + "/reload":               {http.MethodPost},
+	ADMIN_URL + "/drain":                {http.MethodPost, http.MethodDelete},
+	"/":                                 {http.MethodGet},
+	"/license":                          {http.MethodGet},
+}
+
+// The maximum request body accepted on any route. No endpoint expects a payload, so this
+// only guards against clients pushing data at the proxy.
+const maxRequestBodyBytes = 1 << 20
+
+// methodGuard wraps the handler so requests using a method not allowed for the route are
+// rejected with a 405 and an `Allow` header listing the valid methods. Unknown routes are
+// passed through untouched so they get the regular 404.
+func methodGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if methods, ok := routeMethods[r.URL.Path]; ok && !slices.Contains(methods, r.Method) {
+			slog.Error("Method not allowed", "method", r.Method, "path", r.URL.Path)
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (p *Proxy) HandleStream(w http.ResponseWriter, r *http.Request) {
 	debugLog := debug.GetDebugLogger()
 	startTime := time.Now()
@@ -95,17 +297,39 @@ func (p *Proxy) HandleStream(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// Verify the request method
-	if r.Method != http.MethodGet {
-		statusCode = http.StatusMethodNotAllowed
-		slog.Error("Method not allowed", "method", r.Method, "path", r.URL.Path)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Identify the request, joining the trace of the caller when it sent its own ID
+	reqID := requestIDFor(r, p.InboundRequestIDHeader)
+	w.Header().Set(REQUEST_ID_HEADER, reqID)
+
+	// Reject new streams while draining, the active ones are kept
+	if p.rejectDraining(w) {
+		statusCode = http.StatusServiceUnavailable
+		slog.Debug("Draining, rejecting the stream request", "client", clientIP(r))
+		return
+	}
+
+	// Reject clients requesting streams faster than allowed
+	if ok, retryAfter := p.RateLimit.Allow(clientIP(r)); !ok {
+		statusCode = http.StatusTooManyRequests
+		slog.Warn("Stream request rate limited", "client", clientIP(r), "retry_after", retryAfter)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	// Cap the streams a single client may have open, each holding an engine session
+	client := clientIP(r)
+	if !p.ClientStreams.Acquire(client) {
+		statusCode = http.StatusTooManyRequests
+		slog.Warn("Client reached its maximum open streams", "client", client, "max_streams", p.ClientStreams.max)
+		http.Error(w, "Too many open streams", http.StatusTooManyRequests)
 		return
 	}
+	defer p.ClientStreams.Release(client)
 
 	q := r.URL.Query()
 	// Verify the client has included the ID parameter
-	aceId, err := acexy.NewAceID(q.Get("id"), q.Get("infohash"))
+	aceId, err := acexy.NewAceIDWithPrecedence(q.Get("id"), q.Get("infohash"), p.IDPrecedence)
 	if err != nil {
 		statusCode = http.StatusBadRequest
 		slog.Error("ID parameter is required", "path", r.URL.Path, "error", err)
@@ -114,119 +338,445 @@ func (p *Proxy) HandleStream(w http.ResponseWriter, r *http.Request) {
 	}
 	aceIDStr = aceId.String()
 
-	// Check that the client is not trying to force a PID
+	// Reject the content denied, or missing from the allow list, without touching the engine
+	if _, id := aceId.ID(); !p.Filter.Allowed(id) {
+		statusCode = http.StatusForbidden
+		slog.Warn("Content not allowed by the content lists", "stream", aceId, "client", clientIP(r))
+		http.Error(w, "Content not allowed", http.StatusForbidden)
+		return
+	}
+
+	// Check that the client is not trying to force a PID. When allowed, the PID appended by
+	// upstream proxies is dropped in favour of the generated one.
 	if _, ok := q["pid"]; ok {
+		if !p.IgnoreClientPID {
+			statusCode = http.StatusBadRequest
+			slog.Error("PID parameter is not allowed", "path", r.URL.Path)
+			http.Error(w, "PID parameter is not allowed", http.StatusBadRequest)
+			return
+		}
+		slog.Debug("Ignoring the client PID", "path", r.URL.Path, "pid", q.Get("pid"))
+		q.Del("pid")
+	}
+
+	// Reject content that the middleware has repeatedly refused without touching the engine
+	if reason, blocked := p.BadContent.Blocked(aceIDStr); blocked {
+		statusCode = http.StatusNotFound
+		slog.Debug("Content temporarily blocked after repeated middleware errors", "stream", aceId, "reason", reason)
+		http.Error(w, "Content not available: "+reason, http.StatusNotFound)
+		return
+	}
+
+	// Clients may tag the stream with their own label, which is not relayed to the engine
+	label, err := parseStreamLabel(q.Get(STREAM_LABEL_PARAM))
+	if err != nil {
 		statusCode = http.StatusBadRequest
-		slog.Error("PID parameter is not allowed", "path", r.URL.Path)
-		http.Error(w, "PID parameter is not allowed", http.StatusBadRequest)
+		slog.Warn("Invalid stream label", "stream", aceId, "error", err)
+		http.Error(w, "Invalid label: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	q.Del(STREAM_LABEL_PARAM)
 
-	// Select the best available engine from orchestrator if configured
-	var selectedHost string
-	var selectedPort int
-	var selectedEngineContainerID string
+	// The preferred region only drives the engine selection, it is not relayed to the engine.
+	// The selection is also told the content, to prefer an engine that has it cached.
+	_, streamKey := aceId.ID()
+	selectCtx := withContentID(withPreferredRegion(r.Context(), requestRegion(r, p.RegionHeader)), aceIDStr)
+	selectCtx = withStreamKey(selectCtx, streamKey)
+	q.Del(REGION_QUERY_PARAM)
 
-	if p.Orch != nil {
-		// Try to get an available engine from orchestrator
-		host, port, engineContainerID, err := p.Orch.SelectBestEngine()
-		if err != nil {
-			// Check if it's a structured provisioning error
-			var provErr *ProvisioningError
-			if errors.As(err, &provErr) {
-				statusCode = http.StatusServiceUnavailable
-				p.handleProvisioningError(w, provErr)
-				return
-			}
+	// When allowed, clients may pin the stream to an engine for debugging
+	var pinned string
+	if p.AllowEnginePinning {
+		pinned = q.Get(ENGINE_PIN_PARAM)
+		q.Del(ENGINE_PIN_PARAM)
+	}
 
-			// Check if it's a provisioning issue and provide specific error messages (legacy)
-			if strings.Contains(err.Error(), "VPN") {
-				statusCode = http.StatusServiceUnavailable
-				slog.Error("Stream failed due to VPN issue", "error", err)
-				http.Error(w, "Service temporarily unavailable: VPN connection required", http.StatusServiceUnavailable)
-				return
-			}
-			if strings.Contains(err.Error(), "circuit breaker") {
-				statusCode = http.StatusServiceUnavailable
-				slog.Error("Stream failed due to circuit breaker", "error", err)
-				http.Error(w, "Service temporarily unavailable: Too many failures, please retry later", http.StatusServiceUnavailable)
-				return
-			}
-			if strings.Contains(err.Error(), "cannot provision") {
-				statusCode = http.StatusServiceUnavailable
-				slog.Error("Stream failed - provisioning blocked", "error", err)
-				http.Error(w, fmt.Sprintf("Service temporarily unavailable: %s", err.Error()), http.StatusServiceUnavailable)
-				return
-			}
+	// The request is pending until the stream is fetched, signaling capacity pressure
+	p.pending.Add(1)
+	fetched := sync.OnceFunc(func() { p.pending.Add(-1) })
+	defer fetched()
 
-			slog.Warn("Failed to select engine from orchestrator, falling back to configured engine", "error", err)
-			selectedHost = p.Acexy.Host
-			selectedPort = p.Acexy.Port
-		} else {
-			selectedHost = host
-			selectedPort = port
-			selectedEngineContainerID = engineContainerID
-			slog.Info("Selected engine from orchestrator", "host", host, "port", port)
+	// Select the best available engine, serving the holding response while it is provisioned
+	var engine selectedEngine
+	held := notHeld
+	selectionStart := time.Now()
+	if pinned != "" {
+		if engine, err = p.Orch.PinnedEngine(pinned); err != nil {
+			statusCode = http.StatusBadRequest
+			slog.Warn("Invalid pinned engine", "stream", aceId, "engine", pinned, "error", err)
+			http.Error(w, "Invalid engine: "+err.Error(), http.StatusBadRequest)
+			return
 		}
+		slog.Info("Stream pinned to engine", "stream", aceId, "container_id", engine.ContainerID)
+	} else if p.Holding != nil {
+		engine, held, err = p.Holding.Select(w, selectCtx, p.Acexy.Endpoint, p.selectEngine)
 	} else {
-		// No orchestrator configured, use the default configured engine
-		selectedHost = p.Acexy.Host
-		selectedPort = p.Acexy.Port
+		engine, err = p.selectEngine(selectCtx)
 	}
+	if held == heldManifest {
+		slog.Info("Served the holding manifest while the engine is provisioned", "stream", aceId)
+		return
+	}
+	if err != nil {
+		statusCode = http.StatusServiceUnavailable
+		if held == heldClip {
+			slog.Error("No engine available after the holding clip", "stream", aceId, "error", err)
+			return
+		}
+		if p.handleSelectionError(w, err) {
+			return
+		}
+		slog.Error("No engine available", "error", err)
+		http.Error(w, "Service temporarily unavailable: no engine available", http.StatusServiceUnavailable)
+		return
+	}
+	engine = p.Orch.VerifyEngineIdentity(engine)
+	p.selectionLatency.Observe(time.Since(selectionStart), reqID)
+	selectedHost := engine.Host
+	selectedPort := engine.Port
+	selectedEngineContainerID := engine.ContainerID
+	target := engine.target(p.Acexy.Scheme)
 
-	// Temporarily update acexy configuration for this request
-	originalHost := p.Acexy.Host
-	originalPort := p.Acexy.Port
-	p.Acexy.Host = selectedHost
-	p.Acexy.Port = selectedPort
-
-	// Restore original configuration after stream handling
-	defer func() {
-		p.Acexy.Host = originalHost
-		p.Acexy.Port = originalPort
-	}()
+	// The client may have left while the engine was being selected or provisioned
+	if err := r.Context().Err(); err != nil {
+		slog.Info("Client disconnected before the stream was fetched", "stream", aceId, "error", err)
+		return
+	}
 
-	// Gather the stream information
-	stream, err := p.Acexy.FetchStream(aceId, q)
+	// Gather the stream information from the selected engine. The shared configuration is
+	// left untouched, as it is the fallback engine of the other requests.
+	stream, err := p.Acexy.FetchStreamFrom(target, aceId, q)
 	if err != nil {
-		statusCode = http.StatusInternalServerError
+		statusCode = fetchErrorStatus(err)
 		slog.Error("Failed to fetch stream", "stream", aceId, "error", err)
 
-		http.Error(w, "Failed to start stream: "+err.Error(), http.StatusInternalServerError)
+		var middlewareErr *acexy.MiddlewareError
+		if errors.As(err, &middlewareErr) {
+			p.BadContent.RecordFailure(aceIDStr, middlewareErr.Message)
+		}
+
+		// The holding clip already started the response, there is no error to send
+		if held != heldClip {
+			http.Error(w, "Failed to start stream: "+err.Error(), statusCode)
+		}
 		return
 	}
+	fetched()
+	p.startLatency.Observe(time.Since(startTime), reqID)
+	p.rewriteEngineURLs(stream, target)
+	stream.ContainerID = selectedEngineContainerID
 
-	// Emit stream started event to orchestrator for internal tracking
-	var streamID string
-	if p.Orch != nil {
-		idType, key := aceId.ID()
-		playbackID := playbackIDFromStat(stream.StatURL)
-		streamID = key + "|" + playbackID
-		orchKeyType := mapAceIDTypeToOrchestrator(idType)
-		
-		slog.Debug("Emitting stream_started event to orchestrator",
-			"stream_id", streamID, "host", selectedHost, "port", selectedPort)
-		
-		p.Orch.EmitStarted(selectedHost, selectedPort, orchKeyType, key,
-			playbackID, stream.StatURL, stream.CommandURL, streamID, selectedEngineContainerID)
+	// Key the stream by the infohash its content ID resolved to, converging both identifiers
+	if p.DedupByResolvedInfohash {
+		if resolved, ok := resolvedAceID(aceId, stream); ok {
+			slog.Debug("Keying stream by its resolved infohash", "stream", aceId, "infohash", stream.Infohash)
+			aceId = resolved
+			aceIDStr = aceId.String()
+		}
 	}
 
-	// Set response headers
-	switch p.Acexy.Endpoint {
-	case acexy.M3U8_ENDPOINT:
-		w.Header().Set("Content-Type", "application/x-mpegURL")
-	case acexy.MPEG_TS_ENDPOINT:
-		w.Header().Set("Content-Type", "video/MP2T")
-		w.Header().Set("Transfer-Encoding", "chunked")
+	// Copy through a multiwriter, which accounts the bytes delivered to the client
+	var clientOut io.Writer = w
+	gz := p.manifestGzip(w, r)
+	if gz != nil {
+		clientOut = gz
 	}
+	clientOut = p.ClientQuota.Writer(clientIP(r), clientOut)
+	out := pmw.New(clientOut)
 
-	// Write headers before starting stream
-	w.WriteHeader(http.StatusOK)
+	// Track the stream while it is being served
+	idType, key := aceId.ID()
+	registered := &activeStream{
+		PlaybackID:  playbackIDFromStat(stream.StatURL),
+		AceID:       aceIDStr,
+		Key:         key,
+		Stream:      stream,
+		EngineHost:  selectedHost,
+		EnginePort:  selectedPort,
+		ContainerID: selectedEngineContainerID,
+		StartedAt:   time.Now(),
+		Client:      clientIP(r),
+		Label:       label,
+		Output:      out,
+		Writer:      clientOut,
+
+		EngineForwarded: engine.Forwarded,
+	}
+	sessionID := registered.PlaybackID
+	playbackID, duplicate := p.streams.Add(registered)
+	if duplicate && p.sharesResolvedSession(sessionID, aceIDStr) {
+		slog.Debug("Sharing the engine session of another stream of the same content",
+			"stream", aceId, "playback_id", sessionID, "host", selectedHost, "port", selectedPort)
+	} else if duplicate {
+		slog.Warn("Engine returned the playback session ID of another active stream",
+			"stream", aceId, "playback_id", sessionID, "host", selectedHost, "port", selectedPort, "refetch", p.RefetchDuplicateSessions)
+
+		// The duplicated session is shared with the other stream, so it is left untouched
+		if p.RefetchDuplicateSessions {
+			if refetched, err := p.Acexy.FetchStreamFrom(target, aceId, q); err != nil {
+				slog.Warn("Failed to refetch the duplicated stream, keeping the shared session", "stream", aceId, "error", err)
+			} else {
+				p.rewriteEngineURLs(refetched, target)
+				refetched.ContainerID = selectedEngineContainerID
+				p.streams.Remove(playbackID)
+				stream = refetched
+				registered.Stream = stream
+				registered.PlaybackID = playbackIDFromStat(stream.StatURL)
+				playbackID, _ = p.streams.Add(registered)
+			}
+		}
+	}
+	defer func() { p.streams.Remove(playbackID) }()
+	p.Orch.RecordServedContent(aceIDStr, selectedEngineContainerID)
+
+	// Report the stream start to the orchestrator and the hooks. Probes are served but not
+	// reported. Streams with fewer concurrent clients than required are reported once a later
+	// client of the same ID reaches them, from its request: the report state is guarded.
+	streamID := key + "|" + playbackID
+	hookEvent := streamHookEvent{
+		StreamID:    streamID,
+		AceID:       aceIDStr,
+		EngineHost:  selectedHost,
+		EnginePort:  selectedPort,
+		ContainerID: selectedEngineContainerID,
+	}
+	var reportMu sync.Mutex
+	var reported, finished bool
+	reportStarted := func() {
+		reported = true
+		startedID := registered.StreamID()
+		if p.Orch != nil {
+			slog.Debug("Emitting stream_started event to orchestrator",
+				"stream_id", startedID, "host", registered.EngineHost, "port", registered.EnginePort)
+
+			startedID = p.Orch.EmitStarted(startedStream{
+				Host:              registered.EngineHost,
+				Port:              registered.EnginePort,
+				KeyType:           mapAceIDTypeToOrchestrator(idType),
+				Key:               key,
+				PlaybackID:        registered.PlaybackID,
+				Stream:            registered.Stream,
+				StreamID:          startedID,
+				EngineContainerID: registered.ContainerID,
+				ClientLabel:       label,
+				RequestID:         reqID,
+			})
+			registered.AssignStreamID(startedID)
+		}
+		hookEvent.StreamID = startedID
+		p.Hooks.StreamStarted(hookEvent)
+		p.Events.StreamStarted(hookEvent)
+	}
+	// finishReport stops any later start report, returning whether the start was reported
+	finishReport := func() bool {
+		reportMu.Lock()
+		defer reportMu.Unlock()
+		finished = true
+		return reported
+	}
+	if r.Header.Get(PROBE_HEADER) != "" {
+		slog.Debug("Probe request, not reporting stream start", "stream_id", streamID)
+	} else if clients := p.streams.CountAceID(aceIDStr); clients < p.MinClientsForEvent {
+		slog.Debug("Not enough clients to report stream start, deferring it",
+			"stream_id", streamID, "clients", clients, "min_clients", p.MinClientsForEvent)
+		p.streams.DeferStart(registered, func() {
+			reportMu.Lock()
+			defer reportMu.Unlock()
+			if !reported && !finished {
+				slog.Debug("Enough clients reached, reporting the deferred stream start", "stream_id", registered.StreamID())
+				reportStarted()
+			}
+		})
+	} else {
+		reportMu.Lock()
+		reportStarted()
+		reportMu.Unlock()
+		streamID = registered.StreamID()
+		if p.MinClientsForEvent > 1 {
+			p.streams.ReportDeferred(aceIDStr)
+		}
+	}
+
+	// Release the engine session right away if the client already left
+	if err := r.Context().Err(); err != nil {
+		slog.Info("Client abandoned the stream before playback", "stream", aceId, "error", err)
+		if finishReport() {
+			p.Orch.EmitEnded(registered.StreamID(), "client_abandoned")
+			hookEvent.Reason = "client_abandoned"
+			p.Hooks.StreamEnded(hookEvent)
+			p.Events.StreamEnded(hookEvent)
+		}
+		if err := acexy.CloseStream(stream); err != nil {
+			slog.Debug("Failed to send stop command to engine", "stream", aceId, "error", err)
+		}
+		return
+	}
+
+	// Set response headers, unless the holding clip already sent them
+	streamCtx := r.Context()
+	if held != heldClip {
+		switch p.Acexy.Endpoint {
+		case acexy.M3U8_ENDPOINT:
+			w.Header().Set("Content-Type", "application/x-mpegURL")
+			if gz != nil {
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Header().Add("Vary", "Accept-Encoding")
+				defer gz.Close()
+			}
+		case acexy.MPEG_TS_ENDPOINT:
+			w.Header().Set("Content-Type", "video/MP2T")
+			if p.ForceChunked {
+				w.Header().Set("Transfer-Encoding", "chunked")
+			}
+		}
+		if p.EnableAux && len(stream.AuxURLs) > 0 {
+			setAuxHeaders(w, playbackID, stream.AuxURLs)
+		}
+		if p.ExposeEngineHeaders && selectedEngineContainerID != "" {
+			w.Header().Set(ENGINE_HEADER, selectedEngineContainerID)
+			w.Header().Set(ENGINE_ADDR_HEADER, net.JoinHostPort(selectedHost, strconv.Itoa(selectedPort)))
+			w.Header().Set(ENGINE_FORWARDED_HEADER, strconv.FormatBool(registered.EngineForwarded))
+		}
+
+		// Write headers before starting stream. In MPEG-TS mode they mirror the framing of
+		// the engine, known once it answers the playback request.
+		if p.Acexy.Endpoint == acexy.MPEG_TS_ENDPOINT && !p.ForceChunked {
+			streamCtx = acexy.WithResponseFunc(streamCtx, mirrorEngineFraming(w))
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+
+	// Keep the engine session warm while streaming, deprioritizing the engine if it stops
+	// answering. It is restarted on each engine session the stream moves to.
+	stopKeepalive := func() {}
+	defer func() { stopKeepalive() }()
+	startKeepalive := func() {
+		stopKeepalive()
+		if p.Keepalive == nil {
+			return
+		}
+		keepaliveCtx, cancel := context.WithCancel(r.Context())
+		stopKeepalive = cancel
+		statURL, sessionID, host, port, containerID := stream.StatURL, streamID, selectedHost, selectedPort, selectedEngineContainerID
+		go p.Keepalive.Run(keepaliveCtx, statURL, func(err error) {
+			slog.Warn("Engine failing keepalive pings", "stream_id", sessionID,
+				"host", host, "port", port, "container_id", containerID, "error", err)
+			p.Orch.MarkEngineFailing(containerID)
+		})
+	}
+	startKeepalive()
+
+	// moveSession moves the stream to the session fetched from another engine once the
+	// current one failed with the given reason. The failed session is ended and stopped, and
+	// the stream is tracked, reported and kept alive under the new one.
+	moveSession := func(next selectedEngine, nextStream *acexy.AceStream, failedReason string) {
+		reportMu.Lock()
+		defer reportMu.Unlock()
+		if reported {
+			p.Orch.EmitEnded(registered.StreamID(), failedReason)
+		}
+		if err := acexy.CloseStream(stream); err != nil {
+			slog.Debug("Failed to send stop command to the failed engine", "stream_id", streamID, "error", err)
+		}
+
+		p.streams.Remove(playbackID)
+		stream = nextStream
+		selectedHost, selectedPort, selectedEngineContainerID = next.Host, next.Port, next.ContainerID
+		registered.Stream = stream
+		registered.PlaybackID = playbackIDFromStat(stream.StatURL)
+		registered.EngineHost, registered.EnginePort, registered.ContainerID = next.Host, next.Port, next.ContainerID
+		registered.EngineForwarded = next.Forwarded
+		playbackID, _ = p.streams.Add(registered)
+		streamID = key + "|" + playbackID
+		registered.AssignStreamID(streamID)
+		if reported {
+			streamID = p.Orch.EmitStarted(startedStream{
+				Host:              selectedHost,
+				Port:              selectedPort,
+				KeyType:           mapAceIDTypeToOrchestrator(idType),
+				Key:               key,
+				PlaybackID:        playbackID,
+				Stream:            stream,
+				StreamID:          streamID,
+				EngineContainerID: selectedEngineContainerID,
+				ClientLabel:       label,
+				RequestID:         reqID,
+			})
+			registered.AssignStreamID(streamID)
+		}
+		hookEvent.StreamID = streamID
+		hookEvent.EngineHost, hookEvent.EnginePort, hookEvent.ContainerID = selectedHost, selectedPort, selectedEngineContainerID
+		startKeepalive()
+	}
+
+	// Select the engine taking the stream over if this one fails
+	standby := p.reserveStandby(selectCtx, engine)
+	defer standby.Release()
 
 	// Start streaming - this blocks until complete or client disconnects
-	slog.Debug("Starting stream", "path", r.URL.Path, "id", aceId)
+	slog.Debug("Starting stream", "path", r.URL.Path, "id", aceId, "request_id", reqID)
 	streamStartTime := time.Now()
-	copier, streamErr := p.Acexy.StartStream(stream, w)
+	copier, streamErr := p.Acexy.StartStreamContext(streamCtx, stream, out)
+
+	// Retry on other engines while the stream fails before the client got any data. The
+	// failed session is ended with its failure, so the orchestrator accounts it to its engine.
+	// An engine stuck before the first byte is always failed over once.
+	for attempt := 1; p.shouldRetryStart(r.Context(), attempt, streamErr, out.Written(clientOut)); attempt++ {
+		failedReason, _ := classifyDisconnectReason(streamErr)
+		slog.Warn("Stream failed to start, retrying on another engine", "stream_id", streamID, "attempt", attempt,
+			"host", selectedHost, "port", selectedPort, "container_id", selectedEngineContainerID, "error", streamErr)
+		p.Orch.MarkEngineFailing(selectedEngineContainerID)
+
+		next, err := p.Orch.SelectBestEngineContext(withExcludedEngine(selectCtx, selectedEngineContainerID))
+		if err != nil {
+			slog.Error("No engine to retry the stream on", "stream_id", streamID, "error", err)
+			break
+		}
+		next = p.Orch.VerifyEngineIdentity(next)
+		nextStream, err := p.fetchFromEngine(next, aceId, q)
+		if err != nil {
+			slog.Error("Failed to fetch the stream from the retry engine", "stream_id", streamID,
+				"container_id", next.ContainerID, "error", err)
+			break
+		}
+
+		moveSession(next, nextStream, failedReason)
+
+		slog.Info("Retrying the stream on another engine", "stream_id", streamID,
+			"host", selectedHost, "port", selectedPort, "container_id", selectedEngineContainerID)
+		copier, streamErr = p.Acexy.StartStreamContext(streamCtx, stream, out)
+	}
+
+	// Continue the response from the standby engine when the primary one fails mid-stream
+	var failedOverBytes int64
+	if standby != nil && failoverNeeded(r.Context(), streamErr) {
+		slog.Warn("Engine failed mid-stream, failing over to the standby engine", "stream_id", streamID,
+			"host", selectedHost, "port", selectedPort, "container_id", selectedEngineContainerID, "error", streamErr)
+		p.Orch.MarkEngineFailing(selectedEngineContainerID)
+
+		if next, nextStream, err := p.fetchFromStandby(r.Context(), standby, aceId, q); err != nil {
+			slog.Error("Failover to the standby engine failed", "stream_id", streamID, "error", err)
+		} else {
+			failedReason, _ := classifyDisconnectReason(streamErr)
+			if copier != nil {
+				failedOverBytes = copier.BytesCopied()
+			}
+			moveSession(next, nextStream, failedReason)
+			slog.Info("Stream taken over by the standby engine", "stream_id", streamID,
+				"host", selectedHost, "port", selectedPort, "container_id", selectedEngineContainerID)
+			copier, streamErr = p.Acexy.StartStreamContext(streamCtx, stream, out)
+		}
+	}
+
+	// The stream is no longer served, a start report still deferred is dropped
+	startReported := finishReport()
+	streamID = registered.StreamID()
+
+	// Tell the viewer the stream is gone for good, if still there
+	if failoverNeeded(r.Context(), streamErr) {
+		p.ErrorClip.Serve(w, p.Acexy.Endpoint)
+	}
 	streamDuration := time.Since(streamStartTime)
 	
 	// Determine reason for stream ending and classify the error
@@ -237,12 +787,16 @@ func (p *Proxy) HandleStream(w http.ResponseWriter, r *http.Request) {
 	if copier != nil {
 		bytesCopied = copier.BytesCopied()
 	}
+	bytesCopied += failedOverBytes
 	
 	if streamErr != nil {
 		slog.Error("Failed to stream", "stream", aceId, "error", streamErr, "bytes_copied", bytesCopied, "duration", streamDuration)
 		
 		// Classify the error to determine appropriate reason with more detail
-		reason, detailedReason = classifyDisconnectReason(streamErr)
+		reason, detailedReason = classifyStreamEnd(streamErr, streamDuration, p.EarlyEOFThreshold)
+		if registered.cancelled.Load() {
+			reason, detailedReason = "orchestrator_cancelled", "orchestrator marked the stream as cancelled"
+		}
 		
 		// Log detailed disconnect information in debug mode
 		debugLog.LogDisconnect(streamID, aceIDStr, reason, streamErr.Error(), bytesCopied, streamDuration, map[string]interface{}{
@@ -251,6 +805,21 @@ func (p *Proxy) HandleStream(w http.ResponseWriter, r *http.Request) {
 			"engine_port":     selectedPort,
 			"container_id":    selectedEngineContainerID,
 		})
+	} else if bytesCopied == 0 && !p.AllowNoDataCompletion {
+		// The engine closed the stream cleanly without sending anything, a failure in disguise
+		slog.Warn("Stream ended without sending any data", "stream", aceId, "duration", streamDuration,
+			"host", selectedHost, "port", selectedPort, "container_id", selectedEngineContainerID)
+		reason = "no_data"
+		detailedReason = "engine closed the stream without sending any data"
+		p.noData.Add(1)
+		p.Orch.MarkEngineFailing(selectedEngineContainerID)
+
+		debugLog.LogDisconnect(streamID, aceIDStr, reason, "", bytesCopied, streamDuration, map[string]interface{}{
+			"detailed_reason": detailedReason,
+			"engine_host":     selectedHost,
+			"engine_port":     selectedPort,
+			"container_id":    selectedEngineContainerID,
+		})
 	} else {
 		// Stream completed successfully
 		slog.Debug("Stream completed", "path", r.URL.Path, "id", aceId, "bytes_copied", bytesCopied, "duration", streamDuration)
@@ -266,12 +835,51 @@ func (p *Proxy) HandleStream(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 	
+	// Tell the streams ended by the shutdown apart, whether they drained in time or not
+	switch {
+	case registered.terminated.Load():
+		reason = "shutdown_forced"
+	case p.ShuttingDown():
+		reason = "shutdown_drained"
+	}
+
+	// Credit the engine with the stream it served fine, the standby when it took it over
+	if engineEndedFine(reason) && bytesCopied > failedOverBytes {
+		p.Orch.RecordEngineSuccess(selectedEngineContainerID)
+	}
+
+	p.disconnects.Record(reason)
+	p.history.Record(endedStream{
+		StreamID:    streamID,
+		AceID:       aceIDStr,
+		Client:      registered.Client,
+		Label:       label,
+		EngineHost:  selectedHost,
+		EnginePort:  selectedPort,
+		ContainerID: selectedEngineContainerID,
+		StartedAt:   registered.StartedAt,
+		EndedAt:     time.Now(),
+		Duration:    streamDuration,
+		BytesSent:   bytesCopied,
+		Reason:      reason,
+		PeakClients: p.streams.PeakClients(playbackID),
+	})
+
+	// Report the stream end to the hooks
+	if startReported {
+		hookEvent.Reason = reason
+		p.Hooks.StreamEnded(hookEvent)
+		p.Events.StreamEnded(hookEvent)
+	}
+
 	// Emit stream_ended event to orchestrator and send stop command to engine
-	if p.Orch != nil && streamID != "" {
-		slog.Debug("Stream ending, emitting stream_ended event",
-			"stream_id", streamID, "reason", reason)
-		p.Orch.EmitEnded(streamID, reason)
-		
+	if p.Orch != nil {
+		if startReported {
+			slog.Debug("Stream ending, emitting stream_ended event",
+				"stream_id", streamID, "reason", reason)
+			p.Orch.EmitEnded(streamID, reason)
+		}
+
 		// Send stop command to AceStream engine to clean up resources
 		if err := acexy.CloseStream(stream); err != nil {
 			slog.Debug("Failed to send stop command to engine", 
@@ -280,6 +888,19 @@ func (p *Proxy) HandleStream(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// fetchErrorStatus returns the status answering a failed stream fetch: a 404 when the engine
+// does not know the stream, a 502 for the other engine HTTP errors and a 500 otherwise
+func fetchErrorStatus(err error) int {
+	var httpErr *acexy.EngineHTTPError
+	if !errors.As(err, &httpErr) {
+		return http.StatusInternalServerError
+	}
+	if httpErr.StatusCode == http.StatusNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
 // handleProvisioningError handles structured provisioning errors and returns user-friendly responses
 func (p *Proxy) handleProvisioningError(w http.ResponseWriter, err *ProvisioningError) {
 	details := err.Details
@@ -307,6 +928,14 @@ func (p *Proxy) handleProvisioningError(w http.ResponseWriter, err *Provisioning
 		userMessage = "Service at capacity: Please try again in a moment"
 	case "vpn_error":
 		userMessage = "Service temporarily unavailable: VPN error during provisioning"
+	case ALL_RECOVERING_CODE:
+		userMessage = "Service temporarily unavailable: All engines are recovering"
+	case PROVISION_RATE_LIMITED_CODE:
+		userMessage = "Service temporarily unavailable: Too many provisioning attempts, please retry later"
+	case ORCH_RATE_LIMITED_CODE:
+		userMessage = "Service temporarily unavailable: Orchestrator is rate limiting requests, please retry later"
+	case PROVISION_LIMIT_CODE:
+		userMessage = "Service at capacity: Too many engines being provisioned, please try again in a moment"
 	default:
 		userMessage = "Service temporarily unavailable: " + details.Message
 	}
@@ -319,14 +948,72 @@ func (p *Proxy) handleProvisioningError(w http.ResponseWriter, err *Provisioning
 	})
 }
 
-func (p *Proxy) HandleStatus(w http.ResponseWriter, r *http.Request) {
-	// Verify the request method
-	if r.Method != http.MethodGet {
-		slog.Error("Method not allowed", "method", r.Method, "path", r.URL.Path)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// selectEngine picks the engine the stream is fetched from: through the fallback chain when
+// configured, or from the orchestrator, falling back to the configured engine unless
+// provisioning is blocked. Without orchestrator, the configured engine is used.
+func (p *Proxy) selectEngine(ctx context.Context) (selectedEngine, error) {
+	configured := selectedEngine{Host: p.Acexy.Host, Port: p.Acexy.Port}
+
+	if p.Fallback != nil {
+		// Walk the configured fallback chain, failing only when every hop does
+		engine, err := p.Fallback.Select(ctx, p.Orch, &p.streams, p.Acexy.Scheme)
+		if err != nil {
+			return selectedEngine{}, err
+		}
+		slog.Info("Selected engine from fallback chain", "host", engine.Host, "port", engine.Port, "scheme", engine.Scheme)
+		return engine, nil
+	}
+	if p.Orch == nil {
+		return configured, nil
+	}
+
+	engine, err := p.Orch.SelectBestEngineContext(ctx)
+	if err != nil {
+		// Provisioning is blocked, so the request fails instead of using the fallback engine
+		if provisioningBlocked(err) {
+			return selectedEngine{}, err
+		}
+		slog.Warn("Failed to select engine from orchestrator, falling back to configured engine", "error", err)
+		return configured, nil
+	}
+	slog.Info("Selected engine from orchestrator", "host", engine.Host, "port", engine.Port, "scheme", engine.Scheme)
+	return engine, nil
+}
+
+// provisioningBlocked reports whether the selection failed because the orchestrator cannot
+// provision engines, as opposed to a failure reaching it
+func provisioningBlocked(err error) bool {
+	var provErr *ProvisioningError
+	return errors.As(err, &provErr) ||
+		errors.Is(err, ErrVPNDisconnected) ||
+		errors.Is(err, ErrCircuitBreakerOpen) ||
+		errors.Is(err, ErrProvisioningBlocked)
+}
+
+// handleSelectionError writes the response for the engine selection errors caused by the
+// orchestrator being unable to provision engines. Returns false, without writing anything,
+// for any other error.
+func (p *Proxy) handleSelectionError(w http.ResponseWriter, err error) bool {
+	var provErr *ProvisioningError
+	switch {
+	case errors.As(err, &provErr):
+		p.handleProvisioningError(w, provErr)
+	case errors.Is(err, ErrVPNDisconnected):
+		slog.Error("Stream failed due to VPN issue", "error", err)
+		http.Error(w, "Service temporarily unavailable: VPN connection required", http.StatusServiceUnavailable)
+	case errors.Is(err, ErrCircuitBreakerOpen):
+		slog.Error("Stream failed due to circuit breaker", "error", err)
+		http.Error(w, "Service temporarily unavailable: Too many failures, please retry later", http.StatusServiceUnavailable)
+	case errors.Is(err, ErrProvisioningBlocked):
+		slog.Error("Stream failed - provisioning blocked", "error", err)
+		http.Error(w, fmt.Sprintf("Service temporarily unavailable: %s", err.Error()), http.StatusServiceUnavailable)
+	default:
+		return false
 	}
+	return true
+}
 
+func (p *Proxy) HandleStatus(w http.ResponseWriter, r *http.Request) {
 	// In stateless mode, just return basic health status
 	_, err := p.Acexy.GetStatus(nil)
 	if err != nil {
@@ -335,11 +1022,39 @@ func (p *Proxy) HandleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Return simple health check
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]any{
-		"status": "ok",
-	})
+	// The bare status is kept by default for compatibility, the health summary is opt-in.
+	// Each client gets its own engine session, so streams counts the distinct IDs served.
+	streams, clients := p.streams.CountIDs(), p.streams.Len()
+	verbose := p.VerboseStatus || r.URL.Query().Get("verbose") == "1"
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		// Return simple health check
+		status := map[string]any{"status": "ok"}
+		if verbose {
+			status["streams"] = streams
+			status["clients"] = clients
+			status["draining"] = p.Draining()
+			if p.Orch != nil {
+				status["orchestrator_reachable"] = p.Orch.HealthSnapshot().Reachable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status)
+	case "text":
+		// Plain key=value lines for constrained clients that cannot parse JSON
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "status=ok")
+		if verbose {
+			fmt.Fprintf(w, "streams=%d\nclients=%d\n", streams, clients)
+			fmt.Fprintf(w, "draining=%t\n", p.Draining())
+			if p.Orch != nil {
+				fmt.Fprintf(w, "orchestrator_reachable=%t\n", p.Orch.HealthSnapshot().Reachable)
+			}
+		}
+	default:
+		http.Error(w, "Unsupported format: "+format, http.StatusBadRequest)
+	}
 }
 
 func (s *Size) Set(value string) error {
@@ -355,74 +1070,506 @@ func (s *Size) String() string { return humanize.Bytes(s.Bytes) }
 
 func (s *Size) Get() any { return s.Bytes }
 
-func parseArgs() {
+// parseArgs builds the configuration from the command line flags, overridden by the
+// environment variables when present
+func parseArgs() Config {
+	var cfg Config
+
 	// Parse the command-line arguments
-	flag.StringVar(&addr, "addr", "127.0.0.1:6878", "Server address")
-	flag.StringVar(&scheme, "scheme", "http", "AceStream scheme")
-	flag.StringVar(&host, "host", "127.0.0.1", "AceStream host (fallback when orchestrator not configured)")
-	flag.IntVar(&port, "port", 6878, "AceStream port (fallback when orchestrator not configured)")
-	flag.DurationVar(&streamTimeout, "timeout", 60*time.Second, "Stream timeout (M3U8 mode)")
-	flag.BoolVar(&m3u8, "m3u8", false, "M3U8 mode")
-	flag.DurationVar(&emptyTimeout, "emptyTimeout", 10*time.Second, "Empty timeout (no data copied)")
-	flag.DurationVar(&noResponseTimeout, "noResponseTimeout", 20*time.Second, "Timeout to receive first response byte from engine")
-	flag.IntVar(&maxStreamsPerEngine, "maxStreamsPerEngine", 1, "Maximum streams per engine when using orchestrator")
-	flag.BoolVar(&debugMode, "debugMode", false, "Enable debug mode with detailed logging")
-	flag.StringVar(&debugLogDir, "debugLogDir", "./debug_logs", "Directory for debug logs")
-	flag.Var(&size, "buffer", "Buffer size for copying (e.g. 1MiB)")
-	size.Default = 1 << 20
+	flag.StringVar(&cfg.Addr, "addr", "127.0.0.1:6878", "Server address")
+	flag.StringVar(&cfg.TLSCert, "tlsCert", "", "TLS certificate file (enables HTTPS together with -tlsKey)")
+	flag.StringVar(&cfg.TLSKey, "tlsKey", "", "TLS private key file (enables HTTPS together with -tlsCert)")
+	flag.DurationVar(&cfg.ReadHeaderTimeout, "readHeaderTimeout", DEFAULT_READ_HEADER_TIMEOUT, "Time clients are given to send the request headers")
+	flag.DurationVar(&cfg.IdleTimeout, "idleTimeout", DEFAULT_IDLE_TIMEOUT, "Time an idle keep-alive connection is kept open")
+	flag.StringVar(&cfg.RedirectHTTP, "redirectHTTP", "", "Address of a plain HTTP listener redirecting to HTTPS, e.g. :80 (requires TLS)")
+	flag.StringVar(&cfg.Scheme, "scheme", "http", "AceStream scheme")
+	flag.StringVar(&cfg.Host, "host", "127.0.0.1", "AceStream host (fallback when orchestrator not configured)")
+	flag.IntVar(&cfg.Port, "port", 6878, "AceStream port (fallback when orchestrator not configured)")
+	flag.DurationVar(&cfg.StreamTimeout, "timeout", 60*time.Second, "Stream timeout (M3U8 mode)")
+	flag.BoolVar(&cfg.M3U8, "m3u8", false, "M3U8 mode")
+	flag.BoolVar(&cfg.ForceChunked, "forceChunked", false, "Always send MPEG-TS responses chunked, instead of keeping the Content-Length of finite (VOD) streams the engine sends with one")
+	flag.BoolVar(&cfg.CompressManifest, "compressManifest", false, "Gzip the M3U8 manifest for clients sending Accept-Encoding: gzip (M3U8 mode only)")
+	flag.DurationVar(&cfg.EmptyTimeout, "emptyTimeout", 10*time.Second, "Empty timeout (no data copied)")
+	flag.DurationVar(&cfg.NoResponseTimeout, "noResponseTimeout", 20*time.Second, "Timeout to receive first response byte from engine")
+	flag.IntVar(&cfg.Orch.MaxStreamsPerEngine, "maxStreamsPerEngine", 1, "Maximum streams per engine when using orchestrator")
+	flag.IntVar(&cfg.Orch.EndedStreamsCap, "endedStreamsCap", DEFAULT_ENDED_STREAMS_CAP, "Ended streams remembered so their stream_ended event is sent once, the oldest are forgotten past it")
+	flag.IntVar(&cfg.Orch.SelectionRetries, "selectionRetries", 0, "Retries, with backoff, of the orchestrator queries failing with a 5xx during the engine selection (0 disables)")
+	flag.BoolVar(&cfg.Orch.FailReadyOnAuth, "failReadyOnOrchAuth", false, "Fail /readyz while the orchestrator rejects the API key")
+	flag.IntVar(&cfg.Orch.MinReadyEngines, "minReadyEngines", 1, "Healthy orchestrator engines with a free stream slot required for /readyz to succeed, unless new ones can be provisioned")
+	flag.IntVar(&cfg.Orch.MinClientsForEvent, "minClientsForEvent", 1, "Concurrent clients of the same ID before stream_started is emitted to the orchestrator")
+	flag.BoolVar(&cfg.DebugMode, "debugMode", false, "Enable debug mode with detailed logging")
+	flag.StringVar(&cfg.LogFormat, "logFormat", LOG_FORMAT_TEXT, "Format of the logs: text (key=value) or json (one object per line)")
+	flag.StringVar(&cfg.DebugLogDir, "debugLogDir", "./debug_logs", "Directory for debug logs")
+	flag.StringVar(&cfg.DebugSink, "debugSink", "", "URL the debug logs are periodically uploaded to with gzip compressed PUTs, deleting them locally once uploaded. The uploads are not signed, so only plain PUT endpoints work, not S3 buckets requiring SigV4 (empty keeps them local)")
+	flag.StringVar(&cfg.AdminToken, "adminToken", "", "Token required to access the admin endpoints (empty leaves them open)")
+	flag.IntVar(&cfg.BadContentThreshold, "badContentThreshold", 3, "Middleware errors for the same ID before it is temporarily blocked")
+	flag.DurationVar(&cfg.BadContentWindow, "badContentWindow", 1*time.Minute, "Window in which middleware errors are counted towards the threshold")
+	flag.DurationVar(&cfg.BadContentTTL, "badContentTTL", 30*time.Second, "How long a repeatedly failing ID is blocked (0 disables)")
+	flag.StringVar(&cfg.DenyList, "denyList", "", "Content IDs or infohashes rejected with a 403, comma separated or @file with one per line")
+	flag.StringVar(&cfg.AllowList, "allowList", "", "Only content IDs or infohashes streamed, comma separated or @file with one per line (empty allows all)")
+	flag.Float64Var(&cfg.RateLimit, "rateLimit", 0, "Stream requests per second allowed for each client IP (0 disables)")
+	flag.IntVar(&cfg.MaxStreamsPerClient, "maxStreamsPerClient", 0, "Streams each client IP may have open at once, further requests get a 429 (0 is unbounded)")
+	flag.IntVar(&cfg.RateLimitBurst, "rateLimitBurst", 5, "Stream requests a client IP may burst above the rate limit")
+	flag.StringVar(&cfg.FallbackChain, "fallbackChain", "", "Ordered engine sources, e.g. orchestrator,10.0.0.5:6878,10.0.0.6:6878 (empty uses the orchestrator, then -host/-port)")
+	flag.DurationVar(&cfg.FallbackHopTimeout, "fallbackHopTimeout", 5*time.Second, "Time each static engine hop of the fallback chain is given to provide an engine (0 leaves it bound by the request only)")
+	flag.DurationVar(&cfg.FallbackOrchTimeout, "fallbackOrchestratorTimeout", 0, "Time the orchestrator hop of the fallback chain is given to provide an engine, cancelling its provision when exceeded (0 leaves it bound by the request only, as provisioning takes a while)")
+	flag.StringVar(&cfg.OnStreamStart, "onStreamStart", "", "Command run when a stream starts (stream details in ACEXY_* environment variables)")
+	flag.StringVar(&cfg.OnStreamEnd, "onStreamEnd", "", "Command run when a stream ends (stream details in ACEXY_* environment variables)")
+	flag.DurationVar(&cfg.KeepaliveInterval, "keepaliveInterval", 0, "Interval at which the stat URL of active streams is polled to keep engine sessions warm (0 disables)")
+	flag.BoolVar(&cfg.AllowNoDataCompletion, "allowNoDataCompletion", false, "Report streams the engine ends cleanly without sending any data as completed instead of no_data, which also deprioritizes the engine")
+	flag.DurationVar(&cfg.EarlyEOFThreshold, "earlyEOFThreshold", 0, "Streams ending with an EOF before being served this long are reported as early_eof, likely an engine startup problem (0 disables)")
+	flag.DurationVar(&cfg.HookTimeout, "hookTimeout", 10*time.Second, "Time after which a stream hook is killed")
+	flag.StringVar(&cfg.EventSinkURL, "eventSinkURL", "", "Comma separated message queues stream events are published to as JSON, e.g. redis://:password@redis:6379?channel=acexy:events (empty disables)")
+	flag.BoolVar(&cfg.ProvisionHoldingResponse, "provisionHoldingResponse", false, "Serve a placeholder instead of a 503 while an engine is provisioned")
+	flag.StringVar(&cfg.ErrorClip, "errorClip", "", "MPEG-TS clip (e.g. stream unavailable) written to clients whose stream fails, before closing the response (MPEG-TS mode only)")
+	flag.StringVar(&cfg.ProvisionHoldingClip, "provisionHoldingClip", "", "MPEG-TS clip looped as placeholder while an engine is provisioned (requires -provisionHoldingResponse)")
+	flag.StringVar(&cfg.IDPrecedence, "idPrecedence", string(acexy.STRICT_PRECEDENCE), "Which of id and infohash is used when a request gives both: id, infohash, or strict to reject conflicting values")
+	flag.BoolVar(&cfg.IgnoreClientPID, "ignoreClientPID", false, "Drop the pid parameter sent by clients instead of rejecting the request, using the generated one")
+	flag.BoolVar(&cfg.OpenMetricsExemplars, "openmetricsExemplars", false, "Serve /metrics in the OpenMetrics format to scrapers asking for it, attaching the request ID of an example request to the latency histogram buckets")
+	flag.BoolVar(&cfg.VerboseStatus, "verboseStatus", false, "Always include the stream and client counts, the drain mode and the orchestrator reachability in /ace/status, otherwise only returned with ?verbose=1")
+	flag.BoolVar(&cfg.HideRoot, "hideRoot", false, "Return a 404 at / instead of the license, which stays available at /license")
+	flag.BoolVar(&cfg.SignalDrain, "signalDrain", false, "Toggle the drain mode on SIGUSR1 and log the active streams on SIGUSR2")
+	flag.DurationVar(&cfg.ShutdownGrace, "shutdownGrace", 0, "Time the active streams are given to finish on SIGINT/SIGTERM before they are terminated (0 terminates them right away)")
+	flag.BoolVar(&cfg.EnableAux, "enableAux", false, "Relay auxiliary middleware resources (subtitles, thumbnails) through /ace/aux")
+	flag.Var(&cfg.BufferSize, "buffer", "Buffer size for copying (e.g. 1MiB)")
+	flag.Var(&cfg.BufferSize, "readBuffer", "Size of the reads from the engine, same as -buffer (e.g. 1MiB)")
+	flag.Var(&cfg.WriteChunk, "writeChunk", "Maximum bytes written to the clients at once, smaller chunks lowering the latency (e.g. 64KiB, defaults to the read buffer)")
+	flag.IntVar(&cfg.MaxStreamWorkers, "maxStreamWorkers", 0, "Maximum streams copied at once, the rest wait for a free worker (0 is unbounded)")
+	flag.Var(&cfg.ClientByteQuota, "clientByteQuota", "Bytes each client IP may receive within the quota window before it is disconnected, e.g. 2GiB (0 disables)")
+	flag.DurationVar(&cfg.ClientByteQuotaWindow, "clientByteQuotaWindow", 24*time.Hour, "Window the client byte quota applies to, the bytes received by a client being counted anew once it elapsed (0 never resets them)")
+	flag.BoolVar(&cfg.AllowEngineRedirects, "allowEngineRedirects", false, "Follow engine redirects of the stream requests to other hosts, logging them (by default only redirects within the engine host are followed)")
+	flag.StringVar(&cfg.APIPrefix, "apiPrefix", "", "Path prepended to the AceStream middleware endpoints, for non-standard engine builds (e.g. /hls)")
+	flag.BoolVar(&cfg.RefetchDuplicateSessions, "refetchDuplicateSessions", false, "Fetch streams again when the engine returns the playback session ID of another active stream")
+	flag.BoolVar(&cfg.DedupByResolvedInfohash, "dedupByResolvedInfohash", false, "Key streams requested by content ID by the infohash the engine resolves it to, so both identifiers share one stream")
+	flag.StringVar(&cfg.InboundRequestIDHeader, "inboundRequestIdHeader", "", "Header the request ID of the caller is adopted from, e.g. X-Request-Id, instead of generating one. It is returned in X-Request-Id and sent in the orchestrator events")
+	flag.StringVar(&cfg.RegionHeader, "regionHeader", "", "Header the preferred engine region is read from when ?region= is not given (e.g. CF-IPCountry)")
+	flag.DurationVar(&cfg.Orch.HealthMaxStaleness, "healthMaxStaleness", 2*time.Minute, "Age after which the orchestrator health is considered unknown and provisioning is not attempted (0 disables)")
+	flag.BoolVar(&cfg.Orch.ProbeEngineVersion, "probeEngineVersion", false, "Probe the AceStream version of each engine on its first use, reporting it in the selection logs and /admin/engines")
+	flag.BoolVar(&cfg.AllowEnginePinning, "allowEnginePinning", false, "Allow clients to pin a stream to an orchestrator engine with the engine query parameter, for debugging")
+	flag.BoolVar(&cfg.ExposeEngineHeaders, "exposeEngineHeaders", false, "Report the container and address of the engine chosen by the orchestrator in the X-Acexy-Engine and X-Acexy-Engine-Addr response headers")
+	flag.BoolVar(&cfg.RewriteEngineURLs, "rewriteEngineURLs", false, "Rewrite the host of the stat and command URLs reported by the engine to the engine host acexy used")
+	flag.IntVar(&cfg.StartRetries, "startRetries", 0, "Other orchestrator engines a stream is retried on when it fails before the client got any data (0 disables)")
+	flag.DurationVar(&cfg.ResolveCacheTTL, "resolveCacheTTL", 0, "Time the addresses the engine host names (e.g. container names) resolve to are cached, refreshed early when connecting to them fails (0 resolves them on each connection)")
+	flag.DurationVar(&cfg.FirstByteFailoverTimeout, "firstByteFailoverTimeout", 0, "Time an engine has to send the first byte of a stream before it is marked as failing and the stream moves to another engine, instead of waiting for noResponseTimeout (0 disables)")
+	flag.BoolVar(&cfg.WarmStandby, "warmStandby", false, "Select a standby engine when a stream starts, taking the stream over if the primary engine fails (it holds no stream slot, and its selection may provision an extra engine)")
+	flag.StringVar(&cfg.Orch.InstanceID, "instanceID", "", "ID of this acexy instance, included in every orchestrator event and /admin/summary (a random UUID when empty)")
+	flag.BoolVar(&cfg.Orch.BatchEvents, "batchEvents", false, "Coalesce the stream started and ended events over a short window, sending them in order to the orchestrator /events/batch endpoint (falls back to one request per event if missing)")
+	flag.BoolVar(&cfg.Orch.PropagateEngineLabels, "propagateEngineLabels", false, "Add the labels of the selected engine (region, tenant...) to the stream_started events sent to the orchestrator")
+	flag.BoolVar(&cfg.Orch.CostAwareSelection, "costAwareSelection", false, "Prefer engines with a lower acexy.cost label until they are full, before balancing by load")
+	flag.DurationVar(&cfg.Orch.NewEngineProbation, "newEngineProbation", 0, "Quarantine a newly provisioned engine failing its first stream within this time from its provision, instead of selecting it again (0 disables)")
+	flag.BoolVar(&cfg.Orch.RespectClusterCapacity, "respectClusterCapacity", false, "Refuse new streams and provisions while the orchestrator reports the cluster capacity exhausted, even when an engine still looks free")
+	flag.BoolVar(&cfg.Orch.ClusterDedup, "clusterDedup", false, "Prefer the engine the orchestrator lists as already streaming the requested content, for any acexy instance, instead of starting another P2P session elsewhere")
+	flag.BoolVar(&cfg.Orch.PreferWarmCache, "preferWarmCache", false, "Prefer the engine that last served a content when it is requested again, as it likely still has it cached")
+	flag.IntVar(&cfg.Orch.MaxConcurrentProvisions, "maxConcurrentProvisions", 0, "Maximum engines provisioned at once, further selections wait for a free slot (0 is unbounded)")
+	flag.BoolVar(&cfg.Orch.EagerRecoverProvision, "eagerRecoverProvision", false, "Provision an engine as soon as the orchestrator can provision again after being blocked (e.g. VPN down), if none is free, so the next client finds one ready")
+	flag.IntVar(&cfg.Orch.MaxProvisionAttemptsPerMinute, "maxProvisionAttemptsPerMinute", 0, "Maximum provisioning attempts per minute across all requests, further selections fail right away (0 is unbounded)")
+	flag.BoolVar(&cfg.Orch.CancelOrphanProvisions, "cancelOrphanProvisions", false, "Ask the orchestrator to remove engines provisioned for clients that are gone, instead of leaving them orphaned")
+	flag.StringVar(&cfg.Orch.CancelProvisionPath, "cancelProvisionPath", DEFAULT_CANCEL_PROVISION_PATH, "Orchestrator endpoint called with DELETE to remove an orphan provisioned engine, {id} is replaced by its container ID")
+	flag.BoolVar(&cfg.Orch.VerifyEngineIdentity, "verifyEngineIdentity", false, "Check with the orchestrator that an engine is still the selected container before using it, re-resolving engines recycled at the same host and port")
+	flag.DurationVar(&cfg.Orch.EngineStreamCacheTTL, "engineStreamCacheTTL", 0, "How long the active stream count of each engine is cached during the engine selection (0 queries every engine each time)")
+	flag.DurationVar(&cfg.Orch.ReconcileInterval, "reconcileInterval", 0, "Interval at which the streams listed by the orchestrator are reconciled with the served ones (0 disables)")
+	flag.DurationVar(&cfg.StuckThreshold, "stuckThreshold", 0, "Time a stream may deliver no data to its connected client before it is reported as stuck, with a stream_stuck orchestrator event (0 disables)")
+	flag.DurationVar(&cfg.Orch.CancelPollInterval, "cancelPollInterval", 0, "Interval at which the orchestrator is asked for the streams it cancelled, stopping the served ones (0 disables)")
+	flag.Var(&cfg.Orch.LabelSelector, "engineLabelSelector", "Only use engines with these labels, also set on provisioned engines (e.g. team=media,env=prod)")
+	cfg.BufferSize.Default = 1 << 20
 
 	// Actually parse the command line flags
 	flag.Parse()
 
 	// Env overrides
 	if v := os.Getenv("ACEXY_ADDR"); v != "" {
-		addr = v
+		cfg.Addr = v
 	}
 	if v := os.Getenv("ACEXY_SCHEME"); v != "" {
-		scheme = v
+		cfg.Scheme = v
 	}
 	if v := os.Getenv("ACEXY_HOST"); v != "" {
-		host = v
+		cfg.Host = v
 	}
 	if v := os.Getenv("ACEXY_PORT"); v != "" {
 		if p, err := strconv.Atoi(v); err == nil {
-			port = p
+			cfg.Port = p
 		}
 	}
 	if v := os.Getenv("ACEXY_TIMEOUT"); v != "" {
 		if d, err := time.ParseDuration(v); err == nil {
-			streamTimeout = d
+			cfg.StreamTimeout = d
 		}
 	}
 	if v := os.Getenv("ACEXY_M3U8"); v != "" {
-		m3u8 = v == "1" || v == "true" || v == "TRUE"
+		cfg.M3U8 = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_FORCE_CHUNKED"); v != "" {
+		cfg.ForceChunked = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_COMPRESS_MANIFEST"); v != "" {
+		cfg.CompressManifest = v == "1" || v == "true" || v == "TRUE"
 	}
 	if v := os.Getenv("ACEXY_EMPTY_TIMEOUT"); v != "" {
 		if d, err := time.ParseDuration(v); err == nil {
-			emptyTimeout = d
+			cfg.EmptyTimeout = d
 		}
 	}
 	if v := os.Getenv("ACEXY_NO_RESPONSE_TIMEOUT"); v != "" {
 		if d, err := time.ParseDuration(v); err == nil {
-			noResponseTimeout = d
+			cfg.NoResponseTimeout = d
 		}
 	}
 	if v := os.Getenv("ACEXY_BUFFER"); v != "" {
 		if s, err := humanize.ParseBytes(v); err == nil {
-			size.Bytes = s
+			cfg.BufferSize.Bytes = s
+		}
+	}
+	if v := os.Getenv("ACEXY_READ_BUFFER"); v != "" {
+		if s, err := humanize.ParseBytes(v); err == nil {
+			cfg.BufferSize.Bytes = s
+		}
+	}
+	if v := os.Getenv("ACEXY_WRITE_CHUNK"); v != "" {
+		if s, err := humanize.ParseBytes(v); err == nil {
+			cfg.WriteChunk.Bytes = s
+		}
+	}
+	if v := os.Getenv("ACEXY_MAX_STREAM_WORKERS"); v != "" {
+		if m, err := strconv.Atoi(v); err == nil && m >= 0 {
+			cfg.MaxStreamWorkers = m
+		}
+	}
+	if v := os.Getenv("ACEXY_CLIENT_BYTE_QUOTA"); v != "" {
+		if s, err := humanize.ParseBytes(v); err == nil {
+			cfg.ClientByteQuota.Bytes = s
+		}
+	}
+	if v := os.Getenv("ACEXY_CLIENT_BYTE_QUOTA_WINDOW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.ClientByteQuotaWindow = d
 		}
 	}
 	if v := os.Getenv("ACEXY_MAX_STREAMS_PER_ENGINE"); v != "" {
 		if m, err := strconv.Atoi(v); err == nil && m > 0 {
-			maxStreamsPerEngine = m
+			cfg.Orch.MaxStreamsPerEngine = m
+		}
+	}
+	if v := os.Getenv("ACEXY_ENDED_STREAMS_CAP"); v != "" {
+		if m, err := strconv.Atoi(v); err == nil && m > 0 {
+			cfg.Orch.EndedStreamsCap = m
+		}
+	}
+	if v := os.Getenv("ACEXY_ENGINE_LABEL_SELECTOR"); v != "" {
+		// Using engines of other tenants is not an option, so an invalid selector is fatal
+		if err := cfg.Orch.LabelSelector.Set(v); err != nil {
+			slog.Error("Invalid ACEXY_ENGINE_LABEL_SELECTOR", "error", err)
+			os.Exit(1)
+		}
+	}
+	if v := os.Getenv("ACEXY_HEALTH_MAX_STALENESS"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Orch.HealthMaxStaleness = d
+		}
+	}
+	if v := os.Getenv("ACEXY_PROBE_ENGINE_VERSION"); v != "" {
+		cfg.Orch.ProbeEngineVersion = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_REWRITE_ENGINE_URLS"); v != "" {
+		cfg.RewriteEngineURLs = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_ALLOW_ENGINE_PINNING"); v != "" {
+		cfg.AllowEnginePinning = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_EXPOSE_ENGINE_HEADERS"); v != "" {
+		cfg.ExposeEngineHeaders = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_WARM_STANDBY"); v != "" {
+		cfg.WarmStandby = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_START_RETRIES"); v != "" {
+		if m, err := strconv.Atoi(v); err == nil && m >= 0 {
+			cfg.StartRetries = m
+		}
+	}
+	if v := os.Getenv("ACEXY_RESOLVE_CACHE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.ResolveCacheTTL = d
+		}
+	}
+	if v := os.Getenv("ACEXY_FIRST_BYTE_FAILOVER_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.FirstByteFailoverTimeout = d
+		}
+	}
+	if v := os.Getenv("ACEXY_PREFER_WARM_CACHE"); v != "" {
+		cfg.Orch.PreferWarmCache = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_RESPECT_CLUSTER_CAPACITY"); v != "" {
+		cfg.Orch.RespectClusterCapacity = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_CLUSTER_DEDUP"); v != "" {
+		cfg.Orch.ClusterDedup = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_COST_AWARE_SELECTION"); v != "" {
+		cfg.Orch.CostAwareSelection = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_BATCH_EVENTS"); v != "" {
+		cfg.Orch.BatchEvents = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_PROPAGATE_ENGINE_LABELS"); v != "" {
+		cfg.Orch.PropagateEngineLabels = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_MAX_CONCURRENT_PROVISIONS"); v != "" {
+		if m, err := strconv.Atoi(v); err == nil && m >= 0 {
+			cfg.Orch.MaxConcurrentProvisions = m
+		}
+	}
+	if v := os.Getenv("ACEXY_MAX_PROVISION_ATTEMPTS_PER_MINUTE"); v != "" {
+		if m, err := strconv.Atoi(v); err == nil && m >= 0 {
+			cfg.Orch.MaxProvisionAttemptsPerMinute = m
+		}
+	}
+	if v := os.Getenv("ACEXY_EAGER_RECOVER_PROVISION"); v != "" {
+		cfg.Orch.EagerRecoverProvision = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_CANCEL_ORPHAN_PROVISIONS"); v != "" {
+		cfg.Orch.CancelOrphanProvisions = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_CANCEL_PROVISION_PATH"); v != "" {
+		cfg.Orch.CancelProvisionPath = v
+	}
+	if v := os.Getenv("ACEXY_ENGINE_STREAM_CACHE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Orch.EngineStreamCacheTTL = d
+		}
+	}
+	if v := os.Getenv("ACEXY_NEW_ENGINE_PROBATION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Orch.NewEngineProbation = d
+		}
+	}
+	if v := os.Getenv("ACEXY_VERIFY_ENGINE_IDENTITY"); v != "" {
+		cfg.Orch.VerifyEngineIdentity = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_RECONCILE_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Orch.ReconcileInterval = d
+		}
+	}
+	if v := os.Getenv("ACEXY_STUCK_THRESHOLD"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.StuckThreshold = d
+		}
+	}
+	if v := os.Getenv("ACEXY_CANCEL_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Orch.CancelPollInterval = d
+		}
+	}
+	if v := os.Getenv("ACEXY_MIN_CLIENTS_FOR_EVENT"); v != "" {
+		if m, err := strconv.Atoi(v); err == nil && m > 0 {
+			cfg.Orch.MinClientsForEvent = m
+		}
+	}
+	if v := os.Getenv("ACEXY_SELECTION_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.Orch.SelectionRetries = n
+		}
+	}
+	if v := os.Getenv("ACEXY_FAIL_READY_ON_ORCH_AUTH"); v != "" {
+		cfg.Orch.FailReadyOnAuth = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_MIN_READY_ENGINES"); v != "" {
+		if m, err := strconv.Atoi(v); err == nil && m > 0 {
+			cfg.Orch.MinReadyEngines = m
 		}
 	}
+	if v := os.Getenv("ACEXY_LOG_FORMAT"); v != "" {
+		cfg.LogFormat = v
+	}
 	if v := os.Getenv("DEBUG_MODE"); v != "" {
-		debugMode = v == "1" || v == "true" || v == "TRUE"
+		cfg.DebugMode = v == "1" || v == "true" || v == "TRUE"
 	}
 	if v := os.Getenv("DEBUG_LOG_DIR"); v != "" {
-		debugLogDir = v
+		cfg.DebugLogDir = v
+	}
+	if v := os.Getenv("DEBUG_SINK"); v != "" {
+		cfg.DebugSink = v
+	}
+	if v := os.Getenv("ACEXY_ADMIN_TOKEN"); v != "" {
+		cfg.AdminToken = v
+	}
+	if v := os.Getenv("ACEXY_BAD_CONTENT_THRESHOLD"); v != "" {
+		if t, err := strconv.Atoi(v); err == nil {
+			cfg.BadContentThreshold = t
+		}
+	}
+	if v := os.Getenv("ACEXY_BAD_CONTENT_WINDOW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.BadContentWindow = d
+		}
+	}
+	if v := os.Getenv("ACEXY_BAD_CONTENT_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.BadContentTTL = d
+		}
+	}
+	if v := os.Getenv("ACEXY_DENY_LIST"); v != "" {
+		cfg.DenyList = v
+	}
+	if v := os.Getenv("ACEXY_ALLOW_LIST"); v != "" {
+		cfg.AllowList = v
+	}
+	if v := os.Getenv("ACEXY_RATE_LIMIT"); v != "" {
+		if l, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.RateLimit = l
+		}
+	}
+	if v := os.Getenv("ACEXY_MAX_STREAMS_PER_CLIENT"); v != "" {
+		if m, err := strconv.Atoi(v); err == nil && m >= 0 {
+			cfg.MaxStreamsPerClient = m
+		}
+	}
+	if v := os.Getenv("ACEXY_RATE_LIMIT_BURST"); v != "" {
+		if b, err := strconv.Atoi(v); err == nil {
+			cfg.RateLimitBurst = b
+		}
+	}
+	if v := os.Getenv("ACEXY_TLS_CERT"); v != "" {
+		cfg.TLSCert = v
+	}
+	if v := os.Getenv("ACEXY_TLS_KEY"); v != "" {
+		cfg.TLSKey = v
+	}
+	if v := os.Getenv("ACEXY_REDIRECT_HTTP"); v != "" {
+		cfg.RedirectHTTP = v
+	}
+	if v := os.Getenv("ACEXY_READ_HEADER_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.ReadHeaderTimeout = d
+		}
+	}
+	if v := os.Getenv("ACEXY_IDLE_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.IdleTimeout = d
+		}
+	}
+	if v := os.Getenv("ACEXY_FALLBACK_CHAIN"); v != "" {
+		cfg.FallbackChain = v
+	}
+	if v := os.Getenv("ACEXY_FALLBACK_HOP_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.FallbackHopTimeout = d
+		}
+	}
+	if v := os.Getenv("ACEXY_FALLBACK_ORCHESTRATOR_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.FallbackOrchTimeout = d
+		}
+	}
+	if v := os.Getenv("ACEXY_ON_STREAM_START"); v != "" {
+		cfg.OnStreamStart = v
+	}
+	if v := os.Getenv("ACEXY_ON_STREAM_END"); v != "" {
+		cfg.OnStreamEnd = v
+	}
+	if v := os.Getenv("ACEXY_HOOK_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.HookTimeout = d
+		}
+	}
+	if v := os.Getenv("ACEXY_EVENT_SINK_URL"); v != "" {
+		cfg.EventSinkURL = v
+	}
+
+	if v := os.Getenv("ACEXY_KEEPALIVE_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.KeepaliveInterval = d
+		}
+	}
+	if v := os.Getenv("ACEXY_EARLY_EOF_THRESHOLD"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.EarlyEOFThreshold = d
+		}
+	}
+	if v := os.Getenv("ACEXY_ALLOW_NO_DATA_COMPLETION"); v != "" {
+		cfg.AllowNoDataCompletion = v == "1" || v == "true" || v == "TRUE"
+	}
+
+	if v := os.Getenv("ACEXY_PROVISION_HOLDING_RESPONSE"); v != "" {
+		cfg.ProvisionHoldingResponse = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_PROVISION_HOLDING_CLIP"); v != "" {
+		cfg.ProvisionHoldingClip = v
+	}
+	if v := os.Getenv("ACEXY_ERROR_CLIP"); v != "" {
+		cfg.ErrorClip = v
+	}
+
+	if v := os.Getenv("ACEXY_ENABLE_AUX"); v != "" {
+		cfg.EnableAux = v == "1" || v == "true" || v == "TRUE"
+	}
+
+	if v := os.Getenv("ACEXY_HIDE_ROOT"); v != "" {
+		cfg.HideRoot = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_OPENMETRICS_EXEMPLARS"); v != "" {
+		cfg.OpenMetricsExemplars = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_VERBOSE_STATUS"); v != "" {
+		cfg.VerboseStatus = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_SIGNAL_DRAIN"); v != "" {
+		cfg.SignalDrain = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_SHUTDOWN_GRACE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.ShutdownGrace = d
+		}
+	}
+	if v := os.Getenv("ACEXY_IGNORE_CLIENT_PID"); v != "" {
+		cfg.IgnoreClientPID = v == "1" || v == "true" || v == "TRUE"
+	}
+
+	if v := os.Getenv("ACEXY_REFETCH_DUPLICATE_SESSIONS"); v != "" {
+		cfg.RefetchDuplicateSessions = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_DEDUP_BY_RESOLVED_INFOHASH"); v != "" {
+		cfg.DedupByResolvedInfohash = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_REGION_HEADER"); v != "" {
+		cfg.RegionHeader = v
+	}
+	if v := os.Getenv("ACEXY_INBOUND_REQUEST_ID_HEADER"); v != "" {
+		cfg.InboundRequestIDHeader = v
+	}
+
+	if v := os.Getenv("ACEXY_API_PREFIX"); v != "" {
+		cfg.APIPrefix = v
+	}
+	if v := os.Getenv("ACEXY_ALLOW_ENGINE_REDIRECTS"); v != "" {
+		cfg.AllowEngineRedirects = v == "1" || v == "true" || v == "TRUE"
+	}
+	if v := os.Getenv("ACEXY_ID_PRECEDENCE"); v != "" {
+		cfg.IDPrecedence = v
+	}
+	if _, err := acexy.ParseIDPrecedence(cfg.IDPrecedence); err != nil {
+		slog.Error("Invalid ID precedence", "error", err)
+		os.Exit(1)
+	}
+	prefix, err := normalizeAPIPrefix(cfg.APIPrefix)
+	if err != nil {
+		slog.Error("Invalid API prefix", "error", err)
+		os.Exit(1)
+	}
+	cfg.APIPrefix = prefix
+	cfg.BufferSize.Bytes = normalizeBufferSize(cfg.BufferSize.Bytes, cfg.Endpoint())
+	cfg.WriteChunk.Bytes = normalizeBufferSize(cfg.WriteChunk.Bytes, cfg.Endpoint())
+
+	// Orchestrator settings are only read from the environment
+	cfg.Orch.URL = os.Getenv("ACEXY_ORCH_URL")
+	cfg.Orch.APIKey = os.Getenv("ACEXY_ORCH_APIKEY")
+	cfg.Orch.ContainerID = os.Getenv("ACEXY_CONTAINER_ID")
+	if v := os.Getenv("ACEXY_INSTANCE_ID"); v != "" {
+		cfg.Orch.InstanceID = v
 	}
+	return cfg
 }
 
 func LookupLogLevel() slog.Level {
@@ -443,60 +1590,73 @@ func LookupLogLevel() slog.Level {
 
 func main() {
 	// Parse the command-line arguments
-	parseArgs()
-	slog.SetLogLoggerLevel(LookupLogLevel())
+	cfg := parseArgs()
+	handler, err := newLogHandler(os.Stderr, cfg.LogFormat, LookupLogLevel())
+	if err != nil {
+		slog.Error("Invalid log format", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(slog.New(handler))
 	slog.Debug("CLI Args", "args", flag.CommandLine)
 
 	// Initialize debug logger
-	debug.InitDebugLogger(debugMode, debugLogDir)
-	if debugMode {
-		slog.Info("Debug mode enabled", "log_dir", debugLogDir)
-	}
-
-	var endpoint acexy.AcexyEndpoint
-	if m3u8 {
-		endpoint = acexy.M3U8_ENDPOINT
-	} else {
-		endpoint = acexy.MPEG_TS_ENDPOINT
-	}
-
-	// Create orchestrator client
-	orchURL := os.Getenv("ACEXY_ORCH_URL")
-	var orchClient *orchClient
-	if orchURL != "" {
-		orchClient = newOrchClient(orchURL)
-		orchClient.SetMaxStreamsPerEngine(maxStreamsPerEngine)
-		slog.Info("Orchestrator integration enabled", "url", orchURL, "max_streams_per_engine", maxStreamsPerEngine)
-	} else {
-		slog.Info("Orchestrator integration disabled - using fallback engine configuration", "host", host, "port", port)
-	}
-
-	// Create a new Acexy instance
-	acexy := &acexy.Acexy{
-		Scheme:            scheme,
-		Host:              host,
-		Port:              port,
-		Endpoint:          endpoint,
-		EmptyTimeout:      emptyTimeout,
-		BufferSize:        int(size.Get().(uint64)),
-		NoResponseTimeout: noResponseTimeout,
+	debug.InitDebugLogger(cfg.DebugMode, cfg.DebugLogDir)
+	if cfg.DebugMode {
+		slog.Info("Debug mode enabled", "log_dir", cfg.DebugLogDir)
+		if cfg.DebugSink != "" {
+			slog.Info("Shipping debug logs", "sink", redactDebugSink(cfg.DebugSink), "interval", debug.DEFAULT_SHIP_INTERVAL)
+			go debug.GetDebugLogger().StartShipping(context.Background(), cfg.DebugSink, debug.DEFAULT_SHIP_INTERVAL)
+		}
 	}
-	acexy.Init()
 
 	// Create a new HTTP server
-	proxy := &Proxy{Acexy: acexy, Orch: orchClient}
+	proxy := NewProxy(cfg)
+	if cfg.SignalDrain {
+		slog.Info("SIGUSR1 toggles the drain mode, SIGUSR2 logs the active streams")
+		go proxy.handleSignals(context.Background(), notifyControlSignals())
+	}
 	mux := http.NewServeMux()
 	mux.Handle(APIv1_URL+"/getstream", proxy)
 	mux.Handle(APIv1_URL+"/getstream/", proxy)
 	mux.Handle(APIv1_URL+"/status", proxy)
 	mux.Handle("/", proxy) // Let proxy handle all other requests including root
+	srv := newServer(cfg.Addr, methodGuard(mux), cfg)
+
+	// Redirect plain HTTP clients when serving HTTPS
+	if cfg.RedirectHTTP != "" {
+		if !cfg.TLSEnabled() {
+			slog.Warn("Ignoring -redirectHTTP as TLS is not configured")
+		} else {
+			go func() {
+				slog.Info("Redirecting HTTP to HTTPS", "addr", cfg.RedirectHTTP)
+				if err := newServer(cfg.RedirectHTTP, httpsRedirect(cfg.Addr), cfg).ListenAndServe(); err != nil {
+					slog.Error("Failed to start HTTP redirect server", "error", err)
+				}
+			}()
+		}
+	}
 
 	// Start the HTTP server
-	slog.Info("Starting server", "addr", addr)
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	slog.Info("Starting server", "addr", cfg.Addr)
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
 		slog.Error("Failed to start server", "error", err)
 		os.Exit(1)
 	}
+
+	// Drain the streams on SIGINT/SIGTERM, terminating those outliving the grace period
+	shutdown := make(chan struct{})
+	go func() {
+		sig := <-notifyShutdownSignals()
+		slog.Info("Received shutdown signal", "signal", sig)
+		proxy.Shutdown(srv, cfg.ShutdownGrace)
+		close(shutdown)
+	}()
+	if err := serve(srv, ln, cfg); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Failed to start server", "error", err)
+		os.Exit(1)
+	}
+	<-shutdown
 }
 
 // mapAceIDTypeToOrchestrator maps acexy ID types to orchestrator expected types
@@ -571,6 +1731,19 @@ func classifyDisconnectReason(err error) (reason string, detailedReason string)
 	if strings.Contains(errStrLower, "stream empty timeout") {
 		return "empty_timeout", "stream closed due to inactivity (no data received within timeout period)"
 	}
+	if errors.Is(err, acexy.ErrFirstByteTimeout) {
+		return "first_byte_timeout", "engine accepted the stream but sent no data within the first byte timeout"
+	}
+	if errors.Is(err, acexy.ErrStopped) || errors.Is(err, pmw.ErrNoWriters) {
+		return "client_disconnected", "all clients left the stream"
+	}
+	if errors.Is(err, errClientQuotaExceeded) {
+		return "quota_exceeded", "client received all the bytes allowed by its quota"
+	}
+	var redirectErr *acexy.RedirectError
+	if errors.As(err, &redirectErr) {
+		return "engine_redirect", fmt.Sprintf("engine redirected the stream to another host (%s)", redirectErr.To)
+	}
 	
 	// Check for client-side disconnects
 	if strings.Contains(errStrLower, "broken pipe") {
@@ -640,3 +1813,15 @@ func classifyDisconnectReason(err error) (reason string, detailedReason string)
 	// Generic error fallback
 	return "error", fmt.Sprintf("unclassified error: %s", errStr)
 }
+
+// classifyStreamEnd classifies why a stream ended like classifyDisconnectReason, also taking
+// how long it was served into account: an EOF before the early EOF threshold likely comes
+// from an engine failing to start the stream rather than from its normal end, and is
+// reported as `early_eof`. A threshold of 0 disables the distinction.
+func classifyStreamEnd(err error, duration, earlyEOFThreshold time.Duration) (reason string, detailedReason string) {
+	reason, detailedReason = classifyDisconnectReason(err)
+	if reason == "eof" && duration < earlyEOFThreshold {
+		return "early_eof", fmt.Sprintf("%s after %s, likely an engine startup problem", detailedReason, duration.Round(time.Millisecond))
+	}
+	return reason, detailedReason
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io"
 	"testing"
+	"time"
 )
 
 func TestClassifyDisconnectReason_ClientDisconnects(t *testing.T) {
@@ -214,3 +215,33 @@ func TestClassifyDisconnectReason_UnknownError(t *testing.T) {
 		t.Errorf("Expected detail to include error message, got %s", detail)
 	}
 }
+
+func TestClassifyStreamEnd_EarlyEOF(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		duration       time.Duration
+		threshold      time.Duration
+		expectedReason string
+	}{
+		{"short EOF", io.EOF, 500 * time.Millisecond, 2 * time.Second, "early_eof"},
+		{"short unexpected EOF", io.ErrUnexpectedEOF, time.Second, 2 * time.Second, "early_eof"},
+		{"long EOF", io.EOF, 3 * time.Hour, 2 * time.Second, "eof"},
+		{"EOF at the threshold", io.EOF, 2 * time.Second, 2 * time.Second, "eof"},
+		{"short EOF without threshold", io.EOF, 500 * time.Millisecond, 0, "eof"},
+		{"short timeout", errors.New("i/o timeout"), 500 * time.Millisecond, 2 * time.Second, "timeout"},
+		{"short completion", nil, 500 * time.Millisecond, 2 * time.Second, "completed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, detail := classifyStreamEnd(tt.err, tt.duration, tt.threshold)
+			if reason != tt.expectedReason {
+				t.Errorf("Expected reason %s, got %s", tt.expectedReason, reason)
+			}
+			if detail == "" {
+				t.Error("Expected a detailed reason")
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMethodGuardRejectsUnexpectedMethods verifies every known route answers a 405 with the
+// right Allow header when called with a method it does not support
+func TestMethodGuardRejectsUnexpectedMethods(t *testing.T) {
+	handler := methodGuard(&Proxy{})
+
+	tests := []struct {
+		method        string
+		path          string
+		expectedAllow string
+	}{
+		{http.MethodPost, "/ace/getstream", "GET"},
+		{http.MethodDelete, "/ace/getstream/", "GET"},
+		{http.MethodPut, "/ace/status", "GET"},
+		{http.MethodPost, "/metrics", "GET"},
+		{http.MethodPost, "/admin/summary", "GET"},
+		{http.MethodGet, "/admin/orchestrator/refresh", "POST"},
+		{http.MethodPost, "/", "GET"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Errorf("Expected status 405, got %d", rec.Code)
+			}
+			if allow := rec.Header().Get("Allow"); allow != tt.expectedAllow {
+				t.Errorf("Expected Allow header %q, got %q", tt.expectedAllow, allow)
+			}
+		})
+	}
+}
+
+// TestMethodGuardPassesAllowedMethods verifies allowed methods and unknown routes reach the proxy
+func TestMethodGuardPassesAllowedMethods(t *testing.T) {
+	handler := methodGuard(&Proxy{})
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for GET /metrics, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/unknown", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown route, got %d", rec.Code)
+	}
+}
+
+// TestMethodGuardLimitsRequestBody verifies request bodies above the limit cannot be read
+func TestMethodGuardLimitsRequestBody(t *testing.T) {
+	var readErr error
+	handler := methodGuard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+
+	body := strings.NewReader(strings.Repeat("a", maxRequestBodyBytes+1))
+	req := httptest.NewRequest(http.MethodPost, "/admin/orchestrator/refresh", body)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if readErr == nil {
+		t.Error("Expected an error when reading a body above the limit")
+	}
+}
+
+// TestHideRoot verifies the root returns a 404 when hidden, while the license is still
+// served at /license
+func TestHideRoot(t *testing.T) {
+	proxy := &Proxy{HideRoot: true}
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a hidden root, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), LICENSE) {
+		t.Error("Expected the hidden root not to reveal the license")
+	}
+
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/license", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), LICENSE) {
+		t.Errorf("Expected the license at /license, got %d", rec.Code)
+	}
+
+	proxy.HideRoot = false
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(rec.Body.String(), LICENSE) {
+		t.Error("Expected the license at the root by default")
+	}
+}
+
+// TestHandleStreamEngineHTTPError verifies an engine HTTP error on getstream is mapped to the
+// client status: a 404 when the engine does not know the stream, a 502 otherwise
+func TestHandleStreamEngineHTTPError(t *testing.T) {
+	for engineStatus, expected := range map[int]int{
+		http.StatusNotFound:            http.StatusNotFound,
+		http.StatusInternalServerError: http.StatusBadGateway,
+	} {
+		engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "engine error page", engineStatus)
+		}))
+
+		engineURL, _ := url.Parse(engine.URL)
+		acexyInst := &acexy.Acexy{
+			Scheme:            engineURL.Scheme,
+			Host:              engineURL.Hostname(),
+			Port:              parsePort(engineURL.Port()),
+			Endpoint:          acexy.MPEG_TS_ENDPOINT,
+			NoResponseTimeout: 5 * time.Second,
+		}
+		acexyInst.Init()
+
+		proxy := &Proxy{Acexy: acexyInst}
+		rec := httptest.NewRecorder()
+		proxy.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+		engine.Close()
+
+		if rec.Code != expected {
+			t.Errorf("Engine status %d: expected %d, got %d", engineStatus, expected, rec.Code)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, fmt.Sprintf("engine returned HTTP status %d", engineStatus)) {
+			t.Errorf("Engine status %d: expected a clear error, got %q", engineStatus, body)
+		}
+	}
+}
+
+// TestIgnoreClientPID verifies a client PID is rejected by default, and dropped in favour of
+// the generated one when allowed
+func TestIgnoreClientPID(t *testing.T) {
+	var enginePID string
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enginePID = r.URL.Query().Get("pid")
+		w.Write([]byte(`{"response": null, "error": "unknown content"}`))
+	}))
+	defer engine.Close()
+
+	engineURL, _ := url.Parse(engine.URL)
+	acexyInst := &acexy.Acexy{
+		Scheme:            engineURL.Scheme,
+		Host:              engineURL.Hostname(),
+		Port:              parsePort(engineURL.Port()),
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+
+	proxy := &Proxy{Acexy: acexyInst}
+	rec := httptest.NewRecorder()
+	proxy.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123&pid=upstream", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 in strict mode, got %d", rec.Code)
+	}
+	if enginePID != "" {
+		t.Errorf("Expected the rejected request not to reach the engine")
+	}
+
+	proxy.IgnoreClientPID = true
+	rec = httptest.NewRecorder()
+	proxy.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123&pid=upstream", nil))
+	if rec.Code == http.StatusBadRequest {
+		t.Errorf("Expected the client PID to be ignored, got status 400: %s", rec.Body.String())
+	}
+	if enginePID == "" || enginePID == "upstream" {
+		t.Errorf("Expected the engine to get the generated PID, got %q", enginePID)
+	}
+}
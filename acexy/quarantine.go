@@ -0,0 +1,136 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Time a new engine failing its first stream is kept out of the selection
+const ENGINE_QUARANTINE_TTL = 10 * time.Minute
+
+// Orchestrator endpoint told about the quarantined engines
+const ENGINE_QUARANTINED_PATH = "/events/engine_quarantined"
+
+// quarantineEvent tells the orchestrator an engine it provisioned looks broken from the start
+type quarantineEvent struct {
+	ContainerID       string `json:"container_id,omitempty"`
+	InstanceID        string `json:"instance_id,omitempty"`
+	EngineContainerID string `json:"engine_container_id"`
+	AgeSeconds        int    `json:"age_seconds"` // Time from its provision to the failure
+	QuarantineSeconds int    `json:"quarantine_seconds"`
+}
+
+// engineProbation watches the engines acexy provisioned until they serve a stream. An engine
+// failing its first stream within the probation is likely broken from the start, and having
+// no history it would keep being selected, so it is quarantined instead of only being
+// deprioritized like other failing engines.
+type engineProbation struct {
+	window time.Duration
+
+	mu          sync.Mutex
+	provisioned map[string]time.Time // Engines on probation, by provision time
+	quarantined map[string]time.Time // Quarantined engines, until the given time
+}
+
+// newEngineProbation creates the probation of the new engines. Returns nil (disabled) when
+// the window is not positive.
+func newEngineProbation(window time.Duration) *engineProbation {
+	if window <= 0 {
+		return nil
+	}
+	return &engineProbation{
+		window:      window,
+		provisioned: make(map[string]time.Time),
+		quarantined: make(map[string]time.Time),
+	}
+}
+
+// Provisioned puts the freshly provisioned engine on probation
+func (p *engineProbation) Provisioned(containerID string) {
+	if p == nil || containerID == "" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for id, at := range p.provisioned {
+		if now.Sub(at) > p.window {
+			delete(p.provisioned, id)
+		}
+	}
+	p.provisioned[containerID] = now
+}
+
+// Succeeded ends the probation of the engine, which proved it works
+func (p *engineProbation) Succeeded(containerID string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.provisioned, containerID)
+}
+
+// Failed quarantines the engine when it is still on probation. Returns the time since it
+// was provisioned and whether it was quarantined.
+func (p *engineProbation) Failed(containerID string) (time.Duration, bool) {
+	if p == nil {
+		return 0, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	at, ok := p.provisioned[containerID]
+	if !ok {
+		return 0, false
+	}
+	delete(p.provisioned, containerID)
+	age := time.Since(at)
+	if age > p.window {
+		return age, false
+	}
+	p.quarantined[containerID] = time.Now().Add(ENGINE_QUARANTINE_TTL)
+	return age, true
+}
+
+// Quarantined reports whether the engine is quarantined
+func (p *engineProbation) Quarantined(containerID string) bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	until, ok := p.quarantined[containerID]
+	if ok && time.Now().After(until) {
+		delete(p.quarantined, containerID)
+		return false
+	}
+	return ok
+}
+
+// quarantineIfNew quarantines the failing engine when it failed its first stream within the
+// probation, telling the orchestrator
+func (c *orchClient) quarantineIfNew(containerID string) {
+	age, quarantined := c.probation.Failed(containerID)
+	if !quarantined {
+		return
+	}
+
+	slog.Warn("New engine failed its first stream, quarantining it",
+		"container_id", containerID, "age", age.Round(time.Second), "quarantine", ENGINE_QUARANTINE_TTL)
+	c.post(ENGINE_QUARANTINED_PATH, quarantineEvent{
+		ContainerID:       c.containerID,
+		InstanceID:        c.instanceID,
+		EngineContainerID: containerID,
+		AgeSeconds:        int(age.Seconds()),
+		QuarantineSeconds: int(ENGINE_QUARANTINE_TTL.Seconds()),
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestNewEngineQuarantine verifies a freshly provisioned engine failing its first stream is
+// quarantined rather than selected again once the failure no longer deprioritizes it, and the
+// orchestrator is told, while an engine failing outside its probation is not quarantined
+func TestNewEngineQuarantine(t *testing.T) {
+	quarantined := make(chan quarantineEvent, 10)
+	var provisions atomic.Int32
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-old", Host: "host-old", Port: 8001, HealthStatus: "healthy"},
+				{ContainerID: "engine-new", Host: "host-new", Port: 8002, HealthStatus: "healthy"},
+			})
+		case "/streams":
+			streams := []streamState{}
+			if r.URL.Query().Get("container_id") == "engine-old" {
+				streams = append(streams, streamState{ID: "abc|p1", Key: "abc", Status: "started"})
+			}
+			json.NewEncoder(w).Encode(streams)
+		case "/provision/acestream":
+			provisions.Add(1)
+			json.NewEncoder(w).Encode(aceProvisionResponse{ContainerID: "engine-new", HostHTTPPort: 8002})
+		case ENGINE_QUARANTINED_PATH:
+			var event quarantineEvent
+			json.NewDecoder(r.Body).Decode(&event)
+			quarantined <- event
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer orch.Close()
+
+	newClient := func(probation time.Duration) *orchClient {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		return &orchClient{
+			base:                orch.URL,
+			maxStreamsPerEngine: 2,
+			hc:                  &http.Client{Timeout: 3 * time.Second},
+			ctx:                 ctx,
+			cancel:              cancel,
+			endedStreams:        make(map[string]bool),
+			probation:           newEngineProbation(probation),
+		}
+	}
+	// Provisions engine-new, has it fail its first stream and lets the failing mark expire
+	failNewEngine := func(client *orchClient, age time.Duration) {
+		t.Helper()
+		if _, err := client.ProvisionWithRetryContext(context.Background(), 1); err != nil {
+			t.Fatalf("Unexpected provision error: %v", err)
+		}
+		if age > 0 {
+			client.probation.provisioned["engine-new"] = time.Now().Add(-age)
+		}
+		client.MarkEngineFailing("engine-new")
+		client.inflight.Wait()
+		client.failingEnginesMu.Lock()
+		client.failingEngines["engine-new"] = time.Now()
+		client.failingEnginesMu.Unlock()
+	}
+	selected := func(client *orchClient) string {
+		t.Helper()
+		engine, err := client.SelectBestEngine()
+		if err != nil {
+			t.Fatalf("Unexpected selection error: %v", err)
+		}
+		return engine.ContainerID
+	}
+
+	// Failing within the probation, quarantined and skipped
+	client := newClient(time.Minute)
+	failNewEngine(client, 0)
+	if got := selected(client); got != "engine-old" {
+		t.Errorf("Expected the quarantined engine to be skipped, got %s", got)
+	}
+	select {
+	case event := <-quarantined:
+		if event.EngineContainerID != "engine-new" || event.QuarantineSeconds != int(ENGINE_QUARANTINE_TTL.Seconds()) {
+			t.Errorf("Unexpected quarantine event %+v", event)
+		}
+	default:
+		t.Error("Expected the orchestrator to be told about the quarantine")
+	}
+
+	// Failing after the probation, selected again as the least loaded engine
+	client = newClient(time.Minute)
+	failNewEngine(client, 2*time.Minute)
+	if got := selected(client); got != "engine-new" {
+		t.Errorf("Expected engine-new once its probation passed, got %s", got)
+	}
+
+	// Having served a stream fine, a later failure is not quarantined
+	client = newClient(time.Minute)
+	if _, err := client.ProvisionWithRetryContext(context.Background(), 1); err != nil {
+		t.Fatalf("Unexpected provision error: %v", err)
+	}
+	client.RecordEngineSuccess("engine-new")
+	client.MarkEngineFailing("engine-new")
+	if client.probation.Quarantined("engine-new") {
+		t.Error("Expected an engine that served a stream not to be quarantined")
+	}
+
+	// Disabled
+	client = newClient(0)
+	failNewEngine(client, 0)
+	if got := selected(client); got != "engine-new" {
+		t.Errorf("Expected engine-new without the probation, got %s", got)
+	}
+	client.inflight.Wait()
+	if len(quarantined) != 0 {
+		t.Errorf("Expected no more quarantine events, got %d", len(quarantined))
+	}
+	if got := provisions.Load(); got != 4 {
+		t.Errorf("Expected 4 provisions, got %d", got)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRateLimitBurst verifies stream requests beyond the burst are rejected with 429 and a
+// Retry-After header, while other clients and the admin routes are not affected
+func TestRateLimitBurst(t *testing.T) {
+	proxy := &Proxy{RateLimit: newRateLimiter(0.5, 2)}
+
+	request := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Requests without an ID are rejected before reaching any engine, but still count
+	for i := 0; i < 2; i++ {
+		if rec := request("/ace/getstream", "10.0.0.1:1234"); rec.Code != http.StatusBadRequest {
+			t.Fatalf("Request %d: expected status 400, got %d", i+1, rec.Code)
+		}
+	}
+
+	rec := request("/ace/getstream", "10.0.0.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 past the burst, got %d", rec.Code)
+	}
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("Expected Retry-After 2, got %q", retryAfter)
+	}
+
+	if rec := request("/ace/getstream", "10.0.0.2:1234"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected other clients not to be limited, got status %d", rec.Code)
+	}
+	if rec := request("/admin/summary", "10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("Expected admin routes not to be limited, got status %d", rec.Code)
+	}
+}
+
+// TestRateLimitDisabled verifies a nil rate limiter allows every request
+func TestRateLimitDisabled(t *testing.T) {
+	limiter := newRateLimiter(0, 1)
+	if limiter != nil {
+		t.Fatal("Expected a nil rate limiter for a zero rate")
+	}
+	for i := 0; i < 10; i++ {
+		if ok, _ := limiter.Allow("10.0.0.1"); !ok {
+			t.Fatalf("Request %d was limited by a disabled rate limiter", i+1)
+		}
+	}
+}
+
+// TestRateLimitPrune verifies refilled buckets are dropped, scanning them at most once per
+// refill interval
+func TestRateLimitPrune(t *testing.T) {
+	limiter := newRateLimiter(10, 1)
+	limiter.Allow("10.0.0.1")
+	limiter.Allow("10.0.0.2")
+
+	// Refilled, but only dropped once the refill interval elapsed since the last scan
+	limiter.lastPrune = time.Now()
+	limiter.buckets["10.0.0.1"].lastSeen = time.Now().Add(-time.Second)
+	limiter.Allow("10.0.0.3")
+	if len(limiter.buckets) != 3 {
+		t.Fatalf("Expected no scan within the refill interval, got %d buckets", len(limiter.buckets))
+	}
+
+	limiter.lastPrune = time.Now().Add(-time.Second)
+	limiter.Allow("10.0.0.4")
+	if _, ok := limiter.buckets["10.0.0.1"]; ok || len(limiter.buckets) != 3 {
+		t.Errorf("Expected only the refilled bucket to be dropped, got %v", limiter.buckets)
+	}
+}
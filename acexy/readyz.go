@@ -0,0 +1,99 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// readiness is the result of a readiness check
+type readiness struct {
+	Ready          bool   `json:"ready"`
+	HealthyEngines int    `json:"healthy_engines"`
+	MinEngines     int    `json:"min_engines"`
+	CanProvision   bool   `json:"can_provision"`
+	OrchAuthBroken bool   `json:"orchestrator_auth_broken"`
+	Error          string `json:"error,omitempty"`
+}
+
+// HandleReadyz reports whether acexy has streaming headroom, so load balancers only send it
+// traffic it can serve: at least the minimum ready engines must be healthy with a free
+// stream slot, unless the orchestrator can provision new ones. Without orchestrator, it is
+// ready as long as an engine can be reached, as `/healthz`.
+func (p *Proxy) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	ready := p.checkReadiness()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(ready)
+}
+
+// checkReadiness reports whether acexy can take new streams
+func (p *Proxy) checkReadiness() readiness {
+	ready := p.checkEngines()
+	if p.Draining() {
+		ready.Ready = false
+		ready.Error = "draining"
+	}
+	return ready
+}
+
+// checkEngines counts the engines able to take a stream
+func (p *Proxy) checkEngines() readiness {
+	ready := readiness{MinEngines: max(p.MinReadyEngines, 1)}
+	if p.Orch == nil {
+		health := p.checkEngineHealth()
+		ready.Ready, ready.Error = health.Healthy, health.Error
+		if health.Healthy {
+			ready.HealthyEngines = 1
+		}
+		return ready
+	}
+
+	healthy, err := p.Orch.ReadyEngines()
+	if err != nil {
+		ready.Error = err.Error()
+	}
+	ready.HealthyEngines = healthy
+	ready.CanProvision, _ = p.Orch.CanProvision()
+	ready.Ready = healthy >= ready.MinEngines || ready.CanProvision
+	if !ready.Ready {
+		slog.Debug("Not ready, too few healthy engines with capacity",
+			"healthy_engines", healthy, "min_engines", ready.MinEngines, "error", err)
+	}
+
+	// The engines listed may be stale while the orchestrator rejects the API key
+	ready.OrchAuthBroken = p.Orch.AuthBroken()
+	if ready.OrchAuthBroken && p.FailReadyOnOrchAuth {
+		slog.Debug("Not ready, the orchestrator rejects the API key")
+		ready.Ready = false
+		ready.Error = "orchestrator rejects the API key"
+	}
+	return ready
+}
+
+// ReadyEngines returns how many engines matching the label selector are healthy and have
+// a free stream slot, according to the streams listed with the cached engines
+func (c *orchClient) ReadyEngines() (int, error) {
+	engines, err := c.GetEngines()
+	if err != nil {
+		return 0, err
+	}
+
+	ready := 0
+	for _, engine := range engines {
+		if !c.labelSelector.Matches(engine.Labels) || engine.HealthStatus != "healthy" || c.engineFailing(engine.ContainerID) {
+			continue
+		}
+		if len(engine.Streams) < c.maxStreamsPerEngine {
+			ready++
+		}
+	}
+	return ready, nil
+}
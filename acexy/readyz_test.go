@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getReadyz(t *testing.T, proxy *Proxy) (int, readiness) {
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var ready readiness
+	if err := json.NewDecoder(rec.Body).Decode(&ready); err != nil {
+		t.Fatalf("Failed to decode readiness: %v", err)
+	}
+	return rec.Code, ready
+}
+
+// TestReadyzMinEngines verifies acexy is only ready with enough healthy engines with a free
+// stream slot, or when new engines can be provisioned
+func TestReadyzMinEngines(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &orchClient{
+		base:                "http://test",
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		engineCache: []engineState{
+			{ContainerID: "engine-idle", HealthStatus: "healthy"},
+			{ContainerID: "engine-full", HealthStatus: "healthy", Streams: []string{"s1"}},
+			{ContainerID: "engine-unhealthy", HealthStatus: "unhealthy"},
+		},
+		engineCacheTime:     time.Now(),
+		engineCacheDuration: time.Hour,
+	}
+	proxy := &Proxy{Orch: client, MinReadyEngines: 2}
+
+	code, ready := getReadyz(t, proxy)
+	if code != http.StatusServiceUnavailable || ready.Ready {
+		t.Errorf("Expected not to be ready with 1 of 2 engines, got %d: %+v", code, ready)
+	}
+	if ready.HealthyEngines != 1 || ready.MinEngines != 2 {
+		t.Errorf("Expected 1 healthy engine of the 2 required, got %+v", ready)
+	}
+
+	// Provisioning makes up for the missing engines
+	client.health.mu.Lock()
+	client.health.canProvision = true
+	client.health.mu.Unlock()
+	if code, ready := getReadyz(t, proxy); code != http.StatusOK || !ready.Ready {
+		t.Errorf("Expected to be ready when engines can be provisioned, got %d: %+v", code, ready)
+	}
+
+	// Enough engines without provisioning
+	client.health.mu.Lock()
+	client.health.canProvision = false
+	client.health.mu.Unlock()
+	proxy.MinReadyEngines = 1
+	if code, ready := getReadyz(t, proxy); code != http.StatusOK || !ready.Ready {
+		t.Errorf("Expected to be ready with 1 of 1 engines, got %d: %+v", code, ready)
+	}
+}
+
+// TestReadyzWithoutOrchestrator verifies readiness follows the engine connectivity
+func TestReadyzWithoutOrchestrator(t *testing.T) {
+	engine := httptest.NewServer(http.NotFoundHandler())
+	defer engine.Close()
+
+	if code, ready := getReadyz(t, newHealthzTestProxy(engine.URL)); code != http.StatusOK || !ready.Ready {
+		t.Errorf("Expected to be ready with a reachable engine, got %d: %+v", code, ready)
+	}
+}
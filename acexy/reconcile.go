@@ -0,0 +1,78 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// reconciler periodically compares the streams acexy serves with those the orchestrator
+// lists for this container. Streams the orchestrator still lists although acexy no longer
+// serves them (e.g. their `stream_ended` event was lost) are ended again, so the
+// orchestrator accounting heals itself.
+type reconciler struct {
+	interval time.Duration
+	stale    atomic.Uint64 // Streams ended because the orchestrator still listed them
+}
+
+// newReconciler creates the reconciler. Returns nil (disabled) when the interval is not positive.
+func newReconciler(interval time.Duration) *reconciler {
+	if interval <= 0 {
+		return nil
+	}
+	return &reconciler{interval: interval}
+}
+
+// Run reconciles the streams every interval until the context is done
+func (r *reconciler) Run(ctx context.Context, orch *orchClient, streams *streamRegistry) {
+	if r == nil || orch == nil {
+		return
+	}
+	if orch.containerID == "" {
+		slog.Warn("Stream reconciliation requires the container ID, disabling it")
+		return
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reconcile(orch, streams)
+		}
+	}
+}
+
+// reconcile ends the streams the orchestrator lists but acexy no longer serves
+func (r *reconciler) reconcile(orch *orchClient, streams *streamRegistry) {
+	listed, err := orch.GetContainerStreams()
+	if err != nil {
+		slog.Warn("Failed to get the orchestrator streams for reconciliation", "error", err)
+		return
+	}
+
+	served := streams.StreamIDs()
+	for _, stream := range listed {
+		if _, ok := served[stream.ID]; ok {
+			continue
+		}
+		r.stale.Add(1)
+		slog.Warn("Orchestrator lists a stream acexy no longer serves, ending it", "stream_id", stream.ID)
+		orch.EmitReconciledEnded(stream.ID)
+	}
+}
+
+// Stale returns the number of streams ended because the orchestrator still listed them
+func (r *reconciler) Stale() uint64 {
+	if r == nil {
+		return 0
+	}
+	return r.stale.Load()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestReconcileEndsStaleStreams verifies streams the orchestrator lists for this container
+// but acexy no longer serves get a corrective stream_ended event, while served ones are kept
+func TestReconcileEndsStaleStreams(t *testing.T) {
+	var mu sync.Mutex
+	var ended []endedEvent
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/streams":
+			if r.URL.Query().Get("container_id") != "acexy-1" {
+				t.Errorf("Expected the streams of this container, got %s", r.URL.RawQuery)
+			}
+			json.NewEncoder(w).Encode([]streamState{
+				{ID: "abc|served", Status: "started"},
+				{ID: "abc|gone", Status: "started"},
+				{ID: "def|lost", Status: "started"},
+			})
+		case "/events/stream_ended":
+			var ev endedEvent
+			json.NewDecoder(r.Body).Decode(&ev)
+			mu.Lock()
+			ended = append(ended, ev)
+			mu.Unlock()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	client := newOrchClient(OrchConfig{URL: orch.URL, ContainerID: "acexy-1"})
+	defer client.Close()
+
+	// The ended event of this stream was lost, so it must be sent again
+	client.EmitEnded("abc|gone", "handler_exit")
+
+	var streams streamRegistry
+	streams.Add(&activeStream{PlaybackID: "served", Key: "abc"})
+
+	rec := newReconciler(1)
+	rec.reconcile(client, &streams)
+
+	mu.Lock()
+	defer mu.Unlock()
+	corrective := map[string]bool{}
+	for _, ev := range ended {
+		if ev.Reason == "reconciled" {
+			corrective[ev.StreamID] = true
+		}
+	}
+	if len(corrective) != 2 || !corrective["abc|gone"] || !corrective["def|lost"] {
+		t.Errorf("Expected corrective events for the stale streams only, got %+v", ended)
+	}
+	if rec.Stale() != 2 {
+		t.Errorf("Expected 2 stale streams, got %d", rec.Stale())
+	}
+
+	w := httptest.NewRecorder()
+	(&Proxy{Orch: client, Reconciler: rec}).HandleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "acexy_reconciled_stale_streams_total 2\n") {
+		t.Errorf("Expected the stale streams in the metrics:\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,48 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// recoverProvision provisions an engine as soon as provisioning recovers from being blocked
+// (e.g. the VPN is back), unless a healthy engine still has a free stream slot. The next
+// client then finds an engine ready instead of waiting for it to be provisioned.
+func (c *orchClient) recoverProvision() {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if _, err := c.RefreshEngines(); err != nil {
+		slog.Warn("Failed to list the engines after provisioning recovered", "error", err)
+		return
+	}
+	if free, err := c.ReadyEngines(); err != nil || free > 0 {
+		slog.Debug("Not provisioning after the recovery, engines are free", "free_engines", free, "error", err)
+		return
+	}
+
+	slog.Info("Provisioning recovered with no free engine, provisioning one ahead of the clients")
+	release, err := c.acquireProvisionSlot(ctx)
+	if err != nil {
+		slog.Warn("Failed to provision an engine after provisioning recovered", "error", err)
+		return
+	}
+	provResp, err := c.ProvisionWithRetryContext(ctx, 3)
+	release()
+	if err != nil {
+		slog.Warn("Failed to provision an engine after provisioning recovered", "error", err)
+		return
+	}
+	slog.Info("Provisioned an engine after provisioning recovered", "container_id", provResp.ContainerID)
+
+	// List the new engine right away for the next selection
+	if _, err := c.RefreshEngines(); err != nil {
+		slog.Debug("Failed to list the engines after the recovery provision", "error", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestEagerRecoverProvision verifies an engine is provisioned when the health turns from
+// blocked to able to provision with every engine busy, but not on the first health check,
+// while still blocked or when an engine is free
+func TestEagerRecoverProvision(t *testing.T) {
+	var canProvision, engineFree atomic.Bool
+	provisioned := make(chan struct{}, 10)
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orchestrator/status":
+			var status orchestratorStatus
+			status.Status = "healthy"
+			status.Provisioning.CanProvision = canProvision.Load()
+			if !status.Provisioning.CanProvision {
+				status.Status = "degraded"
+				status.Provisioning.BlockedReason = "VPN disconnected"
+			}
+			json.NewEncoder(w).Encode(status)
+		case "/engines":
+			streams := []string{"s1"}
+			if engineFree.Load() {
+				streams = nil
+			}
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-1", Host: "127.0.0.1", Port: 6878, HealthStatus: "healthy", Streams: streams},
+			})
+		case "/provision/acestream":
+			provisioned <- struct{}{}
+			json.NewEncoder(w).Encode(aceProvisionResponse{ContainerID: "engine-2", HostHTTPPort: 6879})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer orch.Close()
+
+	newClient := func(eager bool) *orchClient {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		return &orchClient{
+			base:                  orch.URL,
+			maxStreamsPerEngine:   1,
+			hc:                    &http.Client{Timeout: 3 * time.Second},
+			ctx:                   ctx,
+			cancel:                cancel,
+			endedStreams:          make(map[string]bool),
+			eagerRecoverProvision: eager,
+		}
+	}
+	expectProvisions := func(step string, want int) {
+		t.Helper()
+		deadline := time.After(300 * time.Millisecond)
+		for got := 0; ; {
+			select {
+			case <-provisioned:
+				if got++; got > want {
+					t.Fatalf("%s: expected %d provisions, got more", step, want)
+				}
+			case <-deadline:
+				if got != want {
+					t.Fatalf("%s: expected %d provisions, got %d", step, want, got)
+				}
+				return
+			}
+		}
+	}
+
+	// Able to provision from the start, nothing recovered
+	canProvision.Store(true)
+	client := newClient(true)
+	client.updateHealth()
+	expectProvisions("first check", 0)
+
+	// Blocked, then recovered with the only engine busy
+	canProvision.Store(false)
+	client.updateHealth()
+	client.updateHealth()
+	expectProvisions("blocked", 0)
+	canProvision.Store(true)
+	client.updateHealth()
+	expectProvisions("recovered", 1)
+	client.updateHealth()
+	expectProvisions("still recovered", 0)
+
+	// Recovered with a free engine
+	engineFree.Store(true)
+	canProvision.Store(false)
+	client.updateHealth()
+	canProvision.Store(true)
+	client.updateHealth()
+	expectProvisions("recovered with a free engine", 0)
+
+	// Disabled
+	engineFree.Store(false)
+	client = newClient(false)
+	canProvision.Store(false)
+	client.updateHealth()
+	canProvision.Store(true)
+	client.updateHealth()
+	expectProvisions("disabled", 0)
+}
@@ -0,0 +1,52 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// The engine label holding the region the engine runs in
+const ENGINE_REGION_LABEL = "region"
+
+// The query parameter a client uses to ask for engines in a region
+const REGION_QUERY_PARAM = "region"
+
+type regionContextKey struct{}
+
+// withPreferredRegion returns a context asking the engine selection to prefer engines
+// in the given region. An empty region leaves the context untouched.
+func withPreferredRegion(ctx context.Context, region string) context.Context {
+	if region == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, regionContextKey{}, region)
+}
+
+// preferredRegion returns the region the engine selection should prefer, if any
+func preferredRegion(ctx context.Context) string {
+	region, _ := ctx.Value(regionContextKey{}).(string)
+	return region
+}
+
+// requestRegion returns the region requested by the client: the `region` query parameter
+// or, when not given, the value of the configured header
+func requestRegion(r *http.Request, header string) string {
+	if region := strings.TrimSpace(r.URL.Query().Get(REGION_QUERY_PARAM)); region != "" {
+		return region
+	}
+	if header != "" {
+		return strings.TrimSpace(r.Header.Get(header))
+	}
+	return ""
+}
+
+// inRegion reports whether the engine carries the given region label. Regions are
+// compared case-insensitively.
+func inRegion(engine engineState, region string) bool {
+	return region != "" && strings.EqualFold(engine.Labels[ENGINE_REGION_LABEL], region)
+}
@@ -0,0 +1,112 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import "sync"
+
+// Number of the latest stream outcomes of each engine its success ratio is computed over
+const ENGINE_RELIABILITY_WINDOW = 20
+
+// engineOutcomes holds the latest stream outcomes of an engine, in a ring
+type engineOutcomes struct {
+	failed []bool
+	next   int
+}
+
+// engineReliability tracks whether the latest streams of each engine succeeded, so the
+// selection prefers engines that serve reliably over those failing now and then without
+// being marked as failing at selection time. The zero value is ready to use.
+type engineReliability struct {
+	mu      sync.Mutex
+	engines map[string]*engineOutcomes
+}
+
+// engineReliabilityStats summarizes the latest stream outcomes of an engine
+type engineReliabilityStats struct {
+	Attempts     int     `json:"attempts"`
+	Failures     int     `json:"failures"`
+	SuccessRatio float64 `json:"success_ratio"` // 1 when the engine served no stream yet
+	Failing      bool    `json:"failing"`       // Whether the engine is currently marked as failing
+}
+
+// Record adds the outcome of a stream served by the engine
+func (r *engineReliability) Record(containerID string, failed bool) {
+	if containerID == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.engines == nil {
+		r.engines = make(map[string]*engineOutcomes)
+	}
+	outcomes, ok := r.engines[containerID]
+	if !ok {
+		outcomes = &engineOutcomes{}
+		r.engines[containerID] = outcomes
+	}
+	if len(outcomes.failed) < ENGINE_RELIABILITY_WINDOW {
+		outcomes.failed = append(outcomes.failed, failed)
+		return
+	}
+	outcomes.failed[outcomes.next] = failed
+	outcomes.next = (outcomes.next + 1) % ENGINE_RELIABILITY_WINDOW
+}
+
+// Health returns the latest stream outcomes of the engine
+func (r *engineReliability) Health(containerID string) engineReliabilityStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	health := engineReliabilityStats{SuccessRatio: 1}
+	outcomes, ok := r.engines[containerID]
+	if !ok || len(outcomes.failed) == 0 {
+		return health
+	}
+	health.Attempts = len(outcomes.failed)
+	for _, failed := range outcomes.failed {
+		if failed {
+			health.Failures++
+		}
+	}
+	health.SuccessRatio = float64(health.Attempts-health.Failures) / float64(health.Attempts)
+	return health
+}
+
+// Forget drops the outcomes of the engines not in the given set
+func (r *engineReliability) Forget(keep map[string]struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for containerID := range r.engines {
+		if _, ok := keep[containerID]; !ok {
+			delete(r.engines, containerID)
+		}
+	}
+}
+
+// RecordEngineSuccess counts a stream the engine served fine towards its success ratio
+func (c *orchClient) RecordEngineSuccess(containerID string) {
+	if c == nil {
+		return
+	}
+	c.reliability.Record(containerID, false)
+	c.probation.Succeeded(containerID)
+}
+
+// GetEngineHealth returns the success ratio of the latest streams of the engine, and
+// whether it is currently marked as failing
+func (c *orchClient) GetEngineHealth(containerID string) engineReliabilityStats {
+	if c == nil {
+		return engineReliabilityStats{SuccessRatio: 1}
+	}
+	health := c.reliability.Health(containerID)
+	health.Failing = c.engineFailing(containerID)
+	return health
+}
+
+// engineEndedFine reports whether the stream end reason tells the engine served it fine
+func engineEndedFine(reason string) bool {
+	return reason == "completed" || reason == "client_disconnected"
+}
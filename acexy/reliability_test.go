@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestSelectionPrefersReliableEngines verifies that among engines with the same health and
+// load, the one whose latest streams succeeded more often is selected first
+func TestSelectionPrefersReliableEngines(t *testing.T) {
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				// Unused for longer, so selected first when only the usage tells them apart
+				{ContainerID: "engine-flaky", Host: "127.0.0.1", Port: 6878, HealthStatus: "healthy", LastStreamUsage: time.Now().Add(-time.Hour)},
+				{ContainerID: "engine-reliable", Host: "127.0.0.1", Port: 6879, HealthStatus: "healthy", LastStreamUsage: time.Now()},
+			})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		endedStreams:        make(map[string]bool),
+	}
+
+	if selected, err := client.SelectBestEngine(); err != nil || selected.ContainerID != "engine-flaky" {
+		t.Fatalf("Expected the engine unused for longer without outcomes, got %q (%v)", selected.ContainerID, err)
+	}
+
+	// 40% of the latest streams of the flaky engine failed, without it being marked as failing
+	for i := 0; i < 10; i++ {
+		client.reliability.Record("engine-flaky", i%5 < 2)
+		client.RecordEngineSuccess("engine-reliable")
+	}
+	selected, err := client.SelectBestEngine()
+	if err != nil || selected.ContainerID != "engine-reliable" {
+		t.Fatalf("Expected the reliable engine to be selected, got %q (%v)", selected.ContainerID, err)
+	}
+
+	health := client.GetEngineHealth("engine-flaky")
+	if health.Attempts != 10 || health.Failures != 4 || health.SuccessRatio != 0.6 || health.Failing {
+		t.Errorf("Expected 4 failures out of 10 streams, got %+v", health)
+	}
+	if health := client.GetEngineHealth("engine-unknown"); health.SuccessRatio != 1 || health.Attempts != 0 {
+		t.Errorf("Expected a full success ratio for an engine without streams, got %+v", health)
+	}
+}
+
+// TestEngineReliabilityWindow verifies only the latest outcomes of an engine are kept
+func TestEngineReliabilityWindow(t *testing.T) {
+	var reliability engineReliability
+	for i := 0; i < ENGINE_RELIABILITY_WINDOW; i++ {
+		reliability.Record("engine-1", true)
+	}
+	for i := 0; i < ENGINE_RELIABILITY_WINDOW/2; i++ {
+		reliability.Record("engine-1", false)
+	}
+
+	health := reliability.Health("engine-1")
+	if health.Attempts != ENGINE_RELIABILITY_WINDOW || health.SuccessRatio != 0.5 {
+		t.Errorf("Expected half of the latest %d streams to succeed, got %+v", ENGINE_RELIABILITY_WINDOW, health)
+	}
+
+	reliability.Forget(map[string]struct{}{})
+	if health := reliability.Health("engine-1"); health.Attempts != 0 {
+		t.Errorf("Expected the outcomes of an unlisted engine to be forgotten, got %+v", health)
+	}
+}
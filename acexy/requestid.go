@@ -0,0 +1,46 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Response header the ID of each stream request is returned in
+const REQUEST_ID_HEADER = "X-Request-Id"
+
+// Maximum length, in bytes, of an inbound request ID
+const REQUEST_ID_MAX_LENGTH = 128
+
+// requestIDFor returns the ID of the stream request: the one sent in the given inbound
+// header when it is valid, so acexy joins the trace of the caller, or a new UUID otherwise
+func requestIDFor(r *http.Request, header string) string {
+	if header != "" {
+		if id := strings.TrimSpace(r.Header.Get(header)); validRequestID(id) {
+			return id
+		}
+	}
+	return uuid.NewString()
+}
+
+// validRequestID reports whether an inbound request ID is safe to adopt: not empty, not
+// too long, and only made of the characters tracing systems use in their IDs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > REQUEST_ID_MAX_LENGTH {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_.:/+=@", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestInboundRequestID verifies the request ID sent by the caller is adopted, echoed and
+// sent in the orchestrator events, while a missing or invalid one is replaced by a new ID
+func TestInboundRequestID(t *testing.T) {
+	_, enginePort := newStandbyTestEngine(t, "test data", false)
+
+	var mu sync.Mutex
+	events := map[string]string{}
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-1", Host: "127.0.0.1", Port: enginePort, HealthStatus: "healthy"},
+			})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		case "/events/stream_started", "/events/stream_ended":
+			var ev struct {
+				RequestID string `json:"request_id"`
+			}
+			json.NewDecoder(r.Body).Decode(&ev)
+			mu.Lock()
+			events[r.URL.Path] = ev.RequestID
+			mu.Unlock()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	acexyInst := &acexy.Acexy{
+		Scheme:            "http",
+		Host:              "127.0.0.1",
+		Port:              1,
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      5 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+	proxy := &Proxy{
+		Acexy: acexyInst,
+		Orch: &orchClient{
+			base:                orch.URL,
+			maxStreamsPerEngine: 1,
+			hc:                  &http.Client{Timeout: 3 * time.Second},
+			ctx:                 ctx,
+			cancel:              cancel,
+			endedStreams:        make(map[string]bool),
+		},
+		InboundRequestIDHeader: "X-Request-Id",
+	}
+
+	stream := func(inbound string) string {
+		mu.Lock()
+		clear(events)
+		mu.Unlock()
+		req := httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil)
+		if inbound != "" {
+			req.Header.Set("X-Request-Id", inbound)
+		}
+		w := httptest.NewRecorder()
+		proxy.HandleStream(w, req)
+		return w.Header().Get(REQUEST_ID_HEADER)
+	}
+
+	if got := stream("trace-4bf92f35:00f067aa"); got != "trace-4bf92f35:00f067aa" {
+		t.Errorf("Expected the inbound request ID to be echoed, got %q", got)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		started, ended := events["/events/stream_started"], events["/events/stream_ended"]
+		mu.Unlock()
+		if started == "trace-4bf92f35:00f067aa" && ended == "trace-4bf92f35:00f067aa" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the request ID in both events, got started %q and ended %q", started, ended)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, inbound := range []string{"", "bad id\twith spaces"} {
+		got := stream(inbound)
+		if _, err := uuid.Parse(got); err != nil {
+			t.Errorf("Inbound %q: expected a generated request ID, got %q", inbound, got)
+		}
+	}
+}
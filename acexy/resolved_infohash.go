@@ -0,0 +1,31 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import "javinator9889/acexy/lib/acexy"
+
+// resolvedAceID returns the ID of the infohash the engine resolved a content ID to. Streams
+// already requested by infohash, or whose infohash was not reported, are not re-keyed.
+func resolvedAceID(aceId acexy.AceID, stream *acexy.AceStream) (acexy.AceID, bool) {
+	if idType, _ := aceId.ID(); idType == "infohash" || stream.Infohash == "" {
+		return aceId, false
+	}
+	resolved, err := acexy.NewAceID("", stream.Infohash)
+	if err != nil {
+		return aceId, false
+	}
+	return resolved, true
+}
+
+// sharesResolvedSession returns whether the stream registered under the given playback
+// session ID is of the same resolved content, so sharing its engine session is expected
+// rather than a duplicate
+func (p *Proxy) sharesResolvedSession(playbackID, aceID string) bool {
+	if !p.DedupByResolvedInfohash {
+		return false
+	}
+	other, ok := p.streams.Get(playbackID)
+	return ok && other.AceID == aceID
+}
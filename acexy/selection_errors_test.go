@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"javinator9889/acexy/lib/acexy"
+)
+
+// TestBlockedProvisioningError verifies blocked provisioning errors unwrap to the sentinel
+// error matching the orchestrator code, inferring it from the reason when missing
+func TestBlockedProvisioningError(t *testing.T) {
+	tests := []struct {
+		code     string
+		reason   string
+		expected error
+	}{
+		{"vpn_disconnected", "Gluetun is down", ErrVPNDisconnected},
+		{"vpn_error", "Gluetun is down", ErrVPNDisconnected},
+		{"circuit_breaker", "Too many failures", ErrCircuitBreakerOpen},
+		{"max_capacity", "VPN port forwarding pending", ErrProvisioningBlocked}, // The code wins over the reason
+		{"", "VPN disconnected", ErrVPNDisconnected},
+		{"", "Circuit breaker is open", ErrCircuitBreakerOpen},
+		{"", "Maintenance", ErrProvisioningBlocked},
+	}
+	for _, tt := range tests {
+		err := blockedProvisioningError(tt.code, tt.reason)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("Code %q, reason %q: expected %v, got %v", tt.code, tt.reason, tt.expected, err)
+		}
+		if got, expected := err.Error(), "cannot provision: "+tt.reason; got != expected {
+			t.Errorf("Expected message %q, got %q", expected, got)
+		}
+	}
+}
+
+// TestHandleSelectionError verifies each typed selection error maps to its response, and
+// that other errors are left to the caller even when their message mentions a known cause
+func TestHandleSelectionError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		handled    bool
+		body       string
+		retryAfter string
+	}{
+		{
+			name:    "vpn",
+			err:     blockedProvisioningError("vpn_disconnected", "VPN disconnected"),
+			handled: true,
+			body:    "Service temporarily unavailable: VPN connection required",
+		},
+		{
+			name:    "circuit breaker",
+			err:     blockedProvisioningError("circuit_breaker", "Too many failures"),
+			handled: true,
+			body:    "Service temporarily unavailable: Too many failures, please retry later",
+		},
+		{
+			name:    "blocked",
+			err:     blockedProvisioningError("", "Maintenance"),
+			handled: true,
+			body:    "Service temporarily unavailable: cannot provision: Maintenance",
+		},
+		{
+			name:    "wrapped by the fallback chain",
+			err:     fmt.Errorf("no engine available in fallback chain: %w", errors.Join(blockedProvisioningError("", "VPN disconnected"), errors.New("engine unreachable"))),
+			handled: true,
+			body:    "Service temporarily unavailable: VPN connection required",
+		},
+		{
+			name: "structured",
+			err: &ProvisioningError{StatusCode: http.StatusServiceUnavailable, Details: &ProvisionError{
+				Code: "max_capacity", Message: "No capacity left", RecoveryETASeconds: 30, ShouldWait: true,
+			}},
+			handled:    true,
+			body:       "Service at capacity: Please try again in a moment",
+			retryAfter: "30",
+		},
+		{
+			name: "unrelated error mentioning a VPN",
+			err:  errors.New("failed to get engines: dial tcp: lookup orchestrator.vpn: no such host"),
+		},
+		{
+			name: "unrelated error mentioning provisioning",
+			err:  errors.New("provisioning failed after 3 attempts: cannot provision: VPN"),
+		},
+	}
+
+	p := &Proxy{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if handled := p.handleSelectionError(w, tt.err); handled != tt.handled {
+				t.Fatalf("Expected handled=%v, got %v", tt.handled, handled)
+			}
+			if !tt.handled {
+				if w.Body.Len() != 0 {
+					t.Errorf("Expected nothing written, got %q", w.Body.String())
+				}
+				return
+			}
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("Expected status 503, got %d", w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("Expected body containing %q, got %q", tt.body, w.Body.String())
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Expected Retry-After %q, got %q", tt.retryAfter, got)
+			}
+		})
+	}
+}
+
+// TestHandleStreamProvisioningBlocked verifies a blocked orchestrator fails the stream
+// request with the typed reason instead of falling back to the configured engine
+func TestHandleStreamProvisioningBlocked(t *testing.T) {
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+	}
+	client.health.canProvision = false
+	client.health.blockedReason = "Circuit breaker is open"
+
+	proxy := &Proxy{
+		Acexy: &acexy.Acexy{Scheme: "http", Host: "127.0.0.1", Port: 1, Endpoint: acexy.MPEG_TS_ENDPOINT},
+		Orch:  client,
+	}
+	proxy.Acexy.Init()
+
+	w := httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503, got %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "Too many failures, please retry later") {
+		t.Errorf("Expected the circuit breaker response, got %q", body)
+	}
+}
+
+// TestAllEnginesRecovering verifies the selection fails with a structured error, whose
+// recovery ETA is the soonest engine to recover, when the orchestrator reports every engine
+// unhealthy and provisioning is blocked, while an engine only deprioritized by acexy is
+// still used
+func TestAllEnginesRecovering(t *testing.T) {
+	var failingHealth atomic.Value
+	failingHealth.Store("unhealthy")
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-unhealthy", Host: "host-1", Port: 8001, HealthStatus: "unhealthy"},
+				{ContainerID: "engine-failing", Host: "host-2", Port: 8002, HealthStatus: failingHealth.Load().(string)},
+			})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		failingEngines:      map[string]time.Time{"engine-failing": time.Now().Add(45 * time.Second)},
+	}
+	client.health.canProvision = false
+	client.health.blockedReason = "Maximum capacity reached"
+
+	_, err := client.SelectBestEngine()
+	var provErr *ProvisioningError
+	if !errors.As(err, &provErr) {
+		t.Fatalf("Expected a structured provisioning error, got %v", err)
+	}
+	if provErr.Details.Code != ALL_RECOVERING_CODE || !provErr.Details.ShouldWait {
+		t.Errorf("Unexpected error details: %+v", provErr.Details)
+	}
+	if eta := provErr.Details.RecoveryETASeconds; eta != int(ENGINE_RECOVERY_ETA.Seconds()) {
+		t.Errorf("Expected the ETA of the soonest engine (%v), got %ds", ENGINE_RECOVERY_ETA, eta)
+	}
+
+	proxy := &Proxy{
+		Acexy: &acexy.Acexy{Scheme: "http", Host: "127.0.0.1", Port: 1, Endpoint: acexy.MPEG_TS_ENDPOINT},
+		Orch:  client,
+	}
+	proxy.Acexy.Init()
+	w := httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503, got %d", w.Code)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != strconv.Itoa(int(ENGINE_RECOVERY_ETA.Seconds())) {
+		t.Errorf("Expected Retry-After from the recovery ETA, got %q", retry)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "All engines are recovering") {
+		t.Errorf("Expected the all recovering response, got %q", body)
+	}
+
+	// An engine the orchestrator reports healthy, only deprioritized after a failed stream, is
+	// still used rather than failing the request
+	failingHealth.Store("healthy")
+	if engine, err := client.SelectBestEngine(); err != nil || engine.ContainerID != "engine-failing" {
+		t.Errorf("Expected the deprioritized engine to be selected, got %s (%v)", engine.ContainerID, err)
+	}
+
+	// Once provisioning is possible, a recovering engine is still used as before
+	failingHealth.Store("unhealthy")
+	client.health.canProvision = true
+	if _, err := client.SelectBestEngine(); err != nil {
+		t.Errorf("Expected a recovering engine to be selected when provisioning is possible, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newHintsTestClient returns a client of an orchestrator reporting the given selection hints
+// with two idle engines, the oldest used one being selected without hints
+func newHintsTestClient(t *testing.T, recommended string, preferNew bool) (*orchClient, *atomic.Int32) {
+	var provisions atomic.Int32
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orchestrator/status":
+			json.NewEncoder(w).Encode(map[string]any{
+				"status":             "healthy",
+				"provisioning":       map[string]any{"can_provision": true},
+				"recommended_engine": recommended,
+				"prefer_new":         preferNew,
+			})
+		case "/engines":
+			engines := []engineState{
+				{ContainerID: "engine-old", Host: "127.0.0.1", Port: 6878, HealthStatus: "healthy", LastStreamUsage: time.Now().Add(-time.Hour)},
+				{ContainerID: "engine-recent", Host: "127.0.0.1", Port: 6879, HealthStatus: "healthy", LastStreamUsage: time.Now()},
+			}
+			if provisions.Load() > 0 {
+				engines = append(engines, engineState{ContainerID: "engine-fresh", Host: "127.0.0.1", Port: 19000, HealthStatus: "healthy"})
+			}
+			json.NewEncoder(w).Encode(engines)
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		case "/provision/acestream":
+			provisions.Add(1)
+			json.NewEncoder(w).Encode(aceProvisionResponse{ContainerID: "engine-fresh", HostHTTPPort: 19000})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(orch.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		endedStreams:        make(map[string]bool),
+	}
+	if err := client.updateHealth(); err != nil {
+		t.Fatalf("Failed to update the health: %v", err)
+	}
+	return client, &provisions
+}
+
+// TestSelectionHintRecommendedEngine verifies the engine recommended by the orchestrator is
+// selected over the one the selection would otherwise pick
+func TestSelectionHintRecommendedEngine(t *testing.T) {
+	for _, tt := range []struct {
+		recommended string
+		expected    string
+	}{
+		{"", "engine-old"},
+		{"engine-recent", "engine-recent"},
+		{"engine-unknown", "engine-old"},
+	} {
+		client, _ := newHintsTestClient(t, tt.recommended, false)
+		engine, err := client.SelectBestEngine()
+		if err != nil {
+			t.Fatalf("Recommended %q: selection failed: %v", tt.recommended, err)
+		}
+		if engine.ContainerID != tt.expected {
+			t.Errorf("Recommended %q: expected %s, got %s", tt.recommended, tt.expected, engine.ContainerID)
+		}
+	}
+}
+
+// TestSelectionHintPreferNew verifies a declared scale-up makes the selection provision a new
+// engine despite the available ones
+func TestSelectionHintPreferNew(t *testing.T) {
+	client, provisions := newHintsTestClient(t, "", true)
+	engine, err := client.SelectBestEngine()
+	if err != nil {
+		t.Fatalf("Selection failed: %v", err)
+	}
+	if provisions.Load() != 1 || engine.ContainerID != "engine-fresh" {
+		t.Errorf("Expected a new engine to be provisioned, got %s after %d provisions", engine.ContainerID, provisions.Load())
+	}
+	if snapshot := client.HealthSnapshot(); !snapshot.PreferNew {
+		t.Errorf("Expected the hint in the health snapshot, got %+v", snapshot)
+	}
+}
@@ -0,0 +1,68 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Delay before the first retry of an orchestrator query failing transiently during the
+// engine selection, doubled on each further retry
+const SELECTION_RETRY_BACKOFF = 100 * time.Millisecond
+
+// orchStatusError is returned when an orchestrator query is answered with an unexpected status
+type orchStatusError struct {
+	StatusCode int
+	RetryAfter int // Seconds the orchestrator asked to wait before retrying, if any
+}
+
+func (e *orchStatusError) Error() string {
+	return fmt.Sprintf("orchestrator returned status %d", e.StatusCode)
+}
+
+// transientOrchError reports whether the orchestrator query failed with a 5xx or was rate
+// limited, which a retry may overcome
+func transientOrchError(err error) bool {
+	var statusErr *orchStatusError
+	return errors.As(err, &statusErr) &&
+		(statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests)
+}
+
+// retryDelay returns the delay before retrying the failed query, at least the Retry-After the
+// orchestrator asked for
+func retryDelay(err error, backoff time.Duration) time.Duration {
+	var statusErr *orchStatusError
+	if errors.As(err, &statusErr) {
+		return max(backoff, time.Duration(statusErr.RetryAfter)*time.Second)
+	}
+	return backoff
+}
+
+// retryTransient runs the orchestrator query, retrying it with backoff up to the configured
+// selection retries while it fails transiently. A rate limited query is retried no sooner
+// than the orchestrator asked. Retries that would not complete before the context deadline
+// are skipped, so the selection deadline caps the added latency.
+func (c *orchClient) retryTransient(ctx context.Context, query string, run func() error) error {
+	err := run()
+	backoff := SELECTION_RETRY_BACKOFF
+	for attempt := 1; attempt <= c.selectionRetries && transientOrchError(err); attempt++ {
+		delay := retryDelay(err, backoff)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			break
+		}
+		slog.Debug("Retrying orchestrator query after a transient error", "query", query, "attempt", attempt, "delay", delay, "error", err)
+		if c.wait(ctx, delay) != nil {
+			break
+		}
+		err = run()
+		backoff *= 2
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSelectionRetriesTransientErrors verifies a 503 listing the engines is retried during
+// the selection when enabled, unless the retry would overrun the selection deadline
+func TestSelectionRetriesTransientErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		retries  int
+		deadline time.Duration
+		selected bool
+	}{
+		{"disabled", 0, 0, false},
+		{"retried", 2, 0, true},
+		{"deadline too close", 2, 50 * time.Millisecond, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var listings atomic.Int32
+			orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/engines":
+					if listings.Add(1) == 1 {
+						http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+						return
+					}
+					json.NewEncoder(w).Encode([]engineState{
+						{ContainerID: "engine-1", Host: "127.0.0.1", Port: 6878, HealthStatus: "healthy"},
+					})
+				case "/streams":
+					json.NewEncoder(w).Encode([]streamState{})
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer orch.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			client := &orchClient{
+				base:                orch.URL,
+				maxStreamsPerEngine: 1,
+				hc:                  &http.Client{Timeout: 3 * time.Second},
+				ctx:                 ctx,
+				cancel:              cancel,
+				endedStreams:        make(map[string]bool),
+				selectionRetries:    tt.retries,
+			}
+
+			selectCtx := context.Background()
+			if tt.deadline > 0 {
+				var cancelSelect context.CancelFunc
+				selectCtx, cancelSelect = context.WithTimeout(selectCtx, tt.deadline)
+				defer cancelSelect()
+			}
+			engine, err := client.SelectBestEngineContext(selectCtx)
+			if tt.selected && (err != nil || engine.ContainerID != "engine-1") {
+				t.Errorf("Expected engine-1 to be selected after a retry, got %+v, %v", engine, err)
+			}
+			if !tt.selected && err == nil {
+				t.Errorf("Expected the selection to fail, got %+v", engine)
+			}
+		})
+	}
+}
+
+// TestTransientOrchError verifies only 5xx statuses are retried
+func TestTransientOrchError(t *testing.T) {
+	if !transientOrchError(&orchStatusError{StatusCode: http.StatusBadGateway}) {
+		t.Error("Expected a 502 to be transient")
+	}
+	if transientOrchError(&orchStatusError{StatusCode: http.StatusUnauthorized}) {
+		t.Error("Expected a 401 not to be transient")
+	}
+}
@@ -0,0 +1,64 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Default time a client is given to send the request headers
+const DEFAULT_READ_HEADER_TIMEOUT = 10 * time.Second
+
+// Default time an idle keep-alive connection is kept open
+const DEFAULT_IDLE_TIMEOUT = 2 * time.Minute
+
+// TLSEnabled reports whether the listener should terminate TLS
+func (c Config) TLSEnabled() bool {
+	return c.TLSCert != "" && c.TLSKey != ""
+}
+
+// newServer creates the HTTP server for the handler, bounding the time clients are given to
+// send the request headers and to keep an idle connection open. No read or write timeout is
+// set on purpose, since stream responses last as long as the client watches them.
+func newServer(addr string, handler http.Handler, cfg Config) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// serve accepts connections on the listener, terminating TLS when it is configured
+func serve(srv *http.Server, ln net.Listener, cfg Config) error {
+	if cfg.TLSEnabled() {
+		slog.Info("Serving HTTPS", "addr", ln.Addr())
+		return srv.ServeTLS(ln, cfg.TLSCert, cfg.TLSKey)
+	}
+	slog.Info("Serving HTTP", "addr", ln.Addr())
+	return srv.Serve(ln)
+}
+
+// httpsRedirect redirects every request to the same URL over HTTPS, on the port of the
+// given TLS listener address
+func httpsRedirect(tlsAddr string) http.Handler {
+	_, tlsPort, _ := net.SplitHostPort(tlsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if tlsPort != "" && tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate generates a self-signed certificate for 127.0.0.1 and returns the
+// paths of the certificate and key files
+func writeTestCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "acexy-test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+// TestServeTLS verifies the server accepts TLS connections when a certificate is configured
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	cfg := Config{TLSCert: certFile, TLSKey: keyFile}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	srv := &http.Server{Handler: methodGuard(&Proxy{})}
+	go serve(srv, ln, cfg)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/ace/status")
+	if err != nil {
+		t.Fatalf("TLS request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.TLS == nil {
+		t.Error("Expected the connection to use TLS")
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	// Plain HTTP is not accepted on the TLS listener
+	plain, err := http.Get("http://" + ln.Addr().String() + "/ace/status")
+	if err == nil {
+		defer plain.Body.Close()
+		if plain.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected plain HTTP to be rejected, got status %d", plain.StatusCode)
+		}
+	}
+}
+
+// TestHTTPSRedirect verifies plain HTTP requests are redirected to the HTTPS listener
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		tlsAddr  string
+		expected string
+	}{
+		{":8443", "https://example.com:8443/ace/getstream?id=abc"},
+		{":443", "https://example.com/ace/getstream?id=abc"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		httpsRedirect(tt.tlsAddr).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com:80/ace/getstream?id=abc", nil))
+
+		if rec.Code != http.StatusMovedPermanently {
+			t.Errorf("Expected status 301, got %d", rec.Code)
+		}
+		if location := rec.Header().Get("Location"); location != tt.expected {
+			t.Errorf("Expected redirect to %s, got %s", tt.expected, location)
+		}
+	}
+}
+
+// TestReadHeaderTimeout verifies a client sending its headers too slowly is cut off
+func TestReadHeaderTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	srv := newServer("", methodGuard(&Proxy{}), Config{ReadHeaderTimeout: 100 * time.Millisecond})
+	go serve(srv, ln, Config{})
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Send the request line but never finish the headers
+	if _, err := conn.Write([]byte("GET /ace/status HTTP/1.1\r\nHost: acexy\r\n")); err != nil {
+		t.Fatalf("Failed to write the request: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	buf := make([]byte, 1024)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("Expected the slow client to be cut off by the server")
+			}
+			break
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the connection to be closed after the header timeout, took %v", elapsed)
+	}
+}
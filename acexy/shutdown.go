@@ -0,0 +1,88 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Time the streams terminated at shutdown are given to report their end, and the events
+// being sent to the orchestrator to be delivered
+const SHUTDOWN_REPORT_TIMEOUT = 5 * time.Second
+
+// Interval at which the shutdown checks whether the streams drained
+const SHUTDOWN_POLL_INTERVAL = 100 * time.Millisecond
+
+// notifyShutdownSignals starts relaying SIGINT and SIGTERM to the returned channel instead
+// of terminating the process
+func notifyShutdownSignals() chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	return signals
+}
+
+// ShuttingDown reports whether the proxy is shutting down
+func (p *Proxy) ShuttingDown() bool {
+	return p.shuttingDown.Load()
+}
+
+// Shutdown stops accepting streams and waits up to the grace period for the active ones to
+// finish, which end as `shutdown_drained`. The streams still served past it are terminated,
+// ending as `shutdown_forced`, and counted. The server, if any, is closed.
+func (p *Proxy) Shutdown(srv *http.Server, grace time.Duration) {
+	p.shuttingDown.Store(true)
+	p.SetDraining(true)
+	active := p.streams.Len()
+	slog.Info("Shutting down, draining the active streams", "active_streams", active, "grace", grace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if srv != nil {
+		// Stop accepting connections, the stream handlers keep running
+		_ = srv.Shutdown(ctx)
+	}
+	p.waitStreams(ctx)
+
+	forced := p.streams.Terminate()
+	p.forcedTerminations.Add(uint64(forced))
+	if forced > 0 {
+		reportCtx, cancelReport := context.WithTimeout(context.Background(), SHUTDOWN_REPORT_TIMEOUT)
+		defer cancelReport()
+		p.waitStreams(reportCtx)
+	}
+	if srv != nil {
+		_ = srv.Close()
+	}
+	// Let the ended events of the streams reach the orchestrator before its client is
+	// closed, which flushes the batched ones synchronously
+	p.Orch.WaitEvents(SHUTDOWN_REPORT_TIMEOUT)
+	p.Orch.Close()
+
+	if forced > 0 {
+		slog.Warn("Shutdown complete, streams forcibly terminated past the grace period",
+			"drained", max(active-forced, 0), "forced", forced, "grace", grace)
+	} else {
+		slog.Info("Shutdown complete, every stream drained", "drained", active, "forced", 0, "grace", grace)
+	}
+}
+
+// waitStreams blocks until no stream is served or the context is done
+func (p *Proxy) waitStreams(ctx context.Context) {
+	ticker := time.NewTicker(SHUTDOWN_POLL_INTERVAL)
+	defer ticker.Stop()
+	for p.streams.Len() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestShutdownForcesUndrainableStreams verifies a stream not finishing within the shutdown
+// grace period is terminated, reported as forced and counted
+func TestShutdownForcesUndrainableStreams(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback123")
+	defer close(release)
+	wait := streamConcurrently(t, proxy, 1)
+
+	done := make(chan struct{})
+	go func() {
+		proxy.Shutdown(nil, 50*time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the shutdown to complete")
+	}
+	wait()
+
+	if streams := proxy.history.Latest(1); len(streams) != 1 || streams[0].Reason != "shutdown_forced" {
+		t.Errorf("Expected the stream to end as forced by the shutdown, got %+v", streams)
+	}
+	if forced := proxy.forcedTerminations.Load(); forced != 1 {
+		t.Errorf("Expected 1 forced termination, got %d", forced)
+	}
+
+	w := httptest.NewRecorder()
+	proxy.HandleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "acexy_forced_terminations_total 1") {
+		t.Errorf("Expected the forced terminations in the metrics, got:\n%s", w.Body.String())
+	}
+
+	// New streams are rejected once shutting down
+	w = httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected new streams to be rejected with 503, got %d", w.Code)
+	}
+}
+
+// TestShutdownDrainsStreams verifies a stream finishing within the shutdown grace period
+// is reported as drained and not counted as forced
+func TestShutdownDrainsStreams(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback123")
+	wait := streamConcurrently(t, proxy, 1)
+
+	done := make(chan struct{})
+	go func() {
+		proxy.Shutdown(nil, 10*time.Second)
+		close(done)
+	}()
+	for !proxy.ShuttingDown() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(release)
+	wait()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the shutdown to complete once the stream drained")
+	}
+
+	if streams := proxy.history.Latest(1); len(streams) != 1 || streams[0].Reason != "shutdown_drained" {
+		t.Errorf("Expected the stream to end as drained, got %+v", streams)
+	}
+	if forced := proxy.forcedTerminations.Load(); forced != 0 {
+		t.Errorf("Expected no forced termination, got %d", forced)
+	}
+}
@@ -0,0 +1,138 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"javinator9889/acexy/lib/acexy"
+	"log/slog"
+	"net/url"
+)
+
+type excludedEngineContextKey struct{}
+
+// withExcludedEngine returns a context asking the engine selection to skip the given engine
+func withExcludedEngine(ctx context.Context, containerID string) context.Context {
+	if containerID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, excludedEngineContextKey{}, containerID)
+}
+
+// excludedEngine returns the engine the selection must skip, if any
+func excludedEngine(ctx context.Context) string {
+	containerID, _ := ctx.Value(excludedEngineContextKey{}).(string)
+	return containerID
+}
+
+// standbyEngine is a second engine selected when a stream starts, so that if the primary
+// engine fails mid-stream the stream is taken over without another selection round-trip.
+// The selection runs in the background while the stream is served.
+type standbyEngine struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	engine selectedEngine
+	err    error
+}
+
+// reserveStandby starts selecting a standby engine other than the primary one. Returns nil
+// when warm standby is disabled or unsupported: it requires the orchestrator, and M3U8
+// streams cannot be taken over within the same response.
+func (p *Proxy) reserveStandby(ctx context.Context, primary selectedEngine) *standbyEngine {
+	if !p.WarmStandby || p.Orch == nil || p.Acexy.Endpoint != acexy.MPEG_TS_ENDPOINT {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(withExcludedEngine(ctx, primary.ContainerID))
+	s := &standbyEngine{done: make(chan struct{}), cancel: cancel}
+	go func() {
+		defer close(s.done)
+		s.engine, s.err = p.Orch.SelectBestEngineContext(ctx)
+		if s.err == nil {
+			slog.Debug("Standby engine selected", "container_id", s.engine.ContainerID, "host", s.engine.Host, "port", s.engine.Port)
+		}
+	}()
+	return s
+}
+
+// Take returns the standby engine, waiting for its selection to finish
+func (s *standbyEngine) Take(ctx context.Context) (selectedEngine, error) {
+	select {
+	case <-s.done:
+		if s.err != nil {
+			return selectedEngine{}, fmt.Errorf("no standby engine: %w", s.err)
+		}
+		return s.engine, nil
+	case <-ctx.Done():
+		return selectedEngine{}, ctx.Err()
+	}
+}
+
+// Release drops the standby engine, aborting its selection if still running
+func (s *standbyEngine) Release() {
+	if s != nil {
+		s.cancel()
+	}
+}
+
+// failoverNeeded reports whether the stream ended because of the engine while the client
+// is still there, so the standby engine should take it over
+func failoverNeeded(ctx context.Context, streamErr error) bool {
+	if streamErr == nil || ctx.Err() != nil {
+		return false
+	}
+	reason, _ := classifyDisconnectReason(streamErr)
+	return reason != "client_disconnected" && reason != "quota_exceeded"
+}
+
+// shouldRetryStart reports whether the stream is retried on another engine after the given
+// attempt failed, the client having received the given bytes
+func (p *Proxy) shouldRetryStart(ctx context.Context, attempt int, streamErr error, delivered uint64) bool {
+	// Other engines are only known through the orchestrator
+	if p.Orch == nil {
+		return false
+	}
+	// Once the client got data, a new session would restart the stream midway
+	if delivered > 0 {
+		return false
+	}
+	// The engine failed the stream, not the client leaving or running out of quota
+	if !failoverNeeded(ctx, streamErr) {
+		return false
+	}
+	// Within the configured retries, or once for an engine stuck before the first byte
+	return attempt <= p.StartRetries || (attempt == 1 && errors.Is(streamErr, acexy.ErrFirstByteTimeout))
+}
+
+// fetchFromStandby fetches the stream from the standby engine, which serves the rest of
+// the request
+func (p *Proxy) fetchFromStandby(ctx context.Context, standby *standbyEngine, aceId acexy.AceID, q url.Values) (selectedEngine, *acexy.AceStream, error) {
+	engine, err := standby.Take(ctx)
+	if err != nil {
+		return selectedEngine{}, nil, err
+	}
+	engine = p.Orch.VerifyEngineIdentity(engine)
+
+	stream, err := p.fetchFromEngine(engine, aceId, q)
+	if err != nil {
+		return selectedEngine{}, nil, fmt.Errorf("failed to fetch the stream from the standby engine: %w", err)
+	}
+	return engine, stream, nil
+}
+
+// fetchFromEngine fetches the stream from the given engine, leaving the configured one
+// untouched
+func (p *Proxy) fetchFromEngine(engine selectedEngine, aceId acexy.AceID, q url.Values) (*acexy.AceStream, error) {
+	target := engine.target(p.Acexy.Scheme)
+	stream, err := p.Acexy.FetchStreamFrom(target, aceId, q)
+	if err != nil {
+		return nil, err
+	}
+	p.rewriteEngineURLs(stream, target)
+	stream.ContainerID = engine.ContainerID
+	return stream, nil
+}
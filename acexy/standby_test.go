@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newStandbyTestEngine creates a mock engine serving the given data, cutting the connection
+// afterwards when it fails
+func newStandbyTestEngine(t *testing.T, data string, fails bool) (*httptest.Server, int) {
+	return newStandbyTestEngineSession(t, data, fails, "playback123")
+}
+
+// newStandbyTestEngineSession is like newStandbyTestEngine, handing out the given playback
+// session ID
+func newStandbyTestEngineSession(t *testing.T, data string, fails bool, session string) (*httptest.Server, int) {
+	var engine *httptest.Server
+	engine = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"playback_url": engine.URL + "/stream",
+				"stat_url":     engine.URL + "/ace/stat/test/" + session,
+				"command_url":  engine.URL + "/ace/cmd/test/" + session,
+			}})
+		case "/stream":
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.Write([]byte(data))
+			if fails {
+				// The engine dies mid-stream
+				w.(http.Flusher).Flush()
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			}
+		case "/ace/cmd/test/" + session:
+			json.NewEncoder(w).Encode(map[string]any{"response": "ok"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(engine.Close)
+
+	engineURL, _ := url.Parse(engine.URL)
+	return engine, parsePort(engineURL.Port())
+}
+
+// TestWarmStandbyFailover verifies a stream whose engine fails mid-stream is continued from
+// the pre-selected standby engine, without selecting an engine again, and is reported and
+// recorded under the standby from then on
+func TestWarmStandbyFailover(t *testing.T) {
+	_, primaryPort := newStandbyTestEngineSession(t, "primary-", true, "playback-primary")
+	_, standbyPort := newStandbyTestEngineSession(t, "standby", false, "playback-standby")
+
+	var selections atomic.Int32
+	var eventsMu sync.Mutex
+	var startedPorts []int
+	var endedReasons []string
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/events/stream_started":
+			var ev startedEvent
+			json.NewDecoder(r.Body).Decode(&ev)
+			eventsMu.Lock()
+			startedPorts = append(startedPorts, ev.Engine.Port)
+			eventsMu.Unlock()
+		case "/events/stream_ended":
+			var ev endedEvent
+			json.NewDecoder(r.Body).Decode(&ev)
+			eventsMu.Lock()
+			endedReasons = append(endedReasons, ev.Reason)
+			eventsMu.Unlock()
+		case "/engines":
+			selections.Add(1)
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-primary", Host: "127.0.0.1", Port: primaryPort, HealthStatus: "healthy"},
+				{ContainerID: "engine-standby", Host: "127.0.0.1", Port: standbyPort, HealthStatus: "healthy"},
+			})
+		case "/streams":
+			streams := []streamState{}
+			if r.URL.Query().Get("container_id") == "engine-standby" {
+				streams = append(streams, streamState{ID: "s1", Status: "started"})
+			}
+			json.NewEncoder(w).Encode(streams)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 2,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		endedStreams:        make(map[string]bool),
+	}
+
+	acexyInst := &acexy.Acexy{
+		Scheme:            "http",
+		Host:              "127.0.0.1",
+		Port:              1,
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      5 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+	proxy := &Proxy{Acexy: acexyInst, Orch: client, WarmStandby: true}
+
+	w := httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+
+	if got := w.Body.String(); got != "primary-standby" {
+		t.Errorf("Expected the stream to continue from the standby engine, got %q", got)
+	}
+	// One selection for the primary engine and one for the standby, none on failover
+	if got := selections.Load(); got != 2 {
+		t.Errorf("Expected 2 engine selections, got %d", got)
+	}
+	if !client.engineFailing("engine-primary") {
+		t.Error("Expected the failed primary engine to be deprioritized")
+	}
+
+	// The primary session is ended and the standby one reported in its place
+	client.inflight.Wait()
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if len(startedPorts) != 2 || startedPorts[0] != primaryPort || startedPorts[1] != standbyPort {
+		t.Errorf("Expected the stream started on the primary then the standby engine, got ports %v", startedPorts)
+	}
+	if len(endedReasons) != 2 || endedReasons[0] == "completed" || endedReasons[1] != "completed" {
+		t.Errorf("Expected the failed primary session and the completed standby one ended, got %v", endedReasons)
+	}
+	if ended := proxy.history.Latest(1); len(ended) != 1 || ended[0].ContainerID != "engine-standby" || ended[0].EnginePort != standbyPort {
+		t.Errorf("Expected the stream recorded under the standby engine, got %+v", ended)
+	}
+	if proxy.streams.Len() != 0 {
+		t.Errorf("Expected every stream to be unregistered, %d left", proxy.streams.Len())
+	}
+}
+
+// TestWarmStandbyDisabled verifies no standby is selected unless enabled, nor for M3U8
+func TestWarmStandbyDisabled(t *testing.T) {
+	primary := selectedEngine{ContainerID: "engine-primary"}
+	proxy := &Proxy{Acexy: &acexy.Acexy{Endpoint: acexy.MPEG_TS_ENDPOINT}, Orch: &orchClient{}}
+	if s := proxy.reserveStandby(context.Background(), primary); s != nil {
+		t.Error("Expected no standby when disabled")
+	}
+
+	proxy.WarmStandby = true
+	proxy.Acexy.Endpoint = acexy.M3U8_ENDPOINT
+	if s := proxy.reserveStandby(context.Background(), primary); s != nil {
+		t.Error("Expected no standby for M3U8 streams")
+	}
+}
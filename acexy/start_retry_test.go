@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"javinator9889/acexy/lib/acexy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestStartRetries verifies a stream failing to start is retried on another engine, the
+// client getting its data, and the failed engine is deprioritized
+func TestStartRetries(t *testing.T) {
+	// The first engine hands out a playback URL nobody listens on
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	var broken *httptest.Server
+	broken = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"playback_url": dead.URL + "/stream",
+				"stat_url":     broken.URL + "/ace/stat/test/playback-broken",
+				"command_url":  broken.URL + "/ace/cmd/test/playback-broken",
+			}})
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"response": "ok"})
+		}
+	}))
+	defer broken.Close()
+	brokenURL, _ := url.Parse(broken.URL)
+	_, workingPort := newStandbyTestEngine(t, "retried data", false)
+
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-broken", Host: "127.0.0.1", Port: parsePort(brokenURL.Port()), HealthStatus: "healthy"},
+				{ContainerID: "engine-working", Host: "127.0.0.1", Port: workingPort, HealthStatus: "healthy", LastStreamUsage: time.Now()},
+			})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		endedStreams:        make(map[string]bool),
+	}
+
+	for _, tt := range []struct {
+		retries  int
+		expected string
+	}{
+		{0, ""},
+		{1, "retried data"},
+	} {
+		client.failingEngines = nil
+		acexyInst := &acexy.Acexy{
+			Scheme:            "http",
+			Host:              "127.0.0.1",
+			Port:              1,
+			Endpoint:          acexy.MPEG_TS_ENDPOINT,
+			EmptyTimeout:      5 * time.Second,
+			BufferSize:        1024,
+			NoResponseTimeout: 5 * time.Second,
+		}
+		acexyInst.Init()
+		proxy := &Proxy{Acexy: acexyInst, Orch: client, StartRetries: tt.retries}
+
+		w := httptest.NewRecorder()
+		proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+
+		if got := w.Body.String(); got != tt.expected {
+			t.Errorf("Retries %d: expected %q, got %q", tt.retries, tt.expected, got)
+		}
+		if tt.retries > 0 && !client.engineFailing("engine-broken") {
+			t.Errorf("Retries %d: expected the broken engine to be deprioritized", tt.retries)
+		}
+		if proxy.streams.Len() != 0 {
+			t.Errorf("Retries %d: expected every stream to be unregistered, %d left", tt.retries, proxy.streams.Len())
+		}
+	}
+}
+
+// TestStartRetryRetargetsKeepalive verifies the keepalive moves to the session of the engine
+// a stream is retried on, so the dead session it left is not held against the new engine
+func TestStartRetryRetargetsKeepalive(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	var broken *httptest.Server
+	broken = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"playback_url": dead.URL + "/stream",
+				"stat_url":     broken.URL + "/ace/stat/test/playback-broken",
+				"command_url":  broken.URL + "/ace/cmd/test/playback-broken",
+			}})
+		case "/ace/stat/test/playback-broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"response": "ok"})
+		}
+	}))
+	defer broken.Close()
+	brokenURL, _ := url.Parse(broken.URL)
+
+	// The working engine streams for several keepalive intervals
+	var working *httptest.Server
+	working = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ace/getstream":
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{
+				"playback_url": working.URL + "/stream",
+				"stat_url":     working.URL + "/ace/stat/test/playback-working",
+				"command_url":  working.URL + "/ace/cmd/test/playback-working",
+			}})
+		case "/stream":
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.Write([]byte("retried data"))
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{"status": "dl"}})
+		}
+	}))
+	defer working.Close()
+	workingURL, _ := url.Parse(working.URL)
+
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-broken", Host: "127.0.0.1", Port: parsePort(brokenURL.Port()), HealthStatus: "healthy"},
+				{ContainerID: "engine-working", Host: "127.0.0.1", Port: parsePort(workingURL.Port()), HealthStatus: "healthy", LastStreamUsage: time.Now()},
+			})
+		case "/streams":
+			json.NewEncoder(w).Encode([]streamState{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer orch.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                orch.URL,
+		maxStreamsPerEngine: 1,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		endedStreams:        make(map[string]bool),
+	}
+	acexyInst := &acexy.Acexy{
+		Scheme:            "http",
+		Host:              "127.0.0.1",
+		Port:              1,
+		Endpoint:          acexy.MPEG_TS_ENDPOINT,
+		EmptyTimeout:      5 * time.Second,
+		BufferSize:        1024,
+		NoResponseTimeout: 5 * time.Second,
+	}
+	acexyInst.Init()
+	proxy := &Proxy{Acexy: acexyInst, Orch: client, StartRetries: 1, Keepalive: newKeepalive(20 * time.Millisecond)}
+
+	w := httptest.NewRecorder()
+	proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123", nil))
+
+	if got := w.Body.String(); got != "retried data" {
+		t.Errorf("Expected the retried data, got %q", got)
+	}
+	if client.engineFailing("engine-working") {
+		t.Error("Expected the retry engine not to be marked failing by the dead session keepalive")
+	}
+}
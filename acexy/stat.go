@@ -0,0 +1,53 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"io"
+	"javinator9889/acexy/lib/acexy"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Time the engine is given to answer a proxied statistics request
+const STAT_TIMEOUT = 10 * time.Second
+
+// HandleStat relays the engine statistics (peers, download speed...) of an active stream,
+// looked up by its `id` or `infohash`, so dashboards never have to reach the engine. When
+// several clients watch the same content, the stream served the longest is used.
+func (p *Proxy) HandleStat(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	aceId, err := acexy.NewAceIDWithPrecedence(q.Get("id"), q.Get("infohash"), p.IDPrecedence)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stream, ok := p.streams.OldestAceID(aceId.String())
+	if !ok || stream.Stream == nil || stream.Stream.StatURL == "" {
+		http.Error(w, "Stream not active", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), STAT_TIMEOUT)
+	defer cancel()
+	resp, err := p.Acexy.FetchStat(ctx, stream.Stream)
+	if err != nil {
+		slog.Error("Failed to fetch stream statistics", "stream", aceId, "error", err)
+		http.Error(w, "Failed to fetch stream statistics", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if v := resp.Header.Get("Content-Type"); v != "" {
+		w.Header().Set("Content-Type", v)
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		slog.Debug("Failed to relay stream statistics", "stream", aceId, "error", err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleStatProxiesEngineStatistics(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "session1")
+	wait := streamConcurrently(t, proxy, 1)
+	defer wait()
+	defer close(release)
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ace/stat?id=test123", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected the engine content type, got %q", ct)
+	}
+
+	var stat struct {
+		Response struct {
+			Status string `json:"status"`
+			Peers  int    `json:"peers"`
+		} `json:"response"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&stat); err != nil {
+		t.Fatalf("Failed to decode the statistics: %v", err)
+	}
+	if stat.Response.Status != "dl" || stat.Response.Peers != 7 {
+		t.Errorf("Unexpected statistics relayed: %+v", stat.Response)
+	}
+}
+
+func TestHandleStatInactiveStream(t *testing.T) {
+	proxy, _, _ := newDuplicateSessionProxy(t, "session1")
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ace/stat?id=test123", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ace/stat", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without an ID, got %d", w.Code)
+	}
+}
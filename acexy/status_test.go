@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newStatusTestProxy creates a proxy serving two clients of one ID and a client of another
+func newStatusTestProxy() *Proxy {
+	proxy := &Proxy{}
+	proxy.streams.Add(&activeStream{PlaybackID: "p1", AceID: "{id: a}"})
+	proxy.streams.Add(&activeStream{PlaybackID: "p2", AceID: "{id: a}"})
+	proxy.streams.Add(&activeStream{PlaybackID: "p3", AceID: "{id: b}"})
+	return proxy
+}
+
+// TestStatusJSON verifies the default status format is the bare JSON status, the stream
+// counts being only included in the verbose one
+func TestStatusJSON(t *testing.T) {
+	proxy := newStatusTestProxy()
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ace/status", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON content type, got %s", ct)
+	}
+	if body := rec.Body.String(); body != "{\"status\":\"ok\"}\n" {
+		t.Errorf("Expected the bare status, got %q", body)
+	}
+
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ace/status?verbose=1", nil))
+	var status struct {
+		Status  string `json:"status"`
+		Streams int    `json:"streams"`
+		Clients int    `json:"clients"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if status.Status != "ok" || status.Streams != 2 || status.Clients != 3 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+// TestStatusText verifies the text format returns key=value lines, the bare status by default
+func TestStatusText(t *testing.T) {
+	proxy := newStatusTestProxy()
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ace/status?format=text", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if body := rec.Body.String(); body != "status=ok\n" {
+		t.Errorf("Unexpected text status %q", body)
+	}
+
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ace/status?format=text&verbose=1", nil))
+	if body := rec.Body.String(); body != "status=ok\nstreams=2\nclients=3\ndraining=false\n" {
+		t.Errorf("Unexpected verbose text status %q", body)
+	}
+
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ace/status?format=xml", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unsupported format, got %d", rec.Code)
+	}
+}
+
+// TestStatusVerbose verifies the health summary is only added with ?verbose=1 or when
+// always enabled, reporting whether the orchestrator answers its health checks
+func TestStatusVerbose(t *testing.T) {
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(orchestratorStatus{Status: "healthy"})
+	}))
+	defer orch.Close()
+
+	proxy := newStatusTestProxy()
+	proxy.Orch = &orchClient{base: orch.URL, hc: &http.Client{Timeout: time.Second}}
+	proxy.SetDraining(true)
+
+	decode := func(target string) map[string]any {
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var status map[string]any
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatalf("Failed to decode status of %s: %v", target, err)
+		}
+		return status
+	}
+
+	if status := decode("/ace/status"); len(status) != 1 {
+		t.Errorf("Expected only the bare status by default, got %v", status)
+	}
+
+	status := decode("/ace/status?verbose=1")
+	if status["status"] != "ok" || status["streams"] != 2.0 || status["clients"] != 3.0 || status["draining"] != true {
+		t.Errorf("Unexpected verbose status %v", status)
+	}
+	if status["orchestrator_reachable"] != false {
+		t.Errorf("Expected the orchestrator unreachable before any health check, got %v", status["orchestrator_reachable"])
+	}
+
+	if err := proxy.Orch.updateHealth(); err != nil {
+		t.Fatalf("Health check failed: %v", err)
+	}
+	proxy.VerboseStatus = true
+	if status := decode("/ace/status"); status["orchestrator_reachable"] != true {
+		t.Errorf("Expected the orchestrator reachable after a health check, got %v", status)
+	}
+
+	orch.Close()
+	proxy.Orch.updateHealth()
+	if status := decode("/ace/status"); status["orchestrator_reachable"] != false {
+		t.Errorf("Expected the orchestrator unreachable after a failed health check, got %v", status)
+	}
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ace/status?format=text", nil))
+	if body := rec.Body.String(); body != "status=ok\nstreams=2\nclients=3\ndraining=true\norchestrator_reachable=false\n" {
+		t.Errorf("Unexpected verbose text status %q", body)
+	}
+}
@@ -0,0 +1,38 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Query parameter clients tag their stream with, for their own reconciliation
+const STREAM_LABEL_PARAM = "label"
+
+// Maximum length, in bytes, of a client-supplied stream label
+const STREAM_LABEL_MAX_LENGTH = 128
+
+// Key the client label is reported under in the orchestrator event labels
+const STREAM_LABEL_EVENT_KEY = "client_label"
+
+// parseStreamLabel validates the label given by the client, trimming the surrounding
+// spaces. Empty labels are allowed, while labels that are too long or carry control
+// characters are rejected.
+func parseStreamLabel(value string) (string, error) {
+	label := strings.TrimSpace(value)
+	if len(label) > STREAM_LABEL_MAX_LENGTH {
+		return "", fmt.Errorf("label is longer than %d bytes", STREAM_LABEL_MAX_LENGTH)
+	}
+	if !utf8.ValidString(label) {
+		return "", fmt.Errorf("label is not valid UTF-8")
+	}
+	if strings.IndexFunc(label, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("label contains control characters")
+	}
+	return label, nil
+}
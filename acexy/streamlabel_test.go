@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStreamLabelRoundTrip verifies the client label is reported by /admin/clients and
+// forwarded in the stream_started event labels
+func TestStreamLabelRoundTrip(t *testing.T) {
+	proxy, release, _ := newDuplicateSessionProxy(t, "playback123")
+	go proxy.HandleStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123&label=user-42", nil))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for proxy.streams.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/clients", nil))
+	close(release)
+
+	var resp struct {
+		Clients []clientUsage `json:"clients"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Clients) != 1 || resp.Clients[0].Label != "user-42" {
+		t.Errorf("Expected the client label in /admin/clients, got %+v", resp.Clients)
+	}
+
+	eventProxy, events := newEventTestProxy(t, 1, nil)
+	eventProxy.HandleStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123&label=user-42", nil))
+
+	var labels map[string]string
+	select {
+	case started := <-events.startedEvents:
+		labels = started.Labels
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the started event")
+	}
+	if labels[STREAM_LABEL_EVENT_KEY] != "user-42" {
+		t.Errorf("Expected the client label in the started event, got %v", labels)
+	}
+	if !strings.HasPrefix(labels["stream_id"], "test123|") {
+		t.Errorf("Expected the stream_id label to be kept, got %v", labels)
+	}
+}
+
+// TestInvalidStreamLabel verifies labels that are too long or carry control characters
+// are rejected before an engine is selected
+func TestInvalidStreamLabel(t *testing.T) {
+	proxy, events := newEventTestProxy(t, 1, nil)
+
+	for _, label := range []string{strings.Repeat("a", STREAM_LABEL_MAX_LENGTH+1), "user%0A42", "user%0042"} {
+		w := httptest.NewRecorder()
+		proxy.HandleStream(w, httptest.NewRequest(http.MethodGet, "/ace/getstream?id=test123&label="+label, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for label %q, got %d", label, w.Code)
+		}
+	}
+
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if events.started != 0 {
+		t.Errorf("Expected no stream to be started, got %d", events.started)
+	}
+}
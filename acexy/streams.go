@@ -0,0 +1,296 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"fmt"
+	"io"
+	"javinator9889/acexy/lib/acexy"
+	"javinator9889/acexy/lib/pmw"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// activeStream describes a stream currently being served by the proxy
+type activeStream struct {
+	PlaybackID  string
+	AceID       string
+	Key         string // Key of the AceStream ID, which the orchestrator stream ID is made of
+	Stream      *acexy.AceStream
+	EngineHost  string
+	EnginePort  int
+	ContainerID string
+	StartedAt   time.Time
+	Client      string            // Address of the client the stream is served to
+	Label       string            // Label the client tagged the stream with, if any
+	Output      *pmw.PMultiWriter // Writer the stream is copied to, accounting the delivered bytes
+	Writer      io.Writer         // The client writer within Output
+
+	// Whether the P2P port of the engine is forwarded through the VPN, slower streams otherwise
+	EngineForwarded bool
+
+	peakClients int         // Most streams of the same ID served at once, guarded by the registry
+	startReport func()      // Reports the deferred start of the stream, guarded by the registry
+	cancelled   atomic.Bool // Whether the stream was stopped because the orchestrator cancelled it
+	terminated  atomic.Bool // Whether the stream was stopped because it outlived the shutdown grace
+
+	// Stream ID the orchestrator assigned when the stream started, if any
+	assignedID atomic.Pointer[string]
+}
+
+// StreamID returns the orchestrator stream ID of the stream: the one the orchestrator
+// assigned when it started, or the key and playback session ID otherwise
+func (s *activeStream) StreamID() string {
+	if id := s.assignedID.Load(); id != nil {
+		return *id
+	}
+	return s.Key + "|" + s.PlaybackID
+}
+
+// AssignStreamID sets the stream ID the orchestrator assigned to the stream
+func (s *activeStream) AssignStreamID(streamID string) {
+	s.assignedID.Store(&streamID)
+}
+
+// BytesSent returns the bytes delivered to the client so far
+func (s *activeStream) BytesSent() uint64 {
+	if s.Output == nil {
+		return 0
+	}
+	return s.Output.Written(s.Writer)
+}
+
+// streamRegistry keeps track of the streams currently being served, keyed by their
+// playback session ID. Each request still gets its own engine session; the registry only
+// allows other endpoints to look up in-flight streams. The zero value is ready to use.
+type streamRegistry struct {
+	mu         sync.RWMutex
+	streams    map[string]*activeStream
+	duplicates uint64 // Streams registered with the playback session ID of another one
+}
+
+// Add registers a stream that started being served. Under load, some engines hand out the
+// playback session ID of another active stream: such a stream is registered under a
+// suffixed ID instead, so both keep their own entry. Returns the ID the stream was
+// registered under and whether its playback session ID was a duplicate.
+func (r *streamRegistry) Add(stream *activeStream) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.streams == nil {
+		r.streams = make(map[string]*activeStream)
+	}
+
+	id := stream.PlaybackID
+	_, duplicate := r.streams[id]
+	for n := 2; r.streams[id] != nil; n++ {
+		id = fmt.Sprintf("%s-%d", stream.PlaybackID, n)
+	}
+	if duplicate {
+		r.duplicates++
+	}
+
+	stream.PlaybackID = id
+	r.streams[id] = stream
+
+	// Update the peak of concurrent clients of every stream of the same ID
+	var same []*activeStream
+	for _, other := range r.streams {
+		if other.AceID == stream.AceID {
+			same = append(same, other)
+		}
+	}
+	for _, other := range same {
+		other.peakClients = max(other.peakClients, len(same))
+	}
+	return id, duplicate
+}
+
+// DeferStart holds back the start report of a registered stream lacking concurrent clients
+// of its ID, until ReportDeferred is called for its ID
+func (r *streamRegistry) DeferStart(stream *activeStream, report func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stream.startReport = report
+}
+
+// ReportDeferred reports the start of the streams of the ID that were held back, once
+// enough concurrent clients of it are served
+func (r *streamRegistry) ReportDeferred(aceID string) {
+	r.mu.Lock()
+	var reports []func()
+	for _, stream := range r.streams {
+		if stream.AceID == aceID && stream.startReport != nil {
+			reports = append(reports, stream.startReport)
+			stream.startReport = nil
+		}
+	}
+	r.mu.Unlock()
+
+	for _, report := range reports {
+		report()
+	}
+}
+
+// Duplicates returns the number of streams registered with a duplicate playback session ID
+func (r *streamRegistry) Duplicates() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.duplicates
+}
+
+// Remove unregisters a stream once it is no longer served
+func (r *streamRegistry) Remove(playbackID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.streams, playbackID)
+}
+
+// PeakClients returns the most streams of the same ID served at once while the stream
+// registered under the given playback session ID was served
+func (r *streamRegistry) PeakClients(playbackID string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if stream, ok := r.streams[playbackID]; ok {
+		return stream.peakClients
+	}
+	return 0
+}
+
+// Cancel stops serving the stream with the given orchestrator stream ID by detaching its
+// client, which ends the copy from the engine. Returns whether such a stream was served.
+func (r *streamRegistry) Cancel(streamID string) bool {
+	r.mu.RLock()
+	var cancelled *activeStream
+	for _, stream := range r.streams {
+		if stream.StreamID() == streamID {
+			cancelled = stream
+			break
+		}
+	}
+	r.mu.RUnlock()
+
+	if cancelled == nil {
+		return false
+	}
+	cancelled.cancelled.Store(true)
+	if cancelled.Output != nil {
+		cancelled.Output.Remove(cancelled.Writer)
+	}
+	return true
+}
+
+// Terminate stops serving every stream by detaching its client. Returns the number of
+// streams stopped.
+func (r *streamRegistry) Terminate() int {
+	streams := r.List()
+	for _, stream := range streams {
+		stream.terminated.Store(true)
+		if stream.Output != nil {
+			stream.Output.Remove(stream.Writer)
+		}
+	}
+	return len(streams)
+}
+
+// Get returns the stream served under the given playback session ID
+func (r *streamRegistry) Get(playbackID string) (*activeStream, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stream, ok := r.streams[playbackID]
+	return stream, ok
+}
+
+// List returns the streams currently being served
+func (r *streamRegistry) List() []*activeStream {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	streams := make([]*activeStream, 0, len(r.streams))
+	for _, stream := range r.streams {
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+// StreamIDs returns the orchestrator stream IDs of the streams currently being served
+func (r *streamRegistry) StreamIDs() map[string]struct{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make(map[string]struct{}, len(r.streams))
+	for _, stream := range r.streams {
+		ids[stream.StreamID()] = struct{}{}
+	}
+	return ids
+}
+
+// CountAceID returns the number of streams currently being served for the given ID
+func (r *streamRegistry) CountAceID(aceID string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for _, stream := range r.streams {
+		if stream.AceID == aceID {
+			count++
+		}
+	}
+	return count
+}
+
+// OldestAceID returns the stream served the longest for the given ID
+func (r *streamRegistry) OldestAceID(aceID string) (*activeStream, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var oldest *activeStream
+	for _, stream := range r.streams {
+		if stream.AceID == aceID && (oldest == nil || stream.StartedAt.Before(oldest.StartedAt)) {
+			oldest = stream
+		}
+	}
+	return oldest, oldest != nil
+}
+
+// CountEngine returns the number of streams currently being served from the given engine
+func (r *streamRegistry) CountEngine(host string, port int) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for _, stream := range r.streams {
+		if stream.EngineHost == host && stream.EnginePort == port {
+			count++
+		}
+	}
+	return count
+}
+
+// CountIDs returns the number of distinct IDs currently being served
+func (r *streamRegistry) CountIDs() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make(map[string]struct{}, len(r.streams))
+	for _, stream := range r.streams {
+		ids[stream.AceID] = struct{}{}
+	}
+	return len(ids)
+}
+
+// Len returns the number of streams currently being served
+func (r *streamRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.streams)
+}
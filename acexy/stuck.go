@@ -0,0 +1,97 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// stuckWatcher reports the streams whose delivered bytes stop advancing while their client
+// is still connected: alive but not progressing sessions, which neither the empty timeout
+// nor a normal end catch. Each stall is reported once, as a `stream_stuck` event, so the
+// orchestrator can correlate it with the engine health.
+type stuckWatcher struct {
+	threshold time.Duration
+	progress  map[string]streamProgress // Last progress of each stream, by playback ID
+	stuck     atomic.Uint64             // Stalls reported
+}
+
+// streamProgress is the byte counter of a stream when it last advanced
+type streamProgress struct {
+	bytes    uint64
+	since    time.Time
+	reported bool
+}
+
+// newStuckWatcher creates the watcher. Returns nil (disabled) when the threshold is not positive.
+func newStuckWatcher(threshold time.Duration) *stuckWatcher {
+	if threshold <= 0 {
+		return nil
+	}
+	return &stuckWatcher{threshold: threshold, progress: make(map[string]streamProgress)}
+}
+
+// Run checks the streams every half threshold until the context is done
+func (s *stuckWatcher) Run(ctx context.Context, orch *orchClient, streams *streamRegistry) {
+	if s == nil {
+		return
+	}
+
+	ticker := time.NewTicker(s.threshold / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.check(now, orch, streams)
+		}
+	}
+}
+
+// check reports the streams whose byte counter did not advance for the threshold
+func (s *stuckWatcher) check(now time.Time, orch *orchClient, streams *streamRegistry) {
+	served := make(map[string]struct{})
+	for _, stream := range streams.List() {
+		served[stream.PlaybackID] = struct{}{}
+		bytes := stream.BytesSent()
+
+		last, ok := s.progress[stream.PlaybackID]
+		if !ok || bytes != last.bytes {
+			s.progress[stream.PlaybackID] = streamProgress{bytes: bytes, since: now}
+			continue
+		}
+		if last.reported || now.Sub(last.since) < s.threshold {
+			continue
+		}
+
+		last.reported = true
+		s.progress[stream.PlaybackID] = last
+		s.stuck.Add(1)
+		streamID := stream.StreamID()
+		slog.Warn("Stream stuck, no data delivered to its client", "stream_id", streamID,
+			"stalled", now.Sub(last.since).Round(time.Second), "bytes_sent", bytes,
+			"engine_host", stream.EngineHost, "engine_port", stream.EnginePort, "container_id", stream.ContainerID)
+		orch.EmitStuck(streamID, stream.ContainerID, now.Sub(last.since), bytes)
+	}
+
+	// Forget the streams that ended
+	for playbackID := range s.progress {
+		if _, ok := served[playbackID]; !ok {
+			delete(s.progress, playbackID)
+		}
+	}
+}
+
+// Stuck returns the number of stalled streams reported
+func (s *stuckWatcher) Stuck() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.stuck.Load()
+}
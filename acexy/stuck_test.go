@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"javinator9889/acexy/lib/pmw"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStuckStreamReported verifies a stream whose byte counter stops advancing is reported
+// once with a stream_stuck event, and again only after it progressed and stalled anew
+func TestStuckStreamReported(t *testing.T) {
+	var mu sync.Mutex
+	var events []stuckEvent
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events/stream_stuck" {
+			var ev stuckEvent
+			json.NewDecoder(r.Body).Decode(&ev)
+			mu.Lock()
+			events = append(events, ev)
+			mu.Unlock()
+		}
+	}))
+	defer orch.Close()
+	client := newOrchClient(OrchConfig{URL: orch.URL, RequestTimeout: time.Second})
+	defer client.Close()
+
+	var streams streamRegistry
+	out := pmw.New(io.Discard)
+	streams.Add(&activeStream{Key: "abc", PlaybackID: "p1", ContainerID: "engine-1", Output: out, Writer: io.Discard})
+	out.Write([]byte("some data"))
+
+	watcher := newStuckWatcher(10 * time.Second)
+	start := time.Now()
+	watcher.check(start, client, &streams)
+	watcher.check(start.Add(5*time.Second), client, &streams)
+	if watcher.Stuck() != 0 {
+		t.Fatalf("Expected no stall reported before the threshold, got %d", watcher.Stuck())
+	}
+
+	// Stalled past the threshold, reported once
+	watcher.check(start.Add(11*time.Second), client, &streams)
+	watcher.check(start.Add(20*time.Second), client, &streams)
+	if watcher.Stuck() != 1 {
+		t.Fatalf("Expected the stall to be reported once, got %d", watcher.Stuck())
+	}
+
+	// Progress resets the stall
+	out.Write([]byte("more"))
+	watcher.check(start.Add(25*time.Second), client, &streams)
+	watcher.check(start.Add(36*time.Second), client, &streams)
+	if watcher.Stuck() != 2 {
+		t.Errorf("Expected a new stall to be reported after progressing, got %d", watcher.Stuck())
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(events)
+		mu.Unlock()
+		if n == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 stream_stuck events, got %d", len(events))
+	}
+	for _, ev := range events {
+		if ev.StreamID != "abc|p1" || ev.EngineContainerID != "engine-1" || ev.StalledSeconds != 11 || (ev.BytesSent != 9 && ev.BytesSent != 13) {
+			t.Errorf("Unexpected stuck event %+v", ev)
+		}
+	}
+
+	// Ended streams are forgotten
+	streams.Remove("p1")
+	watcher.check(start.Add(40*time.Second), client, &streams)
+	if len(watcher.progress) != 0 {
+		t.Errorf("Expected the ended stream to be forgotten, %d left", len(watcher.progress))
+	}
+}
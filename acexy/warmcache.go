@@ -0,0 +1,92 @@
+// Acexy - Copyright (C) 2024 - Javinator9889 <dev at javinator9889 dot com>
+// This program comes with ABSOLUTELY NO WARRANTY; for details type `show w'.
+// This is free software, and you are welcome to redistribute it
+// under certain conditions; type `show c' for details.
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How long an engine is assumed to keep the content it served in its cache
+const WARM_CACHE_TTL = 30 * time.Minute
+
+type contentContextKey struct{}
+
+// withContentID returns a context telling the engine selection which content is requested
+func withContentID(ctx context.Context, aceID string) context.Context {
+	if aceID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, contentContextKey{}, aceID)
+}
+
+// requestedContent returns the content the engine selection is run for, if known
+func requestedContent(ctx context.Context) string {
+	aceID, _ := ctx.Value(contentContextKey{}).(string)
+	return aceID
+}
+
+// warmEntry is the engine that last served some content
+type warmEntry struct {
+	containerID string
+	servedAt    time.Time
+}
+
+// warmCache remembers which engine last served each content. That engine likely still
+// holds the content in its cache, so it serves the same content again faster than others.
+type warmCache struct {
+	mu      sync.Mutex
+	entries map[string]warmEntry
+}
+
+// newWarmCache creates the warm cache tracking. Returns nil (disabled) when not enabled.
+func newWarmCache(enabled bool) *warmCache {
+	if !enabled {
+		return nil
+	}
+	return &warmCache{entries: make(map[string]warmEntry)}
+}
+
+// Record remembers the engine served the given content
+func (c *warmCache) Record(aceID, containerID string) {
+	if c == nil || aceID == "" || containerID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[aceID] = warmEntry{containerID: containerID, servedAt: time.Now()}
+}
+
+// Engine returns the engine that served the given content within WARM_CACHE_TTL
+func (c *warmCache) Engine(aceID string) string {
+	if c == nil || aceID == "" {
+		return ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[aceID]
+	if !ok || time.Since(entry.servedAt) > WARM_CACHE_TTL {
+		return ""
+	}
+	return entry.containerID
+}
+
+// Cleanup forgets the engines whose content is no longer assumed cached
+func (c *warmCache) Cleanup() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for aceID, entry := range c.entries {
+		if time.Since(entry.servedAt) > WARM_CACHE_TTL {
+			delete(c.entries, aceID)
+		}
+	}
+}
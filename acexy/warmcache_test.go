@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestSelectBestEngineWarmCache verifies the engine that previously served some content is
+// chosen again for it even when busier, while other content keeps the least loaded engine
+func TestSelectBestEngineWarmCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engines":
+			json.NewEncoder(w).Encode([]engineState{
+				{ContainerID: "engine-idle", Host: "host-idle", Port: 8001, HealthStatus: "healthy"},
+				{ContainerID: "engine-warm", Host: "host-warm", Port: 8002, HealthStatus: "healthy"},
+			})
+		case "/streams":
+			streams := []streamState{}
+			if r.URL.Query().Get("container_id") == "engine-warm" {
+				streams = append(streams, streamState{ID: "s1", Status: "started"})
+			}
+			json.NewEncoder(w).Encode(streams)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &orchClient{
+		base:                server.URL,
+		maxStreamsPerEngine: 2,
+		hc:                  &http.Client{Timeout: 3 * time.Second},
+		ctx:                 ctx,
+		cancel:              cancel,
+		warm:                newWarmCache(true),
+	}
+	client.RecordServedContent("id:abc", "engine-warm")
+
+	tests := []struct {
+		aceID    string
+		expected string
+	}{
+		{"id:abc", "engine-warm"}, // The engine that served it before wins over load
+		{"id:def", "engine-idle"}, // Other content goes to the least loaded engine
+		{"", "engine-idle"},
+	}
+	for _, tt := range tests {
+		engine, err := client.SelectBestEngineContext(withContentID(context.Background(), tt.aceID))
+		if err != nil {
+			t.Fatalf("Unexpected selection error for %q: %v", tt.aceID, err)
+		}
+		if engine.ContainerID != tt.expected {
+			t.Errorf("Content %q: expected %s to be selected, got %s", tt.aceID, tt.expected, engine.ContainerID)
+		}
+	}
+
+	// Without the preference, load decides as before
+	client.warm = nil
+	client.RecordServedContent("id:abc", "engine-warm")
+	engine, err := client.SelectBestEngineContext(withContentID(context.Background(), "id:abc"))
+	if err != nil {
+		t.Fatalf("Unexpected selection error: %v", err)
+	}
+	if engine.ContainerID != "engine-idle" {
+		t.Errorf("Expected engine-idle without the warm cache preference, got %s", engine.ContainerID)
+	}
+}
+
+// TestWarmCacheExpiry verifies engines are no longer preferred once the content is not
+// assumed cached anymore
+func TestWarmCacheExpiry(t *testing.T) {
+	cache := newWarmCache(true)
+	cache.Record("id:abc", "engine-1")
+	if got := cache.Engine("id:abc"); got != "engine-1" {
+		t.Errorf("Expected engine-1, got %q", got)
+	}
+
+	cache.entries["id:abc"] = warmEntry{containerID: "engine-1", servedAt: time.Now().Add(-WARM_CACHE_TTL - time.Second)}
+	if got := cache.Engine("id:abc"); got != "" {
+		t.Errorf("Expected no engine once expired, got %q", got)
+	}
+	cache.Cleanup()
+	if len(cache.entries) != 0 {
+		t.Errorf("Expected the expired entry to be removed, got %v", cache.entries)
+	}
+}